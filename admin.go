@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkOverrides holds runtime changes made through the admin API, keyed by
+// check type and name.
+type checkOverrides struct {
+	mu       sync.Mutex
+	disabled map[string]time.Time // zero time: until re-enabled
+	acks     map[string]acknowledgement
+	drain    *drainState
+
+	faults      map[string]fault
+	serverFault *fault
+}
+
+var adminOverrides = &checkOverrides{disabled: map[string]time.Time{}, acks: map[string]acknowledgement{}, faults: map[string]fault{}}
+
+// acknowledgement records that an operator knows about a failing check,
+// in the manner of an Alertmanager silence.
+type acknowledgement struct {
+	Author            string    `json:"author"`
+	Comment           string    `json:"comment,omitempty"`
+	Created           time.Time `json:"created"`
+	Expires           time.Time `json:"expires"`
+	ExcludeFromStatus bool      `json:"excludeFromStatus"`
+}
+
+// drainState records that an operator has drained the server, so health
+// endpoints report unhealthy whatever the checks say and load balancers
+// take it out of rotation.
+type drainState struct {
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+func overrideKey(typ, name string) string {
+	return typ + "/" + name
+}
+
+// apply marks results of disabled and acknowledged checks, and forces
+// those of checks with faults, dropping overrides that have expired.
+func (o *checkOverrides) apply(results []checkResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	for i := range results {
+		key := overrideKey(results[i].Type, results[i].Name)
+		if until, ok := o.disabled[key]; ok {
+			results[i].Disabled = true
+			results[i].DisabledUntil = until
+		}
+		if ack, ok := o.acks[key]; ok {
+			results[i].Acknowledgement = &ack
+		}
+	}
+}
+
+func (o *checkOverrides) expire(now time.Time) {
+	for key, until := range o.disabled {
+		if !until.IsZero() && now.After(until) {
+			delete(o.disabled, key)
+		}
+	}
+	for key, ack := range o.acks {
+		if now.After(ack.Expires) {
+			delete(o.acks, key)
+		}
+	}
+	if o.drain != nil && o.drain.Until != nil && now.After(*o.drain.Until) {
+		o.drain = nil
+	}
+	for key, f := range o.faults {
+		if now.After(f.Expires) {
+			delete(o.faults, key)
+		}
+	}
+	if o.serverFault != nil && now.After(o.serverFault.Expires) {
+		o.serverFault = nil
+	}
+}
+
+func (o *checkOverrides) setDrain(drain drainState) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.drain = &drain
+}
+
+func (o *checkOverrides) undrain() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	drained := o.drain != nil
+	o.drain = nil
+	return drained
+}
+
+// drained returns the drain in effect, if any.
+func (o *checkOverrides) drained() *drainState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	if o.drain == nil {
+		return nil
+	}
+	drain := *o.drain
+	return &drain
+}
+
+// applyDrain marks report unhealthy while the server is drained.
+func (o *checkOverrides) applyDrain(report *healthReport) {
+	drain := o.drained()
+	if drain == nil {
+		return
+	}
+	message := "Server is drained since " + drain.Since.Format(time.RFC3339)
+	if drain.Until != nil {
+		message += " until " + drain.Until.Format(time.RFC3339)
+	}
+	if drain.Reason != "" {
+		message += ": " + drain.Reason
+	}
+	report.Healthy, report.Degraded, report.Drained = false, false, true
+	report.Messages = append(report.Messages, message)
+}
+
+func (o *checkOverrides) disable(typ, name string, until time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.disabled[overrideKey(typ, name)] = until
+}
+
+func (o *checkOverrides) enable(typ, name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.disabled, overrideKey(typ, name))
+}
+
+func (o *checkOverrides) acknowledge(typ, name string, ack acknowledgement) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.acks[overrideKey(typ, name)] = ack
+}
+
+func (o *checkOverrides) unacknowledge(typ, name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := overrideKey(typ, name)
+	_, ok := o.acks[key]
+	delete(o.acks, key)
+	return ok
+}
+
+// findCheck returns the check called name, narrowed by typ when names are
+// shared between check types.
+func findCheck(config *Config, name, typ string) (check, error) {
+	var found []check
+	for _, c := range currentChecks(config) {
+		if c.Name == name && (typ == "" || c.Type == typ) {
+			found = append(found, c)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return check{}, fmt.Errorf("no check named %q", name)
+	case 1:
+		return found[0], nil
+	default:
+		return check{}, fmt.Errorf("%d checks are named %q, select one with ?type=", len(found), name)
+	}
+}
+
+// registerAdminHandlers adds the runtime administration endpoints, at
+// /admin and under /api/v1, behind basic auth. cache, when not nil,
+// receives the results of checks run on demand.
+func registerAdminHandlers(live *liveConfig, cache *resultCache) {
+	auth := func(next http.HandlerFunc) http.HandlerFunc {
+		config := live.current()
+		return basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, next)
+	}
+	handle("GET /debug/state", auth(debugStateHandler(live)))
+	handle("POST /admin/checks", auth(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry, err := parseCheckDefinition(live.current(), data)
+		if err != nil {
+			http.Error(w, "Invalid check: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := dynamicChecks.add(live.current(), entry); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errCheckExists) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf("Registered %s check %s", entry.check.Type, entry.check.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(runNow(r.Context(), []check{entry.check}, cache)[0]); err != nil {
+			log.Printf("Failed to encode response: %v", err)
+		}
+	}))
+	handle("DELETE /admin/checks/{name}", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		removed, err := dynamicChecks.remove(c.Type, c.Name)
+		if err != nil {
+			log.Printf("Failed to save registered checks: %v", err)
+		}
+		if !removed {
+			http.Error(w, "only checks registered through the API can be removed", http.StatusConflict)
+			return
+		}
+		if cache != nil {
+			cache.remove(c.Type, c.Name)
+		}
+		log.Printf("Removed %s check %s", c.Type, c.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handle("POST /admin/checks/{name}/disable", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var until time.Time
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			until = time.Now().Add(d)
+		}
+		adminOverrides.disable(c.Type, c.Name, until)
+		log.Printf("Disabled %s check %s (until %s)", c.Type, c.Name, untilString(until))
+		writeAdminResponse(w, c, true, until)
+	}))
+	handle("POST /admin/checks/{name}/enable", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		adminOverrides.enable(c.Type, c.Name)
+		log.Printf("Enabled %s check %s", c.Type, c.Name)
+		writeAdminResponse(w, c, false, time.Time{})
+	}))
+	handle("POST /admin/checks/{name}/acknowledge", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Author            string `json:"author"`
+			Comment           string `json:"comment"`
+			TTL               string `json:"ttl"`
+			ExcludeFromStatus bool   `json:"excludeFromStatus"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.Author == "" {
+			http.Error(w, "author is required", http.StatusBadRequest)
+			return
+		}
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		ack := acknowledgement{
+			Author:            body.Author,
+			Comment:           body.Comment,
+			Created:           now,
+			Expires:           now.Add(ttl),
+			ExcludeFromStatus: body.ExcludeFromStatus,
+		}
+		adminOverrides.acknowledge(c.Type, c.Name, ack)
+		log.Printf("%s acknowledged %s check %s until %s: %s", ack.Author, c.Type, c.Name, ack.Expires.Format(time.RFC3339), ack.Comment)
+		writeJSON(w, map[string]interface{}{"type": c.Type, "name": c.Name, "acknowledgement": ack})
+	}))
+	handle("DELETE /admin/checks/{name}/acknowledge", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if !adminOverrides.unacknowledge(c.Type, c.Name) {
+			http.Error(w, "check is not acknowledged", http.StatusNotFound)
+			return
+		}
+		log.Printf("Removed acknowledgement of %s check %s", c.Type, c.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handle("POST /admin/checks/{name}/run", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, runNow(r.Context(), []check{c}, cache)[0])
+	}))
+	handle("POST /admin/drain", auth(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		drain := drainState{Since: time.Now(), Reason: body.Reason}
+		var until time.Time
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			until = drain.Since.Add(d)
+			drain.Until = &until
+		}
+		adminOverrides.setDrain(drain)
+		expiry := "undrained"
+		if drain.Until != nil {
+			expiry = until.Format(time.RFC3339)
+		}
+		log.Printf("Drained the server until %s, reason %q", expiry, drain.Reason)
+		writeJSON(w, map[string]interface{}{"drained": true, "drain": drain})
+	}))
+	handle("POST /admin/undrain", auth(func(w http.ResponseWriter, r *http.Request) {
+		if adminOverrides.undrain() {
+			log.Printf("Undrained the server")
+		}
+		writeJSON(w, map[string]interface{}{"drained": false})
+	}))
+	handle("POST /admin/run", auth(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, runNow(r.Context(), currentChecks(live.current()), cache))
+	}))
+	if live.current().Config.Admin.Faults {
+		registerFaultHandlers(live, cache, auth)
+	}
+	handle("POST /admin/config/diff", auth(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		next, err := parseConfig(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, diffConfigs(r.Context(), live.current(), next, r.URL.Query().Get("run") != "false"))
+	}))
+}
+
+// runNow runs checks immediately, updating the scheduler's cache so /healthy
+// reflects the fresh results without waiting for the next interval.
+func runNow(ctx context.Context, checks []check, cache *resultCache) []checkReport {
+	results := runChecks(ctx, checks)
+	if cache != nil {
+		var finished []checkResult
+		for _, r := range results {
+			if !r.Skipped {
+				finished = append(finished, r)
+			}
+		}
+		cache.store(finished)
+	}
+	adminOverrides.apply(results)
+	return reports(results, time.Now())
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+func untilString(until time.Time) string {
+	if until.IsZero() {
+		return "re-enabled"
+	}
+	return until.Format(time.RFC3339)
+}
+
+func writeAdminResponse(w http.ResponseWriter, c check, disabled bool, until time.Time) {
+	response := map[string]interface{}{"type": c.Type, "name": c.Name, "disabled": disabled}
+	if !until.IsZero() {
+		response["disabledUntil"] = until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AMQPCheck opens an AMQP 0-9-1 connection and channel to a broker and,
+// when ManagementURL and Queue are set, asserts through the RabbitMQ
+// management API that the queue holds no more than MaxMessages.
+type AMQPCheck struct {
+	Name               string `yaml:"name"`
+	URL                string `yaml:"url"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	ManagementURL      string `yaml:"managementURL"`
+	Queue              string `yaml:"queue"`
+	MaxMessages        int    `yaml:"maxMessages"`
+
+	// Thresholds grade the queue depth.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the broker and management URLs.
+func (a *AMQPCheck) Validate() error {
+	u, err := url.Parse(a.URL)
+	if err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") {
+		return fmt.Errorf("amqp check %s: url must be an amqp:// or amqps:// URL", a.Name)
+	}
+	if a.ManagementURL != "" {
+		if _, err := url.Parse(a.ManagementURL); err != nil {
+			return fmt.Errorf("amqp check %s: invalid managementURL: %w", a.Name, err)
+		}
+		if a.Queue == "" {
+			return fmt.Errorf("amqp check %s: queue is required with managementURL", a.Name)
+		}
+	}
+	if a.Thresholds.set() && a.ManagementURL == "" {
+		return fmt.Errorf("amqp check %s: warn and crit require managementURL", a.Name)
+	}
+	if err := a.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("amqp check %s: %w", a.Name, err)
+	}
+	return nil
+}
+
+func (check AMQPCheck) run(ctx context.Context) checkResult {
+	u, err := url.Parse(check.URL)
+	if err != nil {
+		return checkFailed("AMQP Name: %s, invalid url: %v", check.Name, err)
+	}
+	if err := check.openChannel(ctx, u); err != nil {
+		return checkFailed("AMQP Name: %s, Broker: %s %v", check.Name, u.Host, err)
+	}
+	if check.ManagementURL == "" {
+		return checkOK("AMQP Name: %s, Broker: %s accepted a connection and channel", check.Name, u.Host)
+	}
+
+	depth, err := check.queueDepth(ctx, u)
+	switch {
+	case err != nil:
+		return checkFailed("AMQP Name: %s, Queue: %s depth could not be read: %v", check.Name, check.Queue, err)
+	case check.MaxMessages > 0 && depth > check.MaxMessages:
+		return checkFailed("AMQP Name: %s, Queue: %s, Messages: %d exceeds %d", check.Name, check.Queue, depth, check.MaxMessages)
+	case check.Thresholds.set():
+		return check.Thresholds.apply(checkOK("AMQP Name: %s, Queue: %s, Messages: %d", check.Name, check.Queue, depth), float64(depth), "")
+	default:
+		return checkOK("AMQP Name: %s, Queue: %s, Messages: %d is within limits", check.Name, check.Queue, depth)
+	}
+}
+
+// amqpVhost returns the virtual host encoded in the URL path, defaulting to "/".
+func amqpVhost(u *url.URL) string {
+	if vhost := strings.TrimPrefix(u.Path, "/"); vhost != "" {
+		return vhost
+	}
+	return "/"
+}
+
+// openChannel performs the connection handshake, opens channel 1 and then
+// closes the connection cleanly.
+func (check AMQPCheck) openChannel(ctx context.Context, u *url.URL) error {
+	host := u.Host
+	if u.Port() == "" {
+		port := "5672"
+		if u.Scheme == "amqps" {
+			port = "5671"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	user, pass := "guest", "guest"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+
+	var tlsConfig *tls.Config
+	if u.Scheme == "amqps" {
+		tlsConfig = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: check.InsecureSkipVerify}
+	}
+	conn, err := dialCheck(ctx, "tcp", host, 5*time.Second, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+	r := bufio.NewReader(conn)
+
+	if _, err := conn.Write([]byte("AMQP\x00\x00\x09\x01")); err != nil {
+		return err
+	}
+	if _, err := readAMQPMethod(r, 10, 10); err != nil { // connection.start
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	// connection.start-ok: client-properties, mechanism, response, locale
+	startOk := amqpMethod(10, 11)
+	startOk = binary.BigEndian.AppendUint32(startOk, 0)
+	startOk = appendShortStr(startOk, "PLAIN")
+	startOk = appendLongStr(startOk, "\x00"+user+"\x00"+pass)
+	startOk = appendShortStr(startOk, "en_US")
+	if err := writeAMQPFrame(conn, 0, startOk); err != nil {
+		return err
+	}
+
+	tune, err := readAMQPMethod(r, 10, 30) // connection.tune
+	if err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	if len(tune) < 8 {
+		return fmt.Errorf("malformed connection.tune")
+	}
+	// connection.tune-ok: echo channel-max and frame-max, disable heartbeats
+	tuneOk := append(amqpMethod(10, 31), tune[:6]...)
+	tuneOk = binary.BigEndian.AppendUint16(tuneOk, 0)
+	if err := writeAMQPFrame(conn, 0, tuneOk); err != nil {
+		return err
+	}
+
+	// connection.open: virtual-host, reserved, reserved
+	open := appendShortStr(amqpMethod(10, 40), amqpVhost(u))
+	open = append(appendShortStr(open, ""), 0)
+	if err := writeAMQPFrame(conn, 0, open); err != nil {
+		return err
+	}
+	if _, err := readAMQPMethod(r, 10, 41); err != nil {
+		return fmt.Errorf("could not open vhost %s: %w", amqpVhost(u), err)
+	}
+
+	if err := writeAMQPFrame(conn, 1, appendShortStr(amqpMethod(20, 10), "")); err != nil { // channel.open
+		return err
+	}
+	if _, err := readAMQPMethod(r, 20, 11); err != nil {
+		return fmt.Errorf("could not open channel: %w", err)
+	}
+
+	// connection.close: reply-code, reply-text, class-id, method-id
+	closeMethod := binary.BigEndian.AppendUint16(amqpMethod(10, 50), 200)
+	closeMethod = appendShortStr(closeMethod, "health check")
+	closeMethod = binary.BigEndian.AppendUint32(closeMethod, 0)
+	if err := writeAMQPFrame(conn, 0, closeMethod); err != nil {
+		return err
+	}
+	_, err = readAMQPMethod(r, 10, 51)
+	return err
+}
+
+func amqpMethod(class, method uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, class), method)
+}
+
+func appendShortStr(b []byte, s string) []byte {
+	return append(append(b, byte(len(s))), s...)
+}
+
+func appendLongStr(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...) // #nosec G115 -- credentials are short
+}
+
+func writeAMQPFrame(w io.Writer, channel uint16, payload []byte) error {
+	frame := []byte{1} // method frame
+	frame = binary.BigEndian.AppendUint16(frame, channel)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload))) // #nosec G115 -- payloads are small
+	frame = append(append(frame, payload...), 0xce)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readAMQPMethod reads frames until a method frame arrives and returns its
+// arguments if it is class.method. A connection.close or channel.close from
+// the broker is returned as an error carrying its reply text.
+func readAMQPMethod(r *bufio.Reader, class, method uint16) ([]byte, error) {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(header[3:])
+		if size > 1<<20 {
+			return nil, fmt.Errorf("frame of %d bytes is too large", size)
+		}
+		payload := make([]byte, size+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		if header[0] != 1 || size < 4 {
+			continue // heartbeat or content frame
+		}
+		gotClass, gotMethod := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+		args := payload[4:size]
+		if gotClass == class && gotMethod == method {
+			return args, nil
+		}
+		if ((gotClass == 10 && gotMethod == 50) || (gotClass == 20 && gotMethod == 40)) && len(args) >= 3 {
+			// connection.close (10.50) / channel.close (20.40): reply-code, reply-text
+			code := binary.BigEndian.Uint16(args)
+			text := args[3:]
+			if n := int(args[2]); n <= len(text) {
+				text = text[:n]
+			}
+			return nil, fmt.Errorf("broker closed with %d %s", code, text)
+		}
+		return nil, fmt.Errorf("unexpected method %d.%d", gotClass, gotMethod)
+	}
+}
+
+// queueDepth reads the message count of Queue from the management API,
+// using the broker URL's credentials unless ManagementURL carries its own.
+func (check AMQPCheck) queueDepth(ctx context.Context, broker *url.URL) (int, error) {
+	mgmt, err := url.Parse(check.ManagementURL)
+	if err != nil {
+		return 0, err
+	}
+	creds := mgmt.User
+	if creds == nil {
+		creds = broker.User
+	}
+	mgmt.User = nil
+	endpoint := strings.TrimSuffix(mgmt.String(), "/") + "/api/queues/" + url.PathEscape(amqpVhost(broker)) + "/" + url.PathEscape(check.Queue)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	if creds != nil {
+		pass, _ := creds.Password()
+		req.SetBasicAuth(creds.Username(), pass)
+	}
+	client := checkClient(ctx, mgmt.Scheme == "https")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("management API returned %s", resp.Status)
+	}
+	var queue struct {
+		Messages int `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&queue); err != nil {
+		return 0, err
+	}
+	return queue.Messages, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// apiPrefix is where the versioned API is served. Its paths keep their
+// response schemas for as long as v1 is served; the unversioned paths are
+// aliases that follow the latest version.
+const apiPrefix = "/api/v1"
+
+// handle registers handler for pattern and for the same path under
+// apiPrefix. A pattern may start with a method, as in "POST /admin/run".
+func handle(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, handler)
+	if method, path, found := strings.Cut(pattern, " "); found {
+		http.HandleFunc(method+" "+apiPrefix+path, handler)
+		return
+	}
+	http.HandleFunc(apiPrefix+pattern, handler)
+}
+
+// checksHandler serves /api/v1/checks, the checks of a /healthy report
+// without the aggregate status. It answers 200 whatever their status, as
+// a listing rather than a probe.
+func checksHandler(live *liveConfig, cache *resultCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := live.current()
+		report, _, _, ok := requestHealth(r, config, cache)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if config.Config.Caching.notModified(w, r, report, "checks", http.StatusOK) {
+			return
+		}
+		writeJSON(w, report.Checks)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "log/syslog"
+
+// dialAuditSyslog connects to syslog with the auth facility.
+func dialAuditSyslog(network, address, tag string) (auditSyslog, error) {
+	return syslog.Dial(network, address, syslog.LOG_AUTH|syslog.LOG_NOTICE, tag)
+}
@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// dialAuditSyslog fails: Windows has no syslog.
+func dialAuditSyslog(network, address, tag string) (auditSyslog, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials sign requests to AWS APIs.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// awsCredentialSource returns the configured keys, falling back to the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables and then to the EC2 instance's IAM role. Role
+// credentials are kept until shortly before they expire.
+type awsCredentialSource struct {
+	static awsCredentials
+
+	mu      sync.Mutex
+	role    awsCredentials
+	expires time.Time
+}
+
+func (s *awsCredentialSource) get(ctx context.Context) (awsCredentials, error) {
+	if s.static.AccessKeyID != "" {
+		return s.static, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{id, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Until(s.expires) > 5*time.Minute {
+		return s.role, nil
+	}
+	role, err := imdsGet(ctx, "iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no credentials configured and no instance role: %w", err)
+	}
+	body, err := imdsGet(ctx, "iam/security-credentials/"+strings.TrimSpace(strings.SplitN(role, "\n", 2)[0]))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("reading instance role credentials: %w", err)
+	}
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("reading instance role credentials: %w", err)
+	}
+	s.role = awsCredentials{creds.AccessKeyID, creds.SecretAccessKey, creds.Token}
+	s.expires = creds.Expiration
+	return s.role, nil
+}
+
+// imdsClient talks to the instance metadata service directly, as it must
+// never be reached through a proxy.
+var imdsClient = &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{}}
+
+const imdsAddress = "http://169.254.169.254"
+
+// imdsGet reads a path under /latest/meta-data/ using an IMDSv2 session
+// token.
+func imdsGet(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsAddress+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	token, err := imdsRead(req)
+	if err != nil {
+		return "", err
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, imdsAddress+"/latest/meta-data/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	return imdsRead(req)
+}
+
+func imdsRead(req *http.Request) (string, error) {
+	resp, err := imdsClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata %s returned %s", req.URL.Path, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return string(body), err
+}
+
+// signAWSRequest adds AWS Signature Version 4 headers to req for service in
+// region, where payloadHash is the hex SHA-256 of the request body.
+func signAWSRequest(req *http.Request, service, region, payloadHash string, creds awsCredentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		signed = append(signed, "x-amz-security-token")
+		headers += "x-amz-security-token:" + creds.SessionToken + "\n"
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers, signedHeaders, payloadHash}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxS3ListPages bounds how many pages of up to 1000 objects a backup
+// check lists under a prefix.
+const maxS3ListPages = 50
+
+// BackupCheck fails when the newest backup is missing, older than MaxAge
+// or smaller than MinBytes, so backups that silently stop, or start
+// writing empty files, show up in health. The backups are the files
+// matching Glob or, with S3 set, the objects under Prefix in that bucket.
+type BackupCheck struct {
+	Name     string        `yaml:"name"`
+	Glob     string        `yaml:"glob"`
+	S3       *S3Bucket     `yaml:"s3"`
+	Prefix   string        `yaml:"prefix"`
+	MaxAge   time.Duration `yaml:"maxAge"`
+	MinBytes int64         `yaml:"minBytes"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks one source is set and the limits.
+func (b *BackupCheck) Validate() error {
+	if (b.Glob == "") == (b.S3 == nil) {
+		return fmt.Errorf("backup check %s: set one of glob and s3", b.Name)
+	}
+	if _, err := filepath.Match(b.Glob, ""); err != nil {
+		return fmt.Errorf("backup check %s: invalid glob: %w", b.Name, err)
+	}
+	if b.Prefix != "" && b.S3 == nil {
+		return fmt.Errorf("backup check %s: prefix requires s3", b.Name)
+	}
+	if b.S3 != nil {
+		if err := b.S3.Validate(); err != nil {
+			return fmt.Errorf("backup check %s: s3: %w", b.Name, err)
+		}
+	}
+	if b.MaxAge <= 0 {
+		return fmt.Errorf("backup check %s: maxAge must be positive", b.Name)
+	}
+	if b.MinBytes < 0 {
+		return fmt.Errorf("backup check %s: minBytes must not be negative", b.Name)
+	}
+	return nil
+}
+
+// backupFile is the newest backup found.
+type backupFile struct {
+	name     string
+	size     int64
+	modified time.Time
+}
+
+func (check BackupCheck) run(ctx context.Context) checkResult {
+	source := check.Glob
+	var newest backupFile
+	var err error
+	if check.S3 != nil {
+		source = "s3://" + check.S3.Bucket + "/" + check.Prefix
+		newest, err = check.newestObject(ctx)
+	} else {
+		newest, err = check.newestFile()
+	}
+	switch {
+	case err != nil:
+		return checkFailed("Backup Name: %s, Source: %s %v", check.Name, source, err)
+	case newest.name == "":
+		return checkFailed("Backup Name: %s, Source: %s holds no backups", check.Name, source)
+	}
+	age := time.Since(newest.modified)
+	message := fmt.Sprintf("Backup Name: %s, Newest: %s, %d bytes, %s old", check.Name, newest.name, newest.size, age.Round(time.Second))
+	switch {
+	case age > check.MaxAge:
+		return checkFailed("%s is older than %s", message, check.MaxAge)
+	case newest.size < check.MinBytes:
+		return checkFailed("%s is smaller than %d bytes", message, check.MinBytes)
+	}
+	return checkOK("%s", message)
+}
+
+// newestFile returns the most recently modified regular file matching
+// Glob.
+func (check BackupCheck) newestFile() (backupFile, error) {
+	matches, err := filepath.Glob(check.Glob)
+	if err != nil {
+		return backupFile{}, err
+	}
+	var newest backupFile
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue // removed since, or a directory
+		}
+		if info.ModTime().After(newest.modified) {
+			newest = backupFile{name: match, size: info.Size(), modified: info.ModTime()}
+		}
+	}
+	return newest, nil
+}
+
+// s3ListResult is a page of a ListObjectsV2 response.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// newestObject lists the objects under Prefix and returns the most recently
+// modified.
+func (check BackupCheck) newestObject(ctx context.Context) (backupFile, error) {
+	var newest backupFile
+	token := ""
+	for page := 0; page < maxS3ListPages; page++ {
+		query := url.Values{"list-type": {"2"}, "prefix": {check.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := check.S3.request(ctx, http.MethodGet, "", query)
+		if err != nil {
+			return backupFile{}, fmt.Errorf("is not reachable: %v", err)
+		}
+		if problem := s3Problem(resp); problem != "" {
+			closeAndLog(resp.Body, "response body")
+			return backupFile{}, errors.New(strings.TrimLeft(problem, ", "))
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&result)
+		closeAndLog(resp.Body, "response body")
+		if err != nil {
+			return backupFile{}, fmt.Errorf("listing is invalid: %v", err)
+		}
+		for _, object := range result.Contents {
+			if object.LastModified.After(newest.modified) {
+				newest = backupFile{name: object.Key, size: object.Size, modified: object.LastModified}
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return newest, nil
+		}
+		token = result.NextContinuationToken
+	}
+	return backupFile{}, fmt.Errorf("holds more than %d objects; use a narrower prefix", maxS3ListPages*1000)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachingConfig lets clients polling /healthy and /api/v1/checks revalidate
+// instead of fetching the whole report each time. Responses carry an ETag
+// of the report and, for a request whose If-None-Match has it, a healthy
+// response is answered 304 Not Modified with no body. With ETag "results",
+// the default, the tag changes whenever a check runs again; with "status"
+// it changes only when a check's status, reason or message does, so a
+// client may keep an older lastChecked. Cache-Control is max-age=MaxAge, or
+// no-cache, asking clients to revalidate every time, when MaxAge is unset.
+type CachingConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxAge  time.Duration `yaml:"maxAge"`
+	ETag    string        `yaml:"etag"`
+}
+
+// Validate checks the ETag mode and max age.
+func (c *CachingConfig) Validate() error {
+	if c.ETag != "" && c.ETag != "results" && c.ETag != "status" {
+		return fmt.Errorf("caching: etag must be results or status")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("caching: maxAge must not be negative")
+	}
+	return nil
+}
+
+// notModified sets the caching headers for report, as rendered in format,
+// and answers 304 Not Modified when the request already has it, reporting
+// whether it did. Only responses that would be 2xx are answered 304, so a
+// failing health check always gets its status code.
+func (c *CachingConfig) notModified(w http.ResponseWriter, r *http.Request, report healthReport, format string, statusCode int) bool {
+	if !c.Enabled {
+		return false
+	}
+	etag := c.etag(report, format)
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Add("Vary", "Accept, Accept-Language")
+	if c.MaxAge > 0 {
+		h.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(c.MaxAge.Seconds())))
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	if statusCode/100 != 2 || !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etag returns the weak ETag of report in format. Ages, which change with
+// every request, are left out, and with ETag "status" so are the times the
+// checks ran.
+func (c *CachingConfig) etag(report healthReport, format string) string {
+	checks := make([]checkReport, len(report.Checks))
+	for i, check := range report.Checks {
+		check.Age = 0
+		if c.ETag == "status" {
+			check.LastChecked = time.Time{}
+		}
+		checks[i] = check
+	}
+	report.Checks = checks
+	if c.ETag == "status" {
+		report.StaleAfter = nil
+	}
+	hash := sha256.New()
+	hash.Write([]byte(format + "\n"))
+	if err := json.NewEncoder(hash).Encode(report); err != nil {
+		return `W/"` + strconv.FormatInt(time.Now().UnixNano(), 36) + `"`
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header lists etag, using
+// the weak comparison it calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxRevocationBody bounds the OCSP responses and CRLs read.
+const maxRevocationBody = 10 << 20
+
+// CertificateCheck validates the certificates an HTTPS endpoint presents,
+// which endpoint checks otherwise accept whatever they are. VerifyChain
+// verifies the chain to the system roots, or those in CAFile, for the
+// server name. Revocation checks the leaf certificate is not revoked by
+// its stapled OCSP response, its OCSP responder or, without one, its CRL;
+// a revocation status that cannot be had is a warning. WarnDays and
+// CritDays grade how soon the first certificate in the chain, leaf or
+// intermediate, expires.
+type CertificateCheck struct {
+	VerifyChain bool   `yaml:"verifyChain"`
+	CAFile      string `yaml:"caFile"`
+	Revocation  bool   `yaml:"revocation"`
+	WarnDays    int    `yaml:"warnDays"`
+	CritDays    int    `yaml:"critDays"`
+}
+
+// Validate checks the settings depend on each other as they must.
+func (c *CertificateCheck) Validate() error {
+	if c.CAFile != "" && !c.VerifyChain {
+		return fmt.Errorf("caFile requires verifyChain")
+	}
+	if c.Revocation && !c.VerifyChain {
+		return fmt.Errorf("revocation requires verifyChain")
+	}
+	if c.WarnDays < 0 || c.CritDays < 0 {
+		return fmt.Errorf("warnDays and critDays must not be negative")
+	}
+	return nil
+}
+
+// check validates the certificates of a connection to serverName,
+// returning their status and a description of them.
+func (c CertificateCheck) check(ctx context.Context, state *tls.ConnectionState, serverName string) (checkStatus, string) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return statusCritical, "no certificate was presented"
+	}
+	now := time.Now()
+	chain := state.PeerCertificates
+	var details []string
+	if c.VerifyChain {
+		roots, err := c.roots()
+		if err != nil {
+			return statusCritical, err.Error()
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		chains, err := chain[0].Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots, Intermediates: intermediates, CurrentTime: now})
+		if err != nil {
+			return statusCritical, fmt.Sprintf("chain does not verify: %v", err)
+		}
+		chain = chains[0]
+		details = append(details, "chain verified")
+	}
+
+	status := statusOK
+	if c.Revocation {
+		revocationStatus, detail := c.revocation(ctx, state, chain)
+		if revocationStatus == statusCritical {
+			return statusCritical, detail
+		}
+		status = max(status, revocationStatus)
+		details = append(details, detail)
+	}
+
+	first := chain[0]
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(first.NotAfter) {
+			first = cert
+		}
+	}
+	days := int(first.NotAfter.Sub(now).Hours() / 24)
+	what := "leaf"
+	if first != chain[0] {
+		what = "intermediate " + first.Subject.CommonName
+	}
+	expiry := fmt.Sprintf("%s expires %s (%d days)", what, first.NotAfter.Format("2006-01-02"), days)
+	switch {
+	case now.After(first.NotAfter):
+		return statusCritical, fmt.Sprintf("%s expired %s", what, first.NotAfter.Format("2006-01-02"))
+	case c.CritDays > 0 && days < c.CritDays:
+		return statusCritical, expiry + fmt.Sprintf(", below critical %d days", c.CritDays)
+	case c.WarnDays > 0 && days < c.WarnDays:
+		status = statusWarning
+		expiry += fmt.Sprintf(", below warning %d days", c.WarnDays)
+	}
+	details = append(details, expiry)
+	return status, strings.Join(details, ", ")
+}
+
+// roots returns the pool to verify chains to: CAFile's certificates, or
+// the system's.
+func (c CertificateCheck) roots() (*x509.CertPool, error) {
+	if c.CAFile == "" {
+		return x509.SystemCertPool()
+	}
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read caFile: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("caFile %s holds no certificates", c.CAFile)
+	}
+	return pool, nil
+}
+
+// revocation returns the revocation status of the leaf of a verified
+// chain, preferring a stapled OCSP response, then the certificate's OCSP
+// responder and then its CRL.
+func (c CertificateCheck) revocation(ctx context.Context, state *tls.ConnectionState, chain []*x509.Certificate) (checkStatus, string) {
+	if len(chain) < 2 {
+		return statusWarning, "revocation not checked for a self-signed certificate"
+	}
+	leaf, issuer := chain[0], chain[1]
+	switch {
+	case len(state.OCSPResponse) > 0:
+		return ocspStatus(state.OCSPResponse, leaf, issuer, "stapled OCSP")
+	case len(leaf.OCSPServer) > 0:
+		request, err := ocsp.CreateRequest(leaf, issuer, nil)
+		if err != nil {
+			return statusWarning, fmt.Sprintf("OCSP request could not be made: %v", err)
+		}
+		body, err := fetchRevocation(ctx, http.MethodPost, leaf.OCSPServer[0], request)
+		if err != nil {
+			return statusWarning, fmt.Sprintf("OCSP responder %s %v", leaf.OCSPServer[0], err)
+		}
+		return ocspStatus(body, leaf, issuer, "OCSP")
+	case len(leaf.CRLDistributionPoints) > 0:
+		return crlStatus(ctx, leaf.CRLDistributionPoints[0], leaf, issuer)
+	}
+	return statusWarning, "no OCSP responder or CRL to check revocation against"
+}
+
+// ocspStatus reads the leaf's status from an OCSP response signed for
+// issuer.
+func ocspStatus(der []byte, leaf, issuer *x509.Certificate, source string) (checkStatus, string) {
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return statusWarning, fmt.Sprintf("%s response is invalid: %v", source, err)
+	}
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return statusWarning, fmt.Sprintf("%s response is stale since %s", source, resp.NextUpdate.Format(time.RFC3339))
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return statusOK, source + " good"
+	case ocsp.Revoked:
+		return statusCritical, fmt.Sprintf("revoked at %s by %s", resp.RevokedAt.Format(time.RFC3339), source)
+	}
+	return statusWarning, source + " status unknown"
+}
+
+// crlStatus looks the leaf up in the CRL at url, signed by issuer.
+func crlStatus(ctx context.Context, url string, leaf, issuer *x509.Certificate) (checkStatus, string) {
+	body, err := fetchRevocation(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return statusWarning, fmt.Sprintf("CRL %s %v", url, err)
+	}
+	list, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return statusWarning, fmt.Sprintf("CRL %s is invalid: %v", url, err)
+	}
+	if err := list.CheckSignatureFrom(issuer); err != nil {
+		return statusWarning, fmt.Sprintf("CRL %s signature does not verify: %v", url, err)
+	}
+	if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+		return statusWarning, fmt.Sprintf("CRL %s is stale since %s", url, list.NextUpdate.Format(time.RFC3339))
+	}
+	for _, entry := range list.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return statusCritical, fmt.Sprintf("revoked at %s by CRL", entry.RevocationTime.Format(time.RFC3339))
+		}
+	}
+	return statusOK, "CRL good"
+}
+
+// fetchRevocation requests an OCSP response or CRL and returns the body.
+func fetchRevocation(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("is invalid: %w", err)
+	}
+	req.Header = requestHeaders("", nil)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+	resp, err := checkClient(ctx, strings.HasPrefix(url, "https://")).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is not reachable")
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRevocationBody))
+	if err != nil {
+		return nil, fmt.Errorf("could not be read: %w", err)
+	}
+	return data, nil
+}
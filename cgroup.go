@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CgroupCheck reports on the cgroup the daemon runs in, which in a
+// container is the container's own, so health reflects its limits rather
+// than the host's: memory in use against the memory limit, the share of
+// CPU periods throttled by the quota since the last run, and processes
+// against the pids limit. Memory in use leaves out inactive page cache,
+// which the kernel reclaims before it kills anything, as the kubelet does.
+// The Max limits are percentages, and an OOM kill since the last run fails
+// the check too. Path names another cgroup, such as
+// /system.slice/nginx.service, instead. Both cgroup v2 and v1 are read.
+type CgroupCheck struct {
+	Name            string  `yaml:"name"`
+	Path            string  `yaml:"path"`
+	MaxMemoryUsed   float64 `yaml:"maxMemoryUsed"`
+	MaxCPUThrottled float64 `yaml:"maxCPUThrottled"`
+	MaxPidsUsed     float64 `yaml:"maxPidsUsed"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the path and the limits.
+func (c *CgroupCheck) Validate() error {
+	if c.Path != "" && (!strings.HasPrefix(c.Path, "/") || strings.Contains(c.Path, "..")) {
+		return fmt.Errorf("cgroup check %s: path must be absolute, within the cgroup hierarchy", c.Name)
+	}
+	for _, limit := range []float64{c.MaxMemoryUsed, c.MaxCPUThrottled, c.MaxPidsUsed} {
+		if limit < 0 || limit > 100 {
+			return fmt.Errorf("cgroup check %s: maxMemoryUsed, maxCPUThrottled and maxPidsUsed must be percentages", c.Name)
+		}
+	}
+	return nil
+}
+
+// cgroupRoot is where the cgroup hierarchies are mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupStats is what a cgroup reports. Limits are zero when unlimited and
+// counters are -1 when not available.
+type cgroupStats struct {
+	memoryUsed, memoryLimit int64
+	oomKills                int64
+	periods, throttled      int64
+	pids, pidsLimit         int64
+}
+
+// cgroupSample is the counters seen on the last run of a check.
+type cgroupSample struct {
+	oomKills, periods, throttled int64
+}
+
+var (
+	cgroupSamplesMu sync.Mutex
+	cgroupSamples   = map[string]cgroupSample{}
+)
+
+// swapCgroupSample records s as the latest for key and returns the one
+// before, if any.
+func swapCgroupSample(key string, s cgroupSample) (cgroupSample, bool) {
+	cgroupSamplesMu.Lock()
+	defer cgroupSamplesMu.Unlock()
+	prev, ok := cgroupSamples[key]
+	cgroupSamples[key] = s
+	return prev, ok
+}
+
+func (check CgroupCheck) run(ctx context.Context) checkResult {
+	stats, err := readCgroupStats(check.Path)
+	if err != nil {
+		return checkFailed("Cgroup Name: %s, could not be read: %v", check.Name, err)
+	}
+	prev, seen := swapCgroupSample(check.Name+"\x00"+check.Path, cgroupSample{oomKills: stats.oomKills, periods: stats.periods, throttled: stats.throttled})
+
+	var details, problems []string
+	if stats.memoryUsed >= 0 {
+		if stats.memoryLimit > 0 {
+			used := float64(stats.memoryUsed) / float64(stats.memoryLimit) * 100
+			details = append(details, fmt.Sprintf("Memory: %s of %s (%.0f%%)", formatMiB(stats.memoryUsed), formatMiB(stats.memoryLimit), used))
+			if check.MaxMemoryUsed > 0 && used > check.MaxMemoryUsed {
+				problems = append(problems, fmt.Sprintf("memory used %.0f%% exceeds %.0f%%", used, check.MaxMemoryUsed))
+			}
+		} else {
+			details = append(details, "Memory: "+formatMiB(stats.memoryUsed)+", no limit")
+		}
+	}
+	if stats.oomKills >= 0 && seen && stats.oomKills > prev.oomKills {
+		problems = append(problems, fmt.Sprintf("%d OOM kills since the last run", stats.oomKills-prev.oomKills))
+	}
+	if stats.periods >= 0 {
+		periods, throttled := stats.periods, stats.throttled
+		if seen && periods >= prev.periods {
+			periods, throttled = periods-prev.periods, throttled-prev.throttled
+		}
+		if periods > 0 {
+			share := float64(throttled) / float64(periods) * 100
+			details = append(details, fmt.Sprintf("CPU throttled: %.0f%% of %d periods", share, periods))
+			if check.MaxCPUThrottled > 0 && share > check.MaxCPUThrottled {
+				problems = append(problems, fmt.Sprintf("CPU throttled %.0f%% exceeds %.0f%%", share, check.MaxCPUThrottled))
+			}
+		}
+	}
+	if stats.pids >= 0 && stats.pidsLimit > 0 {
+		used := float64(stats.pids) / float64(stats.pidsLimit) * 100
+		details = append(details, fmt.Sprintf("Pids: %d of %d", stats.pids, stats.pidsLimit))
+		if check.MaxPidsUsed > 0 && used > check.MaxPidsUsed {
+			problems = append(problems, fmt.Sprintf("pids used %.0f%% exceeds %.0f%%", used, check.MaxPidsUsed))
+		}
+	}
+	if len(details) == 0 {
+		details = append(details, "no limits reported")
+	}
+	if len(problems) > 0 {
+		return checkFailed("Cgroup Name: %s, %s, %s", check.Name, strings.Join(details, ", "), strings.Join(problems, "; "))
+	}
+	return checkOK("Cgroup Name: %s, %s", check.Name, strings.Join(details, ", "))
+}
+
+func formatMiB(bytes int64) string {
+	return fmt.Sprintf("%.0f MiB", float64(bytes)/(1<<20))
+}
+
+// readCgroupStats reads the cgroup at path, or the process's own, from
+// the unified hierarchy when the system uses cgroup v2 and from the memory,
+// cpu and pids hierarchies otherwise.
+func readCgroupStats(path string) (cgroupStats, error) {
+	stats := cgroupStats{memoryUsed: -1, oomKills: -1, periods: -1, pids: -1}
+	own, err := ownCgroups()
+	if err != nil {
+		return stats, err
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		dir := cgroupDir(cgroupRoot, path, own[""])
+		if current, err := readCgroupInt(dir, "memory.current"); err == nil {
+			stats.memoryUsed = current - cgroupStat(dir, "memory.stat", "inactive_file")
+			stats.memoryLimit, _ = readCgroupInt(dir, "memory.max")
+			stats.oomKills = cgroupStat(dir, "memory.events", "oom_kill")
+		}
+		stats.readCPU(dir)
+		stats.readPids(dir)
+		return stats, nil
+	}
+
+	memory := cgroupDir(filepath.Join(cgroupRoot, "memory"), path, own["memory"])
+	if usage, err := readCgroupInt(memory, "memory.usage_in_bytes"); err == nil {
+		stats.memoryUsed = usage - cgroupStat(memory, "memory.stat", "total_inactive_file")
+		stats.memoryLimit, _ = readCgroupInt(memory, "memory.limit_in_bytes")
+		if stats.memoryLimit >= 1<<62 {
+			// v1 reports no limit as the largest page-aligned value.
+			stats.memoryLimit = 0
+		}
+		if _, err := os.Stat(filepath.Join(memory, "memory.oom_control")); err == nil {
+			stats.oomKills = cgroupStat(memory, "memory.oom_control", "oom_kill")
+		}
+	}
+	stats.readCPU(cgroupDir(filepath.Join(cgroupRoot, "cpu"), path, own["cpu"]))
+	stats.readPids(cgroupDir(filepath.Join(cgroupRoot, "pids"), path, own["pids"]))
+	if stats.memoryUsed < 0 && stats.periods < 0 && stats.pids < 0 {
+		return stats, errors.New("no memory, cpu or pids controller found")
+	}
+	return stats, nil
+}
+
+// readCPU reads the throttling counters from cpu.stat, the same in both
+// versions.
+func (s *cgroupStats) readCPU(dir string) {
+	if _, err := os.Stat(filepath.Join(dir, "cpu.stat")); err == nil {
+		s.periods = cgroupStat(dir, "cpu.stat", "nr_periods")
+		s.throttled = cgroupStat(dir, "cpu.stat", "nr_throttled")
+	}
+}
+
+// readPids reads the pids controller, the same in both versions.
+func (s *cgroupStats) readPids(dir string) {
+	if current, err := readCgroupInt(dir, "pids.current"); err == nil {
+		s.pids = current
+		s.pidsLimit, _ = readCgroupInt(dir, "pids.max")
+	}
+}
+
+// ownCgroups returns the process's cgroup paths from /proc/self/cgroup,
+// keyed by v1 controller, with the v2 path under "".
+func ownCgroups() (map[string]string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, nil
+}
+
+// cgroupDir returns the directory of path, or of own when path is empty,
+// under a hierarchy's mount point. Without a cgroup namespace a container
+// sees the host's path for its cgroup but has it mounted at the root, so a
+// path that does not exist falls back to the root.
+func cgroupDir(mount, path, own string) string {
+	if path == "" {
+		path = own
+	}
+	dir := filepath.Join(mount, filepath.Clean("/"+path))
+	if _, err := os.Stat(dir); err != nil && path == own {
+		return mount
+	}
+	return dir
+}
+
+// readCgroupInt reads a file holding one number, where "max" is no limit
+// and reads as zero.
+func readCgroupInt(dir, name string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name)) // #nosec G304 -- dir is under cgroupRoot
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// cgroupStat returns the value of key in a flat keyed file such as
+// memory.stat, or zero when it is not there.
+func cgroupStat(dir, name, key string) int64 {
+	file, err := os.Open(filepath.Join(dir, name)) // #nosec G304 -- dir is under cgroupRoot
+	if err != nil {
+		return 0
+	}
+	defer closeAndLog(file, "cgroup file")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), " "); ok && k == key {
+			n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkChange is a check's last change of status, as /changes reports it.
+type checkChange struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message"`
+	Changed time.Time `json:"changed"`
+}
+
+// changeTracker keeps when each check last changed status, so pollers can
+// ask what changed since they last looked without fetching every check.
+type changeTracker struct {
+	mu      sync.Mutex
+	status  map[string]checkStatus  // last status by override key
+	changes map[string]*checkChange // last change by override key
+}
+
+// checkChanges tracks every check run.
+var checkChanges = &changeTracker{status: map[string]checkStatus{}, changes: map[string]*checkChange{}}
+
+// record notes r's status, recording a change when it differs from the
+// last. The first result of a check only sets the baseline, and skipped
+// and unscheduled runs are not statuses of their own.
+func (t *changeTracker) record(r checkResult) {
+	if r.Skipped || r.NotScheduled {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := overrideKey(r.Type, r.Name)
+	last, ok := t.status[key]
+	t.status[key] = r.Status
+	if ok && last != r.Status {
+		t.changes[key] = &checkChange{Type: r.Type, Name: r.Name, From: last.String(), To: r.Status.String(), Reason: r.Reason, Message: r.Message, Changed: r.Checked}
+	}
+}
+
+// since returns the last change of each of checks made at or after t,
+// oldest first.
+func (t *changeTracker) since(checks []check, since time.Time) []checkChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := []checkChange{}
+	for _, c := range checks {
+		if change, ok := t.changes[overrideKey(c.Type, c.Name)]; ok && !change.Changed.Before(since) {
+			out = append(out, *change)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Changed.Before(out[j].Changed) })
+	return out
+}
+
+// parseSince reads ?since=, an RFC 3339 time or a duration back from now.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("since must be an RFC 3339 time or a duration, such as 10m")
+}
+
+// changesHandler serves GET /changes?since=, the checks whose status last
+// changed in that window.
+func changesHandler(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		since, err := parseSince(r.URL.Query().Get("since"), now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"since":   since,
+			"now":     now,
+			"changes": checkChanges.since(currentChecks(live.current()), since),
+		})
+	}
+}
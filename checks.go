@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkStatus is the outcome of a single check, ordered by severity.
+type checkStatus int
+
+const (
+	statusOK checkStatus = iota
+	statusWarning
+	statusCritical
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case statusOK:
+		return "ok"
+	case statusWarning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// checkResult is the outcome of running a single check.
+type checkResult struct {
+	Type    string
+	Name    string
+	Status  checkStatus
+	Message string
+	Checked time.Time
+	// Reason is why a result that is not ok failed, one of the reason
+	// constants.
+	Reason   string
+	Duration time.Duration
+
+	// Disabled is set for checks excluded from the aggregate through the
+	// admin API; DisabledUntil is zero when the disable has no expiry.
+	Disabled      bool
+	DisabledUntil time.Time
+
+	// Acknowledgement is set while an operator has acknowledged the check.
+	Acknowledgement *acknowledgement
+
+	// Skipped is set when the check did not finish before its request ended.
+	Skipped bool
+
+	// NotScheduled is set when the check was not run, being outside its
+	// active windows.
+	NotScheduled bool
+
+	// Composite names the composite check this one counts towards the
+	// aggregate through, instead of counting directly.
+	Composite string
+
+	// Labels and Annotations are the check's, from its options.
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// Remediation is the last onFailure action run for the check.
+	Remediation *remediationRun
+}
+
+// excluded reports whether r is left out of the aggregate status.
+func (r checkResult) excluded() bool {
+	return r.Disabled || r.NotScheduled || r.Composite != "" || (r.Acknowledgement != nil && r.Acknowledgement.ExcludeFromStatus)
+}
+
+func checkOK(format string, a ...interface{}) checkResult {
+	return checkResult{Status: statusOK, Message: fmt.Sprintf(format, a...)}
+}
+
+func checkWarning(format string, a ...interface{}) checkResult {
+	return checkResult{Status: statusWarning, Message: fmt.Sprintf(format, a...), Reason: errorReason(a)}
+}
+
+func checkFailed(format string, a ...interface{}) checkResult {
+	return checkResult{Status: statusCritical, Message: fmt.Sprintf(format, a...), Reason: errorReason(a)}
+}
+
+// CheckOptions are the settings shared by every check type, inlined into
+// each check's YAML.
+type CheckOptions struct {
+	// Interval overrides config.interval for this check.
+	Interval time.Duration `yaml:"interval"`
+	// Schedule is a cron expression to run this check at instead.
+	Schedule string `yaml:"schedule"`
+	// Windows restricts the check to daily HH:MM-HH:MM spans of local time.
+	Windows []string `yaml:"activeWindows"`
+	// SourceAddress overrides config.sourceAddress for this check.
+	SourceAddress string `yaml:"sourceAddress"`
+	// Invert makes the check pass when it would fail and fail when it
+	// would pass, to assert something is not reachable or running.
+	Invert bool `yaml:"invert"`
+	// RiseCount is how many runs in a row must pass before a critical
+	// check recovers, and FallCount how many must be critical before a
+	// passing check turns critical.
+	RiseCount int `yaml:"riseCount"`
+	FallCount int `yaml:"fallCount"`
+	// Labels, such as team or severity, are passed with every result, to
+	// the JSON response, Prometheus and the exporters that take tags, so
+	// consumers can route on them. Annotations, such as a runbook URL, only
+	// go to the JSON response.
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	// Hint, a next step for whoever is on call, and Runbook, a link to the
+	// procedure, are added to the message of a failing result.
+	Hint    string `yaml:"hint"`
+	Runbook string `yaml:"runbook"`
+	// OnFailure is an action to run when the check keeps failing.
+	OnFailure *RemediationConfig `yaml:"onFailure"`
+	// OnlyIf limits the check to the hosts meeting its conditions.
+	OnlyIf *HostConstraint `yaml:"onlyIf"`
+}
+
+// check is a single configured check, ready to run.
+type check struct {
+	Type    string
+	Name    string
+	Run     func(context.Context) checkResult
+	Options CheckOptions
+}
+
+// checks returns every configured check in reporting order.
+func (c *Config) checks() []check {
+	var checks []check
+	for _, port := range c.Ports {
+		for _, p := range port.targets() {
+			checks = append(checks, check{Type: "port", Name: p.Name, Run: p.run, Options: p.CheckOptions})
+		}
+	}
+	for _, s := range c.Services {
+		checks = append(checks, check{Type: "service", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, endpoint := range c.Endpoints {
+		for _, e := range endpoint.targets() {
+			checks = append(checks, check{Type: "endpoint", Name: e.Name, Run: e.run, Options: e.CheckOptions})
+		}
+	}
+	for _, k := range c.Kubernetes {
+		checks = append(checks, check{Type: "kubernetes", Name: k.Name, Run: k.run, Options: k.CheckOptions})
+	}
+	for _, j := range c.Journald {
+		checks = append(checks, check{Type: "journald", Name: j.Name, Run: j.run, Options: j.CheckOptions})
+	}
+	for _, l := range c.Logs {
+		checks = append(checks, check{Type: "logs", Name: l.Name, Run: l.run, Options: l.CheckOptions})
+	}
+	for _, i := range c.Interfaces {
+		checks = append(checks, check{Type: "interface", Name: i.Name, Run: i.run, Options: i.CheckOptions})
+	}
+	for _, g := range c.Gateways {
+		checks = append(checks, check{Type: "gateway", Name: g.Name, Run: g.run, Options: g.CheckOptions})
+	}
+	for _, f := range c.Firewall {
+		checks = append(checks, check{Type: "firewall", Name: f.Name, Run: f.run, Options: f.CheckOptions})
+	}
+	for _, s := range c.SecurityModules {
+		checks = append(checks, check{Type: "securityModule", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, s := range c.Sysctl {
+		checks = append(checks, check{Type: "sysctl", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, p := range c.Packages {
+		checks = append(checks, check{Type: "package", Name: p.Name, Run: p.run, Options: p.CheckOptions})
+	}
+	for _, r := range c.RebootRequired {
+		checks = append(checks, check{Type: "rebootRequired", Name: r.Name, Run: r.run, Options: r.CheckOptions})
+	}
+	for _, t := range c.Temperatures {
+		checks = append(checks, check{Type: "temperature", Name: t.Name, Run: t.run, Options: t.CheckOptions})
+	}
+	for _, g := range c.GPUs {
+		checks = append(checks, check{Type: "gpu", Name: g.Name, Run: g.run, Options: g.CheckOptions})
+	}
+	for _, z := range c.ZFS {
+		checks = append(checks, check{Type: "zfs", Name: z.Name, Run: z.run, Options: z.CheckOptions})
+	}
+	for _, u := range c.UPS {
+		checks = append(checks, check{Type: "ups", Name: u.Name, Run: u.run, Options: u.CheckOptions})
+	}
+	for _, s := range c.SMTP {
+		checks = append(checks, check{Type: "smtp", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, l := range c.LDAP {
+		checks = append(checks, check{Type: "ldap", Name: l.Name, Run: l.run, Options: l.CheckOptions})
+	}
+	for _, k := range c.Kafka {
+		checks = append(checks, check{Type: "kafka", Name: k.Name, Run: k.run, Options: k.CheckOptions})
+	}
+	for _, a := range c.AMQP {
+		checks = append(checks, check{Type: "amqp", Name: a.Name, Run: a.run, Options: a.CheckOptions})
+	}
+	for _, e := range c.Elasticsearch {
+		checks = append(checks, check{Type: "elasticsearch", Name: e.Name, Run: e.run, Options: e.CheckOptions})
+	}
+	for _, e := range c.Etcd {
+		checks = append(checks, check{Type: "etcd", Name: e.Name, Run: e.run, Options: e.CheckOptions})
+	}
+	for _, v := range c.Vault {
+		checks = append(checks, check{Type: "vault", Name: v.Name, Run: v.run, Options: v.CheckOptions})
+	}
+	for _, m := range c.MQTT {
+		checks = append(checks, check{Type: "mqtt", Name: m.Name, Run: m.run, Options: m.CheckOptions})
+	}
+	for _, g := range c.GRPC {
+		checks = append(checks, check{Type: "grpc", Name: g.Name, Run: g.run, Options: g.CheckOptions})
+	}
+	for _, w := range c.WebSockets {
+		checks = append(checks, check{Type: "websocket", Name: w.Name, Run: w.run, Options: w.CheckOptions})
+	}
+	for _, s := range c.SSH {
+		checks = append(checks, check{Type: "ssh", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, f := range c.FTP {
+		checks = append(checks, check{Type: "ftp", Name: f.Name, Run: f.run, Options: f.CheckOptions})
+	}
+	for _, s := range c.Shares {
+		checks = append(checks, check{Type: "share", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, s := range c.S3 {
+		checks = append(checks, check{Type: "s3", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, d := range c.Disks {
+		checks = append(checks, check{Type: "disk", Name: d.Name, Run: d.run, Options: d.CheckOptions})
+	}
+	for _, cg := range c.Cgroups {
+		checks = append(checks, check{Type: "cgroup", Name: cg.Name, Run: cg.run, Options: cg.CheckOptions})
+	}
+	for _, p := range c.PerfCounters {
+		checks = append(checks, check{Type: "perfcounter", Name: p.Name, Run: p.run, Options: p.CheckOptions})
+	}
+	for _, e := range c.EventLogs {
+		checks = append(checks, check{Type: "eventlog", Name: e.Name, Run: e.run, Options: e.CheckOptions})
+	}
+	for _, p := range c.Processes {
+		checks = append(checks, check{Type: "process", Name: p.Name, Run: p.run, Options: p.CheckOptions})
+	}
+	for _, t := range c.Transactions {
+		checks = append(checks, check{Type: "transaction", Name: t.Name, Run: t.run, Options: t.CheckOptions})
+	}
+	for _, t := range c.Throughput {
+		checks = append(checks, check{Type: "throughput", Name: t.Name, Run: t.run, Options: t.CheckOptions})
+	}
+	for _, s := range c.Sockets {
+		checks = append(checks, check{Type: "sockets", Name: s.Name, Run: s.run, Options: s.CheckOptions})
+	}
+	for _, e := range c.Entropy {
+		checks = append(checks, check{Type: "entropy", Name: e.Name, Run: e.run, Options: e.CheckOptions})
+	}
+	for _, f := range c.FileDescriptors {
+		checks = append(checks, check{Type: "filedescriptors", Name: f.Name, Run: f.run, Options: f.CheckOptions})
+	}
+	for _, r := range c.Runaways {
+		checks = append(checks, check{Type: "runaway", Name: r.Name, Run: r.run, Options: r.CheckOptions})
+	}
+	for _, j := range c.CronJobs {
+		checks = append(checks, check{Type: "cronjob", Name: j.Name, Run: j.run, Options: j.CheckOptions})
+	}
+	for _, b := range c.Backups {
+		checks = append(checks, check{Type: "backup", Name: b.Name, Run: b.run, Options: b.CheckOptions})
+	}
+	for _, p := range c.PHPFPM {
+		checks = append(checks, check{Type: "phpfpm", Name: p.Name, Run: p.run, Options: p.CheckOptions})
+	}
+	for _, u := range c.Upstreams {
+		checks = append(checks, check{Type: "upstream", Name: u.Name, Run: u.run, Options: u.CheckOptions})
+	}
+	return checks
+}
+
+// runChecks runs every check concurrently, within checkLimits, and returns
+// their results in order, passing them to checkExporters. ctx is passed to
+// each check; checks still waiting or running when it ends are reported as
+// skipped rather than waited for.
+func runChecks(ctx context.Context, checks []check) []checkResult {
+	pending := make([]chan checkResult, len(checks))
+	for i, c := range checks {
+		pending[i] = make(chan checkResult, 1)
+		go func() {
+			if !c.Options.activeAt(time.Now()) {
+				pending[i] <- checkNotScheduled()
+				return
+			}
+			id := checkActivity.queued(c)
+			defer checkActivity.done(id)
+			release, err := checkLimits.acquire(ctx, c.Type)
+			if err != nil {
+				return
+			}
+			defer release()
+			checkActivity.running(id)
+			runCtx := ctx
+			if c.Options.SourceAddress != "" {
+				runCtx = withSource(ctx, c.Options.SourceAddress)
+			}
+			start := time.Now()
+			result := c.Run(runCtx)
+			result.Duration = time.Since(start)
+			pending[i] <- result
+		}()
+	}
+	results := make([]checkResult, len(checks))
+	for i, c := range checks {
+		var result checkResult
+		select {
+		case result = <-pending[i]:
+			// A check failing once ctx has ended most likely failed because of it.
+			if ctx.Err() != nil && result.Status != statusOK {
+				result = checkSkipped(ctx)
+			} else if c.Options.Invert && !result.NotScheduled {
+				result = result.inverted()
+			}
+			if !result.Skipped && !result.NotScheduled {
+				result = adminOverrides.faulted(c, result)
+			}
+			result = checkHysteresis.apply(c, result)
+			if result.Status != statusOK {
+				result = result.guided(c.Options)
+			}
+			result = checkRemediations.apply(c, result)
+		case <-ctx.Done():
+			result = checkSkipped(ctx)
+		}
+		switch {
+		case result.Status == statusOK:
+			result.Reason = ""
+		case result.Reason == "":
+			result.Reason = reasonFailed
+		}
+		result.Type, result.Name, result.Checked = c.Type, c.Name, time.Now()
+		result.Labels, result.Annotations = c.Options.Labels, c.Options.Annotations
+		checkChanges.record(result)
+		results[i] = result
+	}
+	checkActivity.finished(results)
+	exportResults(results)
+	return results
+}
+
+// inverted turns a passing result critical and a critical one passing,
+// noting it in the message. Warnings are left as they are.
+func (r checkResult) inverted() checkResult {
+	switch r.Status {
+	case statusOK:
+		r.Status, r.Reason = statusCritical, reasonUnexpectedSuccess
+		r.Message += " (expected to fail)"
+	case statusCritical:
+		r.Status = statusOK
+		r.Message += " (failing as expected)"
+	}
+	return r
+}
+
+// guided adds the check's hint and runbook to the message of a failing r.
+func (r checkResult) guided(o CheckOptions) checkResult {
+	if o.Hint != "" {
+		r.Message += ", Hint: " + o.Hint
+	}
+	if o.Runbook != "" {
+		r.Message += ", Runbook: " + o.Runbook
+	}
+	return r
+}
+
+func checkSkipped(ctx context.Context) checkResult {
+	reason, cause := "the request was cancelled", reasonCancelled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason, cause = "the request timed out", reasonTimeout
+	}
+	result := checkFailed("Check skipped: %s", reason)
+	result.Skipped, result.Reason = true, cause
+	return result
+}
+
+// checkReport is the JSON form of a checkResult in the /healthy response.
+type checkReport struct {
+	Type          string     `json:"type"`
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Message       string     `json:"message"`
+	Reason        string     `json:"reason,omitempty"`
+	LastChecked   time.Time  `json:"lastChecked"`
+	Age           float64    `json:"age"`
+	Disabled      bool       `json:"disabled,omitempty"`
+	DisabledUntil *time.Time `json:"disabledUntil,omitempty"`
+
+	Acknowledgement *acknowledgement  `json:"acknowledgement,omitempty"`
+	Skipped         bool              `json:"skipped,omitempty"`
+	NotScheduled    bool              `json:"notScheduled,omitempty"`
+	Composite       string            `json:"composite,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	Remediation     *remediationRun   `json:"remediation,omitempty"`
+}
+
+// reports converts results for the response, with each result's age in
+// seconds as of now.
+func reports(results []checkResult, now time.Time) []checkReport {
+	out := make([]checkReport, 0, len(results))
+	for _, r := range results {
+		report := checkReport{
+			Type:            r.Type,
+			Name:            r.Name,
+			Status:          r.Status.String(),
+			Message:         r.Message,
+			Reason:          r.Reason,
+			LastChecked:     r.Checked,
+			Age:             now.Sub(r.Checked).Round(time.Millisecond).Seconds(),
+			Disabled:        r.Disabled,
+			Acknowledgement: r.Acknowledgement,
+			Skipped:         r.Skipped,
+			NotScheduled:    r.NotScheduled,
+			Composite:       r.Composite,
+			Labels:          r.Labels,
+			Annotations:     r.Annotations,
+			Remediation:     r.Remediation,
+		}
+		if !r.DisabledUntil.IsZero() {
+			until := r.DisabledUntil
+			report.DisabledUntil = &until
+		}
+		out = append(out, report)
+	}
+	return out
+}
+
+// labelPattern is what label names must match, as Prometheus requires.
+var labelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLabels checks label names suit Prometheus and do not clash with
+// the type and name every result is labelled with.
+func validateLabels(labels map[string]string) error {
+	for _, k := range sortedKeys(labels) {
+		if !labelPattern.MatchString(k) || strings.HasPrefix(k, "__") {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+		if k == "type" || k == "name" {
+			return fmt.Errorf("label %s is reserved", k)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// worstStatus returns the most severe status among results, ignoring
+// disabled and unscheduled checks, those covered by a composite and
+// acknowledgements that exclude theirs.
+func worstStatus(results []checkResult) checkStatus {
+	worst := statusOK
+	for _, r := range results {
+		if !r.excluded() && r.Status > worst {
+			worst = r.Status
+		}
+	}
+	return worst
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatchConfig publishes every check run's results to AWS CloudWatch
+// as custom metrics in Namespace, with each check's type and name, and
+// Dimensions, as dimensions. Credentials fall back from the configured keys
+// to the AWS_* environment variables and then to the EC2 instance's IAM
+// role; Region falls back to AWS_REGION and then to the instance's region.
+type CloudWatchConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	Region          string            `yaml:"region"`
+	Endpoint        string            `yaml:"endpoint"`
+	Namespace       string            `yaml:"namespace"`
+	Dimensions      map[string]string `yaml:"dimensions"`
+	AccessKeyID     string            `yaml:"accessKeyID"`
+	SecretAccessKey string            `yaml:"secretAccessKey"`
+	SessionToken    string            `yaml:"sessionToken"`
+}
+
+// cloudWatchMaxDimensions is CloudWatch's limit per metric, less the check
+// type and name.
+const cloudWatchMaxDimensions = 30 - 2
+
+// cloudWatchBatch is how many checks go in one PutMetricData call, each
+// adding two of the 1000 metrics allowed per call.
+const cloudWatchBatch = 500
+
+// Validate checks the endpoint and dimensions.
+func (c *CloudWatchConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("cloudwatch: endpoint must be an http:// or https:// URL")
+		}
+	}
+	if len(c.Dimensions) > cloudWatchMaxDimensions {
+		return fmt.Errorf("cloudwatch: at most %d dimensions can be set", cloudWatchMaxDimensions)
+	}
+	for name, value := range c.Dimensions {
+		if name == "Type" || name == "Name" {
+			return fmt.Errorf("cloudwatch: dimension %s is set for each check", name)
+		}
+		if value == "" {
+			return fmt.Errorf("cloudwatch: dimension %s must have a value", name)
+		}
+	}
+	return nil
+}
+
+// cloudWatchExporter puts results to the CloudWatch PutMetricData API.
+type cloudWatchExporter struct {
+	cfg        CloudWatchConfig
+	dimensions []string
+	creds      *awsCredentialSource
+
+	mu     sync.Mutex
+	region string
+}
+
+func newCloudWatch(cfg CloudWatchConfig) *cloudWatchExporter {
+	e := &cloudWatchExporter{
+		cfg:    cfg,
+		creds:  &awsCredentialSource{static: awsCredentials{cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken}},
+		region: cfg.Region,
+	}
+	if e.cfg.Namespace == "" {
+		e.cfg.Namespace = "ServerHealth"
+	}
+	if e.region == "" {
+		e.region = os.Getenv("AWS_REGION")
+	}
+	for name := range cfg.Dimensions {
+		e.dimensions = append(e.dimensions, name)
+	}
+	sort.Strings(e.dimensions)
+	return e
+}
+
+// export puts a CheckStatus metric (0 ok, 1 warning, 2 critical) and a
+// CheckDuration metric in milliseconds for each check.
+func (e *cloudWatchExporter) export(results []checkResult) {
+	for start := 0; start < len(results); start += cloudWatchBatch {
+		batch := results[start:min(start+cloudWatchBatch, len(results))]
+		form := e.metricData(batch)
+		exportInBackground("cloudwatch", func() error { return e.put(form) })
+	}
+}
+
+// metricData encodes results as a PutMetricData request in the AWS query
+// protocol.
+func (e *cloudWatchExporter) metricData(results []checkResult) url.Values {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {e.cfg.Namespace},
+	}
+	n := 0
+	for _, r := range results {
+		dimensions := [][2]string{{"Type", r.Type}, {"Name", r.Name}}
+		for _, name := range e.dimensions {
+			dimensions = append(dimensions, [2]string{name, e.cfg.Dimensions[name]})
+		}
+		metrics := []struct {
+			name, unit string
+			value      float64
+		}{
+			{"CheckStatus", "None", float64(r.Status)},
+			{"CheckDuration", "Milliseconds", milliseconds(r.Duration)},
+		}
+		for _, m := range metrics {
+			n++
+			prefix := "MetricData.member." + strconv.Itoa(n) + "."
+			form.Set(prefix+"MetricName", m.name)
+			form.Set(prefix+"Unit", m.unit)
+			form.Set(prefix+"Value", strconv.FormatFloat(m.value, 'f', -1, 64))
+			form.Set(prefix+"Timestamp", r.Checked.UTC().Format(time.RFC3339))
+			for i, d := range dimensions {
+				dim := prefix + "Dimensions.member." + strconv.Itoa(i+1) + "."
+				form.Set(dim+"Name", d[0])
+				form.Set(dim+"Value", d[1])
+			}
+		}
+	}
+	return form
+}
+
+func (e *cloudWatchExporter) put(form url.Values) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	region, err := e.regionName(ctx)
+	if err != nil {
+		return err
+	}
+	creds, err := e.creds.get(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := e.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://monitoring." + region + ".amazonaws.com"
+	}
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	hash := sha256.Sum256([]byte(body))
+	signAWSRequest(req, "monitoring", region, hex.EncodeToString(hash[:]), creds, time.Now().UTC())
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PutMetricData returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// regionName returns the configured region, asking the instance metadata
+// service the first time when none is configured.
+func (e *cloudWatchExporter) regionName(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.region == "" {
+		region, err := imdsGet(ctx, "placement/region")
+		if err != nil {
+			return "", fmt.Errorf("no region configured and none from instance metadata: %w", err)
+		}
+		e.region = strings.TrimSpace(region)
+	}
+	return e.region, nil
+}
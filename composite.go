@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CompositeCheck derives one result from other checks: passing when all of
+// them pass (operator "and"), any of them (operator "or") or at least
+// AtLeast of them. Checks are named as type/name, or by name alone when it
+// is unique. The checks it covers are still reported, but count towards the
+// overall status only through the composite.
+type CompositeCheck struct {
+	Name     string   `yaml:"name"`
+	Checks   []string `yaml:"checks"`
+	Operator string   `yaml:"operator"`
+	AtLeast  int      `yaml:"atLeast"`
+}
+
+// Validate checks the operator and that every member names one check.
+func (c *CompositeCheck) Validate(checks []check) error {
+	switch {
+	case c.Operator != "" && c.AtLeast != 0:
+		return fmt.Errorf("composite check %s: operator and atLeast cannot both be set", c.Name)
+	case c.Operator == "" && c.AtLeast == 0:
+		return fmt.Errorf("composite check %s: operator or atLeast is required", c.Name)
+	case c.Operator != "" && c.Operator != "and" && c.Operator != "or":
+		return fmt.Errorf("composite check %s: operator must be and or or", c.Name)
+	case c.AtLeast < 0 || c.AtLeast > len(c.Checks):
+		return fmt.Errorf("composite check %s: atLeast must be between 1 and the number of checks", c.Name)
+	}
+	if len(c.Checks) == 0 {
+		return fmt.Errorf("composite check %s: checks are required", c.Name)
+	}
+	if _, err := c.members(checks); err != nil {
+		return fmt.Errorf("composite check %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// members resolves Checks against checks, returning their override keys.
+func (c *CompositeCheck) members(checks []check) ([]string, error) {
+	return resolveCheckRefs(c.Checks, checks)
+}
+
+// resolveCheckRefs resolves references to checks, as type/name or a name
+// that is unique, returning their override keys.
+func resolveCheckRefs(refs []string, checks []check) ([]string, error) {
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		var matches []string
+		for _, chk := range checks {
+			if key := overrideKey(chk.Type, chk.Name); ref == key || ref == chk.Name {
+				matches = append(matches, key)
+			}
+		}
+		switch {
+		case len(matches) == 0:
+			return nil, fmt.Errorf("no check matches %s", ref)
+		case len(matches) > 1 && !strings.Contains(ref, "/"):
+			return nil, fmt.Errorf("%s matches more than one check, use type/name", ref)
+		}
+		keys = append(keys, matches[0])
+	}
+	return keys, nil
+}
+
+// applyComposites appends a result for each composite, worked out from the
+// results of its members, and marks the members as covered by it. Members
+// left out of the aggregate, such as disabled ones, are not counted.
+func applyComposites(composites []CompositeCheck, checks []check, results []checkResult) []checkResult {
+	index := map[string]int{}
+	for i, r := range results {
+		index[overrideKey(r.Type, r.Name)] = i
+	}
+	covered := map[int]string{}
+	var derived []checkResult
+	for _, comp := range composites {
+		keys, err := comp.members(checks)
+		if err != nil {
+			continue // checked by Validate
+		}
+		var counted, passing int
+		var failing []string
+		var degraded bool
+		var newest time.Time
+		for _, key := range keys {
+			i, ok := index[key]
+			if !ok {
+				continue
+			}
+			if _, seen := covered[i]; !seen {
+				covered[i] = comp.Name
+			}
+			r := results[i]
+			if r.excluded() {
+				continue
+			}
+			counted++
+			if r.Checked.After(newest) {
+				newest = r.Checked
+			}
+			if r.Status == statusCritical {
+				failing = append(failing, key)
+				continue
+			}
+			passing++
+			degraded = degraded || r.Status != statusOK
+		}
+
+		if newest.IsZero() {
+			newest = time.Now()
+		}
+		need := comp.AtLeast
+		switch comp.Operator {
+		case "and":
+			need = counted
+		case "or":
+			need = min(1, counted)
+		}
+		summary := fmt.Sprintf("Composite Name: %s, %d of %d checks passing, need %d", comp.Name, passing, counted, need)
+		if len(failing) > 0 {
+			summary += ", failing: " + strings.Join(failing, ", ")
+		}
+		result := checkOK("%s", summary)
+		switch {
+		case passing < need:
+			result.Status = statusCritical
+		case degraded || len(failing) > 0:
+			result.Status = statusWarning
+		}
+		result.Type, result.Name, result.Checked = "composite", comp.Name, newest
+		derived = append(derived, result)
+	}
+	for i, name := range covered {
+		results[i].Composite = name
+	}
+	return append(results, derived...)
+}
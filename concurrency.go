@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ConcurrencyConfig bounds how many checks run at once, across every caller:
+// the scheduler, /healthy requests, Consul updates and the admin API.
+type ConcurrencyConfig struct {
+	Max     int            `yaml:"max"`
+	PerType map[string]int `yaml:"perType"`
+}
+
+// Validate checks the limits are not negative.
+func (c *ConcurrencyConfig) Validate() error {
+	if c.Max < 0 {
+		return fmt.Errorf("concurrency: max must not be negative")
+	}
+	for typ, n := range c.PerType {
+		if n < 0 {
+			return fmt.Errorf("concurrency: limit for %s must not be negative", typ)
+		}
+	}
+	return nil
+}
+
+// checkLimiter holds a semaphore for all checks and one per limited type.
+// A nil semaphore is unlimited.
+type checkLimiter struct {
+	all     chan struct{}
+	perType map[string]chan struct{}
+}
+
+// checkLimits applies to every check run; main sets it from the config
+// before any check runs.
+var checkLimits = &checkLimiter{}
+
+func newCheckLimiter(cfg ConcurrencyConfig) *checkLimiter {
+	l := &checkLimiter{perType: map[string]chan struct{}{}}
+	if cfg.Max > 0 {
+		l.all = make(chan struct{}, cfg.Max)
+	}
+	for typ, n := range cfg.PerType {
+		if n > 0 {
+			l.perType[typ] = make(chan struct{}, n)
+		}
+	}
+	return l
+}
+
+// acquire blocks until a check of type typ may run or ctx ends, returning
+// the function that releases its slots. The type's slot is taken first so a
+// check waiting on its type does not hold one of the overall slots.
+func (l *checkLimiter) acquire(ctx context.Context, typ string) (func(), error) {
+	typeSem := l.perType[typ]
+	if typeSem != nil {
+		select {
+		case typeSem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if l.all != nil {
+		select {
+		case l.all <- struct{}{}:
+		case <-ctx.Done():
+			if typeSem != nil {
+				<-typeSem
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		if l.all != nil {
+			<-l.all
+		}
+		if typeSem != nil {
+			<-typeSem
+		}
+	}, nil
+}
+
+// slotUsage is how many of a semaphore's slots are taken.
+type slotUsage struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+}
+
+// usage reports the slots taken overall, under "all", and for each limited
+// type. Unlimited semaphores are left out.
+func (l *checkLimiter) usage() map[string]slotUsage {
+	usage := map[string]slotUsage{}
+	if l.all != nil {
+		usage["all"] = slotUsage{Used: len(l.all), Limit: cap(l.all)}
+	}
+	for typ, sem := range l.perType {
+		usage[typ] = slotUsage{Used: len(sem), Limit: cap(sem)}
+	}
+	return usage
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envPrefix starts the environment variables that set config fields.
+const envPrefix = "HEALTH_"
+
+// maxEnvIndex bounds list indexes in overrides, so a stray variable cannot
+// allocate a huge list.
+const maxEnvIndex = 1000
+
+// configSettings are the -set flags, applied over the file and the
+// environment; main sets them.
+var configSettings settingFlags
+
+// settingFlags collects repeated -set path=value flags.
+type settingFlags []string
+
+func (s *settingFlags) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *settingFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("must be path=value, such as config.auth.username=admin")
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// applyConfigOverrides sets fields of config from the environment and then
+// from configSettings, so flags win over variables and both over the file,
+// and records where each came from for /config. Variables are named
+// HEALTH_ and the path in upper snake case, with fields under config taken
+// from the top, as in HEALTH_AUTH_USERNAME and HEALTH_ENDPOINTS_0_URL.
+// Flags name the path as in the file, as in config.auth.username.
+// Variables that name no field, such as those Kubernetes adds for a
+// Service called health, are logged and ignored.
+func applyConfigOverrides(config *Config) error {
+	config.sources = map[string]string{}
+	var names []string
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, envPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	root := reflect.ValueOf(config).Elem()
+	for _, name := range names {
+		value := os.Getenv(name)
+		rest := strings.TrimPrefix(name, envPrefix)
+		path, ok, err := setEnvField(root.Field(0), rest, value)
+		if err == nil && !ok {
+			path, ok, err = setEnvField(root, rest, value)
+		} else if ok {
+			path = "config." + path
+		}
+		switch {
+		case err != nil:
+			return fmt.Errorf("%s: %w", name, err)
+		case !ok:
+			log.Printf("Ignoring %s: not a config field", name)
+		default:
+			config.sources[path] = name
+		}
+	}
+	for _, setting := range configSettings {
+		path, value, _ := strings.Cut(setting, "=")
+		if err := setPathField(root, strings.Split(path, "."), value); err != nil {
+			return fmt.Errorf("-set %s: %w", path, err)
+		}
+		config.sources[path] = "-set"
+	}
+	return nil
+}
+
+// envName converts a YAML key to its part of a variable name: requestTimeout
+// becomes REQUEST_TIMEOUT and maxCPUThrottled MAX_CPU_THROTTLED.
+func envName(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// yamlFields calls fn with each field of the struct v and its YAML key,
+// descending into inline fields, until fn returns true.
+func yamlFields(v reflect.Value, fn func(key string, field reflect.Value) bool) bool {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch {
+		case key == "-":
+			continue
+		case opts == "inline":
+			if yamlFields(v.Field(i), fn) {
+				return true
+			}
+			continue
+		case key == "":
+			key = strings.ToLower(field.Name)
+		}
+		if fn(key, v.Field(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setEnvField sets the field of v that rest, the rest of a variable's
+// name, leads to, trying each field whose name rest starts with, since
+// names such as VAULT_ADDRESS and VAULT_0_NAME say where they go only
+// further on. It returns the field's path and whether one was found.
+func setEnvField(v reflect.Value, rest, value string) (string, bool, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			elem.Elem().Set(v.Elem())
+		}
+		path, ok, err := setEnvField(elem.Elem(), rest, value)
+		if ok && err == nil {
+			v.Set(elem)
+		}
+		return path, ok, err
+	case reflect.Struct:
+		var path string
+		var found bool
+		var err error
+		yamlFields(v, func(key string, field reflect.Value) bool {
+			name := envName(key)
+			if rest == name {
+				path, found, err = key, true, setLeaf(field, value)
+				return true
+			}
+			if !strings.HasPrefix(rest, name+"_") {
+				return false
+			}
+			var sub string
+			sub, found, err = setEnvField(field, strings.TrimPrefix(rest, name+"_"), value)
+			path = key + "." + sub
+			return found || err != nil
+		})
+		return path, found, err
+	case reflect.Slice:
+		index, sub, _ := strings.Cut(rest, "_")
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= maxEnvIndex {
+			return "", false, nil
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if i < v.Len() {
+			elem.Set(v.Index(i))
+		}
+		var path string
+		var ok bool
+		if sub == "" {
+			ok, err = true, setLeaf(elem, value)
+		} else {
+			path, ok, err = setEnvField(elem, sub, value)
+			path = "." + path
+		}
+		if ok && err == nil {
+			setIndex(v, i, elem)
+		}
+		return index + path, ok, err
+	default:
+		return "", false, nil
+	}
+}
+
+// setPathField sets the field of v at path, YAML keys and list indexes.
+func setPathField(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 || path[0] == "" {
+		return setLeaf(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			elem.Elem().Set(v.Elem())
+		}
+		if err := setPathField(elem.Elem(), path, value); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Struct:
+		var err error
+		found := yamlFields(v, func(key string, field reflect.Value) bool {
+			if key != path[0] {
+				return false
+			}
+			err = setPathField(field, path[1:], value)
+			return true
+		})
+		if !found {
+			return fmt.Errorf("unknown field %q", path[0])
+		}
+		return err
+	case reflect.Slice:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= maxEnvIndex {
+			return fmt.Errorf("invalid list index %q", path[0])
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if i < v.Len() {
+			elem.Set(v.Index(i))
+		}
+		if err := setPathField(elem, path[1:], value); err != nil {
+			return err
+		}
+		setIndex(v, i, elem)
+		return nil
+	default:
+		return fmt.Errorf("%q is not a field", path[0])
+	}
+}
+
+// setIndex sets element i of the slice v, growing it as needed.
+func setIndex(v reflect.Value, i int, elem reflect.Value) {
+	if i >= v.Len() {
+		v.Set(reflect.AppendSlice(v, reflect.MakeSlice(v.Type(), i+1-v.Len(), i+1-v.Len())))
+	}
+	v.Index(i).Set(elem)
+}
+
+// setLeaf sets v from value: strings as they are, so passwords need no
+// quoting, and anything else decoded as YAML, as in 10s, true or
+// [nft, journalctl].
+func setLeaf(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	decoded := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(value), decoded.Interface()); err != nil {
+		return err
+	}
+	v.Set(decoded.Elem())
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+// HostConstraint limits a check to the hosts it applies to, so one config
+// can be shipped to a whole fleet. OS and Arch are as Go names them, such
+// as linux or windows and amd64 or arm64, and Hostname is a shell pattern,
+// such as db-*. RoleFile is a file that must exist, such as one dropped by
+// the provisioning of a database server. Every condition set must hold; a
+// check whose conditions do not is not run or reported on this host.
+type HostConstraint struct {
+	OS       string `yaml:"os"`
+	Arch     string `yaml:"arch"`
+	Hostname string `yaml:"hostname"`
+	RoleFile string `yaml:"roleFile"`
+}
+
+// Validate checks the hostname pattern and role file path.
+func (h *HostConstraint) Validate() error {
+	if h.Hostname != "" {
+		if _, err := path.Match(h.Hostname, ""); err != nil {
+			return fmt.Errorf("onlyIf: invalid hostname pattern %q", h.Hostname)
+		}
+	}
+	if h.RoleFile != "" && !filepath.IsAbs(h.RoleFile) {
+		return fmt.Errorf("onlyIf: roleFile must be an absolute path")
+	}
+	return nil
+}
+
+// applies reports whether the host meets every condition. The role file is
+// looked for each time, so a host given a role later picks its checks up
+// without a reload.
+func (h *HostConstraint) applies() bool {
+	if h == nil {
+		return true
+	}
+	if h.OS != "" && h.OS != runtime.GOOS {
+		return false
+	}
+	if h.Arch != "" && h.Arch != runtime.GOARCH {
+		return false
+	}
+	if h.Hostname != "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return false
+		}
+		if ok, _ := path.Match(h.Hostname, hostname); !ok {
+			return false
+		}
+	}
+	if h.RoleFile != "" {
+		if _, err := os.Stat(h.RoleFile); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// applicable returns the checks whose constraints this host meets.
+func applicable(checks []check) []check {
+	out := make([]check, 0, len(checks))
+	for _, c := range checks {
+		if c.Options.OnlyIf.applies() {
+			out = append(out, c)
+		}
+	}
+	return out
+}
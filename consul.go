@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConsulConfig registers the API as a service with a local Consul agent,
+// with one TTL check per configured check. The checks are run every
+// Interval and their results pushed to the agent, so Consul reports the
+// same health as /healthy.
+type ConsulConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Address     string        `yaml:"address"`
+	Token       string        `yaml:"token"`
+	ServiceName string        `yaml:"serviceName"`
+	ServiceID   string        `yaml:"serviceID"`
+	Tags        []string      `yaml:"tags"`
+	Interval    time.Duration `yaml:"interval"`
+	TTL         time.Duration `yaml:"ttl"`
+}
+
+// Validate checks the agent address and timings.
+func (c *ConsulConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Address != "" {
+		u, err := url.Parse(c.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("consul: address must be an http:// or https:// URL")
+		}
+	}
+	if c.Interval < 0 || c.TTL < 0 {
+		return fmt.Errorf("consul: interval and ttl must not be negative")
+	}
+	if c.TTL != 0 && c.TTL <= c.Interval {
+		return fmt.Errorf("consul: ttl must be longer than interval")
+	}
+	return nil
+}
+
+type consulAgent struct {
+	address   string
+	token     string
+	serviceID string
+	name      string
+	tags      []string
+	port      int
+	ttl       time.Duration
+	client    *http.Client
+}
+
+// startConsul registers the service and its checks and starts updating
+// their TTLs, registering again whenever the set returned by checks
+// changes. The returned function stops the updates and deregisters the
+// service.
+func startConsul(config *Config, checks func() []check, port int) (func(), error) {
+	cfg := config.Config.Consul
+	agent := &consulAgent{
+		address:   strings.TrimSuffix(cfg.Address, "/"),
+		token:     cfg.Token,
+		serviceID: cfg.ServiceID,
+		client:    exportClient,
+		port:      port,
+		tags:      cfg.Tags,
+	}
+	if agent.address == "" {
+		agent.address = "http://127.0.0.1:8500"
+	}
+	agent.name = cfg.ServiceName
+	if agent.name == "" {
+		agent.name = "server-health-api"
+	}
+	if agent.serviceID == "" {
+		agent.serviceID = agent.name
+	}
+	interval := cfg.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	agent.ttl = cfg.TTL
+	if agent.ttl == 0 {
+		agent.ttl = 3 * interval
+	}
+
+	registered := checks()
+	if err := agent.register(registered); err != nil {
+		return nil, fmt.Errorf("registering with consul: %w", err)
+	}
+	log.Printf("Registered service %s with consul agent at %s", agent.serviceID, agent.address)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if current := checks(); !sameChecks(current, registered) {
+				if err := agent.register(current); err != nil {
+					log.Printf("Failed to update consul registration: %v", err)
+				} else {
+					registered = current
+				}
+			}
+			agent.update(registered)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		if err := agent.put("/v1/agent/service/deregister/"+url.PathEscape(agent.serviceID), nil); err != nil {
+			log.Printf("Failed to deregister from consul: %v", err)
+		}
+	}, nil
+}
+
+// register registers the service with one TTL check per check, replacing
+// any previous registration.
+func (a *consulAgent) register(checks []check) error {
+	type ttlCheck struct {
+		CheckID string
+		Name    string
+		TTL     string
+	}
+	registration := struct {
+		ID     string
+		Name   string
+		Tags   []string
+		Port   int
+		Checks []ttlCheck
+	}{ID: a.serviceID, Name: a.name, Tags: a.tags, Port: a.port}
+	for _, c := range checks {
+		registration.Checks = append(registration.Checks, ttlCheck{
+			CheckID: a.checkID(c),
+			Name:    c.Type + " " + c.Name,
+			TTL:     a.ttl.String(),
+		})
+	}
+	return a.put("/v1/agent/service/register", registration)
+}
+
+func sameChecks(a, b []check) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *consulAgent) checkID(c check) string {
+	return a.serviceID + ":" + c.Type + ":" + c.Name
+}
+
+// update runs every check and pushes its result to the matching TTL check.
+func (a *consulAgent) update(checks []check) {
+	results := runChecks(context.Background(), checks)
+	adminOverrides.apply(results)
+	for i, result := range results {
+		status := "passing"
+		switch {
+		case result.excluded():
+			// Excluded checks pass so maintenance doesn't pull the service.
+		case result.Status == statusWarning:
+			status = "warning"
+		case result.Status == statusCritical:
+			status = "critical"
+		}
+		body := map[string]string{"Status": status, "Output": result.Message}
+		if err := a.put("/v1/agent/check/update/"+url.PathEscape(a.checkID(checks[i])), body); err != nil {
+			log.Printf("Failed to update consul check %s: %v", a.checkID(checks[i]), err)
+		}
+	}
+}
+
+func (a *consulAgent) put(path string, body interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPut, a.address+path, &payload)
+	if err != nil {
+		return err
+	}
+	if a.token != "" {
+		req.Header.Set("X-Consul-Token", a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul agent returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronLog bounds how much of the end of a log a cron job check reads.
+const maxCronLog = 8 << 20
+
+// CronJobCheck fails when a cron job has not run successfully recently
+// enough. The last run is when StateFile, which the job writes on success,
+// was last modified, or the time it holds as Unix seconds or RFC 3339, or
+// the timestamp of the last line of LogFile matching Pattern. The run is
+// overdue when older than MaxAge or, with JobSchedule, a cron expression,
+// when a scheduled run more than Grace ago has come and gone since.
+type CronJobCheck struct {
+	Name        string        `yaml:"name"`
+	StateFile   string        `yaml:"stateFile"`
+	LogFile     string        `yaml:"logFile"`
+	Pattern     string        `yaml:"pattern"`
+	MaxAge      time.Duration `yaml:"maxAge"`
+	JobSchedule string        `yaml:"jobSchedule"`
+	Grace       time.Duration `yaml:"grace"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// defaultCronGrace is how long a scheduled job has to finish unless grace
+// is set.
+const defaultCronGrace = 5 * time.Minute
+
+// Validate checks a source and a freshness rule are set.
+func (c *CronJobCheck) Validate() error {
+	if (c.StateFile == "") == (c.LogFile == "") {
+		return fmt.Errorf("cron job check %s: set one of stateFile and logFile", c.Name)
+	}
+	if c.LogFile != "" && c.Pattern == "" {
+		return fmt.Errorf("cron job check %s: logFile requires a pattern", c.Name)
+	}
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return fmt.Errorf("cron job check %s: invalid pattern: %w", c.Name, err)
+	}
+	if (c.MaxAge > 0) == (c.JobSchedule != "") {
+		return fmt.Errorf("cron job check %s: set one of maxAge and jobSchedule", c.Name)
+	}
+	if c.MaxAge < 0 || c.Grace < 0 {
+		return fmt.Errorf("cron job check %s: maxAge and grace must not be negative", c.Name)
+	}
+	if c.JobSchedule != "" {
+		if _, err := parseCron(c.JobSchedule); err != nil {
+			return fmt.Errorf("cron job check %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (check CronJobCheck) run(ctx context.Context) checkResult {
+	source := check.StateFile
+	if check.LogFile != "" {
+		source = check.LogFile
+	}
+	last, err := check.lastRun()
+	if err != nil {
+		return checkFailed("Cron Job Name: %s, %s: %v", check.Name, source, err)
+	}
+	now := time.Now()
+	message := fmt.Sprintf("Cron Job Name: %s, Last Run: %s (%s ago)", check.Name, last.Format(time.RFC3339), now.Sub(last).Round(time.Second))
+	if check.MaxAge > 0 {
+		if now.Sub(last) > check.MaxAge {
+			return checkFailed("%s is older than %s", message, check.MaxAge)
+		}
+		return checkOK("%s", message)
+	}
+	schedule, _ := parseCron(check.JobSchedule) // checked by Validate
+	grace := check.Grace
+	if grace == 0 {
+		grace = defaultCronGrace
+	}
+	// The run is overdue when the first one scheduled after it was due
+	// longer than grace ago.
+	if due := schedule.next(last.In(time.Local)); !due.IsZero() && due.Before(now.Add(-grace)) {
+		return checkFailed("%s, missed the run due %s", message, due.Format(time.RFC3339))
+	}
+	return checkOK("%s", message)
+}
+
+// lastRun returns when the job last ran successfully.
+func (check CronJobCheck) lastRun() (time.Time, error) {
+	if check.StateFile != "" {
+		info, err := os.Stat(check.StateFile)
+		if err != nil {
+			return time.Time{}, err
+		}
+		data, err := os.ReadFile(check.StateFile) // #nosec G304 -- path is from the config file
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, ok := parseStateTime(strings.TrimSpace(string(data))); ok {
+			return t, nil
+		}
+		return info.ModTime(), nil
+	}
+
+	f, err := os.Open(check.LogFile) // #nosec G304 -- path is from the config file
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer closeAndLog(f, "log file")
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if info.Size() > maxCronLog {
+		if _, err := f.Seek(-maxCronLog, io.SeekEnd); err != nil {
+			return time.Time{}, err
+		}
+	}
+	pattern := regexp.MustCompile(check.Pattern) // checked by Validate
+	var line []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if pattern.Match(scanner.Bytes()) {
+			line = bytes.Clone(scanner.Bytes())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	if line == nil {
+		return time.Time{}, fmt.Errorf("no line matches %s", check.Pattern)
+	}
+	t, ok := parseLogTime(string(line), info.ModTime())
+	if !ok {
+		return time.Time{}, fmt.Errorf("no timestamp in %q", line)
+	}
+	return t, nil
+}
+
+// parseStateTime reads a state file holding Unix seconds or an RFC 3339
+// time.
+func parseStateTime(s string) (time.Time, bool) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseLogTime reads the timestamp a syslog line starts with, RFC 3339 as
+// rsyslog and journald exports write it or the traditional "Jan _2
+// 15:04:05" in local time. The traditional form has no year, so it is
+// taken as the latest that does not put the line after modified, the
+// log's modification time.
+func parseLogTime(line string, modified time.Time) (time.Time, bool) {
+	if stamp, _, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			return t, true
+		}
+	}
+	const stamp = "Jan _2 15:04:05"
+	if len(line) < len(stamp) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(stamp, line[:len(stamp)], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(modified.Year(), 0, 0)
+	if t.After(modified.Add(time.Minute)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
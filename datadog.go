@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DatadogConfig reports every check run to Datadog as a service check,
+// which monitors can alert on natively, and as status and duration
+// metrics, all tagged with the check's type and name and with Tags. They
+// are sent to the Datadog API for Site with APIKey or, with AgentAddress
+// set, to a local agent's DogStatsD port instead.
+type DatadogConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	APIKey       string            `yaml:"apiKey"`
+	Site         string            `yaml:"site"`
+	AgentAddress string            `yaml:"agentAddress"`
+	Hostname     string            `yaml:"hostname"`
+	Prefix       string            `yaml:"prefix"`
+	Tags         map[string]string `yaml:"tags"`
+}
+
+// Validate checks that results have somewhere to go.
+func (c *DatadogConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch {
+	case c.AgentAddress != "" && c.APIKey != "":
+		return fmt.Errorf("datadog: apiKey and agentAddress cannot both be set")
+	case c.AgentAddress == "" && c.APIKey == "":
+		return fmt.Errorf("datadog: apiKey or agentAddress is required")
+	case c.AgentAddress != "":
+		if _, _, err := net.SplitHostPort(c.AgentAddress); err != nil {
+			return fmt.Errorf("datadog: agentAddress must be host:port: %w", err)
+		}
+	}
+	return nil
+}
+
+// datadogExporter submits results to the Datadog API, or through agent
+// when it is set.
+type datadogExporter struct {
+	cfg      DatadogConfig
+	hostname string
+	tags     []string
+	agent    *statsdEmitter
+}
+
+func newDatadog(cfg DatadogConfig) (*datadogExporter, error) {
+	e := &datadogExporter{cfg: cfg, hostname: cfg.Hostname}
+	if e.cfg.Prefix == "" {
+		e.cfg.Prefix = "server_health"
+	}
+	if e.cfg.Site == "" {
+		e.cfg.Site = "datadoghq.com"
+	}
+	if e.hostname == "" {
+		// The agent fills in its own host name.
+		if h, err := os.Hostname(); err == nil && cfg.AgentAddress == "" {
+			e.hostname = h
+		}
+	}
+	for k, v := range cfg.Tags {
+		e.tags = append(e.tags, k+":"+v)
+	}
+	sort.Strings(e.tags)
+	if cfg.AgentAddress != "" {
+		agent, err := newStatsD(StatsDConfig{Address: cfg.AgentAddress, Prefix: e.cfg.Prefix, DogStatsD: true, Tags: cfg.Tags})
+		if err != nil {
+			return nil, fmt.Errorf("datadog: %w", err)
+		}
+		e.agent = agent
+	}
+	return e, nil
+}
+
+func (e *datadogExporter) checkTags(r checkResult) []string {
+	return append(resultTags(r), e.tags...)
+}
+
+// export sends a service check named <prefix>.check and the
+// <prefix>.check.status and <prefix>.check.duration metrics for each check.
+func (e *datadogExporter) export(results []checkResult) {
+	if len(results) == 0 {
+		return
+	}
+	if e.agent != nil {
+		var lines []string
+		for _, r := range results {
+			lines = append(lines, e.agent.lines(r)...)
+			lines = append(lines, e.serviceCheckLine(r))
+		}
+		e.agent.write(lines)
+		return
+	}
+	exportInBackground("datadog", func() error {
+		if err := e.post("/api/v2/series", e.series(results)); err != nil {
+			return err
+		}
+		return e.post("/api/v1/check_run", e.serviceChecks(results))
+	})
+}
+
+// serviceCheckLine formats r as a DogStatsD service check, whose statuses
+// match ours: 0 for ok, 1 for warning and 2 for critical. The message must
+// come last and cannot hold a newline.
+func (e *datadogExporter) serviceCheckLine(r checkResult) string {
+	line := fmt.Sprintf("_sc|%s.check|%d|d:%d", e.cfg.Prefix, r.Status, r.Checked.Unix())
+	if e.hostname != "" {
+		line += "|h:" + e.hostname
+	}
+	return line + "|#" + strings.Join(e.checkTags(r), ",") + "|m:" + strings.ReplaceAll(r.Message, "\n", "\\n")
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type datadogSeries struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Unit      string            `json:"unit,omitempty"`
+	Points    []datadogPoint    `json:"points"`
+	Tags      []string          `json:"tags"`
+	Resources []datadogResource `json:"resources,omitempty"`
+}
+
+// datadogGauge is the gauge metric type in the v2 series API.
+const datadogGauge = 3
+
+func (e *datadogExporter) series(results []checkResult) interface{} {
+	var series []datadogSeries
+	var resources []datadogResource
+	if e.hostname != "" {
+		resources = []datadogResource{{Name: e.hostname, Type: "host"}}
+	}
+	for _, r := range results {
+		tags := e.checkTags(r)
+		t := r.Checked.Unix()
+		series = append(series,
+			datadogSeries{Metric: e.cfg.Prefix + ".check.status", Type: datadogGauge, Points: []datadogPoint{{t, float64(r.Status)}}, Tags: tags, Resources: resources},
+			datadogSeries{Metric: e.cfg.Prefix + ".check.duration", Type: datadogGauge, Unit: "millisecond", Points: []datadogPoint{{t, milliseconds(r.Duration)}}, Tags: tags, Resources: resources},
+		)
+	}
+	return map[string]interface{}{"series": series}
+}
+
+type datadogServiceCheck struct {
+	Check     string   `json:"check"`
+	HostName  string   `json:"host_name"`
+	Status    int      `json:"status"`
+	Timestamp int64    `json:"timestamp"`
+	Message   string   `json:"message"`
+	Tags      []string `json:"tags"`
+}
+
+func (e *datadogExporter) serviceChecks(results []checkResult) interface{} {
+	checks := make([]datadogServiceCheck, 0, len(results))
+	for _, r := range results {
+		checks = append(checks, datadogServiceCheck{
+			Check:     e.cfg.Prefix + ".check",
+			HostName:  e.hostname,
+			Status:    int(r.Status),
+			Timestamp: r.Checked.Unix(),
+			Message:   r.Message,
+			Tags:      e.checkTags(r),
+		})
+	}
+	return checks
+}
+
+func (e *datadogExporter) post(path string, body interface{}) error {
+	var payload bytes.Buffer
+	if err := json.NewEncoder(&payload).Encode(body); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://api."+e.cfg.Site+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", e.cfg.APIKey)
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return nil
+}
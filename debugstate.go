@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkRun is a check waiting for a concurrency slot or running.
+type checkRun struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	Seconds float64   `json:"seconds"`
+}
+
+// checkFailure is the last result of a check that was not ok.
+type checkFailure struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message"`
+	Checked time.Time `json:"checked"`
+}
+
+// activityTracker records the checks in flight and the last failure of
+// each, for /debug/state, so a check stuck waiting or running shows up.
+type activityTracker struct {
+	mu       sync.Mutex
+	next     uint64
+	runs     map[uint64]*checkRun
+	failures map[string]checkFailure // by override key
+	cycles   uint64
+	cycle    time.Time
+}
+
+// checkActivity tracks every check run.
+var checkActivity = &activityTracker{runs: map[uint64]*checkRun{}, failures: map[string]checkFailure{}}
+
+// queued records c waiting for a slot, returning the run's ID.
+func (t *activityTracker) queued(c check) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.runs[t.next] = &checkRun{Type: c.Type, Name: c.Name, State: "queued", Since: time.Now()}
+	return t.next
+}
+
+// running records that run id has its slot and started.
+func (t *activityTracker) running(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if run, ok := t.runs[id]; ok {
+		run.State, run.Since = "running", time.Now()
+	}
+}
+
+// done forgets run id.
+func (t *activityTracker) done(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.runs, id)
+}
+
+// finished records the results of a run of checks.
+func (t *activityTracker) finished(results []checkResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range results {
+		if r.Status != statusOK && !r.NotScheduled {
+			t.failures[overrideKey(r.Type, r.Name)] = checkFailure{Type: r.Type, Name: r.Name, Status: r.Status.String(), Reason: r.Reason, Message: r.Message, Checked: r.Checked}
+		}
+	}
+}
+
+// cycled records a background run of a check ending.
+func (t *activityTracker) cycled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cycles++
+	t.cycle = time.Now()
+}
+
+// debugStateHandler serves GET /debug/state, the daemon's internals for
+// troubleshooting: the checks queued and running, the last failure of each
+// check, the scheduler's cycles, concurrency slots in use, goroutines and
+// which config is running.
+func debugStateHandler(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		now := time.Now()
+		checks := currentChecks(live.current())
+		known := make(map[string]bool, len(checks))
+		for _, c := range checks {
+			known[overrideKey(c.Type, c.Name)] = true
+		}
+
+		checkActivity.mu.Lock()
+		runs := make([]checkRun, 0, len(checkActivity.runs))
+		for _, run := range checkActivity.runs {
+			r := *run
+			r.Seconds = now.Sub(r.Since).Round(time.Millisecond).Seconds()
+			runs = append(runs, r)
+		}
+		failures := []checkFailure{}
+		for key, f := range checkActivity.failures {
+			if known[key] {
+				failures = append(failures, f)
+			}
+		}
+		scheduler := map[string]interface{}{"interval": live.current().Config.Interval.String(), "cycles": checkActivity.cycles}
+		if !checkActivity.cycle.IsZero() {
+			scheduler["lastCycle"] = checkActivity.cycle
+		}
+		checkActivity.mu.Unlock()
+		sort.Slice(runs, func(i, j int) bool { return runs[i].Since.Before(runs[j].Since) })
+		sort.Slice(failures, func(i, j int) bool { return failures[i].Checked.After(failures[j].Checked) })
+
+		writeJSON(w, map[string]interface{}{
+			"time":       now,
+			"goroutines": runtime.NumGoroutine(),
+			"config": map[string]interface{}{
+				"generation": live.generation.Load(),
+				"applied":    time.Unix(0, live.reloaded.Load()),
+				"checks":     len(checks),
+			},
+			"scheduler":   scheduler,
+			"concurrency": checkLimits.usage(),
+			"leader":      checkLeader.leading(),
+			"queued":      countState(runs, "queued"),
+			"running":     countState(runs, "running"),
+			"runs":        runs,
+			"lastErrors":  failures,
+		})
+	}
+}
+
+// countState counts the runs in state.
+func countState(runs []checkRun, state string) int {
+	n := 0
+	for _, r := range runs {
+		if r.State == state {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// checkResolver resolves the host names checks connect to; main sets it
+// from config.dns. nil uses the system resolver.
+var checkResolver *net.Resolver
+
+// checkSource is config.sourceAddress, the local IP address or interface
+// checks connect from; main sets it. Empty leaves the choice to the system.
+var checkSource string
+
+type sourceKey struct{}
+
+type dialKey struct{}
+
+// DialConfig controls how port and endpoint checks connect, to reproduce
+// how a particular client would. FallbackDelay is how long a dual-stack
+// dial waits on the preferred family before racing the other, 300ms unless
+// set; negative waits for the first to fail. KeepAlive is the TCP
+// keep-alive period, 15s unless set; negative disables it. Family limits
+// connections to ipv4 or ipv6. Prefer instead tries ipv4 or ipv6 first,
+// where Go otherwise tries the family of the first address resolved.
+type DialConfig struct {
+	FallbackDelay time.Duration `yaml:"fallbackDelay"`
+	KeepAlive     time.Duration `yaml:"keepAlive"`
+	Family        string        `yaml:"family"`
+	Prefer        string        `yaml:"prefer"`
+}
+
+// Validate checks the family and preference.
+func (d *DialConfig) Validate() error {
+	for _, family := range []string{d.Family, d.Prefer} {
+		if family != "" && family != "ipv4" && family != "ipv6" {
+			return fmt.Errorf("family and prefer must be ipv4 or ipv6")
+		}
+	}
+	if d.Family != "" && d.Prefer != "" {
+		return fmt.Errorf("family and prefer cannot both be set")
+	}
+	return nil
+}
+
+// withDial returns ctx with a check's dial options, used for the
+// connections made with it.
+func withDial(ctx context.Context, d DialConfig) context.Context {
+	return context.WithValue(ctx, dialKey{}, d)
+}
+
+// dialFor returns the dial options for connections made with ctx.
+func dialFor(ctx context.Context) DialConfig {
+	d, _ := ctx.Value(dialKey{}).(DialConfig)
+	return d
+}
+
+// familyNetwork is the network that dials only family.
+func familyNetwork(family string) string {
+	if family == "ipv6" {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+// dial connects to address with dialer, keeping to Family or trying the
+// Prefer family first. The other family starts after FallbackDelay, or
+// when the preferred one fails, and the first to connect wins.
+func (d DialConfig) dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return dialer.DialContext(ctx, network, address)
+	}
+	if d.Family != "" {
+		return dialer.DialContext(ctx, familyNetwork(d.Family), address)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if d.Prefer == "" || err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	other := "ipv4"
+	if d.Prefer == "ipv4" {
+		other = "ipv6"
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan attempt, 2)
+	start := func(family string) {
+		go func() {
+			conn, err := dialer.DialContext(ctx, familyNetwork(family), address)
+			results <- attempt{conn, err}
+		}()
+	}
+	start(d.Prefer)
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+	var fallback <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		fallback = timer.C
+	}
+	started, failed := 1, 0
+	var firstErr error
+	for {
+		select {
+		case <-fallback:
+			fallback = nil
+			if started == 1 {
+				start(other)
+				started++
+			}
+		case a := <-results:
+			if a.err == nil {
+				// A slower attempt still connecting is cancelled; one that
+				// connected anyway is closed when it reports.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							closeAndLog(late.conn, "connection")
+						}
+					}
+				}(started - failed - 1)
+				return a.conn, nil
+			}
+			failed++
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			if started == 1 {
+				fallback = nil
+				start(other)
+				started++
+			} else if failed == started {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// withSource returns ctx with a check's own source address, replacing
+// checkSource for the connections made with it.
+func withSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// sourceFor returns the source address for connections made with ctx.
+func sourceFor(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return checkSource
+}
+
+// checkDialer returns a dialer bound to the source address for ctx and
+// resolving through checkResolver.
+func checkDialer(ctx context.Context, network string, timeout time.Duration) (*net.Dialer, error) {
+	laddr, err := localAddr(network, sourceFor(ctx))
+	if err != nil {
+		return nil, err
+	}
+	d := dialFor(ctx)
+	return &net.Dialer{Timeout: timeout, LocalAddr: laddr, Resolver: checkResolver, FallbackDelay: d.FallbackDelay, KeepAlive: d.KeepAlive}, nil
+}
+
+// localAddr resolves source, an IP address or interface name, to a local
+// address for network. An interface is bound by its first IPv4 address, or
+// its first global IPv6 address if it has none. An empty source returns nil.
+func localAddr(network, source string) (net.Addr, error) {
+	if source == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(source)
+	if ip == nil {
+		iface, err := net.InterfaceByName(source)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source, err)
+		}
+		var v6 net.IP
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			switch {
+			case !ok:
+			case ipNet.IP.To4() != nil:
+				ip = ipNet.IP
+			case v6 == nil && !ipNet.IP.IsLinkLocalUnicast():
+				v6 = ipNet.IP
+			}
+			if ip != nil {
+				break
+			}
+		}
+		if ip == nil {
+			ip = v6
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("source %s: interface has no usable address", source)
+		}
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip}, nil
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// dialCheck opens a check's connection to address, over TLS when tlsConfig
+// is set, giving up when timeout passes or ctx ends. Only port and endpoint
+// checks, which dial without TLS here, set a family preference.
+func dialCheck(ctx context.Context, network, address string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer, err := checkDialer(ctx, network, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, network, address)
+	}
+	return dialFor(ctx).dial(ctx, dialer, network, address)
+}
+
+// dialHTTP is the DialContext of the HTTP transports used by checks, so
+// they resolve through checkResolver too.
+func dialHTTP(ctx context.Context, network, address string) (net.Conn, error) {
+	return dialCheck(ctx, network, address, 30*time.Second, nil)
+}
+
+// interruptOnDone expires conn's deadline when ctx ends, so a check blocked
+// reading or writing returns at once. Call the returned function when done
+// with conn.
+func interruptOnDone(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		if err := conn.SetDeadline(time.Now()); err != nil {
+			log.Printf("Failed to interrupt connection: %v", err)
+		}
+	})
+}
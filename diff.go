@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diffCommand is the subcommand that compares a proposed config with the
+// running one.
+const diffCommand = "diff"
+
+// configDiff is how a proposed config differs from the running one, with
+// the results of running its new and changed checks once when asked for.
+// Definitions are listed as section/name, as reloads log them.
+type configDiff struct {
+	Added           []string      `json:"added"`
+	Removed         []string      `json:"removed"`
+	Changed         []string      `json:"changed"`
+	RestartRequired bool          `json:"restartRequired"`
+	Results         []checkReport `json:"results,omitempty"`
+}
+
+// diffConfigs compares next with old and, when run is set, runs the checks
+// next adds or changes, as they would run on this host.
+func diffConfigs(ctx context.Context, old, next *Config, run bool) configDiff {
+	var diff configDiff
+	diff.RestartRequired = !reflect.DeepEqual(next.Config, old.Config)
+	diff.Added, diff.Removed, diff.Changed = diffChecks(old, next)
+	if run {
+		subset := next.only(append(append([]string(nil), diff.Added...), diff.Changed...))
+		diff.Results = reports(tryChecks(ctx, applicable(subset.checks())), time.Now())
+	}
+	return diff
+}
+
+// only returns a copy of c keeping, of the definitions in its lists, those
+// whose section/name is in keys.
+func (c *Config) only(keys []string) *Config {
+	keep := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+	}
+	out := *c
+	v := reflect.ValueOf(&out).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.Slice {
+			continue
+		}
+		section, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		kept := reflect.MakeSlice(field.Type(), 0, field.Len())
+		for j := 0; j < field.Len(); j++ {
+			item := field.Index(j)
+			if keep[section+"/"+item.FieldByName("Name").String()] {
+				kept = reflect.Append(kept, item)
+			}
+		}
+		field.Set(kept)
+	}
+	return &out
+}
+
+// tryChecks runs checks once, concurrently within checkLimits, for a dry
+// run. Unlike runChecks it keeps no state: results are not held back by
+// rise and fall counts, trigger no remediation and are neither recorded as
+// changes nor exported.
+func tryChecks(ctx context.Context, checks []check) []checkResult {
+	results := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var result checkResult
+			if release, err := checkLimits.acquire(ctx, c.Type); err != nil {
+				result = checkSkipped(ctx)
+			} else {
+				runCtx := ctx
+				if c.Options.SourceAddress != "" {
+					runCtx = withSource(ctx, c.Options.SourceAddress)
+				}
+				start := time.Now()
+				result = c.Run(runCtx)
+				result.Duration = time.Since(start)
+				release()
+				if c.Options.Invert {
+					result = result.inverted()
+				}
+			}
+			switch {
+			case result.Status == statusOK:
+				result.Reason = ""
+			case result.Reason == "":
+				result.Reason = reasonFailed
+			}
+			if result.Status != statusOK {
+				result = result.guided(c.Options)
+			}
+			result.Type, result.Name, result.Checked = c.Type, c.Name, time.Now()
+			result.Labels, result.Annotations = c.Options.Labels, c.Options.Annotations
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runDiff runs "server-health-api diff": it compares the config at -config
+// with the one at -current, the file the daemon runs, printing what would
+// be added, removed and changed, then runs the new and changed checks once
+// unless -run=false. It returns the exit status: 2 when a config cannot be
+// read, 1 when a check run fails, 0 otherwise.
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet(diffCommand, flag.ExitOnError)
+	configFilePath := flags.String("config", "", "Path to the proposed config file")
+	currentFilePath := flags.String("current", GetEnv("HEALTHCHECK_CONFIG_FILE", "config.yaml"), "Path to the running config file")
+	run := flags.Bool("run", true, "Run the new and changed checks once")
+	flags.Var(&configSettings, "set", "Set a config field, as path=value, over both files and the environment; repeatable")
+	_ = flags.Parse(args) // exits on error
+	if *configFilePath == "" {
+		fmt.Fprintln(os.Stderr, "diff: -config is required")
+		return 2
+	}
+	current, err := readConfig(*currentFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %s: %v\n", *currentFilePath, err)
+		return 2
+	}
+	next, err := readConfig(*configFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %s: %v\n", *configFilePath, err)
+		return 2
+	}
+	configureChecks(&next.Config)
+	ctx := context.Background()
+	if next.Config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, next.Config.RequestTimeout)
+		defer cancel()
+	}
+	diff := diffConfigs(ctx, current, next, *run)
+	for _, line := range []struct {
+		label string
+		keys  []string
+	}{{"added", diff.Added}, {"removed", diff.Removed}, {"changed", diff.Changed}} {
+		for _, key := range line.keys {
+			fmt.Printf("%-8s %s\n", line.label, key)
+		}
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		fmt.Println("no checks changed")
+	}
+	if diff.RestartRequired {
+		fmt.Println("settings under config changed and need a restart to take effect")
+	}
+	status := 0
+	for _, r := range diff.Results {
+		fmt.Printf("%-8s %s: %s\n", r.Status, overrideKey(r.Type, r.Name), r.Message)
+		if r.Status == statusCritical.String() {
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery generates port or endpoint checks from the instances of a
+// service, found through DNS SRV records, the Consul catalog or a JSON file
+// in Prometheus's file_sd format, and looked up again every Refresh, 30s
+// unless set. Port or Endpoint is the template of each check, with the
+// instance's host and port filled in as for Targets, and each check is
+// named after Name with them appended. An instance without a port, which
+// only a file can give, keeps the template's.
+type Discovery struct {
+	Name     string           `yaml:"name"`
+	SRV      string           `yaml:"srv"`
+	Consul   *ConsulDiscovery `yaml:"consul"`
+	File     string           `yaml:"file"`
+	Refresh  time.Duration    `yaml:"refresh"`
+	Port     *Port            `yaml:"port"`
+	Endpoint *Endpoint        `yaml:"endpoint"`
+}
+
+// ConsulDiscovery finds the instances of Service, only those with Tag when
+// set, in the catalog of the Consul agent at Address, http://127.0.0.1:8500
+// unless set. Every instance is found, healthy or not, so the failing ones
+// are checked too.
+type ConsulDiscovery struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+	Service string `yaml:"service"`
+	Tag     string `yaml:"tag"`
+}
+
+// Validate checks there is exactly one source and one template.
+func (d *Discovery) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("discovery requires a name")
+	}
+	sources := 0
+	for _, set := range []bool{d.SRV != "", d.Consul != nil, d.File != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("discovery %s: exactly one of srv, consul and file is required", d.Name)
+	}
+	if (d.Port == nil) == (d.Endpoint == nil) {
+		return fmt.Errorf("discovery %s: exactly one of port and endpoint is required", d.Name)
+	}
+	if d.Refresh < 0 {
+		return fmt.Errorf("discovery %s: refresh must not be negative", d.Name)
+	}
+	if c := d.Consul; c != nil {
+		if c.Service == "" {
+			return fmt.Errorf("discovery %s: consul requires a service", d.Name)
+		}
+		if c.Address != "" {
+			u, err := url.Parse(c.Address)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("discovery %s: consul address must be an http:// or https:// URL", d.Name)
+			}
+		}
+	}
+	if (d.Port != nil && d.Port.Targets.set()) || (d.Endpoint != nil && d.Endpoint.Targets.set()) {
+		return fmt.Errorf("discovery %s: the template cannot set hosts or ports", d.Name)
+	}
+	if d.Endpoint != nil {
+		if d.Endpoint.Socket != "" {
+			return fmt.Errorf("discovery %s: the template cannot set a socket", d.Name)
+		}
+		endpoint := d.endpoint(placeholderInstance)
+		endpoint.Name = d.Name
+		return endpoint.Validate()
+	}
+	return nil
+}
+
+// refresh returns how often the instances are looked up.
+func (d *Discovery) refresh() time.Duration {
+	if d.Refresh > 0 {
+		return d.Refresh
+	}
+	return 30 * time.Second
+}
+
+// discoveredInstance is an instance of a discovered service.
+type discoveredInstance struct {
+	host   string
+	port   int
+	labels map[string]string
+}
+
+// placeholderInstance stands in for the instances to come when validating
+// a template, which is reported under the discovery's name.
+var placeholderInstance = discoveredInstance{host: "127.0.0.1", port: 1}
+
+// port returns the port check of inst.
+func (d *Discovery) port(inst discoveredInstance) Port {
+	t := *d.Port
+	t.Name = d.Name
+	t = t.at(inst.host, inst.port)
+	t.Labels = withLabels(t.Labels, inst.labels)
+	return t
+}
+
+// endpoint returns the endpoint check of inst.
+func (d *Discovery) endpoint(inst discoveredInstance) Endpoint {
+	t := *d.Endpoint
+	t.Name = d.Name
+	t = t.at(inst.host, inst.port)
+	t.Labels = withLabels(t.Labels, inst.labels)
+	return t
+}
+
+// check returns the check of inst.
+func (d *Discovery) check(inst discoveredInstance) check {
+	if d.Port != nil {
+		p := d.port(inst)
+		return check{Type: "port", Name: p.Name, Run: p.run, Options: p.CheckOptions}
+	}
+	e := d.endpoint(inst)
+	return check{Type: "endpoint", Name: e.Name, Run: e.run, Options: e.CheckOptions}
+}
+
+// withLabels returns labels with those of extra added, keeping the
+// template's where both set one and dropping any not valid as labels.
+func withLabels(labels, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return labels
+	}
+	out := make(map[string]string, len(labels)+len(extra))
+	for name, value := range extra {
+		if validateLabels(map[string]string{name: value}) == nil {
+			out[name] = value
+		}
+	}
+	for name, value := range labels {
+		out[name] = value
+	}
+	return out
+}
+
+// discoveryTemplates returns a check for each discovery's template, so its
+// options are validated with those of the configured checks.
+func (c *Config) discoveryTemplates() []check {
+	checks := make([]check, 0, len(c.Discovery))
+	for i := range c.Discovery {
+		d := &c.Discovery[i]
+		if (d.Port == nil) != (d.Endpoint == nil) {
+			template := d.check(placeholderInstance)
+			template.Name = d.Name
+			checks = append(checks, template)
+		}
+	}
+	return checks
+}
+
+// lookup finds the instances of the service.
+func (d *Discovery) lookup(ctx context.Context) ([]discoveredInstance, error) {
+	switch {
+	case d.SRV != "":
+		return lookupSRV(ctx, d.SRV)
+	case d.Consul != nil:
+		return d.Consul.lookup(ctx)
+	}
+	return readFileSD(d.File)
+}
+
+// lookupSRV finds the targets of the SRV records of name.
+func lookupSRV(ctx context.Context, name string) ([]discoveredInstance, error) {
+	resolver := checkResolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]discoveredInstance, 0, len(records))
+	for _, r := range records {
+		instances = append(instances, discoveredInstance{host: strings.TrimSuffix(r.Target, "."), port: int(r.Port)})
+	}
+	return instances, nil
+}
+
+// lookup finds the instances of the service in the Consul catalog.
+func (c *ConsulDiscovery) lookup(ctx context.Context) ([]discoveredInstance, error) {
+	address := strings.TrimSuffix(c.Address, "/")
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	u := address + "/v1/catalog/service/" + url.PathEscape(c.Service)
+	if c.Tag != "" {
+		u += "?tag=" + url.QueryEscape(c.Tag)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s", resp.Status)
+	}
+	var services []struct {
+		Node           string
+		Address        string
+		Datacenter     string
+		ServiceAddress string
+		ServicePort    int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, err
+	}
+	instances := make([]discoveredInstance, 0, len(services))
+	for _, s := range services {
+		host := s.ServiceAddress
+		if host == "" {
+			host = s.Address
+		}
+		instances = append(instances, discoveredInstance{host: host, port: s.ServicePort, labels: map[string]string{"node": s.Node, "datacenter": s.Datacenter}})
+	}
+	return instances, nil
+}
+
+// readFileSD reads a file in Prometheus's file_sd format: a JSON list of
+// groups of host:port targets sharing labels.
+func readFileSD(path string) ([]discoveredInstance, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is from the config file
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var instances []discoveredInstance
+	for _, g := range groups {
+		for _, target := range g.Targets {
+			inst := discoveredInstance{host: target, labels: g.Labels}
+			if host, port, err := net.SplitHostPort(target); err == nil {
+				p, err := strconv.Atoi(port)
+				if err != nil || p < 1 || p > 65535 {
+					return nil, fmt.Errorf("%s: invalid target %q", path, target)
+				}
+				inst.host, inst.port = host, p
+			}
+			instances = append(instances, inst)
+		}
+	}
+	return instances, nil
+}
+
+// discoverer keeps the instances last found by each discovery.
+type discoverer struct {
+	mu        sync.RWMutex
+	instances map[string][]discoveredInstance // by discovery name
+}
+
+// discoveredChecks holds the instances of every discovery.
+var discoveredChecks = &discoverer{instances: map[string][]discoveredInstance{}}
+
+// checks returns the checks of the instances found by config's discoveries.
+func (d *discoverer) checks(config *Config) []check {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var checks []check
+	for i := range config.Discovery {
+		disc := &config.Discovery[i]
+		for _, inst := range d.instances[disc.Name] {
+			checks = append(checks, disc.check(inst))
+		}
+	}
+	return checks
+}
+
+// startDiscovery looks up the instances of each discovery in the live
+// config at once and then every refresh, following reloads. A lookup that
+// fails keeps the instances found before, so a source briefly unreachable
+// does not drop checks. The returned function stops it.
+func startDiscovery(live *liveConfig) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		due := map[string]time.Time{}
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			discoveredChecks.refresh(ctx, live.current().Discovery, due)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// refresh looks up the discoveries that are due, forgetting the instances
+// of those no longer configured.
+func (d *discoverer) refresh(ctx context.Context, discoveries []Discovery, due map[string]time.Time) {
+	configured := make(map[string]bool, len(discoveries))
+	for i := range discoveries {
+		disc := &discoveries[i]
+		configured[disc.Name] = true
+		if time.Now().Before(due[disc.Name]) {
+			continue
+		}
+		due[disc.Name] = time.Now().Add(disc.refresh())
+		lookupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		instances, err := disc.lookup(lookupCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Discovery %s failed: %v", disc.Name, err)
+			continue
+		}
+		d.mu.Lock()
+		d.instances[disc.Name] = instances
+		d.mu.Unlock()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.instances {
+		if !configured[name] {
+			delete(d.instances, name)
+		}
+	}
+	for name := range due {
+		if !configured[name] {
+			delete(due, name)
+		}
+	}
+}
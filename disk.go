@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DiskCheck reports how full the filesystem holding Path is. Warn and crit
+// grade the used percentage. With FillWithin set, the check also keeps the
+// usage seen over TrendWindow, projects when the filesystem fills at that
+// rate of growth, and warns when that is less than FillWithin away.
+type DiskCheck struct {
+	Name        string        `yaml:"name"`
+	Path        string        `yaml:"path"`
+	FillWithin  time.Duration `yaml:"fillWithin"`
+	TrendWindow time.Duration `yaml:"trendWindow"`
+
+	// Thresholds grade the used percentage.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// defaultTrendWindow is how much usage history a fill projection uses when
+// trendWindow is unset.
+const defaultTrendWindow = 6 * time.Hour
+
+// Validate checks the path, durations and thresholds.
+func (d *DiskCheck) Validate() error {
+	if d.Path == "" {
+		return fmt.Errorf("disk check %s: path is required", d.Name)
+	}
+	if d.FillWithin < 0 || d.TrendWindow < 0 {
+		return fmt.Errorf("disk check %s: fillWithin and trendWindow must not be negative", d.Name)
+	}
+	if err := d.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("disk check %s: %w", d.Name, err)
+	}
+	return nil
+}
+
+// diskSample is the space used on a filesystem at one time.
+type diskSample struct {
+	at   time.Time
+	used float64
+}
+
+// diskHistory is the usage recorded by one disk check, kept between runs.
+type diskHistory struct {
+	mu      sync.Mutex
+	samples []diskSample
+}
+
+var (
+	diskHistoriesMu sync.Mutex
+	diskHistories   = map[string]*diskHistory{}
+)
+
+func getDiskHistory(key string) *diskHistory {
+	diskHistoriesMu.Lock()
+	defer diskHistoriesMu.Unlock()
+	h, ok := diskHistories[key]
+	if !ok {
+		h = &diskHistory{}
+		diskHistories[key] = h
+	}
+	return h
+}
+
+// record adds a sample, drops those older than window and returns the
+// growth rate in bytes per second fitted to what remains by least squares.
+// ok is false until the samples span at least a quarter of window, as a
+// rate from a few close samples is mostly noise.
+func (h *diskHistory) record(s diskSample, window time.Duration) (rate float64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, s)
+	cutoff := s.at.Add(-window)
+	for len(h.samples) > 0 && h.samples[0].at.Before(cutoff) {
+		h.samples = h.samples[1:]
+	}
+	if len(h.samples) < 2 || s.at.Sub(h.samples[0].at) < window/4 {
+		return 0, false
+	}
+	var sumT, sumU float64
+	for _, p := range h.samples {
+		sumT += p.at.Sub(h.samples[0].at).Seconds()
+		sumU += p.used
+	}
+	n := float64(len(h.samples))
+	meanT, meanU := sumT/n, sumU/n
+	var num, den float64
+	for _, p := range h.samples {
+		dt := p.at.Sub(h.samples[0].at).Seconds() - meanT
+		num += dt * (p.used - meanU)
+		den += dt * dt
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+func (check DiskCheck) run(ctx context.Context) checkResult {
+	used, avail, err := diskSpace(check.Path)
+	if err != nil {
+		return checkFailed("Disk Name: %s, Path: %s could not be read: %v", check.Name, check.Path, err)
+	}
+	// Like df, the percentage leaves out blocks reserved for root.
+	percent := 0.0
+	if used+avail > 0 {
+		percent = used / (used + avail) * 100
+	}
+	result := checkOK("Disk Name: %s, Path: %s, Used: %.1f%%", check.Name, check.Path, percent)
+	result = check.Thresholds.apply(result, percent, "")
+	if check.FillWithin == 0 {
+		return result
+	}
+
+	window := check.TrendWindow
+	if window == 0 {
+		window = defaultTrendWindow
+	}
+	rate, ok := getDiskHistory(check.Name+"\x00"+check.Path).record(diskSample{at: time.Now(), used: used}, window)
+	if !ok || rate <= 0 {
+		return result
+	}
+	seconds := avail / rate
+	if seconds >= time.Duration(math.MaxInt64).Seconds() {
+		return result
+	}
+	full := time.Duration(seconds * float64(time.Second))
+	result.Message += fmt.Sprintf(", Growth: %.1f MB/h, projected full in %s", rate*3600/1e6, full.Round(time.Minute))
+	if full < check.FillWithin {
+		result.Message += fmt.Sprintf(", within %s", check.FillWithin)
+		if result.Status < statusWarning {
+			result.Status = statusWarning
+		}
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSResponderConfig answers DNS queries over UDP on Listen for Name with
+// Addresses, as A and AAAA records, while the server is healthy or
+// degraded, and with NXDOMAIN while it is unhealthy or drained, so DNS
+// based failover can follow the server's health. Queries for other names
+// are refused. Answers come from the background results, so it requires
+// config.interval.
+type DNSResponderConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Listen    string   `yaml:"listen"`
+	Name      string   `yaml:"name"`
+	Addresses []string `yaml:"addresses"`
+	TTL       int      `yaml:"ttl"`
+}
+
+// Validate checks the listen address, name and addresses.
+func (c *DNSResponderConfig) Validate(interval time.Duration) error {
+	if !c.Enabled {
+		return nil
+	}
+	if interval == 0 {
+		return fmt.Errorf("dnsResponder: config.interval is required")
+	}
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("dnsResponder: listen must be host:port: %w", err)
+		}
+	}
+	name := strings.TrimSuffix(c.Name, ".")
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("dnsResponder: name is required")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("dnsResponder: invalid name %q", c.Name)
+		}
+	}
+	if len(c.Addresses) == 0 {
+		return fmt.Errorf("dnsResponder: addresses are required")
+	}
+	for _, a := range c.Addresses {
+		if net.ParseIP(a) == nil {
+			return fmt.Errorf("dnsResponder: address %q must be an IP address", a)
+		}
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("dnsResponder: ttl must not be negative")
+	}
+	return nil
+}
+
+// DNS record types and classes, and response codes, answered by the
+// responder.
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+	dnsClassANY = 255
+
+	dnsFormErr  = 1
+	dnsNXDomain = 3
+	dnsNotImp   = 4
+	dnsRefused  = 5
+)
+
+// dnsHeaderSize is the size of a DNS message header.
+const dnsHeaderSize = 12
+
+// defaultDNSTTL is the TTL of answers unless ttl is set, short so
+// resolvers notice a failure soon.
+const defaultDNSTTL = 5
+
+// dnsResponder answers queries from the current config and the
+// scheduler's cache.
+type dnsResponder struct {
+	live  *liveConfig
+	cache *resultCache
+	conn  net.PacketConn
+}
+
+// startDNSResponder listens for DNS queries, on :53 unless listen is set.
+// The returned function stops the listener.
+func startDNSResponder(live *liveConfig, cache *resultCache) (func(), error) {
+	address := live.current().Config.DNSResponder.Listen
+	if address == "" {
+		address = ":53"
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("dnsResponder: %w", err)
+	}
+	d := &dnsResponder{live: live, cache: cache, conn: conn}
+	log.Printf("Starting DNS responder on %s", address)
+	go d.serve()
+	return func() { closeAndLog(conn, "dns responder") }, nil
+}
+
+func (d *dnsResponder) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("dnsResponder: %v", err)
+			}
+			return
+		}
+		reply := d.answer(buf[:n])
+		if reply == nil {
+			continue
+		}
+		if _, err := d.conn.WriteTo(reply, addr); err != nil {
+			log.Printf("dnsResponder: %s: %v", addr, err)
+		}
+	}
+}
+
+// answer builds the reply to query, or nil for messages that are not
+// queries.
+func (d *dnsResponder) answer(query []byte) []byte {
+	if len(query) < dnsHeaderSize {
+		return nil
+	}
+	flags := binary.BigEndian.Uint16(query[2:])
+	if flags&0x8000 != 0 {
+		return nil
+	}
+	opcode := flags >> 11 & 0xf
+	// Answers are authoritative, echoing the opcode and recursion desired.
+	reply := dnsHeader(query, 0x8000|opcode<<11|0x0400|flags&0x0100)
+	if opcode != 0 {
+		return dnsRcode(reply, dnsNotImp)
+	}
+	if binary.BigEndian.Uint16(query[4:]) != 1 {
+		return dnsRcode(reply, dnsFormErr)
+	}
+	name, qtype, qclass, end, err := parseDNSQuestion(query)
+	if err != nil {
+		return dnsRcode(reply, dnsFormErr)
+	}
+	reply = append(reply, query[dnsHeaderSize:end]...)
+	binary.BigEndian.PutUint16(reply[4:], 1)
+
+	config := d.live.current()
+	cfg := config.Config.DNSResponder
+	if !strings.EqualFold(name, strings.TrimSuffix(cfg.Name, ".")) || (qclass != dnsClassIN && qclass != dnsClassANY) {
+		return dnsRcode(reply, dnsRefused)
+	}
+	if !backgroundReport(config, d.cache, 0).Healthy {
+		return dnsRcode(reply, dnsNXDomain)
+	}
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+	var answers uint16
+	for _, a := range cfg.Addresses {
+		ip := net.ParseIP(a) // checked by Validate
+		rrType, data := uint16(dnsTypeAAAA), []byte(ip.To16())
+		if v4 := ip.To4(); v4 != nil {
+			rrType, data = dnsTypeA, v4
+		}
+		if qtype != rrType && qtype != dnsTypeANY {
+			continue
+		}
+		// The owner is a pointer to the name in the question.
+		reply = binary.BigEndian.AppendUint16(reply, 0xc000|dnsHeaderSize)
+		reply = binary.BigEndian.AppendUint16(reply, rrType)
+		reply = binary.BigEndian.AppendUint16(reply, dnsClassIN)
+		reply = binary.BigEndian.AppendUint32(reply, uint32(ttl))       // #nosec G115 -- checked not negative by Validate
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(data))) // #nosec G115 -- 4 or 16
+		reply = append(reply, data...)
+		answers++
+	}
+	binary.BigEndian.PutUint16(reply[6:], answers)
+	return reply
+}
+
+// dnsHeader returns a reply header for query with flags and no records.
+func dnsHeader(query []byte, flags uint16) []byte {
+	header := make([]byte, dnsHeaderSize)
+	copy(header, query[:2])
+	binary.BigEndian.PutUint16(header[2:], flags)
+	return header
+}
+
+// dnsRcode sets the response code of reply.
+func dnsRcode(reply []byte, rcode uint16) []byte {
+	binary.BigEndian.PutUint16(reply[2:], binary.BigEndian.Uint16(reply[2:])&^0xf|rcode)
+	return reply
+}
+
+// parseDNSQuestion parses the question of a query, returning its name
+// without the trailing dot, type, class and the offset just after it.
+// Questions may not use name compression.
+func parseDNSQuestion(msg []byte) (string, uint16, uint16, int, error) {
+	var labels []string
+	i, size := dnsHeaderSize, 0
+	for {
+		if i >= len(msg) {
+			return "", 0, 0, 0, fmt.Errorf("truncated question")
+		}
+		n := int(msg[i])
+		i++
+		if n == 0 {
+			break
+		}
+		size += n + 1
+		if n > 63 || size > 255 || i+n > len(msg) {
+			return "", 0, 0, 0, fmt.Errorf("invalid name in question")
+		}
+		labels = append(labels, string(msg[i:i+n]))
+		i += n
+	}
+	if i+4 > len(msg) {
+		return "", 0, 0, 0, fmt.Errorf("truncated question")
+	}
+	return strings.Join(labels, "."), binary.BigEndian.Uint16(msg[i:]), binary.BigEndian.Uint16(msg[i+2:]), i + 4, nil
+}
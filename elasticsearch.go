@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ElasticsearchCheck queries the _cluster/health API of an Elasticsearch
+// or OpenSearch cluster. A green cluster is healthy, yellow is reported as
+// a warning and red fails the check. MinNodes and MaxUnassignedShards add
+// assertions on top of the cluster's own status.
+type ElasticsearchCheck struct {
+	Name                string `yaml:"name"`
+	URL                 string `yaml:"url"`
+	Username            string `yaml:"username"`
+	Password            string `yaml:"password"`
+	MinNodes            int    `yaml:"minNodes"`
+	MaxUnassignedShards *int   `yaml:"maxUnassignedShards"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the cluster URL and thresholds.
+func (e *ElasticsearchCheck) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("elasticsearch check %s: url must be an http:// or https:// URL", e.Name)
+	}
+	if e.MinNodes < 0 || (e.MaxUnassignedShards != nil && *e.MaxUnassignedShards < 0) {
+		return fmt.Errorf("elasticsearch check %s: minNodes and maxUnassignedShards must not be negative", e.Name)
+	}
+	return nil
+}
+
+type clusterHealth struct {
+	ClusterName      string `json:"cluster_name"`
+	Status           string `json:"status"`
+	NumberOfNodes    int    `json:"number_of_nodes"`
+	UnassignedShards int    `json:"unassigned_shards"`
+}
+
+func (check ElasticsearchCheck) run(ctx context.Context) checkResult {
+	health, err := check.clusterHealth(ctx)
+	if err != nil {
+		return checkFailed("Elasticsearch Name: %s, URL: %s cluster health could not be read: %v", check.Name, check.URL, err)
+	}
+	summary := fmt.Sprintf("Cluster: %s, Status: %s, Nodes: %d, Unassigned shards: %d", health.ClusterName, health.Status, health.NumberOfNodes, health.UnassignedShards)
+
+	switch {
+	case health.Status == "red":
+		return checkFailed("Elasticsearch Name: %s, %s, cluster is red", check.Name, summary)
+	case check.MinNodes > 0 && health.NumberOfNodes < check.MinNodes:
+		return checkFailed("Elasticsearch Name: %s, %s, fewer than %d nodes", check.Name, summary, check.MinNodes)
+	case check.MaxUnassignedShards != nil && health.UnassignedShards > *check.MaxUnassignedShards:
+		return checkFailed("Elasticsearch Name: %s, %s, more than %d unassigned shards", check.Name, summary, *check.MaxUnassignedShards)
+	case health.Status == "yellow":
+		return checkWarning("Elasticsearch Name: %s, %s, cluster is yellow", check.Name, summary)
+	case health.Status != "green":
+		return checkFailed("Elasticsearch Name: %s, %s, unknown cluster status", check.Name, summary)
+	default:
+		return checkOK("Elasticsearch Name: %s, %s is as expected", check.Name, summary)
+	}
+}
+
+func (check ElasticsearchCheck) clusterHealth(ctx context.Context) (*clusterHealth, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(check.URL, "/")+"/_cluster/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	if check.Username != "" {
+		req.SetBasicAuth(check.Username, check.Password)
+	}
+	client := checkClient(ctx, req.URL.Scheme == "https")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster health API returned %s", resp.Status)
+	}
+	var health clusterHealth
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
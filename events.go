@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultSnapshotInterval is how often /events repeats the full report
+// unless ?snapshot= says otherwise.
+const defaultSnapshotInterval = 30 * time.Second
+
+// eventBacklog is how many events a subscriber may fall behind by before
+// it is disconnected, so a stalled client cannot hold up the scheduler.
+const eventBacklog = 64
+
+// healthEvent is one server-sent event.
+type healthEvent struct {
+	name string
+	data interface{}
+}
+
+// transitionEvent reports a check changing status.
+type transitionEvent struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// stateEvent reports the server changing between healthy, degraded and
+// unhealthy.
+type stateEvent struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// eventHub fans the changes between background check cycles out to the
+// clients of /events.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan healthEvent]struct{}
+	last        map[string]string // check status by override key
+	state       string
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// healthEvents is the hub the scheduler publishes to.
+var healthEvents = &eventHub{subscribers: map[chan healthEvent]struct{}{}, done: make(chan struct{})}
+
+// publish sends an event for each check whose status differs from the
+// last report published, and for a change of the overall state. The first
+// report only sets the baseline.
+func (h *eventHub) publish(report healthReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	last := make(map[string]string, len(report.Checks))
+	var events []healthEvent
+	for _, c := range report.Checks {
+		key := overrideKey(c.Type, c.Name)
+		last[key] = c.Status
+		if from, ok := h.last[key]; ok && from != c.Status {
+			events = append(events, healthEvent{"transition", transitionEvent{Type: c.Type, Name: c.Name, From: from, To: c.Status, Message: c.Message, Time: now, Labels: c.Labels, Annotations: c.Annotations}})
+		}
+	}
+	if h.last != nil && h.state != report.state() {
+		events = append(events, healthEvent{"state", stateEvent{From: h.state, To: report.state(), Status: report.Status, Time: now}})
+	}
+	h.last, h.state = last, report.state()
+	for ch := range h.subscribers {
+		for _, e := range events {
+			if !h.send(ch, e) {
+				break
+			}
+		}
+	}
+}
+
+// send queues e for the subscriber ch, disconnecting it when it has fallen
+// too far behind. h.mu is held.
+func (h *eventHub) send(ch chan healthEvent, e healthEvent) bool {
+	select {
+	case ch <- e:
+		return true
+	default:
+		delete(h.subscribers, ch)
+		close(ch)
+		return false
+	}
+}
+
+// subscribe returns a channel of events, closed if the subscriber falls
+// behind, and a function that ends the subscription.
+func (h *eventHub) subscribe() (<-chan healthEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan healthEvent, eventBacklog)
+	h.subscribers[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every stream, so they do not hold up shutdown.
+func (h *eventHub) close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// eventsHandler serves /events, a stream of server-sent events: a snapshot
+// of the full report on connecting and every ?snapshot= interval, a
+// transition whenever a check changes status and a state event whenever
+// the server changes between healthy, degraded and unhealthy.
+func eventsHandler(live *liveConfig, cache *resultCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		every, err := snapshotInterval(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Stop proxies such as nginx from buffering the stream.
+		w.Header().Set("X-Accel-Buffering", "no")
+		streamEvents(r.Context(), live, cache, every, func(e healthEvent) bool {
+			return writeEvent(w, flusher, e)
+		})
+	}
+}
+
+// snapshotInterval returns the ?snapshot= interval of a stream request.
+func snapshotInterval(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("snapshot")
+	if v == "" {
+		return defaultSnapshotInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("snapshot must be a duration of at least 1s")
+	}
+	return d, nil
+}
+
+// streamEvents passes a snapshot, then every event and another snapshot
+// every interval, to send until ctx ends, send fails, the subscriber falls
+// behind or the server shuts down.
+func streamEvents(ctx context.Context, live *liveConfig, cache *resultCache, every time.Duration, send func(healthEvent) bool) {
+	events, unsubscribe := healthEvents.subscribe()
+	defer unsubscribe()
+	snapshot := func() bool {
+		return send(healthEvent{"snapshot", backgroundReport(live.current(), cache, 0)})
+	}
+	if !snapshot() {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-healthEvents.done:
+			return
+		case e, ok := <-events:
+			if !ok || !send(e) {
+				return
+			}
+		case <-ticker.C:
+			if !snapshot() {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes e to the stream, reporting whether it could.
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, e healthEvent) bool {
+	data, err := json.Marshal(e.data)
+	if err != nil {
+		log.Printf("Failed to encode event: %v", err)
+		return false
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, data); err != nil {
+		return false
+	}
+	flusher.Flush()
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// resultExporter sends the results of each check run to a metrics or
+// monitoring system. export must not block for long, as it is called on
+// the path that runs checks; exporters that make network requests send in
+// the background.
+type resultExporter interface {
+	export(results []checkResult)
+}
+
+// checkExporters are the exporters configured under config; main sets them
+// before any check runs.
+var checkExporters []resultExporter
+
+// newExporters returns an exporter for each one enabled in cfg.
+func newExporters(cfg AppConfig) ([]resultExporter, error) {
+	var exporters []resultExporter
+	if cfg.StatsD.Enabled {
+		e, err := newStatsD(cfg.StatsD)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	if cfg.InfluxDB.Enabled {
+		exporters = append(exporters, newInfluxDB(cfg.InfluxDB))
+	}
+	if cfg.CloudWatch.Enabled {
+		exporters = append(exporters, newCloudWatch(cfg.CloudWatch))
+	}
+	if cfg.Zabbix.Enabled {
+		exporters = append(exporters, newZabbix(cfg.Zabbix))
+	}
+	if cfg.Datadog.Enabled {
+		e, err := newDatadog(cfg.Datadog)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	if cfg.Webhook.Enabled {
+		e, err := newWebhook(cfg.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	if cfg.SNMP.Enabled {
+		e, err := newSNMP(cfg.SNMP)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	return exporters, nil
+}
+
+// exportResults passes the checks that ran to every exporter. Skipped checks
+// and those outside their active windows have no result to report. With
+// leader election, only the leader exports.
+func exportResults(results []checkResult) {
+	if len(checkExporters) == 0 || !checkLeader.leading() {
+		return
+	}
+	ran := make([]checkResult, 0, len(results))
+	for _, r := range results {
+		if !r.Skipped && !r.NotScheduled {
+			ran = append(ran, r)
+		}
+	}
+	for _, e := range checkExporters {
+		e.export(ran)
+	}
+}
+
+// exportsInFlight counts the sends started by exportInBackground, so
+// shutdown can wait for them.
+var exportsInFlight sync.WaitGroup
+
+// exportInBackground runs send in its own goroutine, logging any error
+// under name.
+func exportInBackground(name string, send func() error) {
+	exportsInFlight.Add(1)
+	go func() {
+		defer exportsInFlight.Done()
+		if err := send(); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}()
+}
+
+// flushExports waits up to timeout for the sends in flight, reporting
+// whether they all finished.
+func flushExports(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		exportsInFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxFaultTTL caps how long a fault lasts, so one left behind by a test
+// cannot keep a server out of service for long.
+const maxFaultTTL = 24 * time.Hour
+
+// fault is a failure forced through the admin API, to test that load
+// balancers, alerting and runbooks respond to this daemon reporting
+// unhealthy. It lasts until Expires.
+type fault struct {
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// parseFault reads a fault from a request body: a ttl, at most maxFaultTTL,
+// and optionally a status, critical (the default) or warning, for checks,
+// and a message.
+func parseFault(r *http.Request, forCheck bool) (fault, error) {
+	var body struct {
+		TTL     string `json:"ttl"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return fault{}, fmt.Errorf("invalid request body: %w", err)
+	}
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil || ttl <= 0 || ttl > maxFaultTTL {
+		return fault{}, fmt.Errorf("ttl must be a positive duration of at most %s", maxFaultTTL)
+	}
+	switch {
+	case body.Status == "":
+		body.Status = statusCritical.String()
+	case !forCheck:
+		return fault{}, fmt.Errorf("status is only for check faults")
+	case body.Status != statusCritical.String() && body.Status != statusWarning.String():
+		return fault{}, fmt.Errorf("status must be critical or warning")
+	}
+	now := time.Now()
+	return fault{Status: body.Status, Message: body.Message, Created: now, Expires: now.Add(ttl)}, nil
+}
+
+// describe is the message reported while f is in effect.
+func (f fault) describe() string {
+	message := "fault injected until " + f.Expires.Format(time.RFC3339)
+	if f.Message != "" {
+		message += ": " + f.Message
+	}
+	return message
+}
+
+// faulted turns the result of a run of c to the status of its fault, if
+// it has one. runChecks calls it as the check's own result comes in, so the
+// fault goes through riseCount and fallCount, is recorded as a change and
+// is exported, as a real failure would be.
+func (o *checkOverrides) faulted(c check, result checkResult) checkResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	f, ok := o.faults[overrideKey(c.Type, c.Name)]
+	if !ok {
+		return result
+	}
+	result.Status, result.Reason = statusCritical, reasonFaultInjected
+	if f.Status == statusWarning.String() {
+		result.Status = statusWarning
+	}
+	result.Message += ", " + f.describe()
+	return result
+}
+
+// applyServerFault marks report unhealthy while the server has a fault.
+func (o *checkOverrides) applyServerFault(report *healthReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	if o.serverFault == nil {
+		return
+	}
+	report.Healthy, report.Degraded = false, false
+	report.Messages = append(report.Messages, "Server "+o.serverFault.describe())
+}
+
+func (o *checkOverrides) setFault(typ, name string, f fault) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.faults[overrideKey(typ, name)] = f
+}
+
+func (o *checkOverrides) clearFault(typ, name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := overrideKey(typ, name)
+	_, ok := o.faults[key]
+	delete(o.faults, key)
+	return ok
+}
+
+func (o *checkOverrides) setServerFault(f *fault) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire(time.Now())
+	had := o.serverFault != nil
+	o.serverFault = f
+	return had
+}
+
+// registerFaultHandlers adds the fault injection endpoints, which
+// config.admin.faults enables, behind auth. A check is run as soon as its
+// fault is injected or cleared, so the change shows in cache at once.
+func registerFaultHandlers(live *liveConfig, cache *resultCache, auth func(http.HandlerFunc) http.HandlerFunc) {
+	handle("POST /admin/checks/{name}/fault", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		f, err := parseFault(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		adminOverrides.setFault(c.Type, c.Name, f)
+		log.Printf("Injected a %s fault into %s check %s until %s: %s", f.Status, c.Type, c.Name, f.Expires.Format(time.RFC3339), f.Message)
+		writeJSON(w, map[string]interface{}{"type": c.Type, "name": c.Name, "fault": f, "result": runNow(r.Context(), []check{c}, cache)[0]})
+	}))
+	handle("DELETE /admin/checks/{name}/fault", auth(func(w http.ResponseWriter, r *http.Request) {
+		c, err := findCheck(live.current(), r.PathValue("name"), r.URL.Query().Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if !adminOverrides.clearFault(c.Type, c.Name) {
+			http.Error(w, "check has no fault", http.StatusNotFound)
+			return
+		}
+		log.Printf("Cleared the fault of %s check %s", c.Type, c.Name)
+		runNow(r.Context(), []check{c}, cache)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handle("POST /admin/fault", auth(func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFault(r, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		adminOverrides.setServerFault(&f)
+		log.Printf("Injected a server fault until %s: %s", f.Expires.Format(time.RFC3339), f.Message)
+		writeJSON(w, map[string]interface{}{"fault": f})
+	}))
+	handle("DELETE /admin/fault", auth(func(w http.ResponseWriter, r *http.Request) {
+		if !adminOverrides.setServerFault(nil) {
+			http.Error(w, "server has no fault", http.StatusNotFound)
+			return
+		}
+		log.Printf("Cleared the server fault")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
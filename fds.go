@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileDescriptorCheck reports open file descriptors against their limits:
+// the system's, from /proc/sys/fs/file-nr, and, with Pattern or Pid set,
+// each matching process's against its soft RLIMIT_NOFILE, so a process
+// leaking descriptors warns before it hits its ulimit. Warn and crit grade
+// the highest used percentage. Reading another user's processes takes
+// root or CAP_SYS_PTRACE; those that cannot be read are counted apart.
+type FileDescriptorCheck struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	Pid     int    `yaml:"pid"`
+
+	// Thresholds grade the highest used percentage.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the pattern, pid and thresholds.
+func (f *FileDescriptorCheck) Validate() error {
+	if _, err := regexp.Compile(f.Pattern); err != nil {
+		return fmt.Errorf("file descriptor check %s: invalid pattern: %w", f.Name, err)
+	}
+	if f.Pid < 0 {
+		return fmt.Errorf("file descriptor check %s: pid must not be negative", f.Name)
+	}
+	if f.Pattern != "" && f.Pid != 0 {
+		return fmt.Errorf("file descriptor check %s: set pattern or pid, not both", f.Name)
+	}
+	if err := f.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("file descriptor check %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+func (check FileDescriptorCheck) run(ctx context.Context) checkResult {
+	open, limit, err := readFileNr()
+	if err != nil {
+		return checkFailed("File Descriptor Name: %s, could not be read: %v", check.Name, err)
+	}
+	highest := float64(open) / float64(limit) * 100
+	details := []string{fmt.Sprintf("System: %d of %d (%.0f%%)", open, limit, highest)}
+
+	if check.Pattern != "" || check.Pid != 0 {
+		pids := []int{check.Pid}
+		if check.Pattern != "" {
+			all, err := processCommands()
+			if err != nil {
+				return checkFailed("File Descriptor Name: %s, processes could not be listed: %v", check.Name, err)
+			}
+			pattern := regexp.MustCompile(check.Pattern) // checked by Validate
+			pids = pids[:0]
+			for pid, command := range all {
+				if pattern.MatchString(command) {
+					pids = append(pids, pid)
+				}
+			}
+		}
+		busiest, busiestUsed, unreadable, read := "", -1.0, 0, 0
+		for _, pid := range pids {
+			open, limit, err := readProcessFds(pid)
+			if err != nil {
+				unreadable++
+				continue
+			}
+			read++
+			if limit <= 0 {
+				continue // unlimited
+			}
+			if used := float64(open) / float64(limit) * 100; used > busiestUsed {
+				comm, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm")) // #nosec G304 -- a pid directory under /proc
+				busiest = fmt.Sprintf("%s (pid %d): %d of %d (%.0f%%)", strings.TrimSpace(string(comm)), pid, open, limit, used)
+				busiestUsed = used
+			}
+		}
+		if len(pids) == 0 {
+			return checkFailed("File Descriptor Name: %s, %s, no process matches %s", check.Name, details[0], check.Pattern)
+		}
+		if read == 0 {
+			return checkFailed("File Descriptor Name: %s, %s, no matching process could be read", check.Name, details[0])
+		}
+		details = append(details, fmt.Sprintf("Processes: %d", read))
+		if busiest != "" {
+			details = append(details, "Busiest: "+busiest)
+		}
+		if unreadable > 0 {
+			details = append(details, fmt.Sprintf("%d unreadable", unreadable))
+		}
+		highest = max(highest, busiestUsed)
+	}
+	result := checkOK("File Descriptor Name: %s, %s", check.Name, strings.Join(details, ", "))
+	return check.Thresholds.apply(result, highest, fmt.Sprintf("Highest: %.0f%%", highest))
+}
+
+// readFileNr returns the file handles allocated system-wide and the most
+// that may be.
+func readFileNr() (int64, int64, error) {
+	value, err := readSysctl("fs.file-nr")
+	if err != nil {
+		return 0, 0, err
+	}
+	var allocated, free, limit int64
+	if _, err := fmt.Sscanf(value, "%d %d %d", &allocated, &free, &limit); err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("invalid file-nr %q", value)
+	}
+	return allocated - free, limit, nil
+}
+
+// readProcessFds returns how many descriptors a process has open and its
+// soft limit on them, zero when unlimited.
+func readProcessFds(pid int) (int, int64, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return 0, 0, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "limits")) // #nosec G304 -- a pid directory under /proc
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "Max open files"); ok {
+			fields := strings.Fields(rest)
+			if len(fields) == 0 || fields[0] == "unlimited" {
+				return len(fds), 0, nil
+			}
+			limit, err := strconv.ParseInt(fields[0], 10, 64)
+			return len(fds), limit, err
+		}
+	}
+	return 0, 0, fmt.Errorf("no open files limit for pid %d", pid)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FirewallCheck asserts that a chain exists in the loaded firewall ruleset
+// and, optionally, that it holds a rule with a given comment tag or
+// containing a given match specification.
+type FirewallCheck struct {
+	Name    string `yaml:"name"`
+	Backend string `yaml:"backend"`
+	Table   string `yaml:"table"`
+	Chain   string `yaml:"chain"`
+	Comment string `yaml:"comment"`
+	Rule    string `yaml:"rule"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+var firewallNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Validate checks the backend and table name.
+func (f *FirewallCheck) Validate() error {
+	switch f.Backend {
+	case "", "nftables", "iptables", "ip6tables":
+	default:
+		return fmt.Errorf("firewall check %s: unsupported backend %q", f.Name, f.Backend)
+	}
+	if f.Table != "" && !firewallNameRegex.MatchString(f.Table) {
+		return fmt.Errorf("firewall check %s: invalid table %q", f.Name, f.Table)
+	}
+	if f.Chain == "" && f.Comment == "" && f.Rule == "" {
+		return fmt.Errorf("firewall check %s: chain, comment or rule is required", f.Name)
+	}
+	return nil
+}
+
+// firewallRule is one rule from a ruleset dump, with the table and chain it belongs to.
+type firewallRule struct {
+	table string
+	chain string
+	text  string
+}
+
+type firewallRuleset struct {
+	chains map[string]bool // "table/chain"
+	rules  []firewallRule
+}
+
+func (check FirewallCheck) run(ctx context.Context) checkResult {
+	backend := check.Backend
+	if backend == "" {
+		backend = "nftables"
+	}
+	ruleset, err := loadFirewallRuleset(ctx, backend, check.Table)
+	if err != nil {
+		return checkFailed("Firewall Name: %s, Backend: %s ruleset could not be read: %v", check.Name, backend, err)
+	}
+	if problem := ruleset.missing(check); problem != "" {
+		return checkFailed("Firewall Name: %s, Backend: %s, %s", check.Name, backend, problem)
+	}
+	return checkOK("Firewall Name: %s, Backend: %s, rules are present as expected", check.Name, backend)
+}
+
+// missing describes which part of the check is not present in the ruleset,
+// or returns an empty string when everything is found.
+func (r *firewallRuleset) missing(check FirewallCheck) string {
+	if check.Chain != "" && !r.hasChain(check.Table, check.Chain) {
+		return fmt.Sprintf("Chain: %s is missing", check.Chain)
+	}
+	if check.Comment == "" && check.Rule == "" {
+		return ""
+	}
+	for _, rule := range r.rules {
+		if check.Table != "" && rule.table != check.Table {
+			continue
+		}
+		if check.Chain != "" && rule.chain != check.Chain {
+			continue
+		}
+		if check.Comment != "" && !ruleHasComment(rule.text, check.Comment) {
+			continue
+		}
+		if check.Rule != "" && !strings.Contains(rule.text, check.Rule) {
+			continue
+		}
+		return ""
+	}
+	if check.Comment != "" {
+		return fmt.Sprintf("Rule with comment: %s is missing", check.Comment)
+	}
+	return fmt.Sprintf("Rule: %s is missing", check.Rule)
+}
+
+func (r *firewallRuleset) hasChain(table, chain string) bool {
+	if table != "" {
+		return r.chains[table+"/"+chain]
+	}
+	for key := range r.chains {
+		if strings.HasSuffix(key, "/"+chain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleHasComment matches both iptables-save (--comment tag) and nft (comment "tag") syntax.
+func ruleHasComment(rule, comment string) bool {
+	for _, form := range []string{
+		`--comment "` + comment + `"`,
+		`--comment ` + comment,
+		`comment "` + comment + `"`,
+	} {
+		if strings.Contains(rule, form) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadFirewallRuleset(ctx context.Context, backend, table string) (*firewallRuleset, error) {
+	var output []byte
+	var err error
+	switch backend {
+	case "nftables":
+		output, err = commandOutput(ctx, "nft", "list", "ruleset")
+	default:
+		args := []string{}
+		if table != "" {
+			args = append(args, "-t", table)
+		}
+		output, err = commandOutput(ctx, backend+"-save", args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if backend == "nftables" {
+		return parseNftRuleset(output)
+	}
+	return parseIptablesSave(output)
+}
+
+// parseIptablesSave parses iptables-save output: "*table", ":CHAIN POLICY [..]" and "-A CHAIN spec".
+func parseIptablesSave(output []byte) (*firewallRuleset, error) {
+	ruleset := &firewallRuleset{chains: map[string]bool{}}
+	var table string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+		case strings.HasPrefix(line, ":"):
+			if fields := strings.Fields(line[1:]); len(fields) > 0 {
+				ruleset.chains[table+"/"+fields[0]] = true
+			}
+		case strings.HasPrefix(line, "-A "):
+			fields := strings.SplitN(line[3:], " ", 2)
+			rule := firewallRule{table: table, chain: fields[0]}
+			if len(fields) > 1 {
+				rule.text = fields[1]
+			}
+			ruleset.rules = append(ruleset.rules, rule)
+		}
+	}
+	return ruleset, scanner.Err()
+}
+
+// parseNftRuleset parses the text form of "nft list ruleset", tracking the
+// enclosing table and chain blocks.
+func parseNftRuleset(output []byte) (*firewallRuleset, error) {
+	ruleset := &firewallRuleset{chains: map[string]bool{}}
+	var table, chain string
+	depth := 0
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasSuffix(line, "{"):
+			depth++
+			if len(fields) >= 2 && fields[0] == "table" {
+				// "table <family> <name> {"
+				table = fields[len(fields)-2]
+			} else if len(fields) >= 2 && fields[0] == "chain" {
+				chain = fields[1]
+				ruleset.chains[table+"/"+chain] = true
+			}
+		case line == "}":
+			depth--
+			switch depth {
+			case 0:
+				table, chain = "", ""
+			case 1:
+				chain = ""
+			}
+		case chain != "" && depth == 2:
+			if fields[0] == "type" || fields[0] == "policy" {
+				continue
+			}
+			ruleset.rules = append(ruleset.rules, firewallRule{table: table, chain: chain, text: line})
+		}
+	}
+	return ruleset, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// OutputTemplate is a response format of the operator's own, such as CSV,
+// XML or an HAProxy agent-check reply, selected by
+// ?format=template&name=<name>. Template, or the contents of File, is a Go
+// template over the report: .Healthy, .Status, .Messages and .Checks, each
+// with .Type, .Name, .Status, .Message, .LastChecked and .Age. The csv, xml
+// and json functions escape values for those formats. ContentType
+// defaults to plain text.
+type OutputTemplate struct {
+	Template    string `yaml:"template"`
+	File        string `yaml:"file"`
+	ContentType string `yaml:"contentType"`
+}
+
+// Validate checks that exactly one of the template and file is given and
+// that the template renders.
+func (t *OutputTemplate) Validate(name string) error {
+	if (t.Template == "") == (t.File == "") {
+		return fmt.Errorf("output template %s: one of template and file is required", name)
+	}
+	text, err := t.text()
+	if err != nil {
+		return fmt.Errorf("output template %s: %w", name, err)
+	}
+	sample := healthReport{Messages: []string{""}, Checks: []checkReport{{}}}
+	if _, err := renderTemplate(text, sample); err != nil {
+		return fmt.Errorf("output template %s: %w", name, err)
+	}
+	return nil
+}
+
+// text returns the template, reading it from File when set.
+func (t *OutputTemplate) text() (string, error) {
+	if t.File == "" {
+		return t.Template, nil
+	}
+	data, err := os.ReadFile(t.File) // #nosec G304 -- path is from the config file
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// contentType returns the Content-Type of the format's responses.
+func (t *OutputTemplate) contentType() string {
+	if t.ContentType == "" {
+		return "text/plain; charset=utf-8"
+	}
+	return t.ContentType
+}
+
+// render renders report through the template.
+func (t *OutputTemplate) render(report healthReport) ([]byte, error) {
+	text, err := t.text()
+	if err != nil {
+		return nil, err
+	}
+	out, err := renderTemplate(text, report)
+	return []byte(out), err
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskSpace returns the bytes used on the filesystem holding path and the
+// bytes available to unprivileged users.
+func diskSpace(path string) (used, avail float64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	size := float64(fs.Bsize) // #nosec G115 -- block sizes are small and positive
+	return float64(fs.Blocks-fs.Bfree) * size, float64(fs.Bavail) * size, nil
+}
+
+// fileInode returns the inode of the file info describes, so a rotated log
+// is noticed even when the new file has grown past the old offset.
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
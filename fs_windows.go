@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace returns the bytes used on the volume holding path and the bytes
+// available to the daemon's user, which quotas may make less than free.
+func diskSpace(path string) (used, avail float64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, &total, &free); err != nil {
+		return 0, 0, err
+	}
+	return float64(total - free), float64(available), nil
+}
+
+// fileInode returns zero: os.FileInfo carries no file index on Windows, so
+// rotation is noticed only when the new file is smaller than the old offset.
+func fileInode(os.FileInfo) uint64 {
+	return 0
+}
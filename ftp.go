@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// FTPCheck logs in to an FTP, FTPS or SFTP server and, when Path is set,
+// verifies that it exists. ftps:// URLs use implicit TLS; StartTLS upgrades
+// a plain ftp:// control connection with AUTH TLS. sftp:// logs in with
+// Password or PrivateKeyFile, optionally pinning HostKeyFingerprint.
+type FTPCheck struct {
+	Name               string `yaml:"name"`
+	URL                string `yaml:"url"`
+	StartTLS           bool   `yaml:"startTLS"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"`
+	PrivateKeyFile     string `yaml:"privateKeyFile"`
+	HostKeyFingerprint string `yaml:"hostKeyFingerprint"`
+	Path               string `yaml:"path"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the URL scheme and the options valid for it.
+func (f *FTPCheck) Validate() error {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return fmt.Errorf("ftp check %s: invalid url: %w", f.Name, err)
+	}
+	switch u.Scheme {
+	case "ftp":
+	case "ftps":
+		if f.StartTLS {
+			return fmt.Errorf("ftp check %s: startTLS cannot be used with ftps", f.Name)
+		}
+	case "sftp":
+		if f.StartTLS {
+			return fmt.Errorf("ftp check %s: startTLS cannot be used with sftp", f.Name)
+		}
+		if f.Username == "" {
+			return fmt.Errorf("ftp check %s: username is required for sftp", f.Name)
+		}
+	default:
+		return fmt.Errorf("ftp check %s: url scheme must be ftp, ftps or sftp", f.Name)
+	}
+	if f.PrivateKeyFile != "" && u.Scheme != "sftp" {
+		return fmt.Errorf("ftp check %s: privateKeyFile is only supported for sftp", f.Name)
+	}
+	return nil
+}
+
+func (check FTPCheck) run(ctx context.Context) checkResult {
+	u, err := url.Parse(check.URL)
+	if err != nil {
+		return checkFailed("FTP Name: %s, invalid url: %v", check.Name, err)
+	}
+	if u.Scheme == "sftp" {
+		err = check.probeSFTP(ctx, u)
+	} else {
+		err = check.probeFTP(ctx, u)
+	}
+	if err != nil {
+		return checkFailed("FTP Name: %s, URL: %s %v", check.Name, check.URL, err)
+	}
+	if check.Path != "" {
+		return checkOK("FTP Name: %s, URL: %s, login succeeded and %s exists", check.Name, check.URL, check.Path)
+	}
+	return checkOK("FTP Name: %s, URL: %s, login succeeded", check.Name, check.URL)
+}
+
+func (check FTPCheck) probeFTP(ctx context.Context, u *url.URL) error {
+	host := u.Host
+	if u.Port() == "" {
+		port := "21"
+		if u.Scheme == "ftps" {
+			port = "990"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: check.InsecureSkipVerify}
+
+	var dialTLS *tls.Config
+	if u.Scheme == "ftps" {
+		dialTLS = tlsConfig
+	}
+	conn, err := dialCheck(ctx, "tcp", host, 5*time.Second, dialTLS)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return fmt.Errorf("sent an unexpected greeting: %w", err)
+	}
+	if check.StartTLS {
+		if _, err := ftpCommand(text, 234, "AUTH TLS"); err != nil {
+			return fmt.Errorf("AUTH TLS failed: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		text = textproto.NewConn(tlsConn)
+	}
+
+	user, pass := check.Username, check.Password
+	if user == "" {
+		user, pass = "anonymous", "server-health-api@"
+	}
+	code, err := ftpCommand(text, 0, "USER %s", user)
+	switch {
+	case err != nil:
+		return fmt.Errorf("login failed: %w", err)
+	case code == 331, code == 332:
+		if _, err := ftpCommand(text, 230, "PASS %s", pass); err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+	case code != 230:
+		return fmt.Errorf("login failed with code %d", code)
+	}
+
+	if check.Path != "" {
+		// SIZE only answers for files, so fall back to CWD for directories.
+		if _, err := ftpCommand(text, 213, "SIZE %s", check.Path); err != nil {
+			if _, err := ftpCommand(text, 250, "CWD %s", check.Path); err != nil {
+				return fmt.Errorf("path %s is not accessible: %w", check.Path, err)
+			}
+		}
+	}
+
+	_, err = ftpCommand(text, 221, "QUIT")
+	return err
+}
+
+// ftpCommand sends a command and reads its reply. With expectCode 0 any
+// reply below 400 is accepted and its code returned.
+func ftpCommand(text *textproto.Conn, expectCode int, format string, args ...interface{}) (int, error) {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return 0, err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	if expectCode == 0 {
+		code, msg, err := text.ReadResponse(0)
+		if err == nil && code >= 400 {
+			err = &textproto.Error{Code: code, Msg: msg}
+		}
+		return code, err
+	}
+	code, _, err := text.ReadResponse(expectCode)
+	return code, err
+}
+
+// SFTP packet types and the version we speak (draft-ietf-secsh-filexfer-02).
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpStat    = 17
+	sftpStatus  = 101
+	sftpAttrs   = 105
+	sftpMaxSize = 256 << 10
+)
+
+func (check FTPCheck) probeSFTP(ctx context.Context, u *url.URL) error {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	var auth []ssh.AuthMethod
+	if check.PrivateKeyFile != "" {
+		signer, err := SSHCheck{PrivateKeyFile: check.PrivateKeyFile, Passphrase: check.Password}.signer()
+		if err != nil {
+			return fmt.Errorf("private key could not be loaded: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else {
+		auth = append(auth, ssh.Password(check.Password))
+	}
+	config := &ssh.ClientConfig{
+		User:    check.Username,
+		Auth:    auth,
+		Timeout: 5 * time.Second,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); check.HostKeyFingerprint != "" && fp != check.HostKeyFingerprint {
+				return fmt.Errorf("host key %s does not match pinned %s", fp, check.HostKeyFingerprint)
+			}
+			return nil
+		},
+	}
+
+	conn, err := dialCheck(ctx, "tcp", host, config.Timeout, nil)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer closeAndLog(client, "ssh client")
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(session, "ssh session")
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("sftp subsystem unavailable: %w", err)
+	}
+
+	if err := writeSFTPPacket(w, sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return err
+	}
+	if typ, _, err := readSFTPPacket(r); err != nil || typ != sftpVersion {
+		return fmt.Errorf("sftp handshake failed")
+	}
+
+	if check.Path != "" {
+		// STAT: request id, path
+		req := binary.BigEndian.AppendUint32(nil, 1)
+		req = binary.BigEndian.AppendUint32(req, uint32(len(check.Path))) // #nosec G115 -- path is short
+		req = append(req, check.Path...)
+		if err := writeSFTPPacket(w, sftpStat, req); err != nil {
+			return err
+		}
+		typ, body, err := readSFTPPacket(r)
+		if err != nil {
+			return err
+		}
+		if typ == sftpStatus && len(body) >= 8 {
+			return fmt.Errorf("path %s is not accessible: status %d", check.Path, binary.BigEndian.Uint32(body[4:]))
+		}
+		if typ != sftpAttrs {
+			return fmt.Errorf("unexpected sftp reply %d", typ)
+		}
+	}
+	return nil
+}
+
+func writeSFTPPacket(w io.Writer, typ byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1)) // #nosec G115 -- packets are small
+	packet = append(append(packet, typ), payload...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 || length > sftpMaxSize {
+		return 0, nil, errors.New("invalid sftp packet length")
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GatewayCheck asserts that a default route exists and that its gateway
+// answers, by ARP resolution, ping, or a TCP connection to Port.
+type GatewayCheck struct {
+	Name   string `yaml:"name"`
+	Family string `yaml:"family"`
+	Method string `yaml:"method"`
+	Port   int    `yaml:"port"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the address family, probe method and port.
+func (g *GatewayCheck) Validate() error {
+	switch g.Family {
+	case "", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("gateway check %s: unsupported family %q", g.Name, g.Family)
+	}
+	switch g.Method {
+	case "", "ping":
+	case "arp":
+		if g.Family == "ipv6" {
+			return fmt.Errorf("gateway check %s: arp is only supported for ipv4", g.Name)
+		}
+	case "tcp":
+		if g.Port < 1 || g.Port > 65535 {
+			return fmt.Errorf("gateway check %s: invalid port: %d", g.Name, g.Port)
+		}
+	default:
+		return fmt.Errorf("gateway check %s: unsupported method %q", g.Name, g.Method)
+	}
+	return nil
+}
+
+func (check GatewayCheck) run(ctx context.Context) checkResult {
+	var gw *net.IPAddr
+	var err error
+	if check.Family == "ipv6" {
+		gw, err = defaultGatewayIPv6()
+	} else {
+		gw, err = defaultGatewayIPv4()
+	}
+	if err != nil {
+		return checkFailed("Gateway Name: %s, no default route: %v", check.Name, err)
+	}
+
+	method := check.Method
+	if method == "" {
+		method = "ping"
+	}
+	if err := probeGateway(ctx, gw, method, check.Port); err != nil {
+		return checkFailed("Gateway Name: %s, Gateway: %s is not reachable via %s: %v", check.Name, gw, method, err)
+	}
+	return checkOK("Gateway Name: %s, Gateway: %s is reachable via %s", check.Name, gw, method)
+}
+
+func probeGateway(ctx context.Context, gw *net.IPAddr, method string, port int) error {
+	switch method {
+	case "tcp":
+		conn, err := dialCheck(ctx, "tcp", net.JoinHostPort(gw.String(), strconv.Itoa(port)), 1*time.Second, nil)
+		if err != nil {
+			return err
+		}
+		closeAndLog(conn, "connection")
+		return nil
+	case "arp":
+		return resolveARP(gw.IP)
+	default:
+		if output, err := commandOutput(ctx, "ping", "-c", "1", "-W", "1", gw.String()); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+}
+
+// resolveARP sends a datagram towards the gateway to trigger neighbour
+// resolution, then looks for a completed entry in the kernel ARP table.
+func resolveARP(gw net.IP) error {
+	if conn, err := net.Dial("udp4", net.JoinHostPort(gw.String(), "9")); err == nil {
+		if _, err := conn.Write([]byte{0}); err != nil {
+			log.Printf("Failed to send ARP trigger to %s: %v", gw, err)
+		}
+		closeAndLog(conn, "connection")
+	}
+
+	deadline := time.Now().Add(1 * time.Second)
+	for {
+		complete, err := arpEntryComplete(gw)
+		if err != nil {
+			return err
+		}
+		if complete {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no ARP reply")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func arpEntryComplete(ip net.IP) (bool, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return false, err
+	}
+	defer closeAndLog(f, "/proc/net/arp")
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// IP address, HW type, Flags, HW address, Mask, Device
+		if len(fields) < 4 || fields[0] != ip.String() {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err == nil && flags&0x2 != 0 {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// defaultGatewayIPv4 reads the default route's gateway from /proc/net/route.
+func defaultGatewayIPv4() (*net.IPAddr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(f, "/proc/net/route")
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface, Destination, Gateway, Flags, RefCnt, Use, Metric, Mask, ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, binary.BigEndian.Uint32(raw))
+		return &net.IPAddr{IP: ip}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no IPv4 default route")
+}
+
+// defaultGatewayIPv6 reads the default route's gateway from /proc/net/ipv6_route.
+// Link-local gateways are returned with the route's device as their zone.
+func defaultGatewayIPv6() (*net.IPAddr, error) {
+	f, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(f, "/proc/net/ipv6_route")
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// dest, dest prefix len, src, src prefix len, next hop, metric, refcnt, use, flags, device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[0] != strings.Repeat("0", 32) || fields[1] != "00" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[4])
+		if err != nil || len(raw) != 16 {
+			continue
+		}
+		ip := net.IP(raw)
+		if ip.IsUnspecified() {
+			continue
+		}
+		gw := &net.IPAddr{IP: ip}
+		if ip.IsLinkLocalUnicast() {
+			gw.Zone = fields[9]
+		}
+		return gw, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no IPv6 default route")
+}
@@ -3,3 +3,9 @@ module github.com/digitalis-io/server-health-api
 go 1.25.3
 
 require gopkg.in/yaml.v2 v2.4.0
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/crypto v0.47.0
+	golang.org/x/sys v0.40.0
+)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GPUCheck asserts via nvidia-smi that at least MinCount NVIDIA GPUs are
+// visible, none reports uncorrected ECC errors or a pending retirement, and
+// each is below the configured temperature and memory utilization limits.
+type GPUCheck struct {
+	Name           string  `yaml:"name"`
+	MinCount       int     `yaml:"minCount"`
+	MaxTemperature float64 `yaml:"maxTemperature"`
+	MaxMemoryUsed  float64 `yaml:"maxMemoryUsed"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the thresholds.
+func (g *GPUCheck) Validate() error {
+	if g.MinCount < 0 {
+		return fmt.Errorf("gpu check %s: invalid minCount: %d", g.Name, g.MinCount)
+	}
+	if g.MaxMemoryUsed < 0 || g.MaxMemoryUsed > 100 {
+		return fmt.Errorf("gpu check %s: maxMemoryUsed must be a percentage", g.Name)
+	}
+	return nil
+}
+
+// gpuStatus is one GPU as reported by nvidia-smi.
+type gpuStatus struct {
+	Index       string
+	Name        string
+	Temperature float64
+	MemoryUsed  float64
+	MemoryTotal float64
+	ECCErrors   string
+	Retired     string
+}
+
+var gpuQueryFields = []string{
+	"index",
+	"name",
+	"temperature.gpu",
+	"memory.used",
+	"memory.total",
+	"ecc.errors.uncorrected.volatile.total",
+	"retired_pages.pending",
+}
+
+func (check GPUCheck) run(ctx context.Context) checkResult {
+	gpus, err := queryGPUs(ctx)
+	if err != nil {
+		return checkFailed("GPU Name: %s, nvidia-smi failed: %v", check.Name, err)
+	}
+	minCount := check.MinCount
+	if minCount == 0 {
+		minCount = 1
+	}
+	if len(gpus) < minCount {
+		return checkFailed("GPU Name: %s, GPUs visible: %d, expected at least: %d", check.Name, len(gpus), minCount)
+	}
+
+	var problems []string
+	for _, gpu := range gpus {
+		id := "GPU " + gpu.Index
+		if n, err := strconv.Atoi(gpu.ECCErrors); err == nil && n > 0 {
+			problems = append(problems, fmt.Sprintf("%s has %d uncorrected ECC errors", id, n))
+		}
+		if gpu.Retired == "Yes" {
+			problems = append(problems, id+" has a page retirement pending")
+		}
+		if check.MaxTemperature > 0 && gpu.Temperature > check.MaxTemperature {
+			problems = append(problems, fmt.Sprintf("%s temperature %.0f°C exceeds %.0f°C", id, gpu.Temperature, check.MaxTemperature))
+		}
+		if check.MaxMemoryUsed > 0 && gpu.MemoryTotal > 0 {
+			if used := gpu.MemoryUsed / gpu.MemoryTotal * 100; used > check.MaxMemoryUsed {
+				problems = append(problems, fmt.Sprintf("%s memory used %.0f%% exceeds %.0f%%", id, used, check.MaxMemoryUsed))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return checkFailed("GPU Name: %s, %s", check.Name, strings.Join(problems, "; "))
+	}
+	return checkOK("GPU Name: %s, GPUs: %d are healthy", check.Name, len(gpus))
+}
+
+func queryGPUs(ctx context.Context) ([]gpuStatus, error) {
+	output, err := commandOutput(ctx, "nvidia-smi", "--query-gpu="+strings.Join(gpuQueryFields, ","), "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	return parseGPUQuery(string(output))
+}
+
+// parseGPUQuery parses nvidia-smi CSV output; unsupported fields are
+// reported as "[N/A]" and parse as zero.
+func parseGPUQuery(output string) ([]gpuStatus, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	gpus := make([]gpuStatus, 0, len(records))
+	for _, rec := range records {
+		if len(rec) != len(gpuQueryFields) {
+			return nil, fmt.Errorf("unexpected nvidia-smi output: %q", strings.Join(rec, ","))
+		}
+		number := func(s string) float64 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return 0
+			}
+			return v
+		}
+		gpus = append(gpus, gpuStatus{
+			Index:       rec[0],
+			Name:        rec[1],
+			Temperature: number(rec[2]),
+			MemoryUsed:  number(rec[3]),
+			MemoryTotal: number(rec[4]),
+			ECCErrors:   strings.TrimSpace(rec[5]),
+			Retired:     strings.TrimSpace(rec[6]),
+		})
+	}
+	return gpus, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// GRPCCheck calls the standard grpc.health.v1.Health/Check RPC over
+// HTTP/2. A server that answers UNIMPLEMENTED has still completed the
+// HTTP/2 and gRPC handshake and is reported as up.
+type GRPCCheck struct {
+	Name               string `yaml:"name"`
+	Address            string `yaml:"address"`
+	TLS                bool   `yaml:"tls"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	Authority          string `yaml:"authority"`
+	Service            string `yaml:"service"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the target address.
+func (g *GRPCCheck) Validate() error {
+	if _, _, err := net.SplitHostPort(g.Address); err != nil {
+		return fmt.Errorf("grpc check %s: address must be host:port: %w", g.Name, err)
+	}
+	return nil
+}
+
+// Health check serving statuses and the gRPC status codes we distinguish.
+const (
+	grpcServing           = 1
+	grpcNotServing        = 2
+	grpcServiceUnknown    = 3
+	grpcCodeNotFound      = 5
+	grpcCodeUnimplemented = 12
+	grpcMaxMessage        = 4 << 20
+)
+
+func (check GRPCCheck) run(ctx context.Context) checkResult {
+	service := check.Service
+	if service == "" {
+		service = "overall"
+	}
+	status, code, err := check.healthCheck(ctx)
+	switch {
+	case err != nil:
+		return checkFailed("gRPC Name: %s, Address: %s %v", check.Name, check.Address, err)
+	case code == grpcCodeUnimplemented:
+		return checkOK("gRPC Name: %s, Address: %s is up but does not implement the health service", check.Name, check.Address)
+	case code == grpcCodeNotFound:
+		return checkFailed("gRPC Name: %s, Address: %s, Service: %s is unknown to the server", check.Name, check.Address, service)
+	case code != 0:
+		return checkFailed("gRPC Name: %s, Address: %s, health check returned gRPC status %d", check.Name, check.Address, code)
+	case status == grpcServing:
+		return checkOK("gRPC Name: %s, Address: %s, Service: %s is serving", check.Name, check.Address, service)
+	case status == grpcServiceUnknown:
+		return checkFailed("gRPC Name: %s, Address: %s, Service: %s is unknown to the server", check.Name, check.Address, service)
+	case status == grpcNotServing:
+		return checkFailed("gRPC Name: %s, Address: %s, Service: %s is not serving", check.Name, check.Address, service)
+	default:
+		return checkFailed("gRPC Name: %s, Address: %s, Service: %s status is unknown", check.Name, check.Address, service)
+	}
+}
+
+// healthCheck returns the serving status and the gRPC status code.
+func (check GRPCCheck) healthCheck(ctx context.Context) (int, int, error) {
+	authority := check.Authority
+	if authority == "" {
+		authority = check.Address
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialCheck(ctx, network, address, 5*time.Second, nil)
+		},
+		Protocols: new(http.Protocols),
+	}
+	scheme := "http"
+	if check.TLS {
+		scheme = "https"
+		serverName, _, err := net.SplitHostPort(authority)
+		if err != nil {
+			serverName = authority
+		}
+		transport.TLSClientConfig = &tls.Config{ServerName: serverName, InsecureSkipVerify: check.InsecureSkipVerify}
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	defer client.CloseIdleConnections()
+
+	// HealthCheckRequest { string service = 1; } in a length-prefixed message.
+	var msg []byte
+	if check.Service != "" {
+		msg = append(binary.AppendUvarint([]byte{0x0a}, uint64(len(check.Service))), check.Service...)
+	}
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))) // #nosec G115 -- service names are short
+	body = append(body, msg...)
+
+	target := &url.URL{Scheme: scheme, Host: check.Address, Path: "/grpc.health.v1.Health/Check"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Host = authority
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("returned HTTP %s", resp.Status)
+	}
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, grpcMaxMessage))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// grpc-status arrives in the trailers, or in the headers for
+	// trailers-only responses.
+	grpcStatus := resp.Trailer.Get("Grpc-Status")
+	if grpcStatus == "" {
+		grpcStatus = resp.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(grpcStatus)
+	if err != nil {
+		return 0, 0, fmt.Errorf("returned no grpc-status")
+	}
+	if code != 0 {
+		return 0, code, nil
+	}
+	if len(payload) < 5 {
+		return 0, 0, fmt.Errorf("returned an empty health response")
+	}
+	return parseHealthStatus(payload[5:]), 0, nil
+}
+
+// parseHealthStatus reads field 1 (status) of a HealthCheckResponse,
+// skipping any other fields. A missing field is the zero value, UNKNOWN.
+func parseHealthStatus(msg []byte) int {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0
+			}
+			if key>>3 == 1 {
+				return int(v) // #nosec G115 -- enum values are small
+			}
+			msg = msg[n:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return 0
+			}
+			msg = msg[n+int(l):] // #nosec G115 -- bounded by len(msg) above
+		default:
+			return 0
+		}
+	}
+	return 0
+}
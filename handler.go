@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// healthReport is the outcome of a /healthy request, rendered in whichever
+// format the client asked for.
+type healthReport struct {
+	Healthy    bool          `json:"-"`
+	Degraded   bool          `json:"degraded,omitempty"`
+	Drained    bool          `json:"drained,omitempty"`
+	Status     string        `json:"status"`
+	Messages   []string      `json:"messages"`
+	Checks     []checkReport `json:"checks"`
+	StaleAfter *time.Time    `json:"staleAfter,omitempty"`
+	Score      *float64      `json:"score,omitempty"`
+
+	// summaries are the statuses of the category summaries that follow
+	// the messages of the checks.
+	summaries []string
+	// score is the health score, set whether or not config.score reports
+	// it, for the HAProxy agent's weight.
+	score float64
+}
+
+// healthyHandler serves /healthy and /api/v1/health, and the checks of a
+// profile for requests that are for one, in whichever format the client
+// asked for. Responses are signed by signer when it is not nil.
+func healthyHandler(live *liveConfig, cache *resultCache, signer *responseSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := live.current()
+		report, statusCode, profile, ok := requestHealth(r, config, cache)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = negotiateFormat(r.Header.Get("Accept"))
+		}
+		switch format {
+		case "json", "text", "html", "template":
+			if config.Config.Caching.notModified(w, r, report, format+" "+r.URL.Query().Get("name"), statusCode) {
+				return
+			}
+		case "prometheus":
+			if config.Config.Caching.notModified(w, r, report, format, http.StatusOK) {
+				return
+			}
+		}
+		switch format {
+		case "json":
+			var body bytes.Buffer
+			if err := json.NewEncoder(&body).Encode(report); err != nil {
+				log.Printf("Failed to encode response: %v", err)
+			}
+			signer.write(w, "application/json", statusCode, body.Bytes())
+		case "text":
+			signer.write(w, "text/plain; charset=utf-8", statusCode, []byte(report.textOutput()))
+		case "prometheus":
+			// Scrapers need a 200 to ingest the metrics, whatever the health.
+			signer.write(w, "text/plain; version=0.0.4; charset=utf-8", http.StatusOK, []byte(report.prometheusOutput()))
+		case "html":
+			var body bytes.Buffer
+			// The live feed reports every check, so profile pages stay static.
+			page := healthPage{healthReport: report, Live: cache != nil && profile == nil}
+			if err := healthHTML.Execute(&body, page); err != nil {
+				log.Printf("Failed to render response: %v", err)
+			}
+			signer.write(w, "text/html; charset=utf-8", statusCode, body.Bytes())
+		case "template":
+			name := r.URL.Query().Get("name")
+			output, ok := config.Config.Outputs[name]
+			if !ok {
+				http.Error(w, "Unknown output template: "+name, http.StatusBadRequest)
+				return
+			}
+			body, err := output.render(report)
+			if err != nil {
+				log.Printf("Failed to render output template %s: %v", name, err)
+				http.Error(w, "Failed to render output template", http.StatusInternalServerError)
+				return
+			}
+			signer.write(w, output.contentType(), statusCode, body)
+		default:
+			http.Error(w, "Unsupported format: use json, text, prometheus, html or template", http.StatusBadRequest)
+		}
+	}
+}
+
+// requestHealth evaluates the checks a health request is for, running them
+// on the request or reading the scheduler's cache when one is given, and
+// returns the report and the status code to answer with. Checks run on a
+// request are cancelled when the client disconnects or
+// config.requestTimeout passes. ok is false when the request is for a
+// profile that does not exist.
+func requestHealth(r *http.Request, config *Config, cache *resultCache) (healthReport, int, *Profile, bool) {
+	checks := currentChecks(config)
+	composites := config.Composites
+	codes := config.Config.StatusCodes
+	var selected map[string]bool
+	profile, ok := config.requestProfile(r)
+	if !ok {
+		return healthReport{}, 0, nil, false
+	}
+	if profile != nil {
+		selected, composites, _ = profile.selection(config.checks(), config.Composites) // checked by Validate
+		checks = selectChecks(checks, selected)
+		codes = profile.StatusCodes.or(codes)
+	}
+	var results []checkResult
+	if cache != nil {
+		results = cache.get()
+		if selected != nil {
+			results = selectResults(results, selected)
+		}
+	} else {
+		ctx := r.Context()
+		if config.Config.RequestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.Config.RequestTimeout)
+			defer cancel()
+		}
+		results = runChecks(ctx, checks)
+	}
+	adminOverrides.apply(results)
+	if config.Config.Vantage.Enabled && r.Header.Get(vantageHeader) == "" {
+		applyVantage(r.Context(), config.Config.Vantage, checks, results)
+	}
+	results = applyComposites(composites, config.checks(), results)
+	messages := config.Config.Messages.templates(requestLanguages(r))
+	messages.apply(results)
+	now := time.Now()
+	healthy, degraded := config.Config.Rollup.evaluate(config.checks(), results)
+	report := healthReport{
+		Healthy:  healthy,
+		Degraded: degraded,
+		Messages: make([]string, 0, len(results)),
+		Checks:   reports(results, now),
+	}
+	for _, result := range results {
+		report.Messages = append(report.Messages, result.Message)
+	}
+	messages.summarize(&report, results)
+	config.Config.Score.apply(&report, &config.Config.Rollup, config.checks(), results)
+	if cache != nil {
+		maxAge := config.Config.MaxAge
+		if maxAge == 0 {
+			maxAge = 2 * config.Config.Interval
+		}
+		stale, first := staleAfter(results, checks, config.Config.Interval, maxAge)
+		report.StaleAfter = &stale
+		if config.Config.MaxAge > 0 && now.After(stale) {
+			report.Healthy, report.Degraded = false, false
+			report.Messages = append(report.Messages, fmt.Sprintf("Check results are stale, %s check %s last ran %s ago", first.Type, first.Name, now.Sub(first.Checked).Round(time.Second)))
+		}
+	}
+	adminOverrides.applyDrain(&report)
+	adminOverrides.applyServerFault(&report)
+	report.Status = messages.status(report.state(), results, "Server is "+report.state())
+	statusCode := orDefault(codes.Healthy, http.StatusOK)
+	if worstStatus(results) == statusWarning {
+		statusCode = orDefault(codes.Warning, statusCode)
+	}
+	switch {
+	case report.Degraded:
+		statusCode = orDefault(codes.Degraded, orDefault(codes.Warning, statusCode))
+	case !report.Healthy:
+		statusCode = orDefault(codes.Unhealthy, http.StatusInternalServerError)
+	}
+	return report, statusCode, profile, true
+}
+
+// selectChecks returns the checks whose override keys are selected.
+func selectChecks(checks []check, selected map[string]bool) []check {
+	var out []check
+	for _, c := range checks {
+		if selected[overrideKey(c.Type, c.Name)] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// selectResults returns the results whose override keys are selected.
+func selectResults(results []checkResult, selected map[string]bool) []checkResult {
+	var out []checkResult
+	for _, r := range results {
+		if selected[overrideKey(r.Type, r.Name)] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func orDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// negotiateFormat picks the response format best matching an Accept
+// header, defaulting to JSON. text/plain with a version parameter, as sent
+// by Prometheus, and OpenMetrics both select the Prometheus exposition
+// format.
+func negotiateFormat(accept string) string {
+	if accept == "" {
+		return "json"
+	}
+	best, bestQ := "json", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var format string
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			format = "json"
+		case "text/plain":
+			format = "text"
+			if _, ok := params["version"]; ok {
+				format = "prometheus"
+			}
+		case "application/openmetrics-text":
+			format = "prometheus"
+		case "text/html":
+			format = "html"
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// state is healthy, degraded or unhealthy.
+func (h healthReport) state() string {
+	switch {
+	case !h.Healthy:
+		return "unhealthy"
+	case h.Degraded:
+		return "degraded"
+	default:
+		return "healthy"
+	}
+}
+
+func (h healthReport) textOutput() string {
+	var b strings.Builder
+	b.WriteString(h.Status + "\n")
+	for _, c := range h.Checks {
+		switch {
+		case c.Disabled:
+			fmt.Fprintf(&b, "[%s] %s (disabled)\n", c.Status, c.Message)
+		case c.Acknowledgement != nil:
+			fmt.Fprintf(&b, "[%s] %s (acknowledged by %s)\n", c.Status, c.Message, c.Acknowledgement.Author)
+		default:
+			fmt.Fprintf(&b, "[%s] %s\n", c.Status, c.Message)
+		}
+	}
+	for i, m := range h.Messages[len(h.Checks):] {
+		status := statusCritical.String()
+		if i < len(h.summaries) {
+			status = h.summaries[i]
+		}
+		b.WriteString("[" + status + "] " + m + "\n")
+	}
+	return b.String()
+}
+
+func (h healthReport) prometheusOutput() string {
+	var b strings.Builder
+	healthy := 0
+	if h.Healthy {
+		healthy = 1
+	}
+	b.WriteString("# HELP server_health_healthy Whether the server is healthy (1) or not (0).\n")
+	b.WriteString("# TYPE server_health_healthy gauge\n")
+	fmt.Fprintf(&b, "server_health_healthy %d\n", healthy)
+	if h.Score != nil {
+		b.WriteString("# HELP server_health_score Share of the checks' weight passing, from 0 to 100.\n")
+		b.WriteString("# TYPE server_health_score gauge\n")
+		fmt.Fprintf(&b, "server_health_score %g\n", *h.Score)
+	}
+
+	checks := append([]checkReport(nil), h.Checks...)
+	sort.SliceStable(checks, func(i, j int) bool {
+		return checks[i].Type < checks[j].Type || (checks[i].Type == checks[j].Type && checks[i].Name < checks[j].Name)
+	})
+	b.WriteString("# HELP server_health_check_status Check status: 0 ok, 1 warning, 2 critical.\n")
+	b.WriteString("# TYPE server_health_check_status gauge\n")
+	for _, c := range checks {
+		fmt.Fprintf(&b, "server_health_check_status{%s} %d\n", promCheckLabels(c), statusValue(c.Status))
+	}
+	b.WriteString("# HELP server_health_check_age_seconds Seconds since the check last ran.\n")
+	b.WriteString("# TYPE server_health_check_age_seconds gauge\n")
+	for _, c := range checks {
+		fmt.Fprintf(&b, "server_health_check_age_seconds{%s} %g\n", promCheckLabels(c), c.Age)
+	}
+	return b.String()
+}
+
+func statusValue(status string) int {
+	switch status {
+	case statusOK.String():
+		return 0
+	case statusWarning.String():
+		return 1
+	default:
+		return 2
+	}
+}
+
+// promCheckLabels is the label set of a check's series: its type, name and
+// configured labels.
+func promCheckLabels(c checkReport) string {
+	labels := "type=" + promLabel(c.Type) + ",name=" + promLabel(c.Name)
+	for _, k := range sortedKeys(c.Labels) {
+		labels += "," + k + "=" + promLabel(c.Labels[k])
+	}
+	return labels
+}
+
+// promLabel quotes a label value, escaping as the exposition format requires.
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// healthPage is what the HTML status page renders: the report, and
+// whether to follow the live feed at /ws.
+type healthPage struct {
+	healthReport
+	Live bool
+}
+
+var healthHTML = template.Must(template.New("health").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+.ok { color: #2e7d32; } .warning { color: #ef6c00; } .critical { color: #c62828; }
+</style></head>
+<body>
+<h1 id="status" class="{{if .Healthy}}ok{{else}}critical{{end}}">{{.Status}}</h1>
+<table>
+<thead><tr><th>Status</th><th>Type</th><th>Name</th><th>Message</th><th>Last checked</th></tr></thead>
+<tbody id="checks">
+{{range .Checks}}<tr><td class="{{.Status}}">{{.Status}}</td><td>{{.Type}}</td><td>{{.Name}}</td><td>{{.Message}}</td><td>{{.LastChecked.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</tbody></table>
+{{if .Live}}<script>
+(function () {
+  var states = {healthy: "ok", degraded: "warning", unhealthy: "critical"};
+  function time(t) { return new Date(t).toISOString().replace("T", " ").slice(0, 19); }
+  function row(c) {
+    var tr = document.createElement("tr");
+    [c.status, c.type, c.name, c.message, time(c.lastChecked)].forEach(function (v, i) {
+      var td = document.createElement("td");
+      td.textContent = v;
+      if (i === 0) { td.className = v; }
+      tr.appendChild(td);
+    });
+    return tr;
+  }
+  var ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+  ws.onmessage = function (m) {
+    var msg = JSON.parse(m.data), h1 = document.getElementById("status");
+    if (msg.event === "snapshot") {
+      h1.textContent = msg.data.status;
+      document.getElementById("checks").replaceChildren.apply(document.getElementById("checks"), msg.data.checks.map(row));
+    } else if (msg.event === "state") {
+      h1.textContent = msg.data.status;
+      h1.className = states[msg.data.to];
+    } else if (msg.event === "transition") {
+      Array.prototype.forEach.call(document.getElementById("checks").rows, function (tr) {
+        if (tr.cells[1].textContent === msg.data.type && tr.cells[2].textContent === msg.data.name) {
+          tr.cells[0].textContent = tr.cells[0].className = msg.data.to;
+          tr.cells[3].textContent = msg.data.message;
+          tr.cells[4].textContent = time(msg.data.time);
+        }
+      });
+    }
+  };
+})();
+</script>{{end}}
+</body>
+</html>
+`))
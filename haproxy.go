@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// HAProxyAgentConfig answers HAProxy agent checks (agent-check with
+// agent-port on a server line) on Listen, so HAProxy takes the server's
+// state and weight from this daemon. A healthy or degraded server is up
+// at a weight of the share of its checks passing, warnings counting half;
+// an unhealthy one is down, and a drained one is in the Drained state,
+// drain (the default) or maint. Only AllowedHosts, IP addresses or CIDR
+// ranges, may connect; unset, that is the loopback addresses.
+type HAProxyAgentConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Listen       string   `yaml:"listen"`
+	AllowedHosts []string `yaml:"allowedHosts"`
+	Drained      string   `yaml:"drained"`
+}
+
+// Validate checks the listen address, allowed hosts and drained state.
+func (c *HAProxyAgentConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("haproxyAgent: listen must be host:port: %w", err)
+	}
+	if _, err := parseAllowedHosts(c.AllowedHosts); err != nil {
+		return fmt.Errorf("haproxyAgent: %w", err)
+	}
+	switch c.Drained {
+	case "", "drain", "maint":
+	default:
+		return fmt.Errorf("haproxyAgent: drained must be drain or maint")
+	}
+	return nil
+}
+
+// haproxyAgent answers agent checks from the current config's checks.
+type haproxyAgent struct {
+	live     *liveConfig
+	cache    *resultCache
+	allowed  []*net.IPNet
+	listener net.Listener
+}
+
+// startHAProxyAgent listens for agent checks. The returned function stops
+// the listener.
+func startHAProxyAgent(live *liveConfig, cache *resultCache) (func(), error) {
+	cfg := live.current().Config.HAProxyAgent
+	allowed, err := parseAllowedHosts(cfg.AllowedHosts)
+	if err != nil {
+		return nil, fmt.Errorf("haproxyAgent: %w", err)
+	}
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("haproxyAgent: %w", err)
+	}
+	a := &haproxyAgent{live: live, cache: cache, allowed: allowed, listener: listener}
+	log.Printf("Starting HAProxy agent listener on %s", cfg.Listen)
+	go a.serve()
+	return func() { closeAndLog(listener, "haproxy agent listener") }, nil
+}
+
+func (a *haproxyAgent) serve() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("haproxyAgent: %v", err)
+			}
+			return
+		}
+		go a.handle(conn)
+	}
+}
+
+// handle writes the agent reply as soon as HAProxy connects, ignoring any
+// agent-send string, and closes the connection.
+func (a *haproxyAgent) handle(conn net.Conn) {
+	defer closeAndLog(conn, "haproxy agent connection")
+	if !permitted(a.allowed, conn.RemoteAddr()) {
+		log.Printf("haproxyAgent: refused connection from %s", conn.RemoteAddr())
+		return
+	}
+	config := a.live.current()
+	timeout := config.Config.RequestTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout + 5*time.Second)); err != nil {
+		return
+	}
+	reply := agentReply(backgroundReport(config, a.cache, timeout), config.Config.HAProxyAgent.Drained)
+	if _, err := conn.Write([]byte(reply)); err != nil {
+		log.Printf("haproxyAgent: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// agentReply is the agent check reply for report: the state, with the
+// health score as the weight when up, and the status as the description
+// HAProxy shows in its stats. The weight is at least 1, as a weight of 0
+// would drain the server. ready leaves any drain or maint state an earlier
+// reply set.
+func agentReply(report healthReport, drained string) string {
+	var state string
+	switch {
+	case report.Drained && drained == "maint":
+		state = "maint"
+	case report.Drained:
+		state = "drain"
+	case !report.Healthy:
+		state = "ready down"
+	default:
+		state = fmt.Sprintf("ready up %d%%", max(1, int(math.Round(report.score))))
+	}
+	description := strings.NewReplacer("\r", " ", "\n", " ").Replace(report.Status)
+	return state + " #" + description + "\n"
+}
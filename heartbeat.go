@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// HeartbeatConfig writes a heartbeat file after every background check
+// run, for watchdogs, cron jobs and exec probes that read health without
+// HTTP. The file holds one line: the time of the run in RFC 3339 and the
+// overall status, healthy, degraded or unhealthy. A file that stops being
+// rewritten means the scheduler has wedged. It needs config.interval, as
+// checks otherwise run only on request.
+type HeartbeatConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+}
+
+// defaultHeartbeatFile is where the heartbeat is written unless file is
+// set.
+const defaultHeartbeatFile = "/tmp/server-health-api/heartbeat"
+
+// Validate checks the file, and that checks run in the background.
+func (c *HeartbeatConfig) Validate(interval time.Duration) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.File != "" && !filepath.IsAbs(c.File) {
+		return fmt.Errorf("heartbeat: file must be an absolute path")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("heartbeat: config.interval is required")
+	}
+	return nil
+}
+
+// file returns the file the heartbeat is written to.
+func (c *HeartbeatConfig) file() string {
+	if c.File == "" {
+		return defaultHeartbeatFile
+	}
+	return c.File
+}
+
+// writeHeartbeat replaces the heartbeat file with the time and the overall
+// status of the cached results, logging any error.
+func writeHeartbeat(config *Config, cache *resultCache) {
+	if !config.Config.Heartbeat.Enabled {
+		return
+	}
+	report := backgroundReport(config, cache, 0)
+	line := fmt.Sprintf("%s %s\n", time.Now().UTC().Format(time.RFC3339), report.state())
+	if err := writeFileAtomic(config.Config.Heartbeat.file(), []byte(line)); err != nil {
+		log.Printf("heartbeat: %v", err)
+	}
+}
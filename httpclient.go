@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPConfig tunes the transports of the HTTP clients checks use. Zero
+// values keep Go's defaults.
+type HTTPConfig struct {
+	DisableKeepAlives   bool          `yaml:"disableKeepAlives"`
+	KeepAlive           time.Duration `yaml:"keepAlive"`
+	MaxIdleConns        int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost int           `yaml:"maxIdleConnsPerHost"`
+	IdleConnTimeout     time.Duration `yaml:"idleConnTimeout"`
+	TLSHandshakeTimeout time.Duration `yaml:"tlsHandshakeTimeout"`
+}
+
+// Validate checks no setting is negative.
+func (h *HTTPConfig) Validate() error {
+	if h.KeepAlive < 0 || h.IdleConnTimeout < 0 || h.TLSHandshakeTimeout < 0 {
+		return fmt.Errorf("keepAlive, idleConnTimeout and tlsHandshakeTimeout must not be negative")
+	}
+	if h.MaxIdleConns < 0 || h.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("maxIdleConns and maxIdleConnsPerHost must not be negative")
+	}
+	return nil
+}
+
+// merge returns h with the settings made in override replacing its own.
+func (h HTTPConfig) merge(override HTTPConfig) HTTPConfig {
+	h.DisableKeepAlives = h.DisableKeepAlives || override.DisableKeepAlives
+	if override.KeepAlive != 0 {
+		h.KeepAlive = override.KeepAlive
+	}
+	if override.MaxIdleConns != 0 {
+		h.MaxIdleConns = override.MaxIdleConns
+	}
+	if override.MaxIdleConnsPerHost != 0 {
+		h.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.IdleConnTimeout != 0 {
+		h.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.TLSHandshakeTimeout != 0 {
+		h.TLSHandshakeTimeout = override.TLSHandshakeTimeout
+	}
+	return h
+}
+
+// checkHTTP is the transport tuning from config.http; main sets it, along
+// with httpClient and httpsClient built from it, before any check runs.
+var checkHTTP HTTPConfig
+
+// httpClient and httpsClient are shared by the checks that make HTTP
+// requests. httpsClient does not verify certificates.
+var (
+	httpClient  = newHTTPClient(HTTPConfig{}, nil)
+	httpsClient = newHTTPClient(HTTPConfig{}, &tls.Config{InsecureSkipVerify: true})
+)
+
+// exportClient is shared by exporters, webhooks and the daemon's other
+// requests of its own, such as to Consul or Vault. Unlike the check clients
+// it always verifies certificates, and it dials as the host does, ignoring
+// config.sourceAddress, config.dns and config.http, which tune checks.
+var exportClient = &http.Client{Timeout: 10 * time.Second, Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+var (
+	sourceClientsMu sync.Mutex
+	sourceClients   = map[string]*http.Client{}
+)
+
+// checkClient returns the shared client for requests made with ctx:
+// httpsClient when https is set, otherwise httpClient. A check with its own
+// sourceAddress gets a client kept for that address instead, as pooled
+// connections must not be reused from a different source.
+func checkClient(ctx context.Context, https bool) *http.Client {
+	source, ok := ctx.Value(sourceKey{}).(string)
+	if !ok || source == checkSource {
+		if https {
+			return httpsClient
+		}
+		return httpClient
+	}
+	key := "http " + source
+	if https {
+		key = "https " + source
+	}
+	sourceClientsMu.Lock()
+	defer sourceClientsMu.Unlock()
+	client, ok := sourceClients[key]
+	if !ok {
+		var tlsConfig *tls.Config
+		if https {
+			tlsConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client = newHTTPClient(checkHTTP, tlsConfig)
+		sourceClients[key] = client
+	}
+	return client
+}
+
+// checkHeaders are sent with every endpoint check request; main sets them
+// from config.userAgent and config.requestHeaders.
+var checkHeaders http.Header
+
+// requestHeaders returns checkHeaders with headers and, when set, userAgent
+// replacing them. The User-Agent defaults to server-health-api/<version>, so
+// targets can tell health checks apart in their logs.
+func requestHeaders(userAgent string, headers map[string]string) http.Header {
+	h := checkHeaders.Clone()
+	if h == nil {
+		h = http.Header{"User-Agent": {"server-health-api/" + currentBuildInfo().Version}}
+	}
+	for name, value := range headers {
+		h.Set(name, value)
+	}
+	if userAgent != "" {
+		h.Set("User-Agent", userAgent)
+	}
+	return h
+}
+
+// newHTTPClient returns a client whose transport is tuned by cfg and dials
+// through checkResolver.
+func newHTTPClient(cfg HTTPConfig, tlsConfig *tls.Config) *http.Client {
+	defaults := http.DefaultTransport.(*http.Transport)
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer, err := checkDialer(ctx, network, 30*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			if dialer.KeepAlive == 0 {
+				dialer.KeepAlive = cfg.KeepAlive
+			}
+			return dialFor(ctx).dial(ctx, dialer, network, address)
+		},
+		TLSClientConfig:       tlsConfig,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		MaxIdleConns:          defaults.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       defaults.IdleConnTimeout,
+		TLSHandshakeTimeout:   defaults.TLSHandshakeTimeout,
+		ExpectContinueTimeout: defaults.ExpectContinueTimeout,
+	}
+	if cfg.MaxIdleConns != 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.IdleConnTimeout != 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.TLSHandshakeTimeout != 0 {
+		transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// hysteresisState is the status a check with riseCount or fallCount last
+// reported, and how many runs in a row have disagreed with it.
+type hysteresisState struct {
+	reported checkStatus
+	streak   int
+}
+
+// hysteresis holds back status changes of checks with riseCount or
+// fallCount until enough runs in a row agree, as load balancers do with
+// rise and fall.
+type hysteresis struct {
+	mu     sync.Mutex
+	states map[string]*hysteresisState // by override key
+}
+
+// checkHysteresis is the state of every check run.
+var checkHysteresis = &hysteresis{states: map[string]*hysteresisState{}}
+
+// apply returns result as it should be reported. A passing check turns
+// critical once fallCount runs in a row are critical, and a critical one
+// recovers once riseCount runs in a row are not; until then the result
+// keeps the status last reported, its message counting the runs. The
+// first run is reported as it is, and skipped runs count for neither.
+func (h *hysteresis) apply(c check, result checkResult) checkResult {
+	if (c.Options.RiseCount <= 1 && c.Options.FallCount <= 1) || result.Skipped || result.NotScheduled {
+		return result
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := overrideKey(c.Type, c.Name)
+	state, ok := h.states[key]
+	if !ok {
+		h.states[key] = &hysteresisState{reported: result.Status}
+		return result
+	}
+	failing, wasFailing := result.Status == statusCritical, state.reported == statusCritical
+	if failing == wasFailing {
+		state.reported, state.streak = result.Status, 0
+		return result
+	}
+	state.streak++
+	need, change := c.Options.FallCount, "failures before reporting critical"
+	if wasFailing {
+		need, change = c.Options.RiseCount, "successes before recovering"
+	}
+	if state.streak >= need {
+		state.reported, state.streak = result.Status, 0
+		return result
+	}
+	result.Message += fmt.Sprintf(" (%d of %d %s)", state.streak, need, change)
+	result.Status = state.reported
+	return result
+}
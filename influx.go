@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InfluxDBConfig writes every check run's results in InfluxDB line protocol
+// to URL, such as an InfluxDB 2 /api/v2/write?org=...&bucket=... endpoint,
+// an InfluxDB 1 /write?db=... endpoint or a Telegraf http_listener. Token is
+// sent as an InfluxDB 2 token, Username and Password as basic auth. Each
+// check is one point in Measurement, or in the measurement Measurements
+// names for its type, tagged with its type and name and with Tags.
+type InfluxDBConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	URL          string            `yaml:"url"`
+	Token        string            `yaml:"token"`
+	Username     string            `yaml:"username"`
+	Password     string            `yaml:"password"`
+	Measurement  string            `yaml:"measurement"`
+	Measurements map[string]string `yaml:"measurements"`
+	Tags         map[string]string `yaml:"tags"`
+}
+
+// Validate checks the write URL.
+func (c *InfluxDBConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("influxdb: url must be an http:// or https:// URL")
+	}
+	if c.Token != "" && c.Username != "" {
+		return fmt.Errorf("influxdb: token and username cannot both be set")
+	}
+	return nil
+}
+
+// influxExporter posts results to an InfluxDB write endpoint.
+type influxExporter struct {
+	cfg    InfluxDBConfig
+	tags   string
+	client *http.Client
+}
+
+func newInfluxDB(cfg InfluxDBConfig) *influxExporter {
+	e := &influxExporter{cfg: cfg, client: exportClient}
+	if e.cfg.Measurement == "" {
+		e.cfg.Measurement = "server_health"
+	}
+	keys := make([]string, 0, len(cfg.Tags))
+	for k := range cfg.Tags {
+		keys = append(keys, k)
+	}
+	// InfluxDB performs best with tags sorted by key.
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.tags += "," + influxEscape(k, ",= ") + "=" + influxEscape(cfg.Tags[k], ",= ")
+	}
+	return e
+}
+
+// export writes one point per check, with the status as an integer (0 ok,
+// 1 warning, 2 critical), an ok flag, the run time in milliseconds and the
+// message.
+func (e *influxExporter) export(results []checkResult) {
+	if len(results) == 0 {
+		return
+	}
+	var body bytes.Buffer
+	for _, r := range results {
+		measurement := e.cfg.Measurement
+		if m, ok := e.cfg.Measurements[r.Type]; ok {
+			measurement = m
+		}
+		tags := e.tags
+		for _, k := range sortedKeys(r.Labels) {
+			tags += "," + k + "=" + influxEscape(r.Labels[k], ",= ")
+		}
+		fmt.Fprintf(&body, "%s,type=%s,name=%s%s status=%di,ok=%t,duration_ms=%s,message=\"%s\" %d\n",
+			influxEscape(measurement, ", "), influxEscape(r.Type, ",= "), influxEscape(r.Name, ",= "), tags,
+			r.Status, r.Status == statusOK, strconv.FormatFloat(milliseconds(r.Duration), 'f', 3, 64),
+			influxEscape(r.Message, `"\`), r.Checked.UnixNano())
+	}
+	exportInBackground("influxdb", func() error { return e.write(body.Bytes()) })
+}
+
+func (e *influxExporter) write(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case e.cfg.Token != "":
+		req.Header.Set("Authorization", "Token "+e.cfg.Token)
+	case e.cfg.Username != "":
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("write returned %s", resp.Status)
+	}
+	return nil
+}
+
+// influxEscape backslash-escapes the characters in special, which differ
+// between measurements, tags and string fields. Line protocol cannot carry
+// newlines at all, so they become spaces.
+func influxEscape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			r = ' '
+		case strings.ContainsRune(special, r):
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// JournaldCheck counts journal messages matching any of Patterns within a
+// sliding Window and fails when the count exceeds Threshold.
+type JournaldCheck struct {
+	Name      string        `yaml:"name"`
+	Unit      string        `yaml:"unit"`
+	Priority  string        `yaml:"priority"`
+	Window    time.Duration `yaml:"window"`
+	Patterns  []string      `yaml:"patterns"`
+	Threshold int           `yaml:"threshold"`
+
+	// Thresholds grade the match count instead of Threshold.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+var journalPriorityRegex = regexp.MustCompile(`^([0-7]|emerg|alert|crit|err|warning|notice|info|debug)$`)
+
+// Validate checks the unit name, priority and patterns.
+func (j *JournaldCheck) Validate() error {
+	if j.Unit != "" && !serviceNameRegex.MatchString(j.Unit) {
+		return fmt.Errorf("journald check %s: invalid unit name %q", j.Name, j.Unit)
+	}
+	if j.Priority != "" && !journalPriorityRegex.MatchString(j.Priority) {
+		return fmt.Errorf("journald check %s: invalid priority %q", j.Name, j.Priority)
+	}
+	if j.Window <= 0 {
+		return fmt.Errorf("journald check %s: window must be positive", j.Name)
+	}
+	if len(j.Patterns) == 0 {
+		return fmt.Errorf("journald check %s: at least one pattern is required", j.Name)
+	}
+	if _, err := compilePatterns(j.Patterns); err != nil {
+		return fmt.Errorf("journald check %s: %w", j.Name, err)
+	}
+	if j.Threshold < 0 {
+		return fmt.Errorf("journald check %s: invalid threshold: %d", j.Name, j.Threshold)
+	}
+	if j.Threshold != 0 && j.Thresholds.set() {
+		return fmt.Errorf("journald check %s: threshold cannot be combined with warn and crit", j.Name)
+	}
+	if err := j.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("journald check %s: %w", j.Name, err)
+	}
+	return nil
+}
+
+func (check JournaldCheck) run(ctx context.Context) checkResult {
+	unit := check.Unit
+	if unit == "" {
+		unit = "all units"
+	}
+	count, err := countJournalMatches(ctx, check)
+	switch {
+	case err != nil:
+		return checkFailed("Journald Name: %s, Unit: %s could not be read: %v", check.Name, unit, err)
+	case check.Thresholds.set():
+		return check.Thresholds.apply(checkOK("Journald Name: %s, Unit: %s, Matches: %d in the last %s", check.Name, unit, count, check.Window), float64(count), "")
+	case count > check.Threshold:
+		return checkFailed("Journald Name: %s, Unit: %s, Matches: %d in the last %s exceeds threshold: %d", check.Name, unit, count, check.Window, check.Threshold)
+	default:
+		return checkOK("Journald Name: %s, Unit: %s, Matches: %d in the last %s is within threshold: %d", check.Name, unit, count, check.Window, check.Threshold)
+	}
+}
+
+func countJournalMatches(ctx context.Context, check JournaldCheck) (int, error) {
+	patterns, err := compilePatterns(check.Patterns)
+	if err != nil {
+		return 0, err
+	}
+
+	since := time.Now().Add(-check.Window).Format("2006-01-02 15:04:05")
+	args := []string{"--no-pager", "--quiet", "--output=cat", "--since=" + since}
+	if check.Unit != "" {
+		args = append(args, "--unit="+check.Unit)
+	}
+	if check.Priority != "" {
+		args = append(args, "--priority="+check.Priority)
+	}
+	output, err := commandOutput(ctx, "journalctl", args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if matchesAny(patterns, scanner.Text()) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
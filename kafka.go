@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// KafkaCheck fetches cluster metadata from the first reachable broker and
+// optionally asserts that Topic exists with the expected partition and
+// replica counts and no offline partitions. Under-replicated partitions are
+// reported as a warning.
+type KafkaCheck struct {
+	Name               string   `yaml:"name"`
+	Brokers            []string `yaml:"brokers"`
+	TLS                bool     `yaml:"tls"`
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify"`
+	Topic              string   `yaml:"topic"`
+	Partitions         int      `yaml:"partitions"`
+	ReplicationFactor  int      `yaml:"replicationFactor"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks that brokers are host:port pairs.
+func (k *KafkaCheck) Validate() error {
+	if len(k.Brokers) == 0 {
+		return fmt.Errorf("kafka check %s: at least one broker is required", k.Name)
+	}
+	for _, b := range k.Brokers {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return fmt.Errorf("kafka check %s: invalid broker %q: %w", k.Name, b, err)
+		}
+	}
+	if k.Partitions < 0 || k.ReplicationFactor < 0 {
+		return fmt.Errorf("kafka check %s: partitions and replicationFactor must not be negative", k.Name)
+	}
+	return nil
+}
+
+// kafkaMetadata is the subset of a Metadata v4 response we assert on.
+type kafkaMetadata struct {
+	Brokers int
+	Topics  map[string]kafkaTopic
+}
+
+type kafkaTopic struct {
+	ErrorCode  int16
+	Partitions []kafkaPartition
+}
+
+type kafkaPartition struct {
+	ID       int32
+	Leader   int32
+	Replicas int
+	ISR      int
+}
+
+const (
+	kafkaMetadataAPIKey     = 3
+	kafkaMetadataAPIVersion = 4
+	kafkaUnknownTopic       = 3
+	kafkaMaxResponse        = 64 << 20
+)
+
+func (check KafkaCheck) run(ctx context.Context) checkResult {
+	var md *kafkaMetadata
+	var err error
+	for _, broker := range check.Brokers {
+		if md, err = check.fetchMetadata(ctx, broker); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return checkFailed("Kafka Name: %s, metadata could not be fetched from any broker: %v", check.Name, err)
+	}
+	if check.Topic == "" {
+		return checkOK("Kafka Name: %s, Brokers: %d are available", check.Name, md.Brokers)
+	}
+
+	topic, ok := md.Topics[check.Topic]
+	switch {
+	case !ok || topic.ErrorCode == kafkaUnknownTopic:
+		return checkFailed("Kafka Name: %s, Topic: %s does not exist", check.Name, check.Topic)
+	case topic.ErrorCode != 0:
+		return checkFailed("Kafka Name: %s, Topic: %s returned error code %d", check.Name, check.Topic, topic.ErrorCode)
+	case check.Partitions > 0 && len(topic.Partitions) != check.Partitions:
+		return checkFailed("Kafka Name: %s, Topic: %s, Partitions: %d is not as expected, want: %d", check.Name, check.Topic, len(topic.Partitions), check.Partitions)
+	}
+
+	var offline, underReplicated int
+	for _, p := range topic.Partitions {
+		if check.ReplicationFactor > 0 && p.Replicas != check.ReplicationFactor {
+			return checkFailed("Kafka Name: %s, Topic: %s, Partition: %d has %d replicas, want: %d", check.Name, check.Topic, p.ID, p.Replicas, check.ReplicationFactor)
+		}
+		if p.Leader < 0 {
+			offline++
+		} else if p.ISR < p.Replicas {
+			underReplicated++
+		}
+	}
+	if offline > 0 {
+		return checkFailed("Kafka Name: %s, Topic: %s has %d offline partitions", check.Name, check.Topic, offline)
+	}
+	if underReplicated > 0 {
+		return checkWarning("Kafka Name: %s, Topic: %s has %d under-replicated partitions", check.Name, check.Topic, underReplicated)
+	}
+	return checkOK("Kafka Name: %s, Topic: %s, Partitions: %d are online", check.Name, check.Topic, len(topic.Partitions))
+}
+
+func (check KafkaCheck) fetchMetadata(ctx context.Context, broker string) (*kafkaMetadata, error) {
+	var tlsConfig *tls.Config
+	if check.TLS {
+		host, _, err := net.SplitHostPort(broker)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: check.InsecureSkipVerify}
+	}
+	conn, err := dialCheck(ctx, "tcp", broker, 5*time.Second, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return nil, err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	// Request header: api_key, api_version, correlation_id, client_id.
+	req := binary.BigEndian.AppendUint16(nil, kafkaMetadataAPIKey)
+	req = binary.BigEndian.AppendUint16(req, kafkaMetadataAPIVersion)
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = appendKafkaString(req, "server-health-api")
+	// Metadata v4 body: topics (null requests all topics), allow_auto_topic_creation.
+	if check.Topic == "" {
+		req = binary.BigEndian.AppendUint32(req, 0xffffffff)
+	} else {
+		req = binary.BigEndian.AppendUint32(req, 1)
+		req = appendKafkaString(req, check.Topic)
+	}
+	req = append(req, 0)
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(req))) // #nosec G115 -- request is a few bytes
+	if _, err := conn.Write(append(frame, req...)); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > kafkaMaxResponse {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return parseKafkaMetadata(resp)
+}
+
+func appendKafkaString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s))) // #nosec G115 -- names are short
+	return append(b, s...)
+}
+
+// kafkaReader decodes big-endian protocol fields, remembering the first error.
+type kafkaReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (k *kafkaReader) read(v interface{}) {
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, v)
+	}
+}
+
+func (k *kafkaReader) int16() int16 {
+	var v int16
+	k.read(&v)
+	return v
+}
+
+func (k *kafkaReader) int32() int32 {
+	var v int32
+	k.read(&v)
+	return v
+}
+
+func (k *kafkaReader) bool() bool {
+	var v bool
+	k.read(&v)
+	return v
+}
+
+func (k *kafkaReader) string() string {
+	n := k.int16()
+	if n < 0 || k.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(k.r, buf); err != nil && k.err == nil {
+		k.err = err
+	}
+	return string(buf)
+}
+
+// arrayLen reads an array length, rejecting counts larger than the remaining data.
+func (k *kafkaReader) arrayLen() int {
+	n := k.int32()
+	if k.err == nil && (n < -1 || int(n) > k.r.Len()) {
+		k.err = errors.New("malformed array length")
+	}
+	if n < 0 || k.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// parseKafkaMetadata decodes a Metadata v4 response, after the size prefix.
+func parseKafkaMetadata(data []byte) (*kafkaMetadata, error) {
+	k := &kafkaReader{r: bytes.NewReader(data)}
+	k.int32() // correlation_id
+	k.int32() // throttle_time_ms
+
+	md := &kafkaMetadata{Topics: map[string]kafkaTopic{}}
+	md.Brokers = k.arrayLen()
+	for i := 0; i < md.Brokers && k.err == nil; i++ {
+		k.int32()  // node_id
+		k.string() // host
+		k.int32()  // port
+		k.string() // rack
+	}
+	k.string() // cluster_id
+	k.int32()  // controller_id
+
+	topics := k.arrayLen()
+	for i := 0; i < topics && k.err == nil; i++ {
+		var topic kafkaTopic
+		topic.ErrorCode = k.int16()
+		name := k.string()
+		k.bool() // is_internal
+		partitions := k.arrayLen()
+		for j := 0; j < partitions && k.err == nil; j++ {
+			var p kafkaPartition
+			k.int16() // error_code
+			p.ID = k.int32()
+			p.Leader = k.int32()
+			p.Replicas = k.arrayLen()
+			for n := 0; n < p.Replicas; n++ {
+				k.int32()
+			}
+			p.ISR = k.arrayLen()
+			for n := 0; n < p.ISR; n++ {
+				k.int32()
+			}
+			topic.Partitions = append(topic.Partitions, p)
+		}
+		md.Topics[name] = topic
+	}
+	if k.err != nil {
+		return nil, fmt.Errorf("malformed metadata response: %w", k.err)
+	}
+	return md, nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// KubernetesCheck asserts that a Deployment or StatefulSet has the expected
+// number of ready replicas, or that a pod (by name or label selector) is Ready.
+type KubernetesCheck struct {
+	Name       string `yaml:"name"`
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+	Namespace  string `yaml:"namespace"`
+	Kind       string `yaml:"kind"`
+	Object     string `yaml:"object"`
+	Selector   string `yaml:"selector"`
+	Replicas   int    `yaml:"replicas"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks that the kind is supported and the target object is set.
+func (k *KubernetesCheck) Validate() error {
+	switch strings.ToLower(k.Kind) {
+	case "deployment", "statefulset":
+		if k.Object == "" {
+			return fmt.Errorf("kubernetes check %s: object is required for kind %s", k.Name, k.Kind)
+		}
+	case "pod":
+		if k.Object == "" && k.Selector == "" {
+			return fmt.Errorf("kubernetes check %s: object or selector is required for kind pod", k.Name)
+		}
+	default:
+		return fmt.Errorf("kubernetes check %s: unsupported kind %q", k.Name, k.Kind)
+	}
+	if k.Replicas < 0 {
+		return fmt.Errorf("kubernetes check %s: invalid replicas: %d", k.Name, k.Replicas)
+	}
+	return nil
+}
+
+func (check KubernetesCheck) run(ctx context.Context) checkResult {
+	client, err := newKubeClient(check.Kubeconfig, check.Context)
+	if err != nil {
+		return checkFailed("Kubernetes Name: %s, credentials could not be loaded: %v", check.Name, err)
+	}
+	defer client.http.CloseIdleConnections()
+	namespace := check.Namespace
+	if namespace == "" {
+		namespace = client.namespace
+	}
+	target := fmt.Sprintf("%s %s/%s", check.Kind, namespace, check.Object)
+
+	if strings.ToLower(check.Kind) == "pod" {
+		if check.Object == "" {
+			target = fmt.Sprintf("pods %s/%s", namespace, check.Selector)
+		}
+		ready, total, err := client.podsReady(ctx, namespace, check.Object, check.Selector)
+		switch {
+		case err != nil:
+			return checkFailed("Kubernetes Name: %s, %s could not be read: %v", check.Name, target, err)
+		case total == 0 || ready < total:
+			return checkFailed("Kubernetes Name: %s, %s, Ready: %d/%d is not as expected", check.Name, target, ready, total)
+		default:
+			return checkOK("Kubernetes Name: %s, %s, Ready: %d/%d is as expected", check.Name, target, ready, total)
+		}
+	}
+
+	ready, want, err := client.workloadReplicas(ctx, strings.ToLower(check.Kind)+"s", namespace, check.Object)
+	if check.Replicas > 0 {
+		want = check.Replicas
+	}
+	switch {
+	case err != nil:
+		return checkFailed("Kubernetes Name: %s, %s could not be read: %v", check.Name, target, err)
+	case ready < want:
+		return checkFailed("Kubernetes Name: %s, %s, Ready Replicas: %d is not as expected, want: %d", check.Name, target, ready, want)
+	default:
+		return checkOK("Kubernetes Name: %s, %s, Ready Replicas: %d/%d is as expected", check.Name, target, ready, want)
+	}
+}
+
+// kubeClient is a minimal read-only client for the Kubernetes REST API.
+type kubeClient struct {
+	server    string
+	token     string
+	username  string
+	password  string
+	namespace string
+	http      *http.Client
+}
+
+// kubeconfig holds the subset of a kubeconfig file needed to reach a cluster.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeClient builds a client from a kubeconfig file, or from the pod's
+// service account when path is empty.
+func newKubeClient(path, contextName string) (*kubeClient, error) {
+	if path == "" {
+		return newInClusterKubeClient()
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- path is from the config file
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+
+	client := &kubeClient{namespace: "default"}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	base := filepath.Dir(path)
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			if c.Context.Namespace != "" {
+				client.namespace = c.Context.Namespace
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		client.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := kubeconfigBytes(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, base)
+		if err != nil {
+			return nil, fmt.Errorf("reading certificate authority: %w", err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates found in certificate authority for cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if client.server == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		client.token = u.User.Token
+		client.username, client.password = u.User.Username, u.User.Password
+		if u.User.TokenFile != "" {
+			token, err := os.ReadFile(resolveKubePath(u.User.TokenFile, base))
+			if err != nil {
+				return nil, err
+			}
+			client.token = strings.TrimSpace(string(token))
+		}
+		cert, err := kubeconfigBytes(u.User.ClientCertificateData, u.User.ClientCertificate, base)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate: %w", err)
+		}
+		key, err := kubeconfigBytes(u.User.ClientKeyData, u.User.ClientKey, base)
+		if err != nil {
+			return nil, fmt.Errorf("reading client key: %w", err)
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	client.http = newKubeHTTPClient(tlsConfig)
+	return client, nil
+}
+
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("no kubeconfig set and not running in a cluster")
+	}
+	token, err := os.ReadFile(inClusterTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(inClusterCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", inClusterCAFile)
+	}
+	namespace := "default"
+	if ns, err := os.ReadFile(inClusterNamespaceFile); err == nil {
+		namespace = strings.TrimSpace(string(ns))
+	}
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: namespace,
+		http:      newKubeHTTPClient(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}),
+	}, nil
+}
+
+func newKubeHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialHTTP, TLSClientConfig: tlsConfig},
+	}
+}
+
+// kubeconfigBytes returns inline base64 data if set, otherwise the contents
+// of the referenced file, or nil when neither is configured.
+func kubeconfigBytes(data, file, base string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolveKubePath(file, base))
+	}
+	return nil, nil
+}
+
+// resolveKubePath resolves paths relative to the kubeconfig's directory, as kubectl does.
+func resolveKubePath(path, base string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+func (c *kubeClient) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API server returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// workloadReplicas returns the ready and desired replica counts of an apps/v1 workload.
+func (c *kubeClient) workloadReplicas(ctx context.Context, resource, namespace, name string) (int, int, error) {
+	var obj struct {
+		Spec struct {
+			Replicas *int `json:"replicas"`
+		} `json:"spec"`
+		Status struct {
+			ReadyReplicas int `json:"readyReplicas"`
+		} `json:"status"`
+	}
+	path := fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s/%s", url.PathEscape(namespace), resource, url.PathEscape(name))
+	if err := c.get(ctx, path, &obj); err != nil {
+		return 0, 0, err
+	}
+	want := 1
+	if obj.Spec.Replicas != nil {
+		want = *obj.Spec.Replicas
+	}
+	return obj.Status.ReadyReplicas, want, nil
+}
+
+type kubePod struct {
+	Status struct {
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+func (p kubePod) ready() bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// podsReady returns how many of the named pod, or the pods matching selector, are Ready.
+func (c *kubeClient) podsReady(ctx context.Context, namespace, name, selector string) (int, int, error) {
+	var pods []kubePod
+	if name != "" {
+		var pod kubePod
+		if err := c.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", url.PathEscape(namespace), url.PathEscape(name)), &pod); err != nil {
+			return 0, 0, err
+		}
+		pods = append(pods, pod)
+	} else {
+		var list struct {
+			Items []kubePod `json:"items"`
+		}
+		path := fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s", url.PathEscape(namespace), url.QueryEscape(selector))
+		if err := c.get(ctx, path, &list); err != nil {
+			return 0, 0, err
+		}
+		pods = list.Items
+	}
+
+	var ready int
+	for _, pod := range pods {
+		if pod.ready() {
+			ready++
+		}
+	}
+	return ready, len(pods), nil
+}
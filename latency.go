@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultLatencySamples is how many recent response times make up an
+// endpoint's latency baseline when latencySamples is unset.
+const defaultLatencySamples = 20
+
+// latencyBaseline is the moving window of recent response times of one
+// endpoint check, kept between runs.
+type latencyBaseline struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+var (
+	latencyBaselinesMu sync.Mutex
+	latencyBaselines   = map[string]*latencyBaseline{}
+)
+
+func getLatencyBaseline(key string) *latencyBaseline {
+	latencyBaselinesMu.Lock()
+	defer latencyBaselinesMu.Unlock()
+	b, ok := latencyBaselines[key]
+	if !ok {
+		b = &latencyBaseline{}
+		latencyBaselines[key] = b
+	}
+	return b
+}
+
+// observe returns the average of the last size response times, then adds
+// d to them. ok is false until size have been seen, so a baseline is never
+// judged from a handful of samples.
+func (b *latencyBaseline) observe(d time.Duration, size int) (average time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.samples) == size {
+		var sum time.Duration
+		for _, s := range b.samples {
+			sum += s
+		}
+		average, ok = sum/time.Duration(size), true
+	}
+	if len(b.samples) < size {
+		b.samples = append(b.samples, d)
+	} else {
+		b.samples[b.next] = d
+	}
+	b.next = (b.next + 1) % size
+	return average, ok
+}
+
+// checkLatency compares elapsed with the endpoint's moving average when
+// LatencyFactor is set, turning result into a warning when it exceeds the
+// average by that factor.
+func (endpoint Endpoint) checkLatency(result checkResult, elapsed time.Duration) checkResult {
+	if endpoint.LatencyFactor == 0 {
+		return result
+	}
+	size := endpoint.LatencySamples
+	if size == 0 {
+		size = defaultLatencySamples
+	}
+	average, ok := getLatencyBaseline(endpoint.Name+"\x00"+endpoint.URL).observe(elapsed, size)
+	if !ok || average <= 0 || float64(elapsed) <= float64(average)*endpoint.LatencyFactor {
+		return result
+	}
+	deviation := fmt.Sprintf("%.1fx the average of %s", float64(elapsed)/float64(average), average.Round(time.Millisecond))
+	// With thresholds set, the response time is already in the message.
+	if endpoint.Thresholds.set() {
+		result.Message += ", " + deviation
+	} else {
+		result.Message += fmt.Sprintf(", Response Time: %s is %s", elapsed.Round(time.Millisecond), deviation)
+	}
+	if result.Status < statusWarning {
+		result.Status = statusWarning
+	}
+	return result
+}
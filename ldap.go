@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// LDAPCheck binds to a directory server (simple or anonymous bind) and
+// optionally reads BaseDN with a base-scope search, verifying both
+// availability and credentials.
+type LDAPCheck struct {
+	Name               string `yaml:"name"`
+	URL                string `yaml:"url"`
+	StartTLS           bool   `yaml:"startTLS"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	BindDN             string `yaml:"bindDN"`
+	Password           string `yaml:"password"`
+	BaseDN             string `yaml:"baseDN"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the URL scheme and that a password is only sent over
+// TLS.
+func (l *LDAPCheck) Validate() error {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return fmt.Errorf("ldap check %s: invalid url: %w", l.Name, err)
+	}
+	switch u.Scheme {
+	case "ldap":
+		if l.Password != "" && !l.StartTLS {
+			return fmt.Errorf("ldap check %s: password requires an ldaps url or startTLS, as a simple bind sends it in the clear", l.Name)
+		}
+	case "ldaps":
+		if l.StartTLS {
+			return fmt.Errorf("ldap check %s: startTLS cannot be used with ldaps", l.Name)
+		}
+	default:
+		return fmt.Errorf("ldap check %s: url scheme must be ldap or ldaps", l.Name)
+	}
+	return nil
+}
+
+func (check LDAPCheck) run(ctx context.Context) checkResult {
+	if err := check.probe(ctx); err != nil {
+		return checkFailed("LDAP Name: %s, URL: %s %v", check.Name, check.URL, err)
+	}
+	if check.BaseDN != "" {
+		return checkOK("LDAP Name: %s, URL: %s, bind and search of %s succeeded", check.Name, check.URL, check.BaseDN)
+	}
+	return checkOK("LDAP Name: %s, URL: %s, bind succeeded", check.Name, check.URL)
+}
+
+func (check LDAPCheck) probe(ctx context.Context) error {
+	u, err := url.Parse(check.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "ldaps" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: check.InsecureSkipVerify}
+
+	var dialTLS *tls.Config
+	if u.Scheme == "ldaps" {
+		dialTLS = tlsConfig
+	}
+	conn, err := dialCheck(ctx, "tcp", host, 5*time.Second, dialTLS)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	session := &ldapSession{conn: conn, reader: bufio.NewReader(conn)}
+	if check.StartTLS {
+		// ExtendedRequest [APPLICATION 23] { requestName [0] StartTLS OID }
+		op := berTLV(0x77, berTLV(0x80, []byte("1.3.6.1.4.1.1466.20037")))
+		if err := session.request(op, 0x78); err != nil {
+			return fmt.Errorf("StartTLS failed: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("StartTLS handshake failed: %w", err)
+		}
+		session.conn, session.reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	// BindRequest [APPLICATION 0] { version 3, name, simple [0] password }
+	bind := berTLV(0x60, concat(berInt(3), berTLV(0x04, []byte(check.BindDN)), berTLV(0x80, []byte(check.Password))))
+	if err := session.request(bind, 0x61); err != nil {
+		return fmt.Errorf("bind failed: %w", err)
+	}
+
+	if check.BaseDN != "" {
+		// SearchRequest [APPLICATION 3] { base, scope baseObject, derefAliases never,
+		// sizeLimit 1, timeLimit 5, typesOnly false, (objectClass=*), attributes "1.1" }
+		search := berTLV(0x63, concat(
+			berTLV(0x04, []byte(check.BaseDN)),
+			berTLV(0x0a, []byte{0}),
+			berTLV(0x0a, []byte{0}),
+			berInt(1),
+			berInt(5),
+			berTLV(0x01, []byte{0}),
+			berTLV(0x87, []byte("objectClass")),
+			berTLV(0x30, berTLV(0x04, []byte("1.1"))),
+		))
+		if err := session.request(search, 0x65); err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+	}
+
+	// UnbindRequest [APPLICATION 2] NULL
+	return session.send(berTLV(0x42, nil))
+}
+
+// ldapSession exchanges LDAPMessage envelopes over a connection.
+type ldapSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+func (s *ldapSession) send(op []byte) error {
+	s.nextID++
+	_, err := s.conn.Write(berTLV(0x30, concat(berInt(s.nextID), op)))
+	return err
+}
+
+// request sends op and reads messages until the one with responseTag,
+// returning an error if its LDAPResult code is not success. Intermediate
+// messages, such as search result entries, are skipped.
+func (s *ldapSession) request(op []byte, responseTag byte) error {
+	if err := s.send(op); err != nil {
+		return err
+	}
+	for {
+		tag, msg, err := readBER(s.reader)
+		if err != nil {
+			return err
+		}
+		if tag != 0x30 {
+			return fmt.Errorf("unexpected message tag 0x%02x", tag)
+		}
+		elems, err := splitBER(msg)
+		if err != nil || len(elems) < 2 {
+			return fmt.Errorf("malformed LDAP message")
+		}
+		if elems[1].tag != responseTag {
+			continue
+		}
+		result, err := splitBER(elems[1].value)
+		if err != nil || len(result) < 3 {
+			return fmt.Errorf("malformed LDAP result")
+		}
+		if code := berIntValue(result[0].value); code != 0 {
+			if diag := string(result[2].value); diag != "" {
+				return fmt.Errorf("result code %d: %s", code, diag)
+			}
+			return fmt.Errorf("result code %d", code)
+		}
+		return nil
+	}
+}
+
+// maxBERLength bounds how much a peer can make us allocate for one element.
+const maxBERLength = 16 << 20
+
+type berElement struct {
+	tag   byte
+	value []byte
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+func berInt(v int) []byte {
+	b := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(0x02, b)
+}
+
+func berIntValue(b []byte) int {
+	var v int
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// readBER reads one complete tag-length-value element from r.
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxBERLength {
+		return 0, nil, fmt.Errorf("BER element of %d bytes is too large", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, err
+	}
+	return tag, value, nil
+}
+
+// splitBER decodes the sequence of elements making up a constructed value.
+func splitBER(data []byte) ([]berElement, error) {
+	var elems []berElement
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		tag, value, err := readBER(r)
+		if errors.Is(err, io.EOF) {
+			return elems, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, berElement{tag: tag, value: value})
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaderConfig elects one of several instances deployed side by side, such
+// as a pair behind a VIP, as the leader, and only the leader sends results
+// to the exporters, so metrics and traps go out once. Every instance keeps
+// answering health requests. Backend "file" holds the lease in File, which
+// must be on storage every instance shares; "consul" holds it as a lock on
+// Key in the Consul KV store at Address. A leader that cannot renew its
+// lease steps down when it runs out, after TTL, and another takes over.
+type LeaderConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Backend string        `yaml:"backend"`
+	File    string        `yaml:"file"`
+	Address string        `yaml:"address"`
+	Token   string        `yaml:"token"`
+	Key     string        `yaml:"key"`
+	ID      string        `yaml:"id"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// Validate checks the backend has what it needs.
+func (c *LeaderConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Backend {
+	case "file":
+		if c.File == "" {
+			return fmt.Errorf("leader: backend file requires file")
+		}
+	case "consul":
+		if c.Address != "" {
+			u, err := url.Parse(c.Address)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("leader: address must be an http:// or https:// URL")
+			}
+		}
+	default:
+		return fmt.Errorf("leader: backend must be file or consul, got %q", c.Backend)
+	}
+	if c.TTL != 0 && c.TTL < 3*time.Second {
+		return fmt.Errorf("leader: ttl must be at least 3s")
+	}
+	if c.Backend == "consul" && c.TTL != 0 && c.TTL < 10*time.Second {
+		return fmt.Errorf("leader: ttl must be at least 10s with consul, its minimum session TTL")
+	}
+	return nil
+}
+
+// leaderLease is how an instance holds the leadership.
+type leaderLease interface {
+	// acquire takes or renews the lease, reporting whether it is held
+	// until ttl from now.
+	acquire(ttl time.Duration) (bool, error)
+	// release gives the lease up, if held.
+	release()
+}
+
+// leaderElection is the leadership state of this instance.
+type leaderElection struct {
+	mu      sync.Mutex
+	enabled bool
+	until   time.Time // when the lease held runs out; zero when not held
+}
+
+// checkLeader is this instance's election; main starts it. While disabled
+// the instance always leads.
+var checkLeader = &leaderElection{}
+
+// leading reports whether this instance should send to the exporters.
+func (e *leaderElection) leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.enabled || time.Now().Before(e.until)
+}
+
+// startLeaderElection competes for the lease every third of the TTL until
+// the returned function, which gives it up, is called.
+func startLeaderElection(cfg LeaderConfig) func() {
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = 15 * time.Second
+	}
+	id := cfg.ID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = host + ":" + strconv.Itoa(os.Getpid())
+	}
+	var lease leaderLease = &fileLease{path: cfg.File, id: id}
+	if cfg.Backend == "consul" {
+		lease = newConsulLease(cfg, id)
+	}
+
+	checkLeader.mu.Lock()
+	checkLeader.enabled = true
+	checkLeader.mu.Unlock()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			checkLeader.renew(lease, id, ttl)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		lease.release()
+	}
+}
+
+// renew tries to take or keep the lease, logging changes of leadership.
+func (e *leaderElection) renew(lease leaderLease, id string, ttl time.Duration) {
+	was := e.leading()
+	start := time.Now()
+	held, err := lease.acquire(ttl)
+	if err != nil {
+		log.Printf("Leader election: %v", err)
+	}
+	e.mu.Lock()
+	if held && err == nil {
+		// Counted from before the attempt, so the lease never outlives
+		// the backend's.
+		e.until = start.Add(ttl)
+	} else if err == nil {
+		e.until = time.Time{}
+	}
+	e.mu.Unlock()
+	switch now := e.leading(); {
+	case now && !was:
+		log.Printf("Leader election: %s is now the leader", id)
+	case !now && was:
+		log.Printf("Leader election: %s is no longer the leader", id)
+	}
+}
+
+// fileLease holds the leadership as a lease file naming the holder and
+// when the lease ends. Updates are made under a lock file created
+// exclusively, which works on NFS, and written by renaming.
+type fileLease struct {
+	path, id string
+}
+
+type fileLeaseState struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *fileLease) acquire(ttl time.Duration) (bool, error) {
+	unlock, err := l.lock(ttl)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	state, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if state.Holder != l.id && now.Before(state.Expires) {
+		return false, nil
+	}
+	if err := l.write(fileLeaseState{Holder: l.id, Expires: now.Add(ttl)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *fileLease) release() {
+	unlock, err := l.lock(time.Minute)
+	if err != nil {
+		log.Printf("Leader election: %v", err)
+		return
+	}
+	defer unlock()
+	if state, err := l.read(); err == nil && state.Holder == l.id {
+		if err := l.write(fileLeaseState{Holder: l.id}); err != nil {
+			log.Printf("Leader election: %v", err)
+		}
+	}
+}
+
+// lock creates the lock file, taking over one left for longer than ttl
+// by an instance that died holding it.
+func (l *fileLease) lock(ttl time.Duration) (func(), error) {
+	path := l.path + ".lock"
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) // #nosec G304 -- path is from the config file
+		if err == nil {
+			closeAndLog(f, "lock file")
+			return func() {
+				if err := os.Remove(path); err != nil {
+					log.Printf("Leader election: %v", err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > ttl {
+			_ = os.Remove(path) // stale; whoever recreates it first wins
+			continue
+		}
+		if attempt == 10 {
+			return nil, fmt.Errorf("lease %s is locked", l.path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (l *fileLease) read() (fileLeaseState, error) {
+	var state fileLeaseState
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fileLeaseState{}, nil // unreadable, so free for the taking
+	}
+	return state, nil
+}
+
+func (l *fileLease) write(state fileLeaseState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		closeAndLog(tmp, "lease file")
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// consulLease holds the leadership as a lock on a key in Consul's KV
+// store, through a session that Consul invalidates, releasing the lock,
+// unless renewed within its TTL.
+type consulLease struct {
+	address, token, key, id string
+	client                  *http.Client
+	session                 string
+	ttl                     time.Duration
+}
+
+func newConsulLease(cfg LeaderConfig, id string) *consulLease {
+	l := &consulLease{address: strings.TrimSuffix(cfg.Address, "/"), token: cfg.Token, key: cfg.Key, id: id, client: exportClient}
+	if l.address == "" {
+		l.address = "http://127.0.0.1:8500"
+	}
+	if l.key == "" {
+		l.key = "server-health-api/leader"
+	}
+	return l
+}
+
+func (l *consulLease) acquire(ttl time.Duration) (bool, error) {
+	// An answer after the TTL is too late to count on.
+	l.ttl = ttl
+	ctx, cancel := context.WithTimeout(context.Background(), ttl)
+	defer cancel()
+	if l.session != "" {
+		var renewed []json.RawMessage
+		status, err := l.put(ctx, "/v1/session/renew/"+url.PathEscape(l.session), nil, &renewed)
+		if err != nil && status != http.StatusNotFound {
+			return false, err
+		}
+		if status == http.StatusNotFound {
+			l.session = "" // expired; start over with a new one
+		}
+	}
+	if l.session == "" {
+		var created struct{ ID string }
+		session := map[string]string{"Name": "server-health-api leader " + l.id, "TTL": ttl.String(), "Behavior": "release", "LockDelay": "0s"}
+		if _, err := l.put(ctx, "/v1/session/create", session, &created); err != nil {
+			return false, err
+		}
+		l.session = created.ID
+	}
+	var acquired bool
+	if _, err := l.put(ctx, "/v1/kv/"+l.key+"?acquire="+url.QueryEscape(l.session), l.id, &acquired); err != nil {
+		return false, err
+	}
+	return acquired, nil
+}
+
+func (l *consulLease) release() {
+	if l.session == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+	if _, err := l.put(ctx, "/v1/session/destroy/"+url.PathEscape(l.session), nil, nil); err != nil {
+		log.Printf("Leader election: %v", err)
+	}
+}
+
+// put sends a PUT to the Consul API, decoding the response into out, and
+// returns the response's status.
+func (l *consulLease) put(ctx context.Context, path string, body, out interface{}) (int, error) {
+	var payload io.Reader = http.NoBody
+	if s, ok := body.(string); ok {
+		payload = strings.NewReader(s)
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, l.address+path, payload)
+	if err != nil {
+		return 0, err
+	}
+	if l.token != "" {
+		req.Header.Set("X-Consul-Token", l.token)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("consul returned %s", resp.Status)
+	}
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
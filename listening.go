@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenStates are the states of bound sockets in /proc/net: LISTEN for
+// TCP and, for UDP, which has no listening, the unconnected CLOSE.
+var listenStates = map[string]string{"tcp": "0A", "udp": "07"}
+
+// runListening checks something is bound to the port by reading the
+// kernel's socket tables rather than connecting, so the application logs
+// no probe connections and sockets behind a local firewall still count.
+func (port Port) runListening(ctx context.Context) checkResult {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	var want []net.IP
+	if port.Address != "" {
+		if ip := net.ParseIP(port.Address); ip != nil {
+			want = []net.IP{ip}
+		} else {
+			addrs, err := checkResolver.LookupIPAddr(ctx, port.Address)
+			if err != nil {
+				return checkFailed("Port Name: %s, Address: %s could not be resolved: %v", port.Name, port.Address, err)
+			}
+			for _, a := range addrs {
+				want = append(want, a.IP)
+			}
+		}
+	}
+	bound, err := boundAddresses(protocol, port.Port)
+	if err != nil {
+		return checkFailed("Port Name: %s, Port: %d could not be read: %v", port.Name, port.Port, err)
+	}
+	for _, ip := range bound {
+		if listensFor(ip, want) {
+			return checkOK("Port Name: %s, Port: %d is listening on %s (%s)", port.Name, port.Port, ip, protocol)
+		}
+	}
+	return checkFailed("Port Name: %s, Port: %d is not listening (%s)", port.Name, port.Port, protocol)
+}
+
+// listensFor reports whether a socket bound to ip takes connections for
+// one of want: any when want is empty, and any of the family, or with ::
+// of both, when ip is unspecified.
+func listensFor(ip net.IP, want []net.IP) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		switch {
+		case w.Equal(ip), w.IsUnspecified():
+			return true
+		case ip.Equal(net.IPv4zero):
+			if w.To4() != nil {
+				return true
+			}
+		case ip.Equal(net.IPv6unspecified):
+			return true
+		}
+	}
+	return false
+}
+
+// boundAddresses returns the local addresses of the sockets of protocol,
+// tcp or udp, bound to port, from /proc/net and its IPv6 counterpart.
+func boundAddresses(protocol string, port int) ([]net.IP, error) {
+	var addresses []net.IP
+	read := 0
+	for _, path := range []string{"/proc/net/" + protocol, "/proc/net/" + protocol + "6"} {
+		file, err := os.Open(path) // #nosec G304 -- one of four fixed paths
+		if err != nil {
+			continue // missing with IPv6 disabled
+		}
+		read++
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // the header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[3] != listenStates[protocol] {
+				continue
+			}
+			hexAddr, hexPort, _ := strings.Cut(fields[1], ":")
+			if p, err := strconv.ParseInt(hexPort, 16, 32); err != nil || int(p) != port {
+				continue
+			}
+			if ip := parseProcIP(hexAddr); ip != nil {
+				addresses = append(addresses, ip)
+			}
+		}
+		err = scanner.Err()
+		closeAndLog(file, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if read == 0 {
+		return nil, fmt.Errorf("/proc/net/%s is not available", protocol)
+	}
+	return addresses, nil
+}
+
+// parseProcIP reads an address as /proc/net writes it: in hex, as 32-bit
+// words in host byte order, which is little-endian on the platforms this
+// runs on.
+func parseProcIP(s string) net.IP {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return net.IP(b)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" // #nosec G505 -- SHA-1 is mandated by the WebSocket handshake
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsWriteTimeout bounds each write to a live feed client.
+const wsWriteTimeout = 10 * time.Second
+
+// wsMessage is one message of the live feed: the events of /events, with
+// the event's name alongside its data.
+type wsMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// liveFeedHandler serves /ws, the events of /events over a WebSocket for
+// the status page and custom UIs. Each text message is a JSON wsMessage.
+// Messages from the client other than pings and close are ignored.
+func liveFeedHandler(live *liveConfig, cache *resultCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		every, err := snapshotInterval(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !headerHasToken(r.Header, "Connection", "upgrade") || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if key == "" {
+			http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			log.Printf("Failed to upgrade to WebSocket: %v", err)
+			return
+		}
+		defer closeAndLog(conn, "websocket")
+		sum := sha1.Sum([]byte(key + wsGUID)) // #nosec G401 -- SHA-1 is mandated by the WebSocket handshake
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		feed := &wsFeed{conn: conn}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go feed.read(rw.Reader, cancel)
+		streamEvents(ctx, live, cache, every, func(e healthEvent) bool {
+			data, err := json.Marshal(wsMessage{Event: e.name, Data: e.data})
+			if err != nil {
+				log.Printf("Failed to encode event: %v", err)
+				return false
+			}
+			return feed.write(wsOpText, data) == nil
+		})
+		// Close with status 1001 (going away); the client's reply is not awaited.
+		_ = feed.write(wsOpClose, []byte{0x03, 0xe9})
+	}
+}
+
+// wsFeed is the server end of a live feed connection.
+type wsFeed struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// write sends one frame, shared between the feed and replies to the client.
+func (f *wsFeed) write(opcode byte, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	return writeWSServerFrame(f.conn, opcode, payload)
+}
+
+// read answers the client's pings until it closes the connection or goes
+// away, then calls done.
+func (f *wsFeed) read(r *bufio.Reader, done func()) {
+	defer done()
+	for {
+		_, opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			return
+		case wsOpPing:
+			if err := f.write(wsOpPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, whatever its case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogCheck tails a log file, counting lines matching any of Patterns, and
+// fails when more than Threshold matches were seen within Window.
+type LogCheck struct {
+	Name      string        `yaml:"name"`
+	Path      string        `yaml:"path"`
+	Patterns  []string      `yaml:"patterns"`
+	Window    time.Duration `yaml:"window"`
+	Threshold int           `yaml:"threshold"`
+	FromStart bool          `yaml:"fromStart"`
+
+	// Thresholds grade the match count instead of Threshold.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the path, window and patterns.
+func (l *LogCheck) Validate() error {
+	if l.Path == "" {
+		return fmt.Errorf("logs check %s: path is required", l.Name)
+	}
+	if l.Window <= 0 {
+		return fmt.Errorf("logs check %s: window must be positive", l.Name)
+	}
+	if len(l.Patterns) == 0 {
+		return fmt.Errorf("logs check %s: at least one pattern is required", l.Name)
+	}
+	if _, err := compilePatterns(l.Patterns); err != nil {
+		return fmt.Errorf("logs check %s: %w", l.Name, err)
+	}
+	if l.Threshold < 0 {
+		return fmt.Errorf("logs check %s: invalid threshold: %d", l.Name, l.Threshold)
+	}
+	if l.Threshold != 0 && l.Thresholds.set() {
+		return fmt.Errorf("logs check %s: threshold cannot be combined with warn and crit", l.Name)
+	}
+	if err := l.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("logs check %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+// logTail is the read position and recent match times for one log file,
+// kept between check runs.
+type logTail struct {
+	mu      sync.Mutex
+	started bool
+	inode   uint64
+	offset  int64
+	matches []time.Time
+}
+
+var (
+	logTailsMu sync.Mutex
+	logTails   = map[string]*logTail{}
+)
+
+func getLogTail(key string) *logTail {
+	logTailsMu.Lock()
+	defer logTailsMu.Unlock()
+	t, ok := logTails[key]
+	if !ok {
+		t = &logTail{}
+		logTails[key] = t
+	}
+	return t
+}
+
+func (check LogCheck) run(ctx context.Context) checkResult {
+	count, err := countLogMatches(check)
+	switch {
+	case err != nil:
+		return checkFailed("Log Name: %s, Path: %s could not be read: %v", check.Name, check.Path, err)
+	case check.Thresholds.set():
+		return check.Thresholds.apply(checkOK("Log Name: %s, Path: %s, Matches: %d in the last %s", check.Name, check.Path, count, check.Window), float64(count), "")
+	case count > check.Threshold:
+		return checkFailed("Log Name: %s, Path: %s, Matches: %d in the last %s exceeds threshold: %d", check.Name, check.Path, count, check.Window, check.Threshold)
+	default:
+		return checkOK("Log Name: %s, Path: %s, Matches: %d in the last %s is within threshold: %d", check.Name, check.Path, count, check.Window, check.Threshold)
+	}
+}
+
+// countLogMatches reads lines appended since the previous run and returns the
+// number of matches recorded within the window. Rotation and truncation are
+// detected by inode and size changes, restarting from the top of the new file.
+func countLogMatches(check LogCheck) (int, error) {
+	patterns, err := compilePatterns(check.Patterns)
+	if err != nil {
+		return 0, err
+	}
+
+	tail := getLogTail(check.Name + "\x00" + check.Path)
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+
+	f, err := os.Open(check.Path) // #nosec G304 -- path is from the config file
+	if err != nil {
+		return 0, err
+	}
+	defer closeAndLog(f, "log file")
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	inode := fileInode(info)
+
+	switch {
+	case !tail.started:
+		tail.started = true
+		if !check.FromStart {
+			tail.offset = info.Size()
+		}
+	case inode != tail.inode || info.Size() < tail.offset:
+		tail.offset = 0
+	}
+	tail.inode = inode
+
+	if _, err := f.Seek(tail.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// Leave a partial last line to be read once it is complete.
+			break
+		}
+		tail.offset += int64(len(line))
+		if matchesAny(patterns, line) {
+			tail.matches = append(tail.matches, now)
+		}
+	}
+
+	cutoff := now.Add(-check.Window)
+	kept := tail.matches[:0]
+	for _, t := range tail.matches {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	tail.matches = kept
+	return len(tail.matches), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	selinuxEnforceFile = "/sys/fs/selinux/enforce"
+	apparmorProfiles   = "/sys/kernel/security/apparmor/profiles"
+)
+
+// SecurityModuleCheck asserts either that SELinux is in the expected mode, or
+// that an AppArmor profile is loaded (optionally in a given mode).
+type SecurityModuleCheck struct {
+	Name            string `yaml:"name"`
+	SELinux         string `yaml:"selinux"`
+	AppArmorProfile string `yaml:"apparmorProfile"`
+	AppArmorMode    string `yaml:"apparmorMode"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the expected modes.
+func (s *SecurityModuleCheck) Validate() error {
+	switch s.SELinux {
+	case "", "enforcing", "permissive", "disabled":
+	default:
+		return fmt.Errorf("security module check %s: invalid selinux mode %q", s.Name, s.SELinux)
+	}
+	switch s.AppArmorMode {
+	case "", "enforce", "complain", "kill", "unconfined":
+	default:
+		return fmt.Errorf("security module check %s: invalid apparmor mode %q", s.Name, s.AppArmorMode)
+	}
+	if (s.SELinux == "") == (s.AppArmorProfile == "") {
+		return fmt.Errorf("security module check %s: exactly one of selinux or apparmorProfile is required", s.Name)
+	}
+	return nil
+}
+
+func (check SecurityModuleCheck) run(ctx context.Context) checkResult {
+	if check.SELinux != "" {
+		mode, err := selinuxMode()
+		switch {
+		case err != nil:
+			return checkFailed("Security Module Name: %s, SELinux mode could not be read: %v", check.Name, err)
+		case mode != check.SELinux:
+			return checkFailed("Security Module Name: %s, Expected SELinux: %s, Actual SELinux: %s", check.Name, check.SELinux, mode)
+		default:
+			return checkOK("Security Module Name: %s, SELinux: %s is as expected", check.Name, mode)
+		}
+	}
+
+	mode, err := apparmorProfileMode(check.AppArmorProfile)
+	switch {
+	case err != nil:
+		return checkFailed("Security Module Name: %s, AppArmor profiles could not be read: %v", check.Name, err)
+	case mode == "":
+		return checkFailed("Security Module Name: %s, AppArmor Profile: %s is not loaded", check.Name, check.AppArmorProfile)
+	case check.AppArmorMode != "" && mode != check.AppArmorMode:
+		return checkFailed("Security Module Name: %s, AppArmor Profile: %s, Expected Mode: %s, Actual Mode: %s", check.Name, check.AppArmorProfile, check.AppArmorMode, mode)
+	default:
+		return checkOK("Security Module Name: %s, AppArmor Profile: %s is loaded in %s mode", check.Name, check.AppArmorProfile, mode)
+	}
+}
+
+// selinuxMode returns "enforcing", "permissive" or "disabled" when selinuxfs
+// is not mounted.
+func selinuxMode() (string, error) {
+	data, err := os.ReadFile(selinuxEnforceFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "disabled", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return "enforcing", nil
+	}
+	return "permissive", nil
+}
+
+// apparmorProfileMode returns the mode of a loaded profile, or an empty
+// string if it is not loaded. Lines look like "/usr/sbin/nginx (enforce)".
+func apparmorProfileMode(profile string) (string, error) {
+	f, err := os.Open(apparmorProfiles)
+	if err != nil {
+		return "", err
+	}
+	defer closeAndLog(f, apparmorProfiles)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndex(line, " (")
+		if i < 0 || line[:i] != profile {
+			continue
+		}
+		return strings.TrimSuffix(line[i+2:], ")"), nil
+	}
+	return "", scanner.Err()
+}
@@ -3,19 +3,23 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -25,102 +29,603 @@ import (
 )
 
 type Config struct {
-	Config    AppConfig  `yaml:"config"`
-	Services  []Service  `yaml:"services"`
-	Ports     []Port     `yaml:"ports"`
-	Endpoints []Endpoint `yaml:"endpoints"`
+	Config          AppConfig             `yaml:"config"`
+	Services        []Service             `yaml:"services"`
+	Ports           []Port                `yaml:"ports"`
+	Endpoints       []Endpoint            `yaml:"endpoints"`
+	Kubernetes      []KubernetesCheck     `yaml:"kubernetes"`
+	Journald        []JournaldCheck       `yaml:"journald"`
+	Logs            []LogCheck            `yaml:"logs"`
+	Interfaces      []InterfaceCheck      `yaml:"interfaces"`
+	Gateways        []GatewayCheck        `yaml:"gateways"`
+	Firewall        []FirewallCheck       `yaml:"firewall"`
+	SecurityModules []SecurityModuleCheck `yaml:"securityModules"`
+	Sysctl          []SysctlCheck         `yaml:"sysctl"`
+	Packages        []PackageCheck        `yaml:"packages"`
+	RebootRequired  []RebootCheck         `yaml:"rebootRequired"`
+	Temperatures    []TemperatureCheck    `yaml:"temperatures"`
+	GPUs            []GPUCheck            `yaml:"gpus"`
+	ZFS             []ZFSCheck            `yaml:"zfs"`
+	UPS             []UPSCheck            `yaml:"ups"`
+	SMTP            []SMTPCheck           `yaml:"smtp"`
+	LDAP            []LDAPCheck           `yaml:"ldap"`
+	Kafka           []KafkaCheck          `yaml:"kafka"`
+	AMQP            []AMQPCheck           `yaml:"amqp"`
+	Elasticsearch   []ElasticsearchCheck  `yaml:"elasticsearch"`
+	Etcd            []EtcdCheck           `yaml:"etcd"`
+	Vault           []VaultCheck          `yaml:"vault"`
+	MQTT            []MQTTCheck           `yaml:"mqtt"`
+	GRPC            []GRPCCheck           `yaml:"grpc"`
+	WebSockets      []WebSocketCheck      `yaml:"websockets"`
+	SSH             []SSHCheck            `yaml:"ssh"`
+	FTP             []FTPCheck            `yaml:"ftp"`
+	Shares          []ShareCheck          `yaml:"shares"`
+	S3              []S3Check             `yaml:"s3"`
+	Disks           []DiskCheck           `yaml:"disks"`
+	Cgroups         []CgroupCheck         `yaml:"cgroups"`
+	PerfCounters    []PerfCounterCheck    `yaml:"perfCounters"`
+	EventLogs       []EventLogCheck       `yaml:"eventLogs"`
+	Processes       []ProcessCheck        `yaml:"processes"`
+	Transactions    []TransactionCheck    `yaml:"transactions"`
+	Throughput      []ThroughputCheck     `yaml:"throughput"`
+	Sockets         []SocketCheck         `yaml:"sockets"`
+	Entropy         []EntropyCheck        `yaml:"entropy"`
+	FileDescriptors []FileDescriptorCheck `yaml:"fileDescriptors"`
+	Runaways        []RunawayCheck        `yaml:"runaways"`
+	CronJobs        []CronJobCheck        `yaml:"cronJobs"`
+	Backups         []BackupCheck         `yaml:"backups"`
+	PHPFPM          []PHPFPMCheck         `yaml:"phpfpm"`
+	Upstreams       []UpstreamCheck       `yaml:"upstreams"`
+	Composites      []CompositeCheck      `yaml:"composites"`
+	Profiles        []Profile             `yaml:"profiles"`
+	Discovery       []Discovery           `yaml:"discovery"`
+
+	// sources maps the paths set by environment variables and flags to
+	// where they came from.
+	sources map[string]string
+}
+
+// ListenConfig is the address the API listens on.
+type ListenConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// SSLConfig serves the API over TLS.
+type SSLConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	Enabled  bool   `yaml:"enabled"`
+}
+
+// AdminConfig enables the admin API. Faults enables the endpoints that
+// force checks or the server to fail for a while, for testing; leave it
+// off in production. Check faults reach exporters and webhooks, while a
+// server fault, like a drain, only changes the overall status.
+type AdminConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	StateFile string `yaml:"stateFile"`
+	Faults    bool   `yaml:"faults"`
+}
+
+// AuthConfig is basic authentication for the API.
+type AuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Enabled  bool   `yaml:"enabled"`
+}
+
+// StatusCodes replace the HTTP status codes /healthy answers with; zero
+// keeps the default.
+type StatusCodes struct {
+	Healthy      int `yaml:"healthy"`
+	Warning      int `yaml:"warning"`
+	Degraded     int `yaml:"degraded"`
+	Unhealthy    int `yaml:"unhealthy"`
+	Unauthorized int `yaml:"unauthorized"`
+}
+
+// Validate checks the codes are valid HTTP statuses.
+func (codes StatusCodes) Validate() error {
+	for _, code := range []int{codes.Healthy, codes.Warning, codes.Degraded, codes.Unhealthy} {
+		if code != 0 && (code < 200 || code > 599) {
+			return fmt.Errorf("invalid status code: %d", code)
+		}
+	}
+	if codes.Unauthorized != 0 && codes.Unauthorized != http.StatusUnauthorized && codes.Unauthorized != http.StatusNotFound {
+		return fmt.Errorf("statusCodes.unauthorized must be 401 or 404")
+	}
+	return nil
 }
 
 type AppConfig struct {
-	Listen struct {
-		Host string `yaml:"host"`
-		Port int    `yaml:"port"`
-	} `yaml:"listen"`
-	SSL struct {
-		CertFile string `yaml:"certFile"`
-		KeyFile  string `yaml:"keyFile"`
-		Enabled  bool   `yaml:"enabled"`
-	} `yaml:"ssl"`
-	Auth struct {
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
-		Enabled  bool   `yaml:"enabled"`
-	} `yaml:"auth"`
+	Listen         ListenConfig              `yaml:"listen"`
+	SSL            SSLConfig                 `yaml:"ssl"`
+	Auth           AuthConfig                `yaml:"auth"`
+	Audit          AuditConfig               `yaml:"audit"`
+	StatusCodes    StatusCodes               `yaml:"statusCodes"`
+	Rollup         RollupConfig              `yaml:"rollup"`
+	Score          ScoreConfig               `yaml:"score"`
+	RequestTimeout time.Duration             `yaml:"requestTimeout"`
+	Interval       time.Duration             `yaml:"interval"`
+	MaxAge         time.Duration             `yaml:"maxAge"`
+	Jitter         time.Duration             `yaml:"jitter"`
+	Spread         bool                      `yaml:"spread"`
+	Admin          AdminConfig               `yaml:"admin"`
+	Concurrency    ConcurrencyConfig         `yaml:"concurrency"`
+	DNS            DNSConfig                 `yaml:"dns"`
+	HTTP           HTTPConfig                `yaml:"http"`
+	UserAgent      string                    `yaml:"userAgent"`
+	Headers        map[string]string         `yaml:"requestHeaders"`
+	Source         string                    `yaml:"sourceAddress"`
+	Consul         ConsulConfig              `yaml:"consul"`
+	StatsD         StatsDConfig              `yaml:"statsd"`
+	InfluxDB       InfluxDBConfig            `yaml:"influxdb"`
+	CloudWatch     CloudWatchConfig          `yaml:"cloudwatch"`
+	Datadog        DatadogConfig             `yaml:"datadog"`
+	Webhook        WebhookConfig             `yaml:"webhook"`
+	NRPE           NRPEConfig                `yaml:"nrpe"`
+	HAProxyAgent   HAProxyAgentConfig        `yaml:"haproxyAgent"`
+	DNSResponder   DNSResponderConfig        `yaml:"dnsResponder"`
+	Zabbix         ZabbixConfig              `yaml:"zabbix"`
+	SNMP           SNMPConfig                `yaml:"snmp"`
+	Signing        SigningConfig             `yaml:"signing"`
+	Caching        CachingConfig             `yaml:"caching"`
+	Vantage        VantageConfig             `yaml:"vantage"`
+	Privileges     PrivilegeConfig           `yaml:"privileges"`
+	Sandbox        SandboxConfig             `yaml:"sandbox"`
+	Probe          ProbeConfig               `yaml:"probe"`
+	Sidecar        SidecarConfig             `yaml:"sidecar"`
+	Heartbeat      HeartbeatConfig           `yaml:"heartbeat"`
+	Messages       MessagesConfig            `yaml:"messages"`
+	Outputs        map[string]OutputTemplate `yaml:"outputTemplates"`
+	Vault          VaultConfig               `yaml:"vault"`
+	Leader         LeaderConfig              `yaml:"leader"`
+	Shutdown       ShutdownConfig            `yaml:"shutdown"`
 }
 
 type Service struct {
 	Name   string `yaml:"name"`
 	Status string `yaml:"status"`
+
+	CheckOptions `yaml:",inline"`
 }
 
 type Port struct {
-	Name    string `yaml:"name"`
+	Name string `yaml:"name"`
+	// Address may be a unix:// URL, connecting to that socket, with no Port.
 	Address string `yaml:"address"`
 	Port    int    `yaml:"port"`
+	Proxy   string `yaml:"proxy"`
+	// Mode "listening" checks the port is bound, from the kernel's socket
+	// tables, instead of connecting to it. Protocol, tcp unless set, may
+	// then be udp.
+	Mode     string     `yaml:"mode"`
+	Protocol string     `yaml:"protocol"`
+	Dial     DialConfig `yaml:"dial"`
+
+	// Targets replace Address and Port, making a check of each.
+	Targets `yaml:",inline"`
+	// Thresholds grade the connect time in milliseconds.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
 }
 
 type Endpoint struct {
-	Name     string `yaml:"name"`
-	URL      string `yaml:"url"`
-	Statuses []int  `yaml:"statuses"`
-	Status   int    `yaml:"status"`
+	Name           string            `yaml:"name"`
+	URL            string            `yaml:"url"`
+	Method         string            `yaml:"method"`
+	DiscardBody    *bool             `yaml:"discardBody"`
+	Statuses       []int             `yaml:"statuses"`
+	Status         int               `yaml:"status"`
+	HostsOverride  map[string]string `yaml:"hostsOverride"`
+	Proxy          string            `yaml:"proxy"`
+	Socket         string            `yaml:"socket"`
+	Host           string            `yaml:"host"`
+	ServerName     string            `yaml:"serverName"`
+	HTTP           HTTPConfig        `yaml:"http"`
+	Dial           DialConfig        `yaml:"dial"`
+	UserAgent      string            `yaml:"userAgent"`
+	RequestHeaders map[string]string `yaml:"headers"`
+	Headers        []HeaderAssertion `yaml:"expectedHeaders"`
+	MinBodyBytes   int64             `yaml:"minBodyBytes"`
+	MaxBodyBytes   int64             `yaml:"maxBodyBytes"`
+	SHA256         string            `yaml:"sha256"`
+	LatencyFactor  float64           `yaml:"latencyFactor"`
+	LatencySamples int               `yaml:"latencySamples"`
+	Certificate    *CertificateCheck `yaml:"certificate"`
+
+	// Targets replace the host and port of the URL, making a check of each.
+	Targets `yaml:",inline"`
+	// Thresholds grade the response time in milliseconds.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// validate checks the port, targets, socket, proxy, thresholds, dial
+// settings and mode, adding the problems found as being about path.
+func (port Port) validate(errs *configErrors, path string) {
+	if port.Targets.set() {
+		if err := port.Targets.Validate(); err != nil {
+			errs.add(path, fmt.Errorf("port check %s: %w", port.Name, err))
+		}
+		if (len(port.Hosts) > 0 && port.Address != "") || (port.Ports != "" && port.Port != 0) {
+			errs.add(path, fmt.Errorf("port check %s: hosts and ports replace address and port, so cannot be set with them", port.Name))
+		}
+	}
+	if _, ok := unixSocket(port.Address); ok {
+		if err := validateUnixSocket(port.Address); err != nil {
+			errs.add(path+".address", fmt.Errorf("port check %s: address %w", port.Name, err))
+		}
+		if port.Port != 0 || port.Ports != "" || port.Proxy != "" || port.Mode != "" || port.Protocol != "" {
+			errs.add(path+".address", fmt.Errorf("port check %s: a unix socket takes no port, proxy, mode or protocol", port.Name))
+		}
+		if err := port.Thresholds.Validate(); err != nil {
+			errs.add(path, fmt.Errorf("port check %s: %w", port.Name, err))
+		}
+		return
+	}
+	if port.Ports == "" && (port.Port < 1 || port.Port > 65535) {
+		errs.add(path+".port", fmt.Errorf("invalid port: %d for %s", port.Port, port.Name))
+	}
+	if port.Proxy != "" {
+		if _, err := parseSOCKS5URL(port.Proxy); err != nil {
+			errs.add(path+".proxy", fmt.Errorf("port check %s: %w", port.Name, err))
+		}
+	}
+	if err := port.Thresholds.Validate(); err != nil {
+		errs.add(path, fmt.Errorf("port check %s: %w", port.Name, err))
+	}
+	if err := port.Dial.Validate(); err != nil {
+		errs.add(path+".dial", fmt.Errorf("port check %s: dial: %w", port.Name, err))
+	}
+	switch port.Mode {
+	case "", "connect":
+		if port.Protocol != "" && port.Protocol != "tcp" {
+			errs.add(path+".protocol", fmt.Errorf("port check %s: protocol %s requires mode listening", port.Name, port.Protocol))
+		}
+	case "listening":
+		if port.Protocol != "" && port.Protocol != "tcp" && port.Protocol != "udp" {
+			errs.add(path+".protocol", fmt.Errorf("port check %s: protocol must be tcp or udp", port.Name))
+		}
+		if port.Proxy != "" || port.Thresholds.set() {
+			errs.add(path+".mode", fmt.Errorf("port check %s: mode listening does not connect, so takes no proxy, warn or crit", port.Name))
+		}
+	default:
+		errs.add(path+".mode", fmt.Errorf("port check %s: mode must be connect or listening", port.Name))
+	}
+}
+
+// HeaderAssertion requires a response header to equal Value or, with Regex
+// set, to match it. With neither set the header only has to be present.
+type HeaderAssertion struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+}
+
+// Validate checks the URL, targets, method, host overrides, proxy, socket,
+// transport and dial settings, header assertions, body assertions and
+// latency settings.
+func (endpoint *Endpoint) Validate() error {
+	if _, err := url.Parse(endpoint.URL); err != nil {
+		return fmt.Errorf("invalid URL %s: %w", endpoint.URL, err)
+	}
+	if err := endpoint.Targets.Validate(); err != nil {
+		return fmt.Errorf("endpoint check %s: %w", endpoint.Name, err)
+	}
+	if endpoint.Targets.set() && endpoint.Socket != "" {
+		return fmt.Errorf("endpoint check %s: hosts and ports cannot be used with socket", endpoint.Name)
+	}
+	switch endpoint.Method {
+	case "", http.MethodGet:
+	case http.MethodHead:
+		if endpoint.MinBodyBytes > 0 || endpoint.SHA256 != "" {
+			return fmt.Errorf("endpoint check %s: minBodyBytes and sha256 cannot be used with HEAD", endpoint.Name)
+		}
+	default:
+		return fmt.Errorf("endpoint check %s: method must be GET or HEAD", endpoint.Name)
+	}
+	for host, ip := range endpoint.HostsOverride {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("endpoint check %s: hostsOverride for %s must be an IP address", endpoint.Name, host)
+		}
+	}
+	if (endpoint.Host != "" || endpoint.ServerName != "") && len(endpoint.HostsOverride) > 0 {
+		return fmt.Errorf("endpoint check %s: host and serverName cannot be used with hostsOverride", endpoint.Name)
+	}
+	if endpoint.ServerName != "" && !strings.HasPrefix(endpoint.URL, "https://") {
+		return fmt.Errorf("endpoint check %s: serverName requires an https url", endpoint.Name)
+	}
+	if endpoint.Proxy != "" {
+		if _, err := parseSOCKS5URL(endpoint.Proxy); err != nil {
+			return fmt.Errorf("endpoint check %s: %w", endpoint.Name, err)
+		}
+	}
+	if endpoint.Socket != "" {
+		if err := validateUnixSocket(endpoint.Socket); err != nil {
+			return fmt.Errorf("endpoint check %s: socket %w", endpoint.Name, err)
+		}
+		if endpoint.Proxy != "" || len(endpoint.HostsOverride) > 0 {
+			return fmt.Errorf("endpoint check %s: socket cannot be used with proxy or hostsOverride", endpoint.Name)
+		}
+	}
+	if err := endpoint.HTTP.Validate(); err != nil {
+		return fmt.Errorf("endpoint check %s: http: %w", endpoint.Name, err)
+	}
+	if err := endpoint.Dial.Validate(); err != nil {
+		return fmt.Errorf("endpoint check %s: dial: %w", endpoint.Name, err)
+	}
+	for _, h := range endpoint.Headers {
+		if h.Name == "" {
+			return fmt.Errorf("endpoint check %s: expectedHeaders entries require a name", endpoint.Name)
+		}
+		if h.Value != "" && h.Regex != "" {
+			return fmt.Errorf("endpoint check %s: header %s cannot set both value and regex", endpoint.Name, h.Name)
+		}
+		if _, err := regexp.Compile(h.Regex); err != nil {
+			return fmt.Errorf("endpoint check %s: header %s: invalid regex: %w", endpoint.Name, h.Name, err)
+		}
+	}
+	if endpoint.MinBodyBytes < 0 || endpoint.MaxBodyBytes < 0 {
+		return fmt.Errorf("endpoint check %s: minBodyBytes and maxBodyBytes must not be negative", endpoint.Name)
+	}
+	if endpoint.MaxBodyBytes > 0 && endpoint.MinBodyBytes > endpoint.MaxBodyBytes {
+		return fmt.Errorf("endpoint check %s: minBodyBytes exceeds maxBodyBytes", endpoint.Name)
+	}
+	if endpoint.SHA256 != "" && !sha256Regex.MatchString(endpoint.SHA256) {
+		return fmt.Errorf("endpoint check %s: sha256 must be 64 hex digits", endpoint.Name)
+	}
+	if endpoint.LatencyFactor != 0 && endpoint.LatencyFactor <= 1 {
+		return fmt.Errorf("endpoint check %s: latencyFactor must be greater than 1", endpoint.Name)
+	}
+	if endpoint.LatencySamples < 0 {
+		return fmt.Errorf("endpoint check %s: latencySamples must not be negative", endpoint.Name)
+	}
+	if endpoint.Certificate != nil {
+		if !strings.HasPrefix(endpoint.URL, "https://") {
+			return fmt.Errorf("endpoint check %s: certificate requires an https url", endpoint.Name)
+		}
+		if err := endpoint.Certificate.Validate(); err != nil {
+			return fmt.Errorf("endpoint check %s: certificate: %w", endpoint.Name, err)
+		}
+	}
+	if err := endpoint.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("endpoint check %s: %w", endpoint.Name, err)
+	}
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxFlag {
+		runSandboxShim(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == selftestCommand {
+		os.Exit(runSelftest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == diffCommand {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
 	configFilePath := flag.String("config", GetEnv("HEALTHCHECK_CONFIG_FILE", "config.yaml"), "Path to the config file")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	watch := flag.Bool("watch", true, "Reload the config file when it changes")
+	flag.Var(&configSettings, "set", "Set a config field, as path=value such as config.auth.username=admin, over the file and environment; repeatable")
+	schema := flag.Bool("schema", false, "Print the JSON Schema of the config file and exit")
+	probe := flag.String("probe", "", "Exit with the health in the sidecar status file at this path, for exec probes")
 
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(currentBuildInfo())
+		return
+	}
+	if *schema {
+		out, err := json.MarshalIndent(configSchema(), "", "  ")
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if *probe != "" {
+		os.Exit(runProbe(*probe))
+	}
+
 	config, err := readConfig(*configFilePath)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
 
-	http.HandleFunc("/healthy", basicAuthMiddleware(config.Config.Auth, func(w http.ResponseWriter, r *http.Request) {
-		messages := []string{} // Local variable for this request
-		response := make(map[string]interface{})
-		if !checkPorts(config.Ports, &messages) || !checkServices(config.Services, &messages) || !checkEndpoints(config.Endpoints, &messages) {
-			w.WriteHeader(http.StatusInternalServerError)
-			response["status"] = "Server is unhealthy"
-		} else {
-			w.WriteHeader(http.StatusOK)
-			response["status"] = "Server is healthy"
+	if config.Config.Admin.StateFile != "" {
+		if err := dynamicChecks.load(config, config.Config.Admin.StateFile); err != nil {
+			log.Fatalf("error: %v", err)
 		}
-		response["messages"] = messages
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Printf("Failed to encode response: %v", err)
+	}
+
+	configureChecks(&config.Config)
+	if checkExporters, err = newExporters(config.Config); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	stopLeader := func() {}
+	if config.Config.Leader.Enabled {
+		stopLeader = startLeaderElection(config.Config.Leader)
+	}
+	if config.Config.Audit.Enabled {
+		if authAudit, err = newAuditLog(config.Config.Audit); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		defer authAudit.close()
+	}
+	signer, err := newResponseSigner(config.Config.Signing)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	live := newLiveConfig(config)
+	stopDiscovery := startDiscovery(live)
+	// Background checks run under checksCtx, which shutdown cancels.
+	checksCtx, stopChecks := context.WithCancel(context.Background())
+	defer stopChecks()
+	var cache *resultCache
+	if config.Config.Interval > 0 {
+		sched := schedule{interval: config.Config.Interval, jitter: config.Config.Jitter, spread: config.Config.Spread}
+		cache = startScheduler(checksCtx, func() []check { return currentChecks(live.current()) }, sched, func(cache *resultCache) {
+			writeHeartbeat(live.current(), cache)
+			healthEvents.publish(backgroundReport(live.current(), cache, 0))
+		})
+	}
+	if *watch && *configFilePath != "" {
+		err := watchConfig(*configFilePath, live, func(next *Config) {
+			if cache != nil && checksCtx.Err() == nil {
+				cache.set(runChecks(checksCtx, currentChecks(next)))
+			}
+		})
+		if err != nil {
+			log.Fatalf("error: watching config: %v", err)
 		}
-	}))
+	}
 
-	l := fmt.Sprintf("%s:%d", GetEnv("HEALTH_LISTEN_HOST", config.Config.Listen.Host), GetEnvInt("HEALTH_LISTEN_PORT", config.Config.Listen.Port))
+	health := profileAuthMiddleware(live, config.Config.Auth, config.Config.StatusCodes.Unauthorized, healthyHandler(live, cache, signer))
+	http.HandleFunc("/healthy", health)
+	http.HandleFunc("/healthy/{profile}", health)
+	http.HandleFunc(apiPrefix+"/health", health)
+	http.HandleFunc(apiPrefix+"/health/{profile}", health)
+	checks := profileAuthMiddleware(live, config.Config.Auth, config.Config.StatusCodes.Unauthorized, checksHandler(live, cache))
+	http.HandleFunc(apiPrefix+"/checks", checks)
+	http.HandleFunc(apiPrefix+"/checks/{profile}", checks)
+	// Anything else is a profile's <pathPrefix>/healthy or not found.
+	http.HandleFunc("/", health)
+	handle("/config", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, configHandler(live, *configFilePath)))
+	handle("/openapi.json", openAPIHandler)
+	handle("/config.schema.json", configSchemaHandler)
+	handle("/version", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, versionHandler))
+	handle("/changes", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, changesHandler(live)))
+	if cache != nil {
+		handle("/events", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, eventsHandler(live, cache)))
+		handle("/ws", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, liveFeedHandler(live, cache)))
+	}
+	if config.Config.Probe.Enabled {
+		handle("/probe", basicAuthMiddleware(config.Config.Auth, config.Config.StatusCodes.Unauthorized, probeHandler(live)))
+	}
+	if config.Config.Admin.Enabled {
+		registerAdminHandlers(live, cache)
+	}
+
+	listenPort := config.Config.Listen.Port
+	listenHost := config.Config.Listen.Host
+	if listenHost == "" && config.Config.Sidecar.Enabled {
+		// Probes read the status file, so nothing outside the pod needs the API.
+		listenHost = "127.0.0.1"
+	}
+	l := fmt.Sprintf("%s:%d", listenHost, listenPort)
 
 	server := &http.Server{
 		Addr:              l,
 		Handler:           nil,
 		ReadHeaderTimeout: 10 * time.Second,
 	}
+	server.RegisterOnShutdown(healthEvents.close)
 
-	// Start server in a goroutine
+	// Listen before serving in a goroutine, so systemd hears the daemon is
+	// ready only once it accepts connections.
+	log.Printf("Starting server on %s", l)
+	listener, err := net.Listen("tcp", l)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
 	go func() {
-		log.Printf("Starting server on %s", l)
 		var err error
 		if config.Config.SSL.Enabled {
-			err = server.ListenAndServeTLS(config.Config.SSL.CertFile, config.Config.SSL.KeyFile)
+			err = server.ServeTLS(listener, config.Config.SSL.CertFile, config.Config.SSL.KeyFile)
 		} else {
-			err = server.ListenAndServe()
+			err = server.Serve(listener)
 		}
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
+	stopConsul := func() {}
+	if config.Config.Consul.Enabled {
+		if stopConsul, err = startConsul(config, func() []check { return currentChecks(live.current()) }, listenPort); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	stopNRPE := func() {}
+	if config.Config.NRPE.Enabled {
+		if stopNRPE, err = startNRPE(live, cache); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	stopHAProxyAgent := func() {}
+	if config.Config.HAProxyAgent.Enabled {
+		if stopHAProxyAgent, err = startHAProxyAgent(live, cache); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	stopDNSResponder := func() {}
+	if config.Config.DNSResponder.Enabled {
+		if stopDNSResponder, err = startDNSResponder(live, cache); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+
+	stopSidecar := func() {}
+	if config.Config.Sidecar.Enabled {
+		stopSidecar = startSidecar(live, cache)
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	stopWatchdog := startWatchdog(live, cache)
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	shutdown := config.Config.Shutdown
+	if shutdown.PreStopDelay > 0 {
+		// Report unhealthy while still serving, so load balancers stop
+		// sending traffic before the listener closes. A second signal
+		// cuts the wait short.
+		log.Printf("Draining for %s before shutting down", shutdown.PreStopDelay)
+		adminOverrides.setDrain(drainState{Since: time.Now(), Reason: "shutting down"})
+		select {
+		case <-time.After(shutdown.PreStopDelay):
+		case <-quit:
+		}
+	}
 	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	stopWatchdog()
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	stopConsul()
+	stopNRPE()
+	stopHAProxyAgent()
+	stopDNSResponder()
+	stopSidecar()
+	stopDiscovery()
+	stopChecks()
+	if !flushExports(shutdown.timeout()) {
+		log.Printf("Gave up waiting for exports after %s", shutdown.timeout())
+	}
+	stopLeader()
+	if shutdown.Webhook != "" {
+		status := "unknown"
+		if cache != nil {
+			status = backgroundReport(live.current(), cache, 0).Status
+		}
+		if err := shutdown.notifyShutdown(status); err != nil {
+			log.Printf("Shutdown webhook: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown.timeout())
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
@@ -128,17 +633,26 @@ func main() {
 	log.Println("Server exited gracefully")
 }
 
-func basicAuthMiddleware(authConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Enabled  bool   `yaml:"enabled"`
-}, next http.HandlerFunc) http.HandlerFunc {
+func basicAuthMiddleware(authConfig AuthConfig, unauthorizedStatus int, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if authConfig.Enabled {
 			username, password, ok := r.BasicAuth()
 			userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(authConfig.Username)) == 1
 			passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(authConfig.Password)) == 1
+			switch {
+			case !ok:
+				authAudit.record(r, "", false, "no credentials")
+			case !userMatch || !passMatch:
+				authAudit.record(r, username, false, "invalid credentials")
+			default:
+				authAudit.record(r, username, true, "")
+			}
 			if !ok || !userMatch || !passMatch {
+				// Answering 404 hides that the endpoint exists at all.
+				if unauthorizedStatus == http.StatusNotFound {
+					http.NotFound(w, r)
+					return
+				}
 				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
@@ -148,145 +662,658 @@ func basicAuthMiddleware(authConfig struct {
 	}
 }
 
+// configureChecks applies the settings under config that every check
+// shares, such as concurrency limits, the resolver and HTTP clients.
+func configureChecks(c *AppConfig) {
+	checkLimits = newCheckLimiter(c.Concurrency)
+	checkResolver = c.DNS.resolver()
+	checkSource = c.Source
+	checkPrivileges = c.Privileges
+	checkSandbox = c.Sandbox
+	checkHTTP = c.HTTP
+	httpClient = newHTTPClient(checkHTTP, nil)
+	httpsClient = newHTTPClient(checkHTTP, &tls.Config{InsecureSkipVerify: true})
+	checkHeaders = requestHeaders(c.UserAgent, c.Headers)
+}
+
+// readConfig reads filename, then applies the environment and -set flags
+// over it. An empty filename reads no file, for deployments configured by
+// the environment alone.
 func readConfig(filename string) (*Config, error) {
+	if filename == "" {
+		return parseConfig(nil)
+	}
 	data, err := os.ReadFile(filename) // #nosec G304 -- filename is from command-line flag, not user input
 	if err != nil {
 		return nil, err
 	}
+	return parseConfig(data)
+}
 
+// parseConfig parses a config file's contents, nil for none, as readConfig
+// does.
+func parseConfig(data []byte) (*Config, error) {
 	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	var lines map[string]int
+	if data != nil {
+		lines = yamlLines(data)
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		errs, err := validateConfigSchema(doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("invalid config: %w", errs.withLines(lines))
+		}
+		// Strict decoding reports misspelt and duplicated keys, with their
+		// line numbers, rather than silently dropping the checks under them.
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if err := applyConfigOverrides(&config); err != nil {
+		return nil, err
+	}
+
+	if err := resolveVaultSecrets(&config); err != nil {
 		return nil, err
 	}
 
 	if err := config.Validate(); err != nil {
+		var errs configErrors
+		if errors.As(err, &errs) {
+			err = errs.withLines(lines)
+		}
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
 	return &config, nil
 }
 
+// Validate checks the whole config, returning every problem found as
+// configErrors, each with the path of the setting it is about when known.
 func (c *Config) Validate() error {
+	var errs configErrors
 	if c.Config.Listen.Port < 1 || c.Config.Listen.Port > 65535 {
-		return fmt.Errorf("invalid listen port: %d", c.Config.Listen.Port)
+		errs.add("config.listen.port", fmt.Errorf("invalid listen port: %d", c.Config.Listen.Port))
 	}
-	for _, port := range c.Ports {
-		if port.Port < 1 || port.Port > 65535 {
-			return fmt.Errorf("invalid port: %d for %s", port.Port, port.Name)
+	errs.add("config.statusCodes", c.Config.StatusCodes.Validate())
+	errs.add("config.audit", c.Config.Audit.Validate())
+	if c.Config.RequestTimeout < 0 {
+		errs.add("config.requestTimeout", fmt.Errorf("requestTimeout must not be negative"))
+	}
+	if c.Config.Interval < 0 || c.Config.MaxAge < 0 {
+		errs.add("config.interval", fmt.Errorf("interval and maxAge must not be negative"))
+	}
+	if c.Config.MaxAge > 0 && c.Config.Interval == 0 {
+		errs.add("config.maxAge", fmt.Errorf("maxAge requires interval"))
+	}
+	if c.Config.Jitter < 0 {
+		errs.add("config.jitter", fmt.Errorf("jitter must not be negative"))
+	}
+	if (c.Config.Jitter > 0 || c.Config.Spread) && c.Config.Interval == 0 {
+		errs.add("config.jitter", fmt.Errorf("jitter and spread require interval"))
+	}
+	for _, check := range append(c.checks(), c.discoveryTemplates()...) {
+		switch {
+		case check.Options.Interval < 0:
+			errs.add("", fmt.Errorf("%s check %s: interval must not be negative", check.Type, check.Name))
+		case check.Options.Interval > 0 && c.Config.Interval == 0:
+			errs.add("", fmt.Errorf("%s check %s: interval requires config.interval", check.Type, check.Name))
+		}
+		if check.Options.RiseCount < 0 || check.Options.FallCount < 0 {
+			errs.add("", fmt.Errorf("%s check %s: riseCount and fallCount must not be negative", check.Type, check.Name))
+		}
+		if check.Options.Schedule != "" {
+			if c.Config.Interval == 0 {
+				errs.add("", fmt.Errorf("%s check %s: schedule requires config.interval", check.Type, check.Name))
+			}
+			if check.Options.Interval > 0 {
+				errs.add("", fmt.Errorf("%s check %s: interval and schedule cannot both be set", check.Type, check.Name))
+			}
+			if _, err := parseCron(check.Options.Schedule); err != nil {
+				errs.add("", fmt.Errorf("%s check %s: %w", check.Type, check.Name, err))
+			}
+		}
+		for _, w := range check.Options.Windows {
+			if _, err := parseWindow(w); err != nil {
+				errs.add("", fmt.Errorf("%s check %s: %w", check.Type, check.Name, err))
+			}
+		}
+		if err := validateLabels(check.Options.Labels); err != nil {
+			errs.add("", fmt.Errorf("%s check %s: %w", check.Type, check.Name, err))
+		}
+		if runbook := check.Options.Runbook; runbook != "" {
+			if u, err := url.Parse(runbook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs.add("", fmt.Errorf("%s check %s: runbook must be an http:// or https:// URL", check.Type, check.Name))
+			}
+		}
+		if check.Options.OnFailure != nil {
+			if err := check.Options.OnFailure.Validate(); err != nil {
+				errs.add("", fmt.Errorf("%s check %s: %w", check.Type, check.Name, err))
+			}
+		}
+		if check.Options.OnlyIf != nil {
+			if err := check.Options.OnlyIf.Validate(); err != nil {
+				errs.add("", fmt.Errorf("%s check %s: %w", check.Type, check.Name, err))
+			}
 		}
 	}
-	for _, endpoint := range c.Endpoints {
-		if _, err := url.Parse(endpoint.URL); err != nil {
-			return fmt.Errorf("invalid URL %s: %w", endpoint.URL, err)
+	if c.Config.Admin.Enabled && !c.Config.Auth.Enabled {
+		errs.add("config.admin.enabled", fmt.Errorf("admin requires auth to be enabled"))
+	}
+	if c.Config.Admin.Faults && !c.Config.Admin.Enabled {
+		errs.add("config.admin.faults", fmt.Errorf("faults requires admin to be enabled"))
+	}
+	errs.add("config.concurrency", c.Config.Concurrency.Validate())
+	errs.add("config.dns", c.Config.DNS.Validate())
+	if err := c.Config.HTTP.Validate(); err != nil {
+		errs.add("config.http", fmt.Errorf("http: %w", err))
+	}
+	errs.add("config.consul", c.Config.Consul.Validate())
+	errs.add("config.statsd", c.Config.StatsD.Validate())
+	errs.add("config.influxdb", c.Config.InfluxDB.Validate())
+	errs.add("config.cloudwatch", c.Config.CloudWatch.Validate())
+	errs.add("config.datadog", c.Config.Datadog.Validate())
+	errs.add("config.webhook", c.Config.Webhook.Validate())
+	errs.add("config.nrpe", c.Config.NRPE.Validate())
+	errs.add("config.haproxyAgent", c.Config.HAProxyAgent.Validate())
+	errs.add("config.dnsResponder", c.Config.DNSResponder.Validate(c.Config.Interval))
+	errs.add("config.zabbix", c.Config.Zabbix.Validate())
+	errs.add("config.snmp", c.Config.SNMP.Validate())
+	errs.add("config.signing", c.Config.Signing.Validate())
+	errs.add("config.caching", c.Config.Caching.Validate())
+	errs.add("config.privileges", c.Config.Privileges.Validate())
+	errs.add("config.sandbox", c.Config.Sandbox.Validate(c.Config.Privileges))
+	errs.add("config.sidecar", c.Config.Sidecar.Validate())
+	errs.add("config.probe", c.Config.Probe.Validate())
+	errs.add("config.heartbeat", c.Config.Heartbeat.Validate(c.Config.Interval))
+	errs.add("config.leader", c.Config.Leader.Validate())
+	errs.add("config.shutdown", c.Config.Shutdown.Validate())
+	for i, port := range c.Ports {
+		port.validate(&errs, fmt.Sprintf("ports.%d", i))
+	}
+	for i, endpoint := range c.Endpoints {
+		errs.add(fmt.Sprintf("endpoints.%d", i), endpoint.Validate())
+	}
+	discoveries := map[string]bool{}
+	for i, d := range c.Discovery {
+		path := fmt.Sprintf("discovery.%d", i)
+		errs.add(path, d.Validate())
+		if d.Port != nil {
+			port := d.port(placeholderInstance)
+			port.Name = d.Name
+			port.validate(&errs, path+".port")
 		}
+		if discoveries[d.Name] {
+			errs.add(path+".name", fmt.Errorf("discovery %s is defined more than once", d.Name))
+		}
+		discoveries[d.Name] = true
 	}
-	return nil
+	for i, check := range c.Kubernetes {
+		errs.add(fmt.Sprintf("kubernetes.%d", i), check.Validate())
+	}
+	for i, check := range c.Journald {
+		errs.add(fmt.Sprintf("journald.%d", i), check.Validate())
+	}
+	for i, check := range c.Logs {
+		errs.add(fmt.Sprintf("logs.%d", i), check.Validate())
+	}
+	for i, check := range c.Interfaces {
+		errs.add(fmt.Sprintf("interfaces.%d", i), check.Validate())
+	}
+	for i, check := range c.Gateways {
+		errs.add(fmt.Sprintf("gateways.%d", i), check.Validate())
+	}
+	for i, check := range c.Firewall {
+		errs.add(fmt.Sprintf("firewall.%d", i), check.Validate())
+	}
+	for i, check := range c.SecurityModules {
+		errs.add(fmt.Sprintf("securityModules.%d", i), check.Validate())
+	}
+	for i, check := range c.Sysctl {
+		errs.add(fmt.Sprintf("sysctl.%d", i), check.Validate())
+	}
+	for i, check := range c.Packages {
+		errs.add(fmt.Sprintf("packages.%d", i), check.Validate())
+	}
+	for i, check := range c.RebootRequired {
+		errs.add(fmt.Sprintf("rebootRequired.%d", i), check.Validate())
+	}
+	for i, check := range c.Temperatures {
+		errs.add(fmt.Sprintf("temperatures.%d", i), check.Validate())
+	}
+	for i, check := range c.GPUs {
+		errs.add(fmt.Sprintf("gpus.%d", i), check.Validate())
+	}
+	for i, check := range c.ZFS {
+		errs.add(fmt.Sprintf("zfs.%d", i), check.Validate())
+	}
+	for i, check := range c.UPS {
+		errs.add(fmt.Sprintf("ups.%d", i), check.Validate())
+	}
+	for i, check := range c.SMTP {
+		errs.add(fmt.Sprintf("smtp.%d", i), check.Validate())
+	}
+	for i, check := range c.LDAP {
+		errs.add(fmt.Sprintf("ldap.%d", i), check.Validate())
+	}
+	for i, check := range c.Kafka {
+		errs.add(fmt.Sprintf("kafka.%d", i), check.Validate())
+	}
+	for i, check := range c.AMQP {
+		errs.add(fmt.Sprintf("amqp.%d", i), check.Validate())
+	}
+	for i, check := range c.Elasticsearch {
+		errs.add(fmt.Sprintf("elasticsearch.%d", i), check.Validate())
+	}
+	for i, check := range c.Etcd {
+		errs.add(fmt.Sprintf("etcd.%d", i), check.Validate())
+	}
+	for i, check := range c.Vault {
+		errs.add(fmt.Sprintf("vault.%d", i), check.Validate())
+	}
+	for i, check := range c.MQTT {
+		errs.add(fmt.Sprintf("mqtt.%d", i), check.Validate())
+	}
+	for i, check := range c.GRPC {
+		errs.add(fmt.Sprintf("grpc.%d", i), check.Validate())
+	}
+	for i, check := range c.WebSockets {
+		errs.add(fmt.Sprintf("websockets.%d", i), check.Validate())
+	}
+	for i, check := range c.SSH {
+		errs.add(fmt.Sprintf("ssh.%d", i), check.Validate())
+	}
+	for i, check := range c.FTP {
+		errs.add(fmt.Sprintf("ftp.%d", i), check.Validate())
+	}
+	for i, check := range c.Shares {
+		errs.add(fmt.Sprintf("shares.%d", i), check.Validate())
+	}
+	for i, check := range c.S3 {
+		errs.add(fmt.Sprintf("s3.%d", i), check.Validate())
+	}
+	for i, check := range c.Disks {
+		errs.add(fmt.Sprintf("disks.%d", i), check.Validate())
+	}
+	for i, check := range c.Cgroups {
+		errs.add(fmt.Sprintf("cgroups.%d", i), check.Validate())
+	}
+	for i, check := range c.PerfCounters {
+		errs.add(fmt.Sprintf("perfCounters.%d", i), check.Validate())
+	}
+	for i, check := range c.EventLogs {
+		errs.add(fmt.Sprintf("eventLogs.%d", i), check.Validate())
+	}
+	for i, check := range c.Processes {
+		errs.add(fmt.Sprintf("processes.%d", i), check.Validate())
+	}
+	for i, check := range c.Transactions {
+		errs.add(fmt.Sprintf("transactions.%d", i), check.Validate())
+	}
+	for i, check := range c.Throughput {
+		errs.add(fmt.Sprintf("throughput.%d", i), check.Validate())
+	}
+	for i, check := range c.Sockets {
+		errs.add(fmt.Sprintf("sockets.%d", i), check.Validate())
+	}
+	for i, check := range c.Entropy {
+		errs.add(fmt.Sprintf("entropy.%d", i), check.Validate())
+	}
+	for i, check := range c.FileDescriptors {
+		errs.add(fmt.Sprintf("fileDescriptors.%d", i), check.Validate())
+	}
+	for i, check := range c.Runaways {
+		errs.add(fmt.Sprintf("runaways.%d", i), check.Validate())
+	}
+	for i, check := range c.CronJobs {
+		errs.add(fmt.Sprintf("cronJobs.%d", i), check.Validate())
+	}
+	for i, check := range c.Backups {
+		errs.add(fmt.Sprintf("backups.%d", i), check.Validate())
+	}
+	for i, check := range c.PHPFPM {
+		errs.add(fmt.Sprintf("phpfpm.%d", i), check.Validate())
+	}
+	for i, check := range c.Upstreams {
+		errs.add(fmt.Sprintf("upstreams.%d", i), check.Validate())
+	}
+	// Results, overrides and composites find checks by type and name, so a
+	// second check of the same name would be hidden behind the first.
+	names := map[string]bool{}
+	for _, chk := range c.checks() {
+		key := overrideKey(chk.Type, chk.Name)
+		if names[key] {
+			errs.add("", fmt.Errorf("duplicate %s check %q", chk.Type, chk.Name))
+		}
+		names[key] = true
+	}
+	composites := map[string]bool{}
+	for i, composite := range c.Composites {
+		if composites[composite.Name] {
+			errs.add(fmt.Sprintf("composites.%d.name", i), fmt.Errorf("duplicate composite check %q", composite.Name))
+		}
+		composites[composite.Name] = true
+		errs.add(fmt.Sprintf("composites.%d", i), composite.Validate(c.checks()))
+	}
+	errs.add("config.vantage", c.Config.Vantage.Validate(c.checks()))
+	errs.add("config.rollup", c.Config.Rollup.Validate(c.checks()))
+	errs.add("config.score", c.Config.Score.Validate(&c.Config.Rollup))
+	errs.add("config.messages", c.Config.Messages.Validate(c.checks()))
+	for name, output := range c.Config.Outputs {
+		errs.add("config.outputTemplates."+name, output.Validate(name))
+	}
+	profiles, hosts, prefixes := map[string]bool{}, map[string]string{}, map[string]string{}
+	for i, profile := range c.Profiles {
+		path := fmt.Sprintf("profiles.%d", i)
+		errs.add(path, profile.Validate(c.checks(), c.Composites))
+		if profiles[profile.Name] {
+			errs.add(path+".name", fmt.Errorf("profile %s is defined more than once", profile.Name))
+		}
+		profiles[profile.Name] = true
+		for j, h := range profile.Hosts {
+			if other, ok := hosts[strings.ToLower(h)]; ok {
+				errs.add(fmt.Sprintf("%s.hosts.%d", path, j), fmt.Errorf("profile %s: host %s is also in profile %s", profile.Name, h, other))
+			}
+			hosts[strings.ToLower(h)] = profile.Name
+		}
+		if other, ok := prefixes[profile.PathPrefix]; ok && profile.PathPrefix != "" {
+			errs.add(path+".pathPrefix", fmt.Errorf("profile %s: pathPrefix %s is also in profile %s", profile.Name, profile.PathPrefix, other))
+		}
+		prefixes[profile.PathPrefix] = profile.Name
+	}
+	return errs.err()
 }
 
-var serviceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9@:._-]+$`)
+var (
+	serviceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9@:._-]+$`)
+	sha256Regex      = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+)
 
-var httpClient = &http.Client{
-	Timeout: 10 * time.Second,
+func (service Service) run(ctx context.Context) checkResult {
+	if !serviceNameRegex.MatchString(service.Name) {
+		return checkFailed("Service Name: %s is invalid", service.Name)
+	}
+	if runtime.GOOS == "windows" {
+		state, err := windowsServiceState(service.Name)
+		switch {
+		case err != nil:
+			return checkFailed("Service Name: %s, Expected Status: %s, Error: %v", service.Name, service.Status, err)
+		case state != service.Status:
+			return checkFailed("Service Name: %s, Expected Status: %s, Actual Status: %s", service.Name, service.Status, state)
+		}
+		return checkOK("Service Name: %s, Status: %s is as expected", service.Name, service.Status)
+	}
+	active, sub, err := unitState(ctx, service.Name)
+	switch {
+	case errors.Is(err, errNoSystemBus):
+		// Without a bus, as in some containers, ask systemctl instead.
+	case err != nil:
+		return checkFailed("Service Name: %s, Expected Status: %s, Error: %v", service.Name, service.Status, err)
+	case active != service.Status:
+		result := checkFailed("Service Name: %s, Expected Status: %s, Actual Status: %s (%s)", service.Name, service.Status, active, sub)
+		result.Reason = reasonStatusMismatch
+		return result
+	default:
+		return checkOK("Service Name: %s, Status: %s (%s) is as expected", service.Name, service.Status, sub)
+	}
+	output, err := commandOutput(ctx, "systemctl", "is-active", service.Name)
+	status := strings.TrimSpace(string(output))
+	if err != nil || status != service.Status {
+		return checkFailed("Service Name: %s, Expected Status: %s, Actual Status: %s", service.Name, service.Status, status)
+	}
+	return checkOK("Service Name: %s, Status: %s is as expected", service.Name, service.Status)
 }
 
-var httpsClient = &http.Client{
-	Timeout: 10 * time.Second,
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	},
+func (port Port) run(ctx context.Context) checkResult {
+	if port.Mode == "listening" {
+		return port.runListening(ctx)
+	}
+	if path, ok := unixSocket(port.Address); ok {
+		return port.runUnix(ctx, path)
+	}
+	ctx = withDial(ctx, port.Dial)
+	address := net.JoinHostPort(port.Address, strconv.Itoa(port.Port))
+	var conn net.Conn
+	var err error
+	start := time.Now()
+	if port.Proxy != "" {
+		proxy, _ := parseSOCKS5URL(port.Proxy) // checked by Validate
+		conn, err = dialSOCKS5(ctx, proxy, address, 5*time.Second)
+	} else {
+		conn, err = dialCheck(ctx, "tcp", address, 1*time.Second, nil)
+	}
+	if err != nil {
+		return checkFailed("Port Name: %s, Port: %d is not available", port.Name, port.Port).because(err)
+	}
+	elapsed := time.Since(start)
+	if err := conn.Close(); err != nil {
+		log.Printf("Failed to close connection: %v", err)
+	}
+	result := checkOK("Port Name: %s, Port: %d is available", port.Name, port.Port)
+	return port.Thresholds.apply(result, milliseconds(elapsed), "Connect Time: "+elapsed.Round(time.Millisecond).String())
 }
 
-func checkServices(services []Service, messages *[]string) bool {
-	var errCount int
-	for _, service := range services {
-		if !serviceNameRegex.MatchString(service.Name) {
-			addToOutputMessages(messages, "Service Name: %s is invalid", service.Name)
-			errCount++
-			continue
-		}
-		cmd := exec.Command("systemctl", "is-active", service.Name) // #nosec G204 -- service.Name is validated by regex
-		output, err := cmd.Output()
-		status := strings.TrimSpace(string(output))
-		if err != nil || status != service.Status {
-			addToOutputMessages(messages, "Service Name: %s, Expected Status: %s, Actual Status: %s", service.Name, service.Status, status)
-			errCount++
-		} else {
-			addToOutputMessages(messages, "Service Name: %s, Status: %s is as expected", service.Name, service.Status)
+func (endpoint Endpoint) run(ctx context.Context) checkResult {
+	ctx = withDial(ctx, endpoint.Dial)
+	client, own := endpoint.client(ctx)
+	if own {
+		defer client.CloseIdleConnections()
+	}
+	method := endpoint.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.URL, nil)
+	if err != nil {
+		return checkFailed("Endpoint Name: %s, URL: %s is invalid: %v", endpoint.Name, endpoint.URL, err)
+	}
+	req.Header = requestHeaders(endpoint.UserAgent, endpoint.RequestHeaders)
+	if endpoint.Host != "" {
+		req.Host = endpoint.Host
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkFailed("Endpoint Name: %s, URL: %s is not reachable", endpoint.Name, endpoint.URL).because(err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body: %v", err)
+		}
+	}()
+
+	statuses := append(endpoint.Statuses, endpoint.Status)
+	if !contains(statuses, resp.StatusCode) {
+		result := checkFailed("Endpoint Name: %s, URL: %s, Status: %d is not as expected, got: %d", endpoint.Name, endpoint.URL, endpoint.Status, resp.StatusCode)
+		result.Reason = reasonStatusMismatch
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			result.Reason = reasonAuth
 		}
+		return result
+	}
+	for _, h := range endpoint.Headers {
+		if problem := h.check(resp.Header); problem != "" {
+			return checkFailed("Endpoint Name: %s, URL: %s, Header: %s %s", endpoint.Name, endpoint.URL, h.Name, problem)
+		}
+	}
+	certStatus, certificate := statusOK, ""
+	if endpoint.Certificate != nil {
+		certStatus, certificate = endpoint.Certificate.check(ctx, resp.TLS, endpoint.tlsServerName())
+		if certStatus == statusCritical {
+			result := checkFailed("Endpoint Name: %s, URL: %s, Certificate: %s", endpoint.Name, endpoint.URL, certificate)
+			result.Reason = reasonTLS
+			return result
+		}
+	}
+	if endpoint.MinBodyBytes > 0 || endpoint.MaxBodyBytes > 0 || endpoint.SHA256 != "" {
+		if problem := endpoint.checkBody(resp); problem != "" {
+			return checkFailed("Endpoint Name: %s, URL: %s, Body: %s", endpoint.Name, endpoint.URL, problem)
+		}
+	}
+	// The body is closed unread by default, which stops the transfer. Reading
+	// it to the end instead lets the connection be reused and fails the
+	// check on a truncated transfer.
+	if endpoint.DiscardBody != nil && !*endpoint.DiscardBody {
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			return checkFailed("Endpoint Name: %s, URL: %s, Body: could not be read: %v", endpoint.Name, endpoint.URL, err)
+		}
+	}
+	elapsed := time.Since(start)
+	result := checkOK("Endpoint Name: %s, URL: %s, Status: %d is as expected", endpoint.Name, endpoint.URL, resp.StatusCode)
+	if certificate != "" {
+		result.Status = certStatus
+		result.Message += ", Certificate: " + certificate
 	}
-	return errCount == 0
+	result = endpoint.Thresholds.apply(result, milliseconds(elapsed), "Response Time: "+elapsed.Round(time.Millisecond).String())
+	return endpoint.checkLatency(result, elapsed)
 }
 
-func checkPorts(ports []Port, messages *[]string) bool {
-	var errCount int
-	for _, port := range ports {
-		address := net.JoinHostPort(port.Address, strconv.Itoa(port.Port))
-		conn, err := net.DialTimeout("tcp", address, 1*time.Second)
-		if err != nil {
-			addToOutputMessages(messages, "Port Name: %s, Port: %d is not available", port.Name, port.Port)
-			errCount++
-		} else {
-			addToOutputMessages(messages, "Port Name: %s, Port: %d is available", port.Name, port.Port)
-			if err := conn.Close(); err != nil {
-				log.Printf("Failed to close connection: %v", err)
-			}
+// checkBody describes how the response body falls outside MinBodyBytes and
+// MaxBodyBytes or fails to match SHA256, or returns an empty string. No more
+// of the body is read than needed to decide, and a Content-Length over the
+// maximum fails without reading it at all.
+func (endpoint Endpoint) checkBody(resp *http.Response) string {
+	maxBytes := endpoint.MaxBodyBytes
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return fmt.Sprintf("Content-Length %d exceeds maximum %d bytes", resp.ContentLength, maxBytes)
+	}
+	body := io.Reader(resp.Body)
+	switch {
+	case maxBytes > 0:
+		body = io.LimitReader(body, maxBytes+1)
+	case endpoint.SHA256 == "":
+		body = io.LimitReader(body, endpoint.MinBodyBytes)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, body)
+	sum := hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case err != nil:
+		return fmt.Sprintf("could not be read: %v", err)
+	case maxBytes > 0 && n > maxBytes:
+		return fmt.Sprintf("exceeds maximum %d bytes", maxBytes)
+	case n < endpoint.MinBodyBytes:
+		return fmt.Sprintf("%d bytes is below minimum %d bytes", n, endpoint.MinBodyBytes)
+	case endpoint.SHA256 != "" && !strings.EqualFold(sum, endpoint.SHA256):
+		return fmt.Sprintf("SHA-256 %s does not match %s", sum, endpoint.SHA256)
+	}
+	return ""
+}
+
+// check describes how header fails the assertion, or returns an empty
+// string. Any one of a repeated header's values may match.
+func (h HeaderAssertion) check(header http.Header) string {
+	values := header.Values(h.Name)
+	if len(values) == 0 {
+		return "is missing"
+	}
+	if h.Value == "" && h.Regex == "" {
+		return ""
+	}
+	pattern := regexp.MustCompile(h.Regex) // checked by Validate
+	for _, v := range values {
+		if (h.Regex == "" && v == h.Value) || (h.Regex != "" && pattern.MatchString(v)) {
+			return ""
 		}
 	}
-	return errCount == 0
+	if h.Regex != "" {
+		return fmt.Sprintf("%q does not match %q", values[0], h.Regex)
+	}
+	return fmt.Sprintf("%q is not %q", values[0], h.Value)
 }
 
-func checkEndpoints(endpoints []Endpoint, messages *[]string) bool {
-	var errCount int
-	for _, endpoint := range endpoints {
-		var resp *http.Response
-		var err error
+// client returns the client for the endpoint and whether it is its own.
+// Endpoints share the client for their URL's scheme unless they tune the
+// transport, override hosts, set the TLS server name, or use a proxy,
+// socket or dial settings, which need a client of their own. With
+// HostsOverride the client connects to those hosts at their given IP
+// addresses, keeping the host name for the Host header and TLS; its own
+// transport means those connections are never reused by checks expecting
+// the real address.
+func (endpoint Endpoint) client(ctx context.Context) (*http.Client, bool) {
+	https := strings.HasPrefix(endpoint.URL, "https://")
+	serverName := endpoint.serverName()
+	if len(endpoint.HostsOverride) == 0 && endpoint.HTTP == (HTTPConfig{}) && endpoint.Proxy == "" && endpoint.Socket == "" && endpoint.Dial == (DialConfig{}) && (!https || serverName == "") {
+		return checkClient(ctx, https), false
+	}
+	var tlsConfig *tls.Config
+	if https {
+		// With no server name set, the transport takes it from the URL.
+		tlsConfig = &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+	}
 
-		if strings.HasPrefix(endpoint.URL, "https://") {
-			resp, err = httpsClient.Get(endpoint.URL)
-		} else {
-			resp, err = httpClient.Get(endpoint.URL)
+	client := newHTTPClient(checkHTTP.merge(endpoint.HTTP), tlsConfig)
+	transport := client.Transport.(*http.Transport)
+	if endpoint.Proxy != "" {
+		proxy, _ := parseSOCKS5URL(endpoint.Proxy) // checked by Validate
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dialSOCKS5(ctx, proxy, address, 30*time.Second)
 		}
-
-		if err != nil {
-			addToOutputMessages(messages, "Endpoint Name: %s, URL: %s is not reachable", endpoint.Name, endpoint.URL)
-			errCount++
-			continue
+	}
+	if path, ok := unixSocket(endpoint.Socket); ok {
+		// Every request goes to the socket, whatever the URL's host, which
+		// is kept for the Host header and TLS.
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialUnix(ctx, path, 30*time.Second)
 		}
-
-		statuses := append(endpoint.Statuses, endpoint.Status)
-		if contains(statuses, resp.StatusCode) {
-			addToOutputMessages(messages, "Endpoint Name: %s, URL: %s, Status: %d is as expected", endpoint.Name, endpoint.URL, resp.StatusCode)
-		} else {
-			addToOutputMessages(messages, "Endpoint Name: %s, URL: %s, Status: %d is not as expected, got: %d", endpoint.Name, endpoint.URL, endpoint.Status, resp.StatusCode)
-			errCount++
+	}
+	if len(endpoint.HostsOverride) > 0 {
+		// An HTTP proxy would resolve the name itself, defeating the override.
+		transport.Proxy = nil
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if host, port, err := net.SplitHostPort(address); err == nil {
+				if ip, ok := endpoint.HostsOverride[host]; ok {
+					address = net.JoinHostPort(ip, port)
+				}
+			}
+			return dial(ctx, network, address)
 		}
+	}
+	return client, true
+}
 
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Failed to close response body: %v", err)
-		}
+// serverName is the TLS server name set by ServerName or Host, or empty
+// when it comes from the URL.
+func (endpoint Endpoint) serverName() string {
+	if endpoint.ServerName != "" || endpoint.Host == "" {
+		return endpoint.ServerName
+	}
+	if host, _, err := net.SplitHostPort(endpoint.Host); err == nil {
+		return host
 	}
-	return errCount == 0
+	return endpoint.Host
 }
 
-func addToOutputMessages(messages *[]string, format string, a ...interface{}) {
-	message := fmt.Sprintf(format, a...)
-	*messages = append(*messages, message)
+// tlsServerName is the name the endpoint's certificate must be valid for.
+func (endpoint Endpoint) tlsServerName() string {
+	if name := endpoint.serverName(); name != "" {
+		return name
+	}
+	if u, err := url.Parse(endpoint.URL); err == nil {
+		return u.Hostname()
+	}
+	return ""
 }
 
-func GetEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+// closeAndLog closes c, logging rather than returning any error.
+func closeAndLog(c io.Closer, what string) {
+	if err := c.Close(); err != nil {
+		log.Printf("Failed to close %s: %v", what, err)
 	}
-	return fallback
 }
 
-func GetEnvInt(key string, fallback int) int {
+func GetEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
-		if i, err := strconv.Atoi(value); err == nil {
-			return i
-		}
-		log.Fatalf("Invalid value for %s: %s", key, value)
+		return value
 	}
 	return fallback
 }
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// MessagesConfig replaces the messages /healthy reports with Go templates,
+// for downstream parsers that need their own phrasing or language. Check
+// renders every check's message, and Checks the messages of single checks,
+// keyed as type/name or by name alone; both see the check's Type, Name,
+// Status, Duration and Message, the built-in text. Healthy, Degraded and
+// Unhealthy replace the overall status, and see Status, the worst check
+// status, and the number of Checks, Warnings and Critical. Categories add
+// a summary message of each category, keyed by check type as the rollup
+// groups them, which sees the same of the category's checks and its
+// Category. Locales holds
+// the same per language, chosen by ?lang= or Accept-Language, with
+// anything a locale leaves out taken from the top level.
+type MessagesConfig struct {
+	MessageTemplates `yaml:",inline"`
+	Locales          map[string]MessageTemplates `yaml:"locales"`
+}
+
+// MessageTemplates are the templates of one language.
+type MessageTemplates struct {
+	Healthy   string            `yaml:"healthy"`
+	Degraded  string            `yaml:"degraded"`
+	Unhealthy string            `yaml:"unhealthy"`
+	Check     string            `yaml:"check"`
+	Checks    map[string]string `yaml:"checks"`
+
+	Categories map[string]string `yaml:"categories"`
+}
+
+// checkMessageData is what check message templates see.
+type checkMessageData struct {
+	Type     string
+	Name     string
+	Status   string
+	Message  string
+	Duration time.Duration
+}
+
+// statusMessageData is what status templates see.
+type statusMessageData struct {
+	Status   string
+	Checks   int
+	Warnings int
+	Critical int
+}
+
+// categoryMessageData is what category templates see.
+type categoryMessageData struct {
+	Category string
+	statusMessageData
+}
+
+// Validate checks that every template parses and renders, and that
+// Checks names configured checks.
+func (c *MessagesConfig) Validate(checks []check) error {
+	categories := map[string]bool{"composite": true}
+	for _, chk := range checks {
+		categories[chk.Type] = true
+	}
+	sets := map[string]MessageTemplates{"": c.MessageTemplates}
+	for lang, set := range c.Locales {
+		if lang == "" {
+			return fmt.Errorf("messages: locale names must not be empty")
+		}
+		sets[lang] = set
+	}
+	for lang, set := range sets {
+		where := "messages"
+		if lang != "" {
+			where = "messages: locale " + lang
+		}
+		for name, text := range map[string]string{"healthy": set.Healthy, "degraded": set.Degraded, "unhealthy": set.Unhealthy} {
+			if _, err := renderTemplate(text, statusMessageData{}); err != nil {
+				return fmt.Errorf("%s: %s: %w", where, name, err)
+			}
+		}
+		if _, err := renderTemplate(set.Check, checkMessageData{}); err != nil {
+			return fmt.Errorf("%s: check: %w", where, err)
+		}
+		for category, text := range set.Categories {
+			if !categories[category] {
+				return fmt.Errorf("%s: categories: no checks are of type %s", where, category)
+			}
+			if _, err := renderTemplate(text, categoryMessageData{}); err != nil {
+				return fmt.Errorf("%s: categories: %s: %w", where, category, err)
+			}
+		}
+		for ref, text := range set.Checks {
+			if _, err := resolveCheckRefs([]string{ref}, checks); err != nil {
+				return fmt.Errorf("%s: checks: %w", where, err)
+			}
+			if _, err := renderTemplate(text, checkMessageData{}); err != nil {
+				return fmt.Errorf("%s: checks: %s: %w", where, ref, err)
+			}
+		}
+	}
+	return nil
+}
+
+// templates returns the templates for the best of langs, most preferred
+// first, filled in from the top level.
+func (c *MessagesConfig) templates(langs []string) MessageTemplates {
+	set := c.MessageTemplates
+	for _, lang := range langs {
+		locale, ok := c.locale(lang)
+		if !ok {
+			base, _, _ := strings.Cut(lang, "-")
+			if locale, ok = c.locale(base); !ok {
+				continue
+			}
+		}
+		if locale.Healthy != "" {
+			set.Healthy = locale.Healthy
+		}
+		if locale.Degraded != "" {
+			set.Degraded = locale.Degraded
+		}
+		if locale.Unhealthy != "" {
+			set.Unhealthy = locale.Unhealthy
+		}
+		if locale.Check != "" {
+			set.Check = locale.Check
+		}
+		checks := map[string]string{}
+		for ref, text := range set.Checks {
+			checks[ref] = text
+		}
+		for ref, text := range locale.Checks {
+			checks[ref] = text
+		}
+		set.Checks = checks
+		categories := map[string]string{}
+		for category, text := range set.Categories {
+			categories[category] = text
+		}
+		for category, text := range locale.Categories {
+			categories[category] = text
+		}
+		set.Categories = categories
+		break
+	}
+	return set
+}
+
+// locale returns the templates of lang, whatever its case.
+func (c *MessagesConfig) locale(lang string) (MessageTemplates, bool) {
+	for name, set := range c.Locales {
+		if strings.EqualFold(name, lang) {
+			return set, true
+		}
+	}
+	return MessageTemplates{}, false
+}
+
+// apply rewrites the messages of results, keeping the built-in message of any
+// that fails to render.
+func (t MessageTemplates) apply(results []checkResult) {
+	for i, r := range results {
+		text, ok := t.Checks[overrideKey(r.Type, r.Name)]
+		if !ok {
+			text, ok = t.Checks[r.Name]
+		}
+		if !ok {
+			text = t.Check
+		}
+		if text == "" {
+			continue
+		}
+		message, err := renderTemplate(text, checkMessageData{Type: r.Type, Name: r.Name, Status: r.Status.String(), Message: r.Message, Duration: r.Duration})
+		if err != nil {
+			log.Printf("messages: %s check %s: %v", r.Type, r.Name, err)
+			continue
+		}
+		results[i].Message = message
+	}
+}
+
+// status returns the overall status message for results in state, one of
+// healthy, degraded and unhealthy, or fallback when there is no template
+// for it or it fails to render.
+func (t MessageTemplates) status(state string, results []checkResult, fallback string) string {
+	text := t.Healthy
+	switch state {
+	case "degraded":
+		text = t.Degraded
+	case "unhealthy":
+		text = t.Unhealthy
+	}
+	if text == "" {
+		return fallback
+	}
+	message, err := renderTemplate(text, summarize(results))
+	if err != nil {
+		log.Printf("messages: status: %v", err)
+		return fallback
+	}
+	return message
+}
+
+// summarize adds the summary message of each category with a template and
+// checks counted among results to report, in category order, after the
+// messages of the checks.
+func (t MessageTemplates) summarize(report *healthReport, results []checkResult) {
+	for _, category := range sortedKeys(t.Categories) {
+		var members []checkResult
+		for _, r := range results {
+			if r.Type == category && !r.excluded() {
+				members = append(members, r)
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+		data := categoryMessageData{Category: category, statusMessageData: summarize(members)}
+		message, err := renderTemplate(t.Categories[category], data)
+		if err != nil {
+			log.Printf("messages: category %s: %v", category, err)
+			continue
+		}
+		report.Messages = append(report.Messages, message)
+		report.summaries = append(report.summaries, data.Status)
+	}
+}
+
+// summarize counts the results not left out of the aggregate.
+func summarize(results []checkResult) statusMessageData {
+	data := statusMessageData{Status: worstStatus(results).String()}
+	for _, r := range results {
+		if r.excluded() {
+			continue
+		}
+		data.Checks++
+		switch r.Status {
+		case statusWarning:
+			data.Warnings++
+		case statusCritical:
+			data.Critical++
+		}
+	}
+	return data
+}
+
+// templateFuncs are the functions operator templates can call, for
+// escaping fields in the formats they produce.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"csv":   csvField,
+	"xml":   xmlEscape,
+	"json":  jsonValue,
+}
+
+// csvField quotes s as a CSV field when it needs it.
+func csvField(s string) string {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// xmlEscape escapes s for XML text and attribute values.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// jsonValue encodes v as JSON.
+func jsonValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// templateCache holds parsed templates by their text.
+var templateCache sync.Map
+
+// renderTemplate renders the template text with data. Empty text renders
+// as empty.
+func renderTemplate(text string, data interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	cached, ok := templateCache.Load(text)
+	if !ok {
+		tmpl, err := template.New("template").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		cached, _ = templateCache.LoadOrStore(text, tmpl)
+	}
+	var b strings.Builder
+	if err := cached.(*template.Template).Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// requestLanguages returns the languages a request asks for, from ?lang=
+// or else Accept-Language, most preferred first.
+func requestLanguages(r *http.Request) []string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return []string{lang}
+	}
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		langs = append(langs, weighted{lang: lang, q: q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	out := make([]string, len(langs))
+	for i, l := range langs {
+		out[i] = l.lang
+	}
+	return out
+}
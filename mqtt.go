@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// MQTTCheck connects to an MQTT 3.1.1 broker, subscribes to a probe topic,
+// publishes a unique message to it and waits for the message to be
+// delivered back within Timeout. This exercises authentication, routing and
+// delivery rather than just the listener.
+type MQTTCheck struct {
+	Name               string        `yaml:"name"`
+	URL                string        `yaml:"url"`
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify"`
+	Username           string        `yaml:"username"`
+	Password           string        `yaml:"password"`
+	Topic              string        `yaml:"topic"`
+	Timeout            time.Duration `yaml:"timeout"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the broker URL and probe topic.
+func (m *MQTTCheck) Validate() error {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return fmt.Errorf("mqtt check %s: invalid url: %w", m.Name, err)
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp", "mqtts", "ssl", "tls":
+	default:
+		return fmt.Errorf("mqtt check %s: url scheme must be mqtt, tcp, mqtts, ssl or tls", m.Name)
+	}
+	for _, c := range m.Topic {
+		if c == '+' || c == '#' {
+			return fmt.Errorf("mqtt check %s: topic must not contain wildcards", m.Name)
+		}
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("mqtt check %s: timeout must not be negative", m.Name)
+	}
+	return nil
+}
+
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttDisconnect = 14
+	mqttMaxPacket  = 16 << 20
+)
+
+func (check MQTTCheck) run(ctx context.Context) checkResult {
+	u, err := url.Parse(check.URL)
+	if err != nil {
+		return checkFailed("MQTT Name: %s, invalid url: %v", check.Name, err)
+	}
+	topic := check.Topic
+	if topic == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		topic = "server-health-api/probe/" + hostname
+	}
+	start := time.Now()
+	if err := check.roundTrip(ctx, u, topic); err != nil {
+		return checkFailed("MQTT Name: %s, Broker: %s %v", check.Name, u.Host, err)
+	}
+	return checkOK("MQTT Name: %s, Broker: %s delivered a message on %s in %s", check.Name, u.Host, topic, time.Since(start).Round(time.Millisecond))
+}
+
+func (check MQTTCheck) roundTrip(ctx context.Context, u *url.URL, topic string) error {
+	secure := u.Scheme == "mqtts" || u.Scheme == "ssl" || u.Scheme == "tls"
+	host := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	timeout := check.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	var tlsConfig *tls.Config
+	if secure {
+		tlsConfig = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: check.InsecureSkipVerify}
+	}
+	conn, err := dialCheck(ctx, "tcp", host, 5*time.Second, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(timeout + 5*time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+	r := bufio.NewReader(conn)
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(nonce)
+
+	// CONNECT: protocol name, level 4, flags, keep alive, then the payload.
+	flags := byte(0x02) // clean session
+	payload := appendMQTTString(nil, "health-"+id)
+	if check.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, check.Username)
+		if check.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, check.Password)
+		}
+	}
+	connect := append(appendMQTTString(nil, "MQTT"), 4, flags, 0, 30)
+	if err := writeMQTTPacket(conn, mqttConnect<<4, append(connect, payload...)); err != nil {
+		return err
+	}
+	_, body, err := readMQTTPacket(r, mqttConnAck)
+	if err != nil {
+		return fmt.Errorf("CONNECT failed: %w", err)
+	}
+	if len(body) < 2 || body[1] != 0 {
+		return fmt.Errorf("refused the connection: %s", mqttConnAckReason(body))
+	}
+
+	subscribe := append(binary.BigEndian.AppendUint16(nil, 1), appendMQTTString(nil, topic)...)
+	if err := writeMQTTPacket(conn, mqttSubscribe<<4|0x02, append(subscribe, 0)); err != nil {
+		return err
+	}
+	if _, body, err = readMQTTPacket(r, mqttSubAck); err != nil {
+		return fmt.Errorf("SUBSCRIBE failed: %w", err)
+	}
+	if len(body) < 3 || body[2] == 0x80 {
+		return fmt.Errorf("rejected the subscription to %s", topic)
+	}
+
+	if err := writeMQTTPacket(conn, mqttPublish<<4, append(appendMQTTString(nil, topic), id...)); err != nil {
+		return err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	for {
+		header, body, err := readMQTTPacket(r, mqttPublish)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("did not deliver the probe message within %s", timeout)
+		}
+		if err != nil {
+			return err
+		}
+		if len(body) < 2 {
+			continue
+		}
+		topicLen := int(binary.BigEndian.Uint16(body))
+		offset := 2 + topicLen
+		if header&0x06 != 0 {
+			offset += 2 // packet identifier for QoS 1 and 2
+		}
+		if offset <= len(body) && string(body[2:2+topicLen]) == topic && string(body[offset:]) == id {
+			break
+		}
+	}
+
+	return writeMQTTPacket(conn, mqttDisconnect<<4, nil)
+}
+
+func mqttConnAckReason(body []byte) string {
+	if len(body) < 2 {
+		return "malformed CONNACK"
+	}
+	switch body[1] {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	default:
+		return fmt.Sprintf("return code %d", body[1])
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...) // #nosec G115 -- topics and credentials are short
+}
+
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	// Remaining length: 7 bits per byte, high bit set on all but the last.
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// readMQTTPacket reads packets until one of the wanted type arrives,
+// returning its fixed header byte and body.
+func readMQTTPacket(r *bufio.Reader, want byte) (byte, []byte, error) {
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length, multiplier := 0, 1
+		for i := 0; ; i++ {
+			if i == 4 {
+				return 0, nil, errors.New("malformed remaining length")
+			}
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length += int(b&0x7f) * multiplier
+			multiplier *= 128
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if length > mqttMaxPacket {
+			return 0, nil, fmt.Errorf("packet of %d bytes is too large", length)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return 0, nil, err
+		}
+		if header>>4 == want {
+			return header, body, nil
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const sysClassNet = "/sys/class/net"
+
+// InterfaceCheck asserts that a network interface exists and is up, and
+// optionally that it has an expected address and healthy link statistics.
+type InterfaceCheck struct {
+	Name      string  `yaml:"name"`
+	Interface string  `yaml:"interface"`
+	Address   string  `yaml:"address"`
+	MinSpeed  int     `yaml:"minSpeed"`
+	MaxErrors *uint64 `yaml:"maxErrors"`
+	MaxDrops  *uint64 `yaml:"maxDrops"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the interface name and expected address.
+func (i *InterfaceCheck) Validate() error {
+	if i.Interface == "" || strings.ContainsAny(i.Interface, "/\x00") {
+		return fmt.Errorf("interface check %s: invalid interface %q", i.Name, i.Interface)
+	}
+	if i.Address != "" {
+		if _, _, err := net.ParseCIDR(i.Address); err != nil && net.ParseIP(i.Address) == nil {
+			return fmt.Errorf("interface check %s: invalid address %q", i.Name, i.Address)
+		}
+	}
+	if i.MinSpeed < 0 {
+		return fmt.Errorf("interface check %s: invalid minSpeed: %d", i.Name, i.MinSpeed)
+	}
+	return nil
+}
+
+func (check InterfaceCheck) run(ctx context.Context) checkResult {
+	if problem := interfaceProblem(check); problem != "" {
+		return checkFailed("Interface Name: %s, Interface: %s %s", check.Name, check.Interface, problem)
+	}
+	return checkOK("Interface Name: %s, Interface: %s is up and as expected", check.Name, check.Interface)
+}
+
+// interfaceProblem returns a description of the first failed assertion, or
+// an empty string when the interface is healthy.
+func interfaceProblem(check InterfaceCheck) string {
+	iface, err := net.InterfaceByName(check.Interface)
+	if err != nil {
+		return "does not exist"
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "is administratively down"
+	}
+	if iface.Flags&net.FlagRunning == 0 {
+		return "has no carrier"
+	}
+
+	if check.Address != "" {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return fmt.Sprintf("addresses could not be read: %v", err)
+		}
+		if !interfaceHasAddress(addrs, check.Address) {
+			return fmt.Sprintf("does not have address %s", check.Address)
+		}
+	}
+
+	if check.MinSpeed > 0 {
+		speed, err := readSysNetUint(check.Interface, "speed")
+		if err != nil {
+			return fmt.Sprintf("link speed could not be read: %v", err)
+		}
+		if speed < uint64(check.MinSpeed) {
+			return fmt.Sprintf("link speed: %dMb/s is below %dMb/s", speed, check.MinSpeed)
+		}
+	}
+
+	if check.MaxErrors != nil {
+		errs, err := sumSysNetCounters(check.Interface, "rx_errors", "tx_errors")
+		if err != nil {
+			return fmt.Sprintf("error counters could not be read: %v", err)
+		}
+		if errs > *check.MaxErrors {
+			return fmt.Sprintf("errors: %d exceed %d", errs, *check.MaxErrors)
+		}
+	}
+
+	if check.MaxDrops != nil {
+		drops, err := sumSysNetCounters(check.Interface, "rx_dropped", "tx_dropped")
+		if err != nil {
+			return fmt.Sprintf("drop counters could not be read: %v", err)
+		}
+		if drops > *check.MaxDrops {
+			return fmt.Sprintf("drops: %d exceed %d", drops, *check.MaxDrops)
+		}
+	}
+	return ""
+}
+
+// interfaceHasAddress reports whether any address equals want, or falls
+// inside it when want is a CIDR.
+func interfaceHasAddress(addrs []net.Addr, want string) bool {
+	_, network, cidrErr := net.ParseCIDR(want)
+	wantIP := net.ParseIP(want)
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if cidrErr == nil && network.Contains(ipNet.IP) {
+			return true
+		}
+		if wantIP != nil && wantIP.Equal(ipNet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func readSysNetUint(iface string, name string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(sysClassNet, iface, name)) // #nosec G304 -- iface is validated
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func sumSysNetCounters(iface string, names ...string) (uint64, error) {
+	var total uint64
+	for _, name := range names {
+		v, err := readSysNetUint(iface, filepath.Join("statistics", name))
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+	return total, nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// NRPEConfig serves checks over the NRPE protocol, so Nagios and Icinga
+// servers can query them with check_nrpe. Commands maps each NRPE command
+// name to a check, named as type/name or by name alone when it is unique.
+// Only AllowedHosts, IP addresses or CIDR ranges, may connect; unset, that
+// is the loopback addresses. With CertFile and KeyFile set connections use
+// TLS, otherwise plain TCP (check_nrpe -n), as Go does not implement the
+// anonymous Diffie-Hellman ciphers of NRPE's default SSL.
+type NRPEConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	Listen       string            `yaml:"listen"`
+	AllowedHosts []string          `yaml:"allowedHosts"`
+	Commands     map[string]string `yaml:"commands"`
+	CertFile     string            `yaml:"certFile"`
+	KeyFile      string            `yaml:"keyFile"`
+}
+
+// Validate checks the listen address, allowed hosts and TLS files.
+func (c *NRPEConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("nrpe: listen must be host:port: %w", err)
+		}
+	}
+	if _, err := parseAllowedHosts(c.AllowedHosts); err != nil {
+		return fmt.Errorf("nrpe: %w", err)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("nrpe: certFile and keyFile must be set together")
+	}
+	for command := range c.Commands {
+		if command == "" || strings.Contains(command, "!") {
+			return fmt.Errorf("nrpe: invalid command name %q", command)
+		}
+	}
+	return nil
+}
+
+// parseAllowedHosts parses IP addresses and CIDR ranges, defaulting to the
+// loopback ranges.
+func parseAllowedHosts(hosts []string) ([]*net.IPNet, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.0/8", "::1/128"}
+	}
+	nets := make([]*net.IPNet, 0, len(hosts))
+	for _, h := range hosts {
+		if !strings.Contains(h, "/") {
+			ip := net.ParseIP(h)
+			if ip == nil {
+				return nil, fmt.Errorf("allowed host %q must be an IP address or CIDR range", h)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(h)
+		if err != nil {
+			return nil, fmt.Errorf("allowed host %q must be an IP address or CIDR range", h)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// NRPE packet types and the UNKNOWN result code.
+const (
+	nrpeQuery    = 1
+	nrpeResponse = 2
+	nrpeUnknown  = 3
+)
+
+// nrpeV2Buffer is the fixed buffer size of a version 2 packet, and the
+// largest query buffer accepted from later versions.
+const nrpeV2Buffer = 1024
+
+// nrpeHeaderSize is what version 3 and 4 packets have before the buffer:
+// version, type, CRC, result code, alignment and buffer length.
+const nrpeHeaderSize = 16
+
+// nrpeServer answers NRPE queries from the current config's checks.
+type nrpeServer struct {
+	live     *liveConfig
+	cache    *resultCache
+	commands map[string]string
+	allowed  []*net.IPNet
+	listener net.Listener
+}
+
+// startNRPE listens for NRPE queries. The returned function stops the
+// listener.
+func startNRPE(live *liveConfig, cache *resultCache) (func(), error) {
+	cfg := live.current().Config.NRPE
+	address := cfg.Listen
+	if address == "" {
+		address = ":5666"
+	}
+	allowed, err := parseAllowedHosts(cfg.AllowedHosts)
+	if err != nil {
+		return nil, fmt.Errorf("nrpe: %w", err)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("nrpe: %w", err)
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			closeAndLog(listener, "nrpe listener")
+			return nil, fmt.Errorf("nrpe: %w", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	}
+	s := &nrpeServer{live: live, cache: cache, commands: cfg.Commands, allowed: allowed, listener: listener}
+	log.Printf("Starting NRPE listener on %s", address)
+	go s.serve()
+	return func() { closeAndLog(listener, "nrpe listener") }, nil
+}
+
+func (s *nrpeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("nrpe: %v", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// permitted reports whether addr is a TCP address of an allowed host.
+func permitted(allowed []*net.IPNet, addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range allowed {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *nrpeServer) handle(conn net.Conn) {
+	defer closeAndLog(conn, "nrpe connection")
+	if !permitted(s.allowed, conn.RemoteAddr()) {
+		log.Printf("nrpe: refused connection from %s", conn.RemoteAddr())
+		return
+	}
+	timeout := s.live.current().Config.RequestTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout + 5*time.Second)); err != nil {
+		return
+	}
+	version, query, err := readNRPEQuery(conn)
+	if err != nil {
+		log.Printf("nrpe: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	code, output := s.answer(query, timeout)
+	if _, err := conn.Write(nrpePacket(version, nrpeResponse, code, output)); err != nil {
+		log.Printf("nrpe: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// answer runs the check mapped to command, or reads it from the cache when
+// checks run in the background, returning its status as the NRPE result
+// code and its message with the run time as performance data.
+func (s *nrpeServer) answer(command string, timeout time.Duration) (int, string) {
+	if command == "_NRPE_CHECK" {
+		return int(statusOK), "server-health-api " + currentBuildInfo().Version
+	}
+	name, args, _ := strings.Cut(command, "!")
+	if args != "" {
+		return nrpeUnknown, "UNKNOWN: command arguments are not accepted"
+	}
+	ref, ok := s.commands[name]
+	if !ok {
+		return nrpeUnknown, fmt.Sprintf("UNKNOWN: command %s is not defined", name)
+	}
+	c, err := nrpeCheck(currentChecks(s.live.current()), ref)
+	if err != nil {
+		return nrpeUnknown, "UNKNOWN: " + err.Error()
+	}
+
+	var result checkResult
+	found := false
+	if s.cache != nil {
+		for _, r := range s.cache.get() {
+			if r.Type == c.Type && r.Name == c.Name {
+				result, found = r, true
+			}
+		}
+	}
+	if !found {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		result = runChecks(ctx, []check{c})[0]
+	}
+	return int(result.Status), fmt.Sprintf("%s: %s|time=%.3fs", strings.ToUpper(result.Status.String()), result.Message, result.Duration.Seconds())
+}
+
+// nrpeCheck returns the check ref names, as type/name or a unique name.
+func nrpeCheck(checks []check, ref string) (check, error) {
+	var found []check
+	for _, c := range checks {
+		if ref == overrideKey(c.Type, c.Name) || ref == c.Name {
+			found = append(found, c)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return check{}, fmt.Errorf("no check matches %s", ref)
+	case len(found) > 1 && !strings.Contains(ref, "/"):
+		return check{}, fmt.Errorf("%s matches more than one check", ref)
+	}
+	return found[0], nil
+}
+
+// readNRPEQuery reads a version 2, 3 or 4 query packet, verifying its CRC,
+// and returns the version with the command.
+func readNRPEQuery(r io.Reader) (int, string, error) {
+	header := make([]byte, nrpeHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, "", err
+	}
+	version := int(binary.BigEndian.Uint16(header[0:]))
+	var packet []byte
+	switch version {
+	case 2:
+		// The buffer starts within the header, after the result code;
+		// the packet is padded to a multiple of four bytes.
+		rest := make([]byte, 10+nrpeV2Buffer+2-nrpeHeaderSize)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return 0, "", err
+		}
+		packet = append(header, rest...)
+	case 3, 4:
+		n := binary.BigEndian.Uint32(header[12:])
+		if n > nrpeV2Buffer*64 {
+			return 0, "", fmt.Errorf("query of %d bytes is too long", n)
+		}
+		// A version 3 packet has three bytes of padding after the buffer.
+		size := int(n)
+		if version == 3 {
+			size += 3
+		}
+		rest := make([]byte, size)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return 0, "", err
+		}
+		packet = append(header, rest...)
+	default:
+		return 0, "", fmt.Errorf("unsupported packet version %d", version)
+	}
+	if binary.BigEndian.Uint16(packet[2:]) != nrpeQuery {
+		return 0, "", fmt.Errorf("packet is not a query")
+	}
+	crc := binary.BigEndian.Uint32(packet[4:])
+	binary.BigEndian.PutUint32(packet[4:], 0)
+	if crc32.ChecksumIEEE(packet) != crc {
+		return 0, "", fmt.Errorf("packet CRC does not match")
+	}
+	buffer := packet[10:]
+	if version != 2 {
+		buffer = packet[nrpeHeaderSize:]
+	}
+	if i := strings.IndexByte(string(buffer), 0); i >= 0 {
+		buffer = buffer[:i]
+	}
+	return version, string(buffer), nil
+}
+
+// nrpePacket builds a packet of the given version. Version 2 output is cut
+// to fit its fixed buffer.
+func nrpePacket(version, typ, code int, output string) []byte {
+	var packet []byte
+	if version == 2 {
+		if len(output) > nrpeV2Buffer-1 {
+			output = output[:nrpeV2Buffer-1]
+		}
+		packet = make([]byte, 10+nrpeV2Buffer+2)
+		copy(packet[10:], output)
+	} else {
+		n := len(output) + 1
+		size := nrpeHeaderSize + n
+		if version == 3 {
+			size += 3
+		}
+		packet = make([]byte, size)
+		binary.BigEndian.PutUint32(packet[12:], uint32(n)) // #nosec G115 -- output is a check message
+		copy(packet[nrpeHeaderSize:], output)
+	}
+	binary.BigEndian.PutUint16(packet[0:], uint16(version)) // #nosec G115 -- 2, 3 or 4
+	binary.BigEndian.PutUint16(packet[2:], uint16(typ))     // #nosec G115 -- a packet type
+	binary.BigEndian.PutUint16(packet[8:], uint16(code))    // #nosec G115 -- a result code
+	binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE(packet))
+	return packet
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// openAPISpec describes every endpoint; keep it in step with the handlers.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the embedded OpenAPI document with info.version set
+// to the running build's version.
+func openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		http.Error(w, "Invalid OpenAPI document", http.StatusInternalServerError)
+		return
+	}
+	if info, ok := spec["info"].(map[string]interface{}); ok {
+		info["version"] = currentBuildInfo().Version
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(spec); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
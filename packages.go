@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// PackageCheck asserts that a package is installed and, optionally, that its
+// version is at least MinVersion.
+type PackageCheck struct {
+	Name       string `yaml:"name"`
+	Package    string `yaml:"package"`
+	Manager    string `yaml:"manager"`
+	MinVersion string `yaml:"minVersion"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+var packageNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9+._-]*$`)
+
+// Validate checks the package name and manager.
+func (p *PackageCheck) Validate() error {
+	if !packageNameRegex.MatchString(p.Package) {
+		return fmt.Errorf("package check %s: invalid package name %q", p.Name, p.Package)
+	}
+	switch p.Manager {
+	case "", "dpkg", "rpm":
+	default:
+		return fmt.Errorf("package check %s: unsupported manager %q", p.Name, p.Manager)
+	}
+	return nil
+}
+
+func (check PackageCheck) run(ctx context.Context) checkResult {
+	version, err := installedPackageVersion(ctx, check.Manager, check.Package)
+	switch {
+	case err != nil:
+		return checkFailed("Package Name: %s, Package: %s is not installed", check.Name, check.Package)
+	case check.MinVersion != "" && compareVersions(version, check.MinVersion) < 0:
+		return checkFailed("Package Name: %s, Package: %s, Version: %s is older than %s", check.Name, check.Package, version, check.MinVersion)
+	default:
+		return checkOK("Package Name: %s, Package: %s, Version: %s is installed", check.Name, check.Package, version)
+	}
+}
+
+// installedPackageVersion queries dpkg or rpm, auto-detecting the manager
+// when none is configured.
+func installedPackageVersion(ctx context.Context, manager, pkg string) (string, error) {
+	if manager == "" {
+		manager = "rpm"
+		if _, err := exec.LookPath("dpkg-query"); err == nil {
+			manager = "dpkg"
+		}
+	}
+
+	if manager == "dpkg" {
+		output, err := commandOutput(ctx, "dpkg-query", "-W", "-f=${Status}\t${Version}", pkg)
+		if err != nil {
+			return "", err
+		}
+		status, version, _ := strings.Cut(strings.TrimSpace(string(output)), "\t")
+		if !strings.HasSuffix(status, " installed") {
+			return "", fmt.Errorf("package status is %q", status)
+		}
+		return version, nil
+	}
+
+	output, err := commandOutput(ctx, "rpm", "-q", "--qf", "%{EPOCH}:%{VERSION}-%{RELEASE}", pkg)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(output)), "(none):"), nil
+}
+
+// compareVersions compares two package versions using the Debian ordering
+// rules ([epoch:]upstream[-revision], with "~" sorting before anything),
+// which also orders RPM epoch:version-release strings sensibly.
+func compareVersions(a, b string) int {
+	aEpoch, aRest := splitEpoch(a)
+	bEpoch, bRest := splitEpoch(b)
+	if c := compareVersionPart(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+	aUp, aRev := splitRevision(aRest)
+	bUp, bRev := splitRevision(bRest)
+	if c := compareVersionPart(aUp, bUp); c != 0 {
+		return c
+	}
+	return compareVersionPart(aRev, bRev)
+}
+
+func splitEpoch(v string) (string, string) {
+	if epoch, rest, ok := strings.Cut(v, ":"); ok {
+		return epoch, rest
+	}
+	return "0", v
+}
+
+func splitRevision(v string) (string, string) {
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		// Compare the non-digit prefixes character by character.
+		var aStr, bStr string
+		aStr, a = splitLeading(a, false)
+		bStr, b = splitLeading(b, false)
+		if c := compareLexical(aStr, bStr); c != 0 {
+			return c
+		}
+		// Then the numeric prefixes by value.
+		var aNum, bNum string
+		aNum, a = splitLeading(a, true)
+		bNum, b = splitLeading(b, true)
+		aNum, bNum = strings.TrimLeft(aNum, "0"), strings.TrimLeft(bNum, "0")
+		if len(aNum) != len(bNum) {
+			if len(aNum) < len(bNum) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(aNum, bNum); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func splitLeading(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareLexical orders "~" first, then the end of string, then letters,
+// then all other characters.
+func compareLexical(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ac, bc int
+		if i < len(a) {
+			ac = versionCharOrder(a[i])
+		}
+		if i < len(b) {
+			bc = versionCharOrder(b[i])
+		}
+		if ac != bc {
+			if ac < bc {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionCharOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
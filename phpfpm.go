@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// PHPFPMCheck queries a PHP-FPM pool's status page over FastCGI, as the
+// web server would, at Address, host:port or the path of a unix socket.
+// The pool's pm.status_path must match StatusPath, "/status" unless set.
+// It fails when fewer than MinIdle workers are idle, more than MaxActive
+// are busy or more than MaxQueue connections are waiting to be accepted.
+type PHPFPMCheck struct {
+	Name       string `yaml:"name"`
+	Address    string `yaml:"address"`
+	StatusPath string `yaml:"statusPath"`
+	MinIdle    int    `yaml:"minIdle"`
+	MaxActive  int    `yaml:"maxActive"`
+	MaxQueue   int    `yaml:"maxQueue"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the address, status path and limits.
+func (p *PHPFPMCheck) Validate() error {
+	if p.Address == "" {
+		return fmt.Errorf("php-fpm check %s: address is required", p.Name)
+	}
+	if !strings.HasPrefix(p.Address, "/") {
+		if _, _, err := net.SplitHostPort(p.Address); err != nil {
+			return fmt.Errorf("php-fpm check %s: address must be host:port or a socket path", p.Name)
+		}
+	}
+	if p.StatusPath != "" && !strings.HasPrefix(p.StatusPath, "/") {
+		return fmt.Errorf("php-fpm check %s: statusPath must start with /", p.Name)
+	}
+	if p.MinIdle < 0 || p.MaxActive < 0 || p.MaxQueue < 0 {
+		return fmt.Errorf("php-fpm check %s: minIdle, maxActive and maxQueue must not be negative", p.Name)
+	}
+	return nil
+}
+
+// fpmStatus is the JSON PHP-FPM's status page reports.
+type fpmStatus struct {
+	Pool               string `json:"pool"`
+	ListenQueue        int    `json:"listen queue"`
+	IdleProcesses      int    `json:"idle processes"`
+	ActiveProcesses    int    `json:"active processes"`
+	TotalProcesses     int    `json:"total processes"`
+	MaxChildrenReached int    `json:"max children reached"`
+	SlowRequests       int    `json:"slow requests"`
+}
+
+func (check PHPFPMCheck) run(ctx context.Context) checkResult {
+	path := check.StatusPath
+	if path == "" {
+		path = "/status"
+	}
+	network := "tcp"
+	if strings.HasPrefix(check.Address, "/") {
+		network = "unix"
+	}
+	body, err := fastCGIGet(ctx, network, check.Address, path, "json")
+	if err != nil {
+		return checkFailed("PHP-FPM Name: %s, Address: %s could not be queried: %v", check.Name, check.Address, err)
+	}
+	var status fpmStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return checkFailed("PHP-FPM Name: %s, Address: %s, status is not JSON: %v", check.Name, check.Address, err)
+	}
+	summary := fmt.Sprintf("Pool: %s, Active: %d, Idle: %d, Total: %d, Queue: %d, Max Children Reached: %d, Slow Requests: %d",
+		status.Pool, status.ActiveProcesses, status.IdleProcesses, status.TotalProcesses, status.ListenQueue, status.MaxChildrenReached, status.SlowRequests)
+	switch {
+	case check.MinIdle > 0 && status.IdleProcesses < check.MinIdle:
+		return checkFailed("PHP-FPM Name: %s, %s, fewer than %d workers idle", check.Name, summary, check.MinIdle)
+	case check.MaxActive > 0 && status.ActiveProcesses > check.MaxActive:
+		return checkFailed("PHP-FPM Name: %s, %s, more than %d workers active", check.Name, summary, check.MaxActive)
+	case check.MaxQueue > 0 && status.ListenQueue > check.MaxQueue:
+		return checkFailed("PHP-FPM Name: %s, %s, more than %d connections queued", check.Name, summary, check.MaxQueue)
+	default:
+		return checkOK("PHP-FPM Name: %s, %s", check.Name, summary)
+	}
+}
+
+// FastCGI record types.
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+)
+
+// maxFastCGIResponse bounds the response read from a FastCGI server.
+const maxFastCGIResponse = 1 << 20
+
+// fastCGIGet makes a GET request for path with query to the FastCGI
+// responder at address and returns the body of a 200 response.
+func fastCGIGet(ctx context.Context, network, address, path, query string) ([]byte, error) {
+	conn, err := dialCheck(ctx, network, address, 2*time.Second, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_NAME":       path,
+		"SCRIPT_FILENAME":   path,
+		"REQUEST_URI":       path + "?" + query,
+		"QUERY_STRING":      query,
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_SOFTWARE":   "server-health-api",
+	}
+	var encoded bytes.Buffer
+	for name, value := range params {
+		writeFastCGILength(&encoded, len(name))
+		writeFastCGILength(&encoded, len(value))
+		encoded.WriteString(name)
+		encoded.WriteString(value)
+	}
+	var request bytes.Buffer
+	// Request 1 as a responder, with the connection closed after it.
+	writeFastCGIRecord(&request, fcgiBeginRequest, []byte{0, 1, 0, 0, 0, 0, 0, 0})
+	writeFastCGIRecord(&request, fcgiParams, encoded.Bytes())
+	writeFastCGIRecord(&request, fcgiParams, nil)
+	writeFastCGIRecord(&request, fcgiStdin, nil)
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	reader := bufio.NewReader(io.LimitReader(conn, maxFastCGIResponse))
+	for ended := false; !ended; {
+		var header [8]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, fmt.Errorf("response ended early: %w", err)
+		}
+		content := make([]byte, int(binary.BigEndian.Uint16(header[4:6]))+int(header[6]))
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, fmt.Errorf("response ended early: %w", err)
+		}
+		content = content[:binary.BigEndian.Uint16(header[4:6])]
+		switch header[1] {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			ended = true
+		}
+	}
+
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("empty response: %s", strings.TrimSpace(stderr.String()))
+	}
+	// The response is CGI's: headers, with the status in Status, then the
+	// body.
+	response := bufio.NewReader(&stdout)
+	headers, err := textproto.NewReader(response).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid response headers: %w", err)
+	}
+	if status := headers.Get("Status"); status != "" && !strings.HasPrefix(status, "200") {
+		return nil, fmt.Errorf("answered %s", status)
+	}
+	return io.ReadAll(response)
+}
+
+// writeFastCGIRecord writes a record of request 1, padded to 8 bytes.
+func writeFastCGIRecord(w *bytes.Buffer, kind byte, content []byte) {
+	padding := (8 - len(content)%8) % 8
+	w.Write([]byte{1, kind, 0, 1, byte(len(content) >> 8), byte(len(content)), byte(padding), 0})
+	w.Write(content)
+	w.Write(make([]byte, padding))
+}
+
+// writeFastCGILength writes a name or value length, in one byte when
+// short and four otherwise.
+func writeFastCGILength(w *bytes.Buffer, n int) {
+	if n < 128 {
+		w.WriteByte(byte(n))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31) // #nosec G115 -- params are short
+	w.Write(b[:])
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// PrivilegeConfig lets the daemon run as an unprivileged user while the
+// commands that need more, such as nft or journalctl, run through
+// "sudo -n" under rules the operator grants in sudoers. Commands names the
+// commands to run this way; empty means every command checks run. sudo
+// never prompts, so a command without a rule fails its check at once.
+type PrivilegeConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	Commands []string `yaml:"commands"`
+}
+
+// checkCommands are the commands checks run.
+var checkCommands = []string{
+	"dpkg-query", "ip6tables-save", "iptables-save", "journalctl", "nft",
+	"nvidia-smi", "ping", "rpm", "systemctl", "zpool",
+}
+
+// checkPrivileges is config.privileges; main sets it.
+var checkPrivileges PrivilegeConfig
+
+// Validate checks that every command named is one checks run.
+func (c *PrivilegeConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	for _, name := range c.Commands {
+		if !slices.Contains(checkCommands, name) {
+			return fmt.Errorf("privileges: unknown command %q, must be one of %s", name, strings.Join(checkCommands, ", "))
+		}
+	}
+	return nil
+}
+
+// escalates reports whether name runs through sudo.
+func (c *PrivilegeConfig) escalates(name string) bool {
+	return c.Enabled && (len(c.Commands) == 0 || slices.Contains(c.Commands, name))
+}
+
+// commandOutput runs a check's command, through sudo when checkPrivileges
+// says so and under checkSandbox, and returns its standard output. Errors
+// from a failed command carry the first line of its standard error.
+func commandOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	argv := append([]string{name}, args...)
+	if checkPrivileges.escalates(name) {
+		argv = append([]string{"sudo", "-n", "--"}, argv...)
+	}
+	var cmd *exec.Cmd
+	if checkSandbox.Enabled {
+		if checkSandbox.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, checkSandbox.Timeout)
+			defer cancel()
+		}
+		cmd = checkSandbox.command(ctx, argv)
+	} else {
+		cmd = exec.CommandContext(ctx, argv[0], argv[1:]...) // #nosec G204 -- name is one of checkCommands
+	}
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+		line, _, _ := strings.Cut(string(bytes.TrimSpace(exitErr.Stderr)), "\n")
+		err = fmt.Errorf("%w: %s", err, line)
+	}
+	return output, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeConfig serves /probe?module=<name>&target=<target>, which runs a
+// module's check against a target given in the request, as Prometheus
+// blackbox_exporter does, for targets not configured as checks. Each
+// module sets exactly one prober, a check of that type as it would be
+// configured but without its target: http is an endpoint check whose url
+// is the target, tcp a port check of host:port, grpc a gRPC check of
+// host:port, ssh and smtp checks of host or host:port, and websocket a
+// WebSocket check whose url is the target. Anyone allowed to probe can
+// make the daemon connect anywhere it can reach, so /probe is off unless
+// enabled and uses config.auth.
+type ProbeConfig struct {
+	Enabled bool                   `yaml:"enabled"`
+	Modules map[string]ProbeModule `yaml:"modules"`
+}
+
+// ProbeModule is one module of /probe.
+type ProbeModule struct {
+	HTTP      *Endpoint       `yaml:"http"`
+	TCP       *Port           `yaml:"tcp"`
+	GRPC      *GRPCCheck      `yaml:"grpc"`
+	SSH       *SSHCheck       `yaml:"ssh"`
+	SMTP      *SMTPCheck      `yaml:"smtp"`
+	WebSocket *WebSocketCheck `yaml:"websocket"`
+}
+
+// Validate checks that every module sets one prober.
+func (c *ProbeConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Modules) == 0 {
+		return fmt.Errorf("probe: modules are required")
+	}
+	for name, module := range c.Modules {
+		set := 0
+		for _, prober := range []bool{module.HTTP != nil, module.TCP != nil, module.GRPC != nil, module.SSH != nil, module.SMTP != nil, module.WebSocket != nil} {
+			if prober {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("probe: module %s must set exactly one of http, tcp, grpc, ssh, smtp and websocket", name)
+		}
+	}
+	return nil
+}
+
+// check returns the module's check of target.
+func (m ProbeModule) check(target string) (check, error) {
+	switch {
+	case m.HTTP != nil:
+		c := *m.HTTP
+		c.Name, c.URL = target, target
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return check{}, fmt.Errorf("target must be an http:// or https:// URL")
+		}
+		return check{Type: "endpoint", Name: c.Name, Run: c.run}, c.Validate()
+	case m.TCP != nil:
+		c := *m.TCP
+		host, port, err := splitTarget(target, true)
+		if err != nil {
+			return check{}, err
+		}
+		c.Name, c.Address, c.Port = target, host, port
+		return check{Type: "port", Name: c.Name, Run: c.run}, nil
+	case m.GRPC != nil:
+		c := *m.GRPC
+		if _, _, err := splitTarget(target, true); err != nil {
+			return check{}, err
+		}
+		c.Name, c.Address = target, target
+		return check{Type: "grpc", Name: c.Name, Run: c.run}, c.Validate()
+	case m.SSH != nil:
+		c := *m.SSH
+		host, port, err := splitTarget(target, false)
+		if err != nil {
+			return check{}, err
+		}
+		c.Name, c.Address = target, host
+		if port != 0 {
+			c.Port = port
+		}
+		return check{Type: "ssh", Name: c.Name, Run: c.run}, c.Validate()
+	case m.SMTP != nil:
+		c := *m.SMTP
+		host, port, err := splitTarget(target, false)
+		if err != nil {
+			return check{}, err
+		}
+		c.Name, c.Address = target, host
+		if port != 0 {
+			c.Port = port
+		}
+		return check{Type: "smtp", Name: c.Name, Run: c.run}, c.Validate()
+	default:
+		c := *m.WebSocket
+		c.Name, c.URL = target, target
+		return check{Type: "websocket", Name: c.Name, Run: c.run}, c.Validate()
+	}
+}
+
+// splitTarget splits a host:port target, or a bare host when the port is
+// optional, returning zero for a missing port.
+func splitTarget(target string, portRequired bool) (string, int, error) {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		if portRequired {
+			return "", 0, fmt.Errorf("target must be host:port")
+		}
+		return target, 0, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in target: %s", portText)
+	}
+	return host, port, nil
+}
+
+// probeHandler serves /probe. It answers the Prometheus exposition format,
+// always with 200 as blackbox_exporter does, so a failed probe is a
+// probe_success of 0 rather than a failed scrape; ?format=json returns the
+// check's report instead. Probes are bounded by the scrape timeout
+// Prometheus sends, or config.requestTimeout.
+func probeHandler(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := live.current()
+		query := r.URL.Query()
+		name := query.Get("module")
+		module, ok := config.Config.Probe.Modules[name]
+		if !ok {
+			http.Error(w, "Unknown module: "+name, http.StatusBadRequest)
+			return
+		}
+		target := query.Get("target")
+		if target == "" {
+			http.Error(w, "target is required", http.StatusBadRequest)
+			return
+		}
+		c, err := module.check(target)
+		if err != nil {
+			http.Error(w, "Invalid target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		timeout := config.Config.RequestTimeout
+		if v, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && v > 0 {
+			// Leave time to answer before Prometheus gives up.
+			timeout = time.Duration(v*float64(time.Second)) - 500*time.Millisecond
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		result := runChecks(ctx, []check{c})[0]
+		if query.Get("format") == "json" {
+			writeJSON(w, reports([]checkResult{result}, time.Now())[0])
+			return
+		}
+		success := 0
+		if result.Status != statusCritical && !result.Skipped {
+			success = 1
+		}
+		var b strings.Builder
+		b.WriteString("# HELP probe_success Whether the probe succeeded (1) or not (0).\n")
+		b.WriteString("# TYPE probe_success gauge\n")
+		fmt.Fprintf(&b, "probe_success %d\n", success)
+		b.WriteString("# HELP probe_duration_seconds How long the probe took.\n")
+		b.WriteString("# TYPE probe_duration_seconds gauge\n")
+		fmt.Fprintf(&b, "probe_duration_seconds %g\n", result.Duration.Seconds())
+		b.WriteString("# HELP probe_status Check status: 0 ok, 1 warning, 2 critical.\n")
+		b.WriteString("# TYPE probe_status gauge\n")
+		fmt.Fprintf(&b, "probe_status{module=%s,type=%s} %d\n", promLabel(name), promLabel(c.Type), statusValue(result.Status.String()))
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := w.Write([]byte(b.String())); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProcessCheck counts the running processes whose command line matches
+// Pattern, a regular expression, and fails when fewer than MinCount, one
+// unless set, or more than MaxCount, when set, are found. Processes are
+// read from /proc, so in a Kubernetes pod with shareProcessNamespace set a
+// sidecar sees, and can check, the processes of the pod's other containers.
+type ProcessCheck struct {
+	Name     string `yaml:"name"`
+	Pattern  string `yaml:"pattern"`
+	MinCount int    `yaml:"minCount"`
+	MaxCount int    `yaml:"maxCount"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the pattern and the counts.
+func (p *ProcessCheck) Validate() error {
+	if p.Pattern == "" {
+		return fmt.Errorf("process check %s: pattern is required", p.Name)
+	}
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("process check %s: invalid pattern: %w", p.Name, err)
+	}
+	if p.MinCount < 0 || p.MaxCount < 0 {
+		return fmt.Errorf("process check %s: minCount and maxCount must not be negative", p.Name)
+	}
+	if p.MaxCount > 0 && p.MaxCount < p.MinCount {
+		return fmt.Errorf("process check %s: maxCount must not be less than minCount", p.Name)
+	}
+	return nil
+}
+
+func (check ProcessCheck) run(ctx context.Context) checkResult {
+	pattern := regexp.MustCompile(check.Pattern) // checked by Validate
+	commands, err := processCommands()
+	if err != nil {
+		return checkFailed("Process Name: %s, processes could not be listed: %v", check.Name, err)
+	}
+	running := 0
+	for _, command := range commands {
+		if pattern.MatchString(command) {
+			running++
+		}
+	}
+	minCount := orDefault(check.MinCount, 1)
+	switch {
+	case running < minCount:
+		return checkFailed("Process Name: %s, Pattern: %s, Running: %d, expected at least %d", check.Name, check.Pattern, running, minCount)
+	case check.MaxCount > 0 && running > check.MaxCount:
+		return checkFailed("Process Name: %s, Pattern: %s, Running: %d, expected at most %d", check.Name, check.Pattern, running, check.MaxCount)
+	default:
+		return checkOK("Process Name: %s, Pattern: %s, Running: %d", check.Name, check.Pattern, running)
+	}
+}
+
+// processCommands returns the command line of every process in /proc
+// other than this one, keyed by pid, with arguments separated by spaces.
+// Kernel threads, which have no command line, are left out, as are
+// processes that exit while being read.
+func processCommands() (map[int]string, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	commands := map[int]string{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline")) // #nosec G304 -- a pid directory under /proc
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		commands[pid] = strings.TrimSpace(string(bytes.ReplaceAll(data, []byte{0}, []byte{' '})))
+	}
+	return commands, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Profile serves a subset of the checks at /healthy/{name}, so consumers
+// with different needs share one daemon: a load balancer probing what takes
+// the server out of rotation, monitoring asking for everything. Checks are
+// named as type/name, or by name alone when it is unique, and may include
+// composites, which bring their members. Auth, when set, replaces
+// config.auth for the profile, and StatusCodes replace those of
+// config.statusCodes that they set.
+//
+// A profile can also answer /healthy itself for requests whose Host header
+// matches one of Hosts, where *.example.com matches any subdomain, or at
+// <PathPrefix>/healthy, so one daemon fronting several co-hosted
+// applications answers each one with only its checks.
+type Profile struct {
+	Name        string      `yaml:"name"`
+	Checks      []string    `yaml:"checks"`
+	Hosts       []string    `yaml:"hosts"`
+	PathPrefix  string      `yaml:"pathPrefix"`
+	Auth        *AuthConfig `yaml:"auth"`
+	StatusCodes StatusCodes `yaml:"statusCodes"`
+}
+
+var profileNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Validate checks the name, status codes and that every check named exists.
+func (p *Profile) Validate(checks []check, composites []CompositeCheck) error {
+	if !profileNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("profile %q: name must be letters, digits, '.', '_' or '-'", p.Name)
+	}
+	if len(p.Checks) == 0 {
+		return fmt.Errorf("profile %s: checks are required", p.Name)
+	}
+	if err := p.StatusCodes.Validate(); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	if p.Auth != nil && p.Auth.Enabled && (p.Auth.Username == "" || p.Auth.Password == "") {
+		return fmt.Errorf("profile %s: auth needs a username and password", p.Name)
+	}
+	for _, h := range p.Hosts {
+		if h == "" || strings.ContainsAny(h, "/: ") || strings.Contains(strings.TrimPrefix(h, "*."), "*") {
+			return fmt.Errorf("profile %s: invalid host %q", p.Name, h)
+		}
+	}
+	if p.PathPrefix != "" && (!strings.HasPrefix(p.PathPrefix, "/") || strings.HasSuffix(p.PathPrefix, "/") || strings.ContainsAny(p.PathPrefix, "{}?#")) {
+		return fmt.Errorf("profile %s: pathPrefix must start with / and not end with one", p.Name)
+	}
+	if _, _, err := p.selection(checks, composites); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// matchesHost reports whether host, without its port, is one of Hosts.
+func (p *Profile) matchesHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range p.Hosts {
+		h = strings.ToLower(h)
+		if suffix, ok := strings.CutPrefix(h, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// selection resolves Checks into the override keys of the checks the
+// profile serves and the composites among them.
+func (p *Profile) selection(checks []check, composites []CompositeCheck) (map[string]bool, []CompositeCheck, error) {
+	candidates := append([]check(nil), checks...)
+	byName := map[string]CompositeCheck{}
+	for _, c := range composites {
+		candidates = append(candidates, check{Type: "composite", Name: c.Name})
+		byName[c.Name] = c
+	}
+	keys, err := resolveCheckRefs(p.Checks, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := map[string]bool{}
+	var comps []CompositeCheck
+	for _, key := range keys {
+		name, ok := strings.CutPrefix(key, "composite/")
+		if !ok {
+			selected[key] = true
+			continue
+		}
+		comp := byName[name]
+		members, err := comp.members(checks)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range members {
+			selected[m] = true
+		}
+		comps = append(comps, comp)
+	}
+	return selected, comps, nil
+}
+
+// profile returns the profile called name, or nil.
+func (c *Config) profile(name string) *Profile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// requestProfile returns the profile a health request is for: named in the
+// path as /healthy/{profile}, or the one whose pathPrefix comes before
+// /healthy, or for /healthy and its /api/v1 equivalents the one matching
+// the Host header, if any. ok is false when the request is for a profile
+// that does not exist.
+func (c *Config) requestProfile(r *http.Request) (profile *Profile, ok bool) {
+	if name := r.PathValue("profile"); name != "" {
+		profile = c.profile(name)
+		return profile, profile != nil
+	}
+	switch r.URL.Path {
+	case "/healthy", apiPrefix + "/health", apiPrefix + "/checks":
+	default:
+		prefix, found := strings.CutSuffix(r.URL.Path, "/healthy")
+		if !found || prefix == "" {
+			return nil, false
+		}
+		for i := range c.Profiles {
+			if c.Profiles[i].PathPrefix == prefix {
+				return &c.Profiles[i], true
+			}
+		}
+		return nil, false
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for i := range c.Profiles {
+		if c.Profiles[i].matchesHost(host) {
+			return &c.Profiles[i], true
+		}
+	}
+	return nil, true
+}
+
+// or fills in the codes that are not set from fallback.
+func (codes StatusCodes) or(fallback StatusCodes) StatusCodes {
+	return StatusCodes{
+		Healthy:      orDefault(codes.Healthy, fallback.Healthy),
+		Warning:      orDefault(codes.Warning, fallback.Warning),
+		Degraded:     orDefault(codes.Degraded, fallback.Degraded),
+		Unhealthy:    orDefault(codes.Unhealthy, fallback.Unhealthy),
+		Unauthorized: orDefault(codes.Unauthorized, fallback.Unauthorized),
+	}
+}
+
+// profileAuthMiddleware authenticates health requests with the auth of the
+// profile they are for, or with auth when it has none or the request is
+// for no profile. Requests for unknown profiles are not found.
+func profileAuthMiddleware(live *liveConfig, auth AuthConfig, unauthorizedStatus int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, ok := live.current().requestProfile(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		requestAuth, status := auth, unauthorizedStatus
+		if profile != nil {
+			if profile.Auth != nil {
+				requestAuth = *profile.Auth
+			}
+			status = orDefault(profile.StatusCodes.Unauthorized, unauthorizedStatus)
+		}
+		basicAuthMiddleware(requestAuth, status, next)(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Reasons a check failed, reported with failing results so automation can
+// branch on the cause rather than match messages. The values are part of
+// the API and must not change.
+const (
+	reasonTimeout           = "timeout"
+	reasonCancelled         = "cancelled"
+	reasonConnectionRefused = "connection_refused"
+	reasonConnectionReset   = "connection_reset"
+	reasonUnreachable       = "unreachable"
+	reasonDNS               = "dns_error"
+	reasonTLS               = "tls_error"
+	reasonStatusMismatch    = "status_mismatch"
+	reasonAuth              = "auth_error"
+	reasonNotFound          = "not_found"
+	reasonPermission        = "permission_denied"
+	reasonThreshold         = "threshold"
+	reasonUnexpectedSuccess = "unexpected_success"
+	reasonFaultInjected     = "fault_injected"
+	reasonFailed            = "check_failed"
+)
+
+// errorReason classifies the first error among a, the arguments of a
+// result's message, returning "" when there is none or it has no known
+// cause.
+func errorReason(a []interface{}) string {
+	for _, arg := range a {
+		if err, ok := arg.(error); ok {
+			return reasonFor(err)
+		}
+	}
+	return ""
+}
+
+// reasonFor classifies err by its cause.
+func reasonFor(err error) string {
+	var dnsErr *net.DNSError
+	var verifyErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dnsErr):
+		return reasonDNS
+	case errors.As(err, &verifyErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return reasonTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.Is(err, context.Canceled):
+		return reasonCancelled
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return reasonConnectionReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return reasonUnreachable
+	case errors.Is(err, os.ErrNotExist):
+		return reasonNotFound
+	case errors.Is(err, os.ErrPermission):
+		return reasonPermission
+	}
+	return ""
+}
+
+// because sets the reason r failed from err, keeping any already set.
+func (r checkResult) because(err error) checkResult {
+	if r.Reason == "" {
+		r.Reason = reasonFor(err)
+	}
+	return r
+}
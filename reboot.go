@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	rebootRequiredFile     = "/var/run/reboot-required"
+	rebootRequiredPkgsFile = "/var/run/reboot-required.pkgs"
+	kernelModulesDir       = "/lib/modules"
+)
+
+// RebootCheck reports when the host needs a reboot, either because the
+// package manager flagged it or because a newer kernel is installed than
+// the one running. Severity selects whether that is a warning or a failure.
+type RebootCheck struct {
+	Name     string `yaml:"name"`
+	Severity string `yaml:"severity"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the severity.
+func (r *RebootCheck) Validate() error {
+	switch r.Severity {
+	case "", "warning", "critical":
+	default:
+		return fmt.Errorf("reboot check %s: invalid severity %q", r.Name, r.Severity)
+	}
+	return nil
+}
+
+func (check RebootCheck) run(ctx context.Context) checkResult {
+	reason, err := pendingRebootReason()
+	switch {
+	case err != nil:
+		return checkFailed("Reboot Name: %s, reboot status could not be determined: %v", check.Name, err)
+	case reason == "":
+		return checkOK("Reboot Name: %s, no reboot is pending", check.Name)
+	case check.Severity == "warning":
+		return checkWarning("Reboot Name: %s, reboot is pending: %s", check.Name, reason)
+	default:
+		return checkFailed("Reboot Name: %s, reboot is pending: %s", check.Name, reason)
+	}
+}
+
+// pendingRebootReason returns why a reboot is needed, or an empty string.
+func pendingRebootReason() (string, error) {
+	if _, err := os.Stat(rebootRequiredFile); err == nil {
+		reason := "reboot-required flag is set"
+		if pkgs, err := os.ReadFile(rebootRequiredPkgsFile); err == nil {
+			if list := strings.Fields(string(pkgs)); len(list) > 0 {
+				reason += " by " + strings.Join(list, ", ")
+			}
+		}
+		return reason, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	running, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return "", err
+	}
+	installed, err := newestInstalledKernel()
+	if err != nil {
+		return "", err
+	}
+	if current := strings.TrimSpace(string(running)); installed != "" && compareVersions(installed, current) > 0 {
+		return fmt.Sprintf("running kernel %s, installed kernel %s", current, installed), nil
+	}
+	return "", nil
+}
+
+// newestInstalledKernel returns the highest kernel version with modules installed.
+func newestInstalledKernel() (string, error) {
+	entries, err := os.ReadDir(kernelModulesDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var newest string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// Skip leftover directories from removed kernels.
+		if _, err := os.Stat(kernelModulesDir + "/" + entry.Name() + "/modules.dep"); err != nil {
+			continue
+		}
+		if newest == "" || compareVersions(entry.Name(), newest) > 0 {
+			newest = entry.Name()
+		}
+	}
+	return newest, nil
+}
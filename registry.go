@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// checkRegistry holds checks registered at runtime through the admin API,
+// optionally persisted to a state file so they survive restarts.
+type checkRegistry struct {
+	mu        sync.RWMutex
+	entries   []registeredCheck
+	stateFile string
+}
+
+// registeredCheck is one runtime check. definition is the document as it
+// was posted, so the state file keeps Vault references rather than secrets.
+type registeredCheck struct {
+	check      check
+	definition interface{}
+}
+
+var dynamicChecks = &checkRegistry{}
+
+var errCheckExists = errors.New("check already exists")
+
+// currentChecks returns the configured checks followed by those
+// discovered and those registered at runtime, leaving out those whose
+// onlyIf this host does not meet. Composites, profiles and the rollup
+// still resolve against every configured check, so a shared config
+// validates on every host; a check left out counts towards none of them.
+func currentChecks(config *Config) []check {
+	checks := append(config.checks(), discoveredChecks.checks(config)...)
+	return applicable(append(checks, dynamicChecks.checks()...))
+}
+
+func (r *checkRegistry) checks() []check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	checks := make([]check, 0, len(r.entries))
+	for _, e := range r.entries {
+		checks = append(checks, e.check)
+	}
+	return checks
+}
+
+// parseCheckDefinition reads a document in the config file's schema that
+// defines exactly one check, validated against the running config.
+func parseCheckDefinition(config *Config, data []byte) (registeredCheck, error) {
+	var def Config
+	if err := yaml.UnmarshalStrict(data, &def); err != nil {
+		return registeredCheck{}, err
+	}
+	if !reflect.ValueOf(def.Config).IsZero() {
+		return registeredCheck{}, fmt.Errorf("the config section cannot be changed at runtime")
+	}
+	var definition interface{}
+	if err := yaml.Unmarshal(data, &definition); err != nil {
+		return registeredCheck{}, err
+	}
+	def.Config = config.Config
+	if err := resolveVaultSecrets(&def); err != nil {
+		return registeredCheck{}, err
+	}
+	if err := def.Validate(); err != nil {
+		return registeredCheck{}, err
+	}
+	checks := def.checks()
+	if len(checks) != 1 {
+		return registeredCheck{}, fmt.Errorf("expected one check, got %d", len(checks))
+	}
+	if checks[0].Options.OnFailure != nil {
+		return registeredCheck{}, fmt.Errorf("onFailure can only be set in the config file")
+	}
+	return registeredCheck{check: checks[0], definition: definition}, nil
+}
+
+// add registers a check, refusing names already used by a check of the same
+// type.
+func (r *checkRegistry) add(config *Config, entry registeredCheck) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing := config.checks()
+	for _, e := range r.entries {
+		existing = append(existing, e.check)
+	}
+	for _, c := range existing {
+		if c.Type == entry.check.Type && c.Name == entry.check.Name {
+			return fmt.Errorf("%w: %s check %q", errCheckExists, c.Type, c.Name)
+		}
+	}
+	r.entries = append(r.entries, entry)
+	if err := r.save(); err != nil {
+		r.entries = r.entries[:len(r.entries)-1]
+		return err
+	}
+	return nil
+}
+
+// remove unregisters a runtime check. Checks from the config file cannot be
+// removed.
+func (r *checkRegistry) remove(typ, name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.entries {
+		if e.check.Type == typ && e.check.Name == name {
+			r.entries = append(r.entries[:i], r.entries[i+1:]...)
+			return true, r.save()
+		}
+	}
+	return false, nil
+}
+
+// save writes every definition to the state file, if one is configured.
+// The caller holds r.mu.
+func (r *checkRegistry) save() error {
+	if r.stateFile == "" {
+		return nil
+	}
+	definitions := make([]interface{}, 0, len(r.entries))
+	for _, e := range r.entries {
+		definitions = append(definitions, e.definition)
+	}
+	data, err := yaml.Marshal(definitions)
+	if err != nil {
+		return err
+	}
+	tmp := r.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, r.stateFile); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// load restores checks registered before a restart from stateFile, which
+// becomes where later changes are saved. A missing file is not an error.
+func (r *checkRegistry) load(config *Config, stateFile string) error {
+	r.mu.Lock()
+	r.stateFile = stateFile
+	r.mu.Unlock()
+
+	data, err := os.ReadFile(stateFile) // #nosec G304 -- path is from the config file
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var definitions []interface{}
+	if err := yaml.Unmarshal(data, &definitions); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", stateFile, err)
+	}
+	for _, d := range definitions {
+		doc, err := yaml.Marshal(d)
+		if err != nil {
+			return err
+		}
+		entry, err := parseCheckDefinition(config, doc)
+		if err != nil {
+			return fmt.Errorf("invalid check in state file %s: %w", stateFile, err)
+		}
+		if err := r.add(config, entry); err != nil {
+			return fmt.Errorf("invalid check in state file %s: %w", stateFile, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets editors and config management finish writing before the
+// file is read, so a burst of events triggers one reload.
+const reloadDelay = 500 * time.Millisecond
+
+// liveConfig holds the running config, replaced when the file is reloaded.
+type liveConfig struct {
+	p atomic.Pointer[Config]
+	// generation counts the configs applied, from 1 for the one started
+	// with, and reloaded is when the last was applied, in Unix nanoseconds.
+	generation atomic.Uint64
+	reloaded   atomic.Int64
+}
+
+func newLiveConfig(config *Config) *liveConfig {
+	live := &liveConfig{}
+	live.store(config)
+	return live
+}
+
+// store makes config the running config.
+func (l *liveConfig) store(config *Config) {
+	l.p.Store(config)
+	l.generation.Add(1)
+	l.reloaded.Store(time.Now().UnixNano())
+}
+
+func (l *liveConfig) current() *Config {
+	return l.p.Load()
+}
+
+// watchConfig reloads path whenever it changes, calling onReload after a new
+// config is applied. The directory is watched rather than the file so
+// replacements by rename, as editors and Kubernetes ConfigMaps do, are seen.
+func watchConfig(path string, live *liveConfig, onReload func(*Config)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		closeAndLog(watcher, "config watcher")
+		return err
+	}
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				reload = time.After(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher error: %v", err)
+			case <-reload:
+				reload = nil
+				if next := reloadConfig(path, live); next != nil {
+					onReload(next)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadConfig reads and validates path and, if its checks changed, makes it
+// the running config, which it returns. Settings under config, such as the
+// listen address and auth, only take effect on restart.
+func reloadConfig(path string, live *liveConfig) *Config {
+	next, err := readConfig(path)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the running config: %v", err)
+		return nil
+	}
+	old := live.current()
+	if !reflect.DeepEqual(next.Config, old.Config) {
+		log.Printf("Config reload: changes under config need a restart to take effect")
+	}
+	next.Config = old.Config
+	if reflect.DeepEqual(next, old) {
+		return nil
+	}
+
+	added, removed, changed := diffChecks(old, next)
+	live.store(next)
+	log.Printf("Reloaded config %s: added %v, removed %v, changed %v", path, added, removed, changed)
+	return next
+}
+
+// diffChecks compares the checks of two configs, listing them as
+// section/name, such as ports/ssh.
+func diffChecks(old, next *Config) (added, removed, changed []string) {
+	oldDefs, nextDefs := checkDefinitions(old), checkDefinitions(next)
+	for key, def := range nextDefs {
+		oldDef, ok := oldDefs[key]
+		switch {
+		case !ok:
+			added = append(added, key)
+		case !reflect.DeepEqual(def, oldDef):
+			changed = append(changed, key)
+		}
+	}
+	for key := range oldDefs {
+		if _, ok := nextDefs[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// checkDefinitions maps section/name to each check's definition.
+func checkDefinitions(c *Config) map[string]interface{} {
+	defs := map[string]interface{}{}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() != reflect.Slice {
+			continue
+		}
+		section, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		for j := 0; j < v.Field(i).Len(); j++ {
+			item := v.Field(i).Index(j)
+			defs[section+"/"+item.FieldByName("Name").String()] = item.Interface()
+		}
+	}
+	return defs
+}
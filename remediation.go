@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRemediationOutput bounds the output of an action kept for reporting.
+const maxRemediationOutput = 4 << 10
+
+// RemediationConfig is an action, such as [systemctl, restart, nginx], a
+// check runs once it has been critical After runs in a row, three unless
+// set, to fix a well-understood failure itself. Command is run directly,
+// not through a shell, as the daemon's user, and is killed after Timeout,
+// 30s unless set. It runs at most once per Cooldown, 10m unless set, so a
+// fix that does not work is not retried on every run.
+type RemediationConfig struct {
+	Command  []string      `yaml:"command"`
+	After    int           `yaml:"after"`
+	Cooldown time.Duration `yaml:"cooldown"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Validate checks the command and limits.
+func (c *RemediationConfig) Validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("onFailure: command is required")
+	}
+	if c.After < 0 || c.Cooldown < 0 || c.Timeout < 0 {
+		return fmt.Errorf("onFailure: after, cooldown and timeout must not be negative")
+	}
+	return nil
+}
+
+// remediationRun is the outcome of an action, reported with the check's
+// results.
+type remediationRun struct {
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"`
+	Running  bool      `json:"running,omitempty"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// remediationState is how many runs in a row a check with an action has
+// been critical, and its last action.
+type remediationState struct {
+	streak int
+	last   *remediationRun
+}
+
+// remediator runs the actions of failing checks.
+type remediator struct {
+	mu     sync.Mutex
+	states map[string]*remediationState // by override key
+}
+
+// checkRemediations is the state of every check with an action.
+var checkRemediations = &remediator{states: map[string]*remediationState{}}
+
+// apply counts critical runs of c, starting its action in the background
+// once enough are in a row and the cooldown since the last has passed,
+// and returns result with the last action attached. Skipped runs count
+// neither way.
+func (m *remediator) apply(c check, result checkResult) checkResult {
+	action := c.Options.OnFailure
+	if action == nil || result.Skipped || result.NotScheduled {
+		return result
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := overrideKey(c.Type, c.Name)
+	state, ok := m.states[key]
+	if !ok {
+		state = &remediationState{}
+		m.states[key] = state
+	}
+	if result.Status != statusCritical {
+		state.streak = 0
+	} else {
+		state.streak++
+	}
+	cooldown := action.Cooldown
+	if cooldown == 0 {
+		cooldown = 10 * time.Minute
+	}
+	due := state.last == nil || (!state.last.Running && time.Since(state.last.Started) >= cooldown)
+	if state.streak >= orDefault(action.After, 3) && due {
+		run := &remediationRun{Command: strings.Join(action.Command, " "), Started: time.Now(), Running: true}
+		state.last = run
+		log.Printf("%s check %s: running %s after %d failures", c.Type, c.Name, run.Command, state.streak)
+		go m.run(c, *action, run)
+	}
+	if state.last != nil {
+		last := *state.last
+		result.Remediation = &last
+	}
+	return result
+}
+
+// run runs the action, recording its outcome in run.
+func (m *remediator) run(c check, action RemediationConfig, run *remediationRun) {
+	timeout := action.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...) // #nosec G204 -- the command is from the config file
+	output, err := cmd.CombinedOutput()
+	if len(output) > maxRemediationOutput {
+		output = output[len(output)-maxRemediationOutput:]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run.Running = false
+	run.Duration = time.Since(run.Started).Round(time.Millisecond).Seconds()
+	run.Output = string(bytes.TrimSpace(output))
+	run.Success = err == nil
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		run.Error = fmt.Sprintf("timed out after %s", timeout)
+	case err != nil:
+		run.Error = err.Error()
+	}
+	if run.Success {
+		log.Printf("%s check %s: %s succeeded", c.Type, c.Name, run.Command)
+	} else {
+		log.Printf("%s check %s: %s failed: %s", c.Type, c.Name, run.Command, run.Error)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DNSConfig replaces the system resolver for the host names checks connect
+// to, so checks keep working when the host's own DNS is what is broken.
+// Nameservers are IP addresses, with an optional port, queried in turn.
+// With TLS set they are queried over DNS over TLS, verified against
+// ServerName or, when it is empty, the nameserver's IP address.
+type DNSConfig struct {
+	Nameservers []string `yaml:"nameservers"`
+	TLS         bool     `yaml:"tls"`
+	ServerName  string   `yaml:"serverName"`
+}
+
+// Validate checks every nameserver is an IP address.
+func (d *DNSConfig) Validate() error {
+	for _, ns := range d.Nameservers {
+		if _, err := d.address(ns); err != nil {
+			return fmt.Errorf("dns: %w", err)
+		}
+	}
+	if (d.TLS || d.ServerName != "") && len(d.Nameservers) == 0 {
+		return fmt.Errorf("dns: tls and serverName require nameservers")
+	}
+	return nil
+}
+
+// address returns ns as host:port, adding the default port for the protocol.
+func (d *DNSConfig) address(ns string) (string, error) {
+	host, port, err := net.SplitHostPort(ns)
+	if err != nil {
+		host, port = ns, "53"
+		if d.TLS {
+			port = "853"
+		}
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("nameserver %s must be an IP address", ns)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// resolver returns a resolver querying the configured nameservers, or nil
+// for the system resolver when none are set.
+func (d *DNSConfig) resolver() *net.Resolver {
+	if len(d.Nameservers) == 0 {
+		return nil
+	}
+	servers := make([]string, 0, len(d.Nameservers))
+	for _, ns := range d.Nameservers {
+		addr, _ := d.address(ns) // checked by Validate
+		servers = append(servers, addr)
+	}
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		// The resolver retries a failed query by dialing again, so each dial
+		// moves on to the next nameserver. Those that refuse a connection
+		// are skipped at once.
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			start := int(next.Add(1) - 1)
+			var err error
+			for i := range servers {
+				server := servers[(start+i)%len(servers)]
+				var conn net.Conn
+				if conn, err = d.dial(ctx, network, server); err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+	}
+}
+
+func (d *DNSConfig) dial(ctx context.Context, network, server string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !d.TLS {
+		return dialer.DialContext(ctx, network, server)
+	}
+	serverName := d.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(server)
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	// The resolver frames queries for TCP on any connection that is not a
+	// net.PacketConn, which is what DNS over TLS expects.
+	return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", server)
+}
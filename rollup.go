@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RollupConfig decides how failing checks add up to the overall status,
+// for hosts where losing one of many redundant checks should not take the
+// server out of service. Checks are grouped into categories by type, and
+// each category passes by its policy in Categories, or when all of its
+// checks pass; the server is healthy when the categories pass by the top
+// level policy. A server whose checks fail without failing those policies
+// is degraded, answered with statusCodes.degraded.
+type RollupConfig struct {
+	RollupPolicy `yaml:",inline"`
+	Categories   map[string]RollupPolicy `yaml:"categories"`
+}
+
+// RollupPolicy is how members add up: passing when all of them pass
+// (policy "all", the default), any of them ("any"), at least Percentage of
+// them ("percentage") or at least Percentage of their weight ("weighted").
+// Weights name members, checks as type/name or by name alone within a
+// category and categories at the top level, and default to 1.
+type RollupPolicy struct {
+	Policy     string             `yaml:"policy"`
+	Percentage float64            `yaml:"percentage"`
+	Weights    map[string]float64 `yaml:"weights"`
+}
+
+// Validate checks the policies, and that categories and weights name
+// configured checks.
+func (c *RollupConfig) Validate(checks []check) error {
+	categories := map[string]bool{"composite": true}
+	for _, chk := range checks {
+		categories[chk.Type] = true
+	}
+	if err := c.RollupPolicy.validate(); err != nil {
+		return fmt.Errorf("rollup: %w", err)
+	}
+	for name := range c.Weights {
+		if !categories[name] {
+			return fmt.Errorf("rollup: weights: no checks are of type %s", name)
+		}
+	}
+	for category, policy := range c.Categories {
+		if !categories[category] {
+			return fmt.Errorf("rollup: categories: no checks are of type %s", category)
+		}
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("rollup: categories: %s: %w", category, err)
+		}
+		refs := make([]string, 0, len(policy.Weights))
+		for ref := range policy.Weights {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		keys, err := resolveCheckRefs(refs, checks)
+		if err != nil {
+			return fmt.Errorf("rollup: categories: %s: weights: %w", category, err)
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, category+"/") {
+				return fmt.Errorf("rollup: categories: %s: weights: %s is not of type %s", category, key, category)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *RollupPolicy) validate() error {
+	switch p.Policy {
+	case "", "all", "any":
+		if p.Percentage != 0 {
+			return fmt.Errorf("percentage is only for the percentage and weighted policies")
+		}
+	case "percentage", "weighted":
+		if p.Percentage <= 0 || p.Percentage > 100 {
+			return fmt.Errorf("percentage must be above 0 and at most 100")
+		}
+	default:
+		return fmt.Errorf("policy must be all, any, percentage or weighted")
+	}
+	if len(p.Weights) > 0 && p.Policy != "weighted" {
+		return fmt.Errorf("weights are only for the weighted policy")
+	}
+	for name, weight := range p.Weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of %s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// met reports whether members, keyed as Weights are, pass by the policy.
+// No members always pass.
+func (p *RollupPolicy) met(members map[string]bool) bool {
+	var count, passing int
+	var total, weight float64
+	for key, ok := range members {
+		w, set := p.Weights[key]
+		if !set {
+			w = 1
+		}
+		count++
+		total += w
+		if ok {
+			passing++
+			weight += w
+		}
+	}
+	if count == 0 {
+		return true
+	}
+	switch p.Policy {
+	case "any":
+		return passing > 0
+	case "percentage":
+		return float64(passing)*100 >= p.Percentage*float64(count)
+	case "weighted":
+		return weight*100 >= p.Percentage*total
+	default:
+		return passing == count
+	}
+}
+
+// evaluate rolls results up into whether the server is healthy, and
+// whether it is degraded: healthy only because the policies tolerate its
+// critical checks. Results left out of the aggregate are not counted.
+func (c *RollupConfig) evaluate(checks []check, results []checkResult) (healthy, degraded bool) {
+	members := map[string]map[string]bool{}
+	for _, r := range results {
+		if r.excluded() {
+			continue
+		}
+		if members[r.Type] == nil {
+			members[r.Type] = map[string]bool{}
+		}
+		key := overrideKey(r.Type, r.Name)
+		members[r.Type][key] = r.Status != statusCritical
+		degraded = degraded || r.Status == statusCritical
+	}
+	categories := map[string]bool{}
+	for category, checkMembers := range members {
+		policy := c.Categories[category]
+		if len(policy.Weights) > 0 {
+			policy.Weights = c.checkWeights(category, checks)
+		}
+		categories[category] = policy.met(checkMembers)
+	}
+	healthy = c.met(categories)
+	return healthy, healthy && degraded
+}
+
+// checkWeights returns the weights of the checks of category, keyed by
+// their override keys.
+func (c *RollupConfig) checkWeights(category string, checks []check) map[string]float64 {
+	weights := map[string]float64{}
+	for ref, weight := range c.Categories[category].Weights {
+		keys, err := resolveCheckRefs([]string{ref}, checks)
+		if err != nil {
+			continue // checked by Validate
+		}
+		weights[keys[0]] = weight
+	}
+	return weights
+}
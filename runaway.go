@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userHZ is the rate of the clock ticks /proc reports CPU time in, 100 on
+// every platform Linux exposes to user space.
+const userHZ = 100
+
+// RunawayCheck looks for processes in trouble: it fails when more than
+// MaxZombies, when set, zombie processes are waiting to be reaped, and
+// when a process has used more than MaxCPU percent of a CPU, or more than
+// MaxMemory percent of memory, for at least For, naming the offenders.
+// CPU use is measured between runs, so For should cover a few intervals.
+// Pattern limits the processes watched for CPU and memory to those whose
+// command line it matches; zombies, which have none, are counted across
+// the system.
+type RunawayCheck struct {
+	Name       string        `yaml:"name"`
+	Pattern    string        `yaml:"pattern"`
+	MaxZombies int           `yaml:"maxZombies"`
+	MaxCPU     float64       `yaml:"maxCPU"`
+	MaxMemory  float64       `yaml:"maxMemory"`
+	For        time.Duration `yaml:"for"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the pattern, limits and duration.
+func (r *RunawayCheck) Validate() error {
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("runaway check %s: invalid pattern: %w", r.Name, err)
+	}
+	if r.MaxZombies < 0 || r.MaxCPU < 0 || r.For < 0 {
+		return fmt.Errorf("runaway check %s: maxZombies, maxCPU and for must not be negative", r.Name)
+	}
+	if r.MaxMemory < 0 || r.MaxMemory > 100 {
+		return fmt.Errorf("runaway check %s: maxMemory must be a percentage", r.Name)
+	}
+	return nil
+}
+
+// processStat is what a check needs of /proc/<pid>/stat.
+type processStat struct {
+	comm   string
+	state  byte
+	ticks  uint64 // user and system CPU time
+	rssKiB uint64
+}
+
+// runawaySample is a process as seen on a check's last run.
+type runawaySample struct {
+	ticks     uint64
+	at        time.Time
+	overSince time.Time // zero while within the limits
+}
+
+var (
+	runawaySamplesMu sync.Mutex
+	runawaySamples   = map[string]map[int]runawaySample{}
+)
+
+func (check RunawayCheck) run(ctx context.Context) checkResult {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return checkFailed("Runaway Name: %s, processes could not be listed: %v", check.Name, err)
+	}
+	memTotal, err := readMemTotal()
+	if err != nil && check.MaxMemory > 0 {
+		return checkFailed("Runaway Name: %s, memory could not be read: %v", check.Name, err)
+	}
+	var pattern *regexp.Regexp
+	var commands map[int]string
+	if check.Pattern != "" {
+		pattern = regexp.MustCompile(check.Pattern) // checked by Validate
+		if commands, err = processCommands(); err != nil {
+			return checkFailed("Runaway Name: %s, processes could not be listed: %v", check.Name, err)
+		}
+	}
+
+	runawaySamplesMu.Lock()
+	defer runawaySamplesMu.Unlock()
+	prev := runawaySamples[check.Name]
+	next := map[int]runawaySample{}
+	now := time.Now()
+	self := os.Getpid()
+	var zombies, offenders []string
+	watched := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		stat, err := readProcessStat(pid)
+		if err != nil {
+			continue // exited while being read
+		}
+		if stat.state == 'Z' {
+			zombies = append(zombies, fmt.Sprintf("%s (pid %d)", stat.comm, pid))
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(commands[pid]) {
+			continue
+		}
+		watched++
+		sample := runawaySample{ticks: stat.ticks, at: now}
+		var readings []string
+		if last, ok := prev[pid]; ok && stat.ticks >= last.ticks && now.After(last.at) {
+			cpu := float64(stat.ticks-last.ticks) / userHZ / now.Sub(last.at).Seconds() * 100
+			if check.MaxCPU > 0 && cpu > check.MaxCPU {
+				readings = append(readings, fmt.Sprintf("CPU %.0f%%", cpu))
+			}
+			sample.overSince = last.overSince
+		}
+		if memTotal > 0 && check.MaxMemory > 0 {
+			if memory := float64(stat.rssKiB) / float64(memTotal) * 100; memory > check.MaxMemory {
+				readings = append(readings, fmt.Sprintf("memory %.0f%%", memory))
+			}
+		}
+		if len(readings) == 0 {
+			sample.overSince = time.Time{}
+		} else if sample.overSince.IsZero() {
+			sample.overSince = now
+		}
+		if len(readings) > 0 && now.Sub(sample.overSince) >= check.For {
+			offenders = append(offenders, fmt.Sprintf("%s (pid %d) %s for %s", stat.comm, pid, strings.Join(readings, " and "), now.Sub(sample.overSince).Round(time.Second)))
+		}
+		next[pid] = sample
+	}
+	runawaySamples[check.Name] = next
+	slices.Sort(zombies)
+	slices.Sort(offenders)
+
+	details := []string{fmt.Sprintf("Processes: %d", watched), fmt.Sprintf("Zombies: %d", len(zombies))}
+	var problems []string
+	if check.MaxZombies > 0 && len(zombies) > check.MaxZombies {
+		shown := zombies
+		if len(shown) > 5 {
+			shown = append(shown[:5:5], "...")
+		}
+		problems = append(problems, fmt.Sprintf("more than %d zombies: %s", check.MaxZombies, strings.Join(shown, ", ")))
+	}
+	if len(offenders) > 0 {
+		problems = append(problems, "runaway: "+strings.Join(offenders, ", "))
+	}
+	if len(problems) > 0 {
+		return checkFailed("Runaway Name: %s, %s, %s", check.Name, strings.Join(details, ", "), strings.Join(problems, "; "))
+	}
+	return checkOK("Runaway Name: %s, %s", check.Name, strings.Join(details, ", "))
+}
+
+// readProcessStat reads a process's name, state, CPU time and resident
+// memory from /proc/<pid>/stat.
+func readProcessStat(pid int) (processStat, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")) // #nosec G304 -- a pid directory under /proc
+	if err != nil {
+		return processStat{}, err
+	}
+	// The name is in parentheses and may itself hold spaces or parentheses.
+	line := string(data)
+	open, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if open < 0 || end < open {
+		return processStat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(line[end+1:])
+	// fields[0] is the state, field 3 of the file, so utime, stime and rss,
+	// fields 14, 15 and 24, are at 11, 12 and 21.
+	if len(fields) < 22 || len(fields[0]) != 1 {
+		return processStat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	pages, _ := strconv.ParseUint(fields[21], 10, 64)
+	return processStat{
+		comm:   line[open+1 : end],
+		state:  fields[0][0],
+		ticks:  utime + stime,
+		rssKiB: pages * uint64(os.Getpagesize()) / 1024,
+	}, nil
+}
+
+// readMemTotal returns the system's memory in KiB from /proc/meminfo.
+func readMemTotal() (uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer closeAndLog(file, "meminfo")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), "MemTotal:"); ok {
+			return strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
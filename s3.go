@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Check sends a SigV4-signed HEAD request for a bucket, or an object in
+// it, on AWS S3 or an S3-compatible store such as MinIO. This verifies
+// reachability and credentials; with MaxAge set the object must also have
+// been modified within that window.
+type S3Check struct {
+	Name   string        `yaml:"name"`
+	Key    string        `yaml:"key"`
+	MaxAge time.Duration `yaml:"maxAge"`
+
+	S3Bucket     `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// S3Bucket is a bucket on AWS S3, in Region, or on the S3-compatible store
+// at Endpoint, and the credentials to sign requests to it with.
+type S3Bucket struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	PathStyle       bool   `yaml:"pathStyle"`
+	AccessKeyID     string `yaml:"accessKeyID"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	SessionToken    string `yaml:"sessionToken"`
+}
+
+// Validate checks the endpoint, bucket and freshness settings.
+func (s *S3Check) Validate() error {
+	if err := s.S3Bucket.Validate(); err != nil {
+		return fmt.Errorf("s3 check %s: %w", s.Name, err)
+	}
+	if s.MaxAge != 0 && s.Key == "" {
+		return fmt.Errorf("s3 check %s: maxAge requires key", s.Name)
+	}
+	return nil
+}
+
+// Validate checks the bucket and endpoint.
+func (b *S3Bucket) Validate() error {
+	if b.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if b.Endpoint != "" {
+		u, err := url.Parse(b.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("endpoint must be an http:// or https:// URL")
+		}
+	}
+	return nil
+}
+
+// s3EmptyPayloadHash is the SHA-256 of an empty request body.
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (check S3Check) run(ctx context.Context) checkResult {
+	target := check.Bucket
+	if check.Key != "" {
+		target += "/" + strings.TrimPrefix(check.Key, "/")
+	}
+	resp, err := check.request(ctx, http.MethodHead, check.Key, nil)
+	if err != nil {
+		return checkFailed("S3 Name: %s, Object: %s is not reachable: %v", check.Name, target, err)
+	}
+	defer closeAndLog(resp.Body, "response body")
+
+	if problem := s3Problem(resp); problem != "" {
+		return checkFailed("S3 Name: %s, Object: %s%s", check.Name, target, problem)
+	}
+
+	if check.MaxAge > 0 {
+		modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			return checkFailed("S3 Name: %s, Object: %s has no valid Last-Modified", check.Name, target)
+		}
+		if age := time.Since(modified); age > check.MaxAge {
+			return checkFailed("S3 Name: %s, Object: %s was last modified %s ago, more than %s", check.Name, target, age.Round(time.Second), check.MaxAge)
+		}
+	}
+	return checkOK("S3 Name: %s, Object: %s is accessible", check.Name, target)
+}
+
+// s3Problem describes an unsuccessful response, following the name of
+// what was requested, or returns an empty string.
+func s3Problem(resp *http.Response) string {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ""
+	case http.StatusForbidden:
+		return ", access denied"
+	case http.StatusNotFound:
+		return " does not exist"
+	case http.StatusMovedPermanently:
+		return ", bucket is in region " + resp.Header.Get("X-Amz-Bucket-Region")
+	default:
+		return " returned " + resp.Status
+	}
+}
+
+// request sends a signed request for key, or the bucket itself when key is
+// empty, with query.
+func (b S3Bucket) request(ctx context.Context, method, key string, query url.Values) (*http.Response, error) {
+	region := b.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	path := "/"
+	if key != "" {
+		path += strings.TrimPrefix(key, "/")
+	}
+	if b.PathStyle {
+		path = "/" + b.Bucket + strings.TrimSuffix(path, "/")
+	} else {
+		u.Host = b.Bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = s3URIEscape(path)
+	// SigV4 signs the query as sorted, RFC 3986-encoded pairs.
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	accessKey, secretKey, token := b.AccessKeyID, b.SecretAccessKey, b.SessionToken
+	if accessKey == "" {
+		accessKey, secretKey, token = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKey != "" {
+		signAWSRequest(req, "s3", region, s3EmptyPayloadHash, awsCredentials{accessKey, secretKey, token}, time.Now().UTC())
+	}
+
+	client := checkClient(ctx, u.Scheme == "https")
+	// A wrong-region 301 is reported rather than followed.
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return noRedirect.Do(req)
+}
+
+// s3URIEscape percent-encodes everything except unreserved characters and
+// '/', as SigV4 requires for S3 object keys.
+func s3URIEscape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SandboxConfig limits the commands checks run, so one that misbehaves
+// cannot take down the host or the daemon. Timeout kills a command that
+// runs longer, with any processes it started. CPUTime and MemoryBytes set
+// RLIMIT_CPU and RLIMIT_AS. Commands get only a minimal PATH and the C
+// locale, plus Environment, rather than the daemon's environment and any
+// secrets in it. NoNewPrivileges stops setuid programs from gaining rights,
+// and Seccomp also denies system calls no check needs, such as ptrace,
+// mount and module loading; both rule out config.privileges, which relies
+// on sudo.
+type SandboxConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	Timeout         time.Duration     `yaml:"timeout"`
+	CPUTime         time.Duration     `yaml:"cpuTime"`
+	MemoryBytes     int64             `yaml:"memoryBytes"`
+	Environment     map[string]string `yaml:"environment"`
+	NoNewPrivileges bool              `yaml:"noNewPrivileges"`
+	Seccomp         bool              `yaml:"seccomp"`
+}
+
+// checkSandbox is config.sandbox; main sets it.
+var checkSandbox SandboxConfig
+
+// sandboxFlag runs the process as the shim that applies the limits to
+// itself and then executes the command in its place.
+const sandboxFlag = "-sandbox-exec"
+
+// Validate checks the limits and that the restrictions asked for are
+// available and compatible with privileges.
+func (c *SandboxConfig) Validate(privileges PrivilegeConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Timeout < 0 || c.CPUTime < 0 || c.MemoryBytes < 0 {
+		return fmt.Errorf("sandbox: timeout, cpuTime and memoryBytes must not be negative")
+	}
+	for key := range c.Environment {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("sandbox: invalid environment variable %q", key)
+		}
+	}
+	if (c.NoNewPrivileges || c.Seccomp) && privileges.Enabled {
+		return fmt.Errorf("sandbox: noNewPrivileges and seccomp stop sudo from working, so they cannot be used with privileges")
+	}
+	if err := sandboxSupported(c.Seccomp); err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	return nil
+}
+
+// environment returns the variables commands run with, sorted.
+func (c *SandboxConfig) environment() []string {
+	vars := map[string]string{
+		"PATH":   "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"LC_ALL": "C",
+	}
+	for key, value := range c.Environment {
+		vars[key] = value
+	}
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// runSandboxShim is the shim's main: it applies the limits in args, which
+// end with "--" and the command, and executes the command. It only returns
+// by exiting.
+func runSandboxShim(args []string) {
+	var limits sandboxLimits
+	for len(args) > 0 && args[0] != "--" {
+		key, value, _ := strings.Cut(args[0], "=")
+		switch key {
+		case "cpu":
+			limits.cpuSeconds, _ = strconv.ParseUint(value, 10, 64)
+		case "memory":
+			limits.memoryBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "nnp":
+			limits.noNewPrivileges = true
+		case "seccomp":
+			limits.seccomp = true
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command given")
+		os.Exit(2)
+	}
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+	if err := limits.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+	err = syscall.Exec(path, args[1:], os.Environ()) // #nosec G204 -- args are one of checkCommands, from commandOutput
+	fmt.Fprintf(os.Stderr, "sandbox: %s: %v\n", path, err)
+	os.Exit(126)
+}
+
+// sandboxLimits are the restrictions the shim applies to itself.
+type sandboxLimits struct {
+	cpuSeconds      uint64
+	memoryBytes     uint64
+	noNewPrivileges bool
+	seccomp         bool
+}
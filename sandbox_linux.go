@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompArches are the audit architectures seccomp filters check for,
+// keyed by GOARCH.
+var seccompArches = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+// deniedSyscalls fail with EPERM under seccomp: no check needs them, and
+// they could inspect the daemon or change the host.
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_UNSHARE,
+	unix.SYS_SETNS, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_REBOOT,
+	unix.SYS_KEXEC_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE, unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN,
+}
+
+func sandboxSupported(seccomp bool) error {
+	if _, ok := seccompArches[runtime.GOARCH]; seccomp && !ok {
+		return fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+	return nil
+}
+
+// command returns the command to run argv under the sandbox: the shim,
+// given the limits and argv, in its own process group.
+func (c *SandboxConfig) command(ctx context.Context, argv []string) *exec.Cmd {
+	shim := []string{sandboxFlag}
+	if c.CPUTime > 0 {
+		// RLIMIT_CPU counts whole seconds; round up so short limits hold.
+		shim = append(shim, "cpu="+strconv.FormatInt(int64((c.CPUTime+time.Second-1)/time.Second), 10))
+	}
+	if c.MemoryBytes > 0 {
+		shim = append(shim, "memory="+strconv.FormatInt(c.MemoryBytes, 10))
+	}
+	if c.NoNewPrivileges {
+		shim = append(shim, "nnp")
+	}
+	if c.Seccomp {
+		shim = append(shim, "seccomp")
+	}
+	shim = append(append(shim, "--"), argv...)
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", shim...)
+	cmd.Env = c.environment()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Children left holding the output pipes must not hold the check.
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// apply sets the limits on the calling thread's process. no_new_privs and
+// seccomp are per thread, so the thread is locked for the exec that
+// follows.
+func (l sandboxLimits) apply() error {
+	runtime.LockOSThread()
+	if l.noNewPrivileges || l.seccomp {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("no_new_privs: %w", err)
+		}
+	}
+	if l.seccomp {
+		if err := installSeccompFilter(); err != nil {
+			return fmt.Errorf("seccomp: %w", err)
+		}
+	}
+	// The address space limit comes last: the shim itself may not fit it.
+	if l.cpuSeconds > 0 {
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: l.cpuSeconds, Max: l.cpuSeconds + 1}); err != nil {
+			return fmt.Errorf("cpu limit: %w", err)
+		}
+	}
+	if l.memoryBytes > 0 {
+		if err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: l.memoryBytes, Max: l.memoryBytes}); err != nil {
+			return fmt.Errorf("memory limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// installSeccompFilter loads a BPF filter that kills the process on a
+// foreign architecture, fails deniedSyscalls (and x32 calls on amd64) with
+// EPERM, and allows everything else.
+func installSeccompFilter() error {
+	const (
+		ld  = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		ret = unix.BPF_RET | unix.BPF_K
+
+		// Offsets of nr and arch in struct seccomp_data.
+		nrOffset   = 0
+		archOffset = 4
+		x32Bit     = 0x40000000
+	)
+	deny := unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)}
+	filter := []unix.SockFilter{
+		{Code: ld, K: archOffset},
+		{Code: jeq, Jt: 1, K: seccompArches[runtime.GOARCH]},
+		{Code: ret, K: unix.SECCOMP_RET_KILL_PROCESS},
+		{Code: ld, K: nrOffset},
+	}
+	if runtime.GOARCH == "amd64" {
+		filter = append(filter, unix.SockFilter{Code: jge, Jf: 1, K: x32Bit}, deny)
+	}
+	for _, nr := range deniedSyscalls {
+		filter = append(filter, unix.SockFilter{Code: jeq, Jf: 1, K: nr}, deny)
+	}
+	filter = append(filter, unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ALLOW})
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]} // #nosec G115 -- the filter has a few dozen instructions
+
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0) // #nosec G103 -- prctl takes the filter by pointer
+}
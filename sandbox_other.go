@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+func sandboxSupported(bool) error {
+	return fmt.Errorf("sandbox is only supported on Linux")
+}
+
+func (l sandboxLimits) apply() error {
+	return sandboxSupported(l.seccomp)
+}
+
+// command runs argv directly; Validate keeps the sandbox from being enabled
+// here.
+func (c *SandboxConfig) command(ctx context.Context, argv []string) *exec.Cmd {
+	return exec.CommandContext(ctx, argv[0], argv[1:]...) // #nosec G204 -- argv is one of checkCommands
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// resultCache holds the latest results of checks run in the background, so
+// /healthy can answer without running every check on each probe.
+type resultCache struct {
+	mu      sync.RWMutex
+	results []checkResult
+}
+
+// schedule controls when checks run in the background.
+type schedule struct {
+	interval time.Duration
+	// jitter is the most each run is delayed at random.
+	jitter time.Duration
+	// spread offsets the first background run of each check by a random
+	// part of its interval, so checks started together drift apart.
+	spread bool
+}
+
+// startScheduler runs the checks returned by checks once, then again in
+// the background whenever each is due: every interval unless the check sets
+// its own interval or a cron schedule. It returns the cache the results are
+// stored in. The first run completes before it returns so the API never
+// serves an empty result set. After that each check runs on its own, its
+// result stored as soon as it finishes, so a slow check holds back no other;
+// a run still going when the check is next due is cut short and reported as
+// timed out. cycle, when not nil, is called with the cache after every
+// result is stored. Once ctx ends no more checks are started, those running
+// are cancelled and their results are dropped, keeping the cache as it was.
+func startScheduler(ctx context.Context, checks func() []check, sched schedule, cycle func(*resultCache)) *resultCache {
+	cache := &resultCache{results: runChecks(ctx, checks())}
+	if cycle != nil {
+		cycle(cache)
+	}
+	go func() {
+		planned := map[string]plannedRun{}
+		running := map[string]bool{}
+		finished := make(chan string)
+		for ctx.Err() == nil {
+			due, wake := sched.due(checks(), cache.get(), planned, running, time.Now())
+			for _, c := range due {
+				key := overrideKey(c.Type, c.Name)
+				running[key] = true
+				go func() {
+					runCtx, cancel := context.WithDeadline(ctx, nextRun(c, time.Now(), sched.interval))
+					defer cancel()
+					results := runChecks(runCtx, []check{c})
+					if ctx.Err() == nil {
+						cache.update(checks(), results)
+					}
+					select {
+					case finished <- key:
+					case <-ctx.Done():
+					}
+				}()
+			}
+			select {
+			case key := <-finished:
+				delete(running, key)
+				checkActivity.cycled()
+				if cycle != nil {
+					cycle(cache)
+				}
+			case <-time.After(time.Until(wake)):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return cache
+}
+
+// plannedRun is when a check runs next, planned from the result it last
+// produced at from.
+type plannedRun struct {
+	from, at time.Time
+}
+
+// due returns the checks due to run at now and when the next of the others
+// falls due, leaving out those still running. Each check's next run is
+// planned once per result, so a result from a run outside the schedule
+// starts a new interval. Checks without a result are due at once.
+func (s schedule) due(checks []check, results []checkResult, planned map[string]plannedRun, running map[string]bool, now time.Time) ([]check, time.Time) {
+	last := map[string]time.Time{}
+	for _, r := range results {
+		last[overrideKey(r.Type, r.Name)] = r.Checked
+	}
+	var due []check
+	wake := now.Add(s.interval)
+	for _, c := range checks {
+		key := overrideKey(c.Type, c.Name)
+		if running[key] {
+			continue
+		}
+		checked, ok := last[key]
+		if !ok {
+			due = append(due, c)
+			continue
+		}
+		run, ok := planned[key]
+		if !ok || !run.from.Equal(checked) {
+			first := !ok
+			run = plannedRun{from: checked, at: nextRun(c, checked, s.interval)}
+			if first && s.spread && c.Options.Schedule == "" {
+				run.at = checked.Add(randDuration(checkInterval(c, s.interval)))
+			}
+			run.at = run.at.Add(randDuration(s.jitter))
+			planned[key] = run
+		}
+		switch {
+		case !run.at.After(now):
+			due = append(due, c)
+		case run.at.Before(wake):
+			wake = run.at
+		}
+	}
+	for key := range planned {
+		if _, ok := last[key]; !ok {
+			delete(planned, key)
+		}
+	}
+	return due, wake
+}
+
+// randDuration returns a random duration in [0, limit).
+func randDuration(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit) // #nosec G404 -- scheduling jitter, not security sensitive
+}
+
+// checkInterval returns how often c runs in the background.
+func checkInterval(c check, interval time.Duration) time.Duration {
+	if c.Options.Interval > 0 {
+		return c.Options.Interval
+	}
+	return interval
+}
+
+// nextRun returns when c runs after a run at from: when its cron schedule
+// next matches, otherwise a whole interval later.
+func nextRun(c check, from time.Time, interval time.Duration) time.Time {
+	if c.Options.Schedule != "" {
+		if cron, err := parseCron(c.Options.Schedule); err == nil {
+			return cron.next(from)
+		}
+	}
+	return from.Add(checkInterval(c, interval))
+}
+
+// get returns a copy of the latest results.
+func (c *resultCache) get() []checkResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]checkResult(nil), c.results...)
+}
+
+// set replaces every cached result.
+func (c *resultCache) set(results []checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = results
+}
+
+// update stores fresh results and orders the cache by current, dropping
+// results of checks that no longer exist.
+func (c *resultCache) update(current []check, fresh []checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	latest := map[string]checkResult{}
+	for _, r := range c.results {
+		latest[overrideKey(r.Type, r.Name)] = r
+	}
+	for _, r := range fresh {
+		latest[overrideKey(r.Type, r.Name)] = r
+	}
+	results := make([]checkResult, 0, len(current))
+	for _, check := range current {
+		if r, ok := latest[overrideKey(check.Type, check.Name)]; ok {
+			results = append(results, r)
+		}
+	}
+	c.results = results
+}
+
+// store replaces the cached results of the same checks with fresh ones run
+// outside the schedule, adding results for checks not yet cached.
+func (c *resultCache) store(fresh []checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range fresh {
+		found := false
+		for i, r := range c.results {
+			if r.Type == f.Type && r.Name == f.Name {
+				c.results[i], found = f, true
+			}
+		}
+		if !found {
+			c.results = append(c.results, f)
+		}
+	}
+}
+
+// remove drops the cached result of a check that no longer exists.
+func (c *resultCache) remove(typ, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := c.results[:0:0]
+	for _, r := range c.results {
+		if r.Type != typ || r.Name != name {
+			results = append(results, r)
+		}
+	}
+	c.results = results
+}
+
+// staleAfter returns when results go out of date, along with the result
+// that goes first: each is allowed maxAge, plus the extra time until its
+// next run for checks running less often than config.interval.
+func staleAfter(results []checkResult, checks []check, interval, maxAge time.Duration) (time.Time, checkResult) {
+	byKey := map[string]check{}
+	for _, c := range checks {
+		byKey[overrideKey(c.Type, c.Name)] = c
+	}
+	var first time.Time
+	var firstResult checkResult
+	for _, r := range results {
+		limit := maxAge
+		if c, ok := byKey[overrideKey(r.Type, r.Name)]; ok {
+			if extra := nextRun(c, r.Checked, interval).Sub(r.Checked) - interval; extra > 0 {
+				limit += extra
+			}
+		}
+		if t := r.Checked.Add(limit); first.IsZero() || t.Before(first) {
+			first, firstResult = t, r
+		}
+	}
+	return first, firstResult
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configSchemaJSON is the JSON Schema config files are validated against,
+// generated from the config types by -schema; regenerate it with
+// "make schema" whenever they change.
+//
+//go:embed config.schema.json
+var configSchemaJSON []byte
+
+// configSchemaID is where the schema is published, for editors.
+const configSchemaID = "https://raw.githubusercontent.com/digitalis-io/server-health-api/main/config.schema.json"
+
+// durationPattern matches Go durations, such as 10s or 1h30m, as the config
+// takes them.
+const durationPattern = `^(0|-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+// configError is a problem with the config, at path, keys and list indexes
+// joined by dots, when known, and the line it was found on, when known.
+type configError struct {
+	path string
+	line int
+	err  error
+}
+
+func (e configError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("line %d: %v", e.line, e.err)
+	}
+	return e.err.Error()
+}
+
+func (e configError) Unwrap() error {
+	return e.err
+}
+
+// configErrors are every problem found with a config, so they can be
+// fixed in one go rather than one restart at a time.
+type configErrors []configError
+
+// add records err, if not nil, as being about path.
+func (e *configErrors) add(path string, err error) {
+	if err != nil {
+		*e = append(*e, configError{path: path, err: err})
+	}
+}
+
+// err returns e as an error, or nil when it is empty.
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e configErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, ce := range e {
+		msgs[i] = "\n  " + ce.Error()
+	}
+	return fmt.Sprintf("%d problems:%s", len(e), strings.Join(msgs, ""))
+}
+
+func (e configErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, ce := range e {
+		errs[i] = ce
+	}
+	return errs
+}
+
+// withLines sets the line of each error from lines, as made by yamlLines,
+// and orders them by line.
+func (e configErrors) withLines(lines map[string]int) configErrors {
+	for i := range e {
+		e[i].line = lineOf(lines, e[i].path)
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		return e[i].line > 0 && (e[j].line == 0 || e[i].line < e[j].line)
+	})
+	return e
+}
+
+// configSchema builds the JSON Schema of the config file from its types.
+func configSchema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	root := typeSchema(reflect.TypeOf(Config{}), defs)
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     configSchemaID,
+		"title":   "server-health-api configuration",
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+}
+
+// typeSchema returns the schema of values of t, adding named structs to
+// defs and referring to them there. Strings also take numbers and booleans,
+// which YAML decodes into them as written, so thresholds can be unquoted.
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), defs)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": []string{"string", "number", "boolean"}}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return objectSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // stops recursion into types that refer to themselves
+			defs[t.Name()] = objectSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// objectSchema returns the schema of the struct t, with the fields of
+// inline structs as its own.
+func objectSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			switch {
+			case key == "-":
+				continue
+			case opts == "inline":
+				collect(field.Type)
+				continue
+			case key == "":
+				key = strings.ToLower(field.Name)
+			}
+			properties[key] = typeSchema(field.Type, defs)
+		}
+	}
+	collect(t)
+	return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// schemaValidator validates decoded YAML against a JSON Schema, supporting
+// the keywords configSchema uses.
+type schemaValidator struct {
+	defs map[string]interface{}
+}
+
+// validateConfigSchema validates doc, a config file decoded as YAML, against
+// the embedded schema.
+func validateConfigSchema(doc interface{}) (configErrors, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(configSchemaJSON, &schema); err != nil {
+		return nil, fmt.Errorf("invalid config schema: %w", err)
+	}
+	defs, _ := schema["$defs"].(map[string]interface{})
+	var errs configErrors
+	schemaValidator{defs: defs}.validate(schema, doc, "", &errs)
+	return errs, nil
+}
+
+func (v schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, errs *configErrors) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		v.validate(def, value, path, errs)
+		return
+	}
+	if value == nil {
+		// An empty value leaves the field unset.
+		return
+	}
+	kind := yamlKind(value)
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !typeAllowed(types, kind) {
+		errs.add(path, fmt.Errorf("%s: must be %s, not %s", displayPath(path), strings.Join(types, " or "), kind))
+		return
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, isString := value.(string); isString && !regexp.MustCompile(pattern).MatchString(s) {
+			errs.add(path, fmt.Errorf("%s: invalid value %q", displayPath(path), s))
+		}
+	}
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(value))
+		byKey := map[string]interface{}{}
+		for k, x := range value {
+			key := fmt.Sprint(k)
+			keys = append(keys, key)
+			byKey[key] = x
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sub := joinPath(path, key)
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				v.validate(property, byKey[key], sub, errs)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs.add(sub, fmt.Errorf("%s: unknown field %q", displayPath(path), key))
+				}
+			case map[string]interface{}:
+				v.validate(additional, byKey[key], sub, errs)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, x := range value {
+				v.validate(items, x, joinPath(path, strconv.Itoa(i)), errs)
+			}
+		}
+	}
+}
+
+// yamlKind returns the JSON Schema type of a decoded YAML value.
+func yamlKind(value interface{}) string {
+	switch value.(type) {
+	case map[interface{}]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// schemaTypes returns the types a schema's type keyword allows.
+func schemaTypes(t interface{}) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, x := range t {
+			types = append(types, fmt.Sprint(x))
+		}
+		return types
+	default:
+		return nil
+	}
+}
+
+// typeAllowed reports whether kind is one of types, counting integers as
+// numbers.
+func typeAllowed(types []string, kind string) bool {
+	for _, t := range types {
+		if t == kind || t == "number" && kind == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// displayPath names path in messages, the top of the file being the root.
+func displayPath(path string) string {
+	if path == "" {
+		return "config file"
+	}
+	return path
+}
+
+// configSchemaHandler serves the embedded config schema, for editors and
+// tooling.
+func configSchemaHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	if _, err := w.Write(configSchemaJSON); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// yamlLines maps the paths in a YAML document to the lines they start on,
+// for error messages. It follows block style by indentation; paths inside
+// flow collections such as [a, b] are not listed, and lineOf gives the line
+// of the collection instead.
+func yamlLines(data []byte) map[string]int {
+	type frame struct {
+		indent int
+		path   string
+		item   bool
+	}
+	lines := map[string]int{}
+	items := map[string]int{}
+	var stack []frame
+	blockIndent := -1
+	for n, text := range strings.Split(string(data), "\n") {
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+		content = strings.TrimRight(content, " \r")
+		if blockIndent >= 0 {
+			if content == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+		for content != "" {
+			parent := ""
+			if content == "-" || strings.HasPrefix(content, "- ") {
+				for len(stack) > 0 && (stack[len(stack)-1].indent > indent || stack[len(stack)-1].indent == indent && stack[len(stack)-1].item) {
+					stack = stack[:len(stack)-1]
+				}
+				if len(stack) > 0 {
+					parent = stack[len(stack)-1].path
+				}
+				path := joinPath(parent, strconv.Itoa(items[parent]))
+				items[parent]++
+				lines[path] = n + 1
+				stack = append(stack, frame{indent: indent, path: path, item: true})
+				rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+				indent += len(content) - len(rest)
+				content = rest
+				if isBlockScalar(content) {
+					blockIndent = indent - 1
+					break
+				}
+				continue
+			}
+			key, value, ok := yamlKey(content)
+			if !ok {
+				break
+			}
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1].path
+			}
+			path := joinPath(parent, key)
+			lines[path] = n + 1
+			stack = append(stack, frame{indent: indent, path: path})
+			if isBlockScalar(value) {
+				blockIndent = indent
+			}
+			break
+		}
+	}
+	return lines
+}
+
+// yamlKey splits a block mapping entry, such as name: web, into its key and
+// value.
+func yamlKey(content string) (key, value string, ok bool) {
+	if q := content[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(content[1:], q)
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest := content[1:end+1], content[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	if strings.HasSuffix(content, ":") {
+		return content[:len(content)-1], "", true
+	}
+	key, value, ok = strings.Cut(content, ": ")
+	return key, strings.TrimSpace(value), ok
+}
+
+// isBlockScalar reports whether a value starts a literal or folded block,
+// whose lines follow, indented.
+func isBlockScalar(value string) bool {
+	value, _, _ = strings.Cut(value, " #")
+	return strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">")
+}
+
+// lineOf returns the line of path, or of its nearest parent listed in
+// lines, or zero.
+func lineOf(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ScoreConfig reports a health score from 0 to 100, for load balancers
+// that weigh servers rather than take them in or out: the share of the
+// checks' weight passing, warnings counting half, as HAProxy agent weights
+// count them. Checks weigh what config.rollup's weights give them, their
+// category's weight times their own, and 1 where none are set; those left
+// out of the aggregate, such as disabled checks and members of composites,
+// are not counted. With Threshold set, the server is healthy when the score
+// is at least Threshold, and degraded when it is healthy with critical
+// checks. Threshold decides health in place of rollup policies, so it
+// cannot be combined with them.
+type ScoreConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Threshold float64 `yaml:"threshold"`
+}
+
+// Validate checks the threshold, and that rollup sets no policy it would
+// replace.
+func (c *ScoreConfig) Validate(rollup *RollupConfig) error {
+	if c.Threshold < 0 || c.Threshold > 100 {
+		return fmt.Errorf("score: threshold must be between 0 and 100")
+	}
+	if c.Threshold > 0 && !c.Enabled {
+		return fmt.Errorf("score: threshold requires enabled")
+	}
+	if c.Threshold > 0 && ((rollup.Policy != "" && rollup.Policy != "all") || len(rollup.Categories) > 0) {
+		return fmt.Errorf("score: threshold cannot be combined with rollup policies, use the weighted policy instead")
+	}
+	return nil
+}
+
+// apply sets the score of report, from results of checks weighed by
+// rollup, and with a threshold whether it is healthy and degraded.
+func (c *ScoreConfig) apply(report *healthReport, rollup *RollupConfig, checks []check, results []checkResult) {
+	score := healthScore(rollup, checks, results)
+	report.score = score
+	if !c.Enabled {
+		return
+	}
+	report.Score = &score
+	if c.Threshold > 0 {
+		report.Healthy = score >= c.Threshold
+		report.Degraded = report.Healthy && worstStatus(results) == statusCritical
+	}
+}
+
+// healthScore is the share of the weight of results passing, as a
+// percentage to one decimal place, weighing each as rollup does. It is 100
+// without results counted.
+func healthScore(rollup *RollupConfig, checks []check, results []checkResult) float64 {
+	weights := map[string]map[string]float64{}
+	var total, passing float64
+	for _, r := range results {
+		if r.excluded() {
+			continue
+		}
+		if _, ok := weights[r.Type]; !ok {
+			weights[r.Type] = rollup.checkWeights(r.Type, checks)
+		}
+		weight, ok := weights[r.Type][overrideKey(r.Type, r.Name)]
+		if !ok {
+			weight = 1
+		}
+		if category, ok := rollup.Weights[r.Type]; ok {
+			weight *= category
+		}
+		total += weight
+		switch r.Status {
+		case statusOK:
+			passing += weight
+		case statusWarning:
+			passing += weight / 2
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return math.Round(1000*passing/total) / 10
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, such as READY=1, to the service manager over
+// $NOTIFY_SOCKET. It does nothing unless the daemon runs as a systemd
+// service with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// An abstract socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(conn, "notify socket")
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often to ping the systemd watchdog: half of
+// WatchdogSec, as systemd recommends, or zero when the watchdog is not
+// enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// startWatchdog pings the systemd watchdog until the returned function is
+// called. With checks running in the background, pings stop once the
+// cached results go stale, so systemd restarts a daemon whose scheduler
+// has wedged rather than one that is merely idle.
+func startWatchdog(live *liveConfig, cache *resultCache) func() {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if schedulerProgressing(live.current(), cache) {
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					log.Printf("watchdog: %v", err)
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Pinging the systemd watchdog every %s", interval)
+	return func() { close(done) }
+}
+
+// schedulerProgressing reports whether the scheduler's results are
+// current, allowing maxAge or two intervals as /healthy does. It is always
+// true when checks run on request.
+func schedulerProgressing(config *Config, cache *resultCache) bool {
+	if cache == nil {
+		return true
+	}
+	maxAge := config.Config.MaxAge
+	if maxAge == 0 {
+		maxAge = 2 * config.Config.Interval
+	}
+	results := cache.get()
+	if len(results) == 0 {
+		return true
+	}
+	stale, first := staleAfter(results, currentChecks(config), config.Config.Interval, maxAge)
+	if time.Now().After(stale) {
+		log.Printf("watchdog: not pinging, %s check %s last ran at %s", first.Type, first.Name, first.Checked.Format(time.RFC3339))
+		return false
+	}
+	return true
+}
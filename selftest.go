@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// selftestCommand is the subcommand that checks the host can run the
+// configured checks.
+const selftestCommand = "selftest"
+
+// prerequisite is something a check needs of the host to be able to pass,
+// such as a command or a readable file, with a test of whether it has it.
+type prerequisite struct {
+	what string
+	test func() error
+}
+
+// runSelftest runs "server-health-api selftest": it reads the config and
+// reports the checks that cannot possibly succeed on this host, because a
+// command they run is missing, a file they read cannot be, or a socket
+// they talk to does not answer. Checks are not run, so what it finds is
+// about the host rather than the services. It returns the exit status:
+// 1 when a check or the config cannot be used, 0 otherwise.
+func runSelftest(args []string) int {
+	flags := flag.NewFlagSet(selftestCommand, flag.ExitOnError)
+	configFilePath := flags.String("config", GetEnv("HEALTHCHECK_CONFIG_FILE", "config.yaml"), "Path to the config file")
+	flags.Var(&configSettings, "set", "Set a config field, as path=value, over the file and environment; repeatable")
+	_ = flags.Parse(args) // exits on error
+	config, err := readConfig(*configFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+		return 1
+	}
+	configureChecks(&config.Config)
+
+	var failed, checked int
+	report := func(key string, prereqs []prerequisite) {
+		checked++
+		var problems []string
+		for _, p := range prereqs {
+			if err := p.test(); err != nil {
+				problems = append(problems, p.what+": "+err.Error())
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("ok    %s\n", key)
+			return
+		}
+		failed++
+		fmt.Printf("FAIL  %s: %s\n", key, strings.Join(problems, "; "))
+	}
+	report("config", config.Config.prerequisites())
+	prereqs := config.prerequisites()
+	for _, chk := range config.checks() {
+		key := overrideKey(chk.Type, chk.Name)
+		if !chk.Options.OnlyIf.applies() {
+			fmt.Printf("skip  %s: onlyIf does not match this host\n", key)
+			continue
+		}
+		needs := prereqs[key]
+		if action := chk.Options.OnFailure; action != nil {
+			needs = append(needs, needsExecutable(action.Command[0]))
+		}
+		report(key, needs)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d cannot succeed on this host\n", failed, checked)
+		return 1
+	}
+	fmt.Printf("all %d can run on this host\n", checked)
+	return 0
+}
+
+// prerequisites returns what the files the config section names need.
+func (c *AppConfig) prerequisites() []prerequisite {
+	var prereqs []prerequisite
+	if c.SSL.Enabled {
+		prereqs = append(prereqs, needsFile(c.SSL.CertFile), needsFile(c.SSL.KeyFile))
+	}
+	if c.Signing.Enabled && c.Signing.KeyFile != "" {
+		prereqs = append(prereqs, needsFile(c.Signing.KeyFile))
+	}
+	return prereqs
+}
+
+// prerequisites returns what each configured check needs of the host,
+// keyed by its override key. Checks that only talk to other hosts need
+// nothing.
+func (c *Config) prerequisites() map[string][]prerequisite {
+	prereqs := map[string][]prerequisite{}
+	add := func(checkType, name string, p ...prerequisite) {
+		key := overrideKey(checkType, name)
+		prereqs[key] = append(prereqs[key], p...)
+	}
+	for _, port := range c.Ports {
+		for _, p := range port.targets() {
+			if path, ok := unixSocket(p.Address); ok {
+				add("port", p.Name, needsSocket(path))
+			}
+			if p.Mode == "listening" {
+				protocol := p.Protocol
+				if protocol == "" {
+					protocol = "tcp"
+				}
+				add("port", p.Name, needsFile("/proc/net/"+protocol))
+			}
+		}
+	}
+	for _, endpoint := range c.Endpoints {
+		for _, e := range endpoint.targets() {
+			if e.Socket != "" {
+				path, _ := unixSocket(e.Socket)
+				add("endpoint", e.Name, needsSocket(path))
+			}
+			if e.Certificate != nil && e.Certificate.CAFile != "" {
+				add("endpoint", e.Name, needsFile(e.Certificate.CAFile))
+			}
+		}
+	}
+	if runtime.GOOS != "windows" {
+		for _, s := range c.Services {
+			add("service", s.Name, needsServiceManager())
+		}
+	}
+	for _, k := range c.Kubernetes {
+		if k.Kubeconfig != "" {
+			add("kubernetes", k.Name, needsFile(k.Kubeconfig))
+		} else {
+			add("kubernetes", k.Name, needsFile(inClusterTokenFile))
+		}
+	}
+	for _, j := range c.Journald {
+		add("journald", j.Name, needsCommand("journalctl"), needsJournal())
+	}
+	for _, l := range c.Logs {
+		add("logs", l.Name, needsFile(l.Path))
+	}
+	for _, i := range c.Interfaces {
+		add("interface", i.Name, needsPath(filepath.Join(sysClassNet, i.Interface)))
+	}
+	for _, g := range c.Gateways {
+		if g.Family == "ipv6" {
+			add("gateway", g.Name, needsFile("/proc/net/ipv6_route"))
+		} else {
+			add("gateway", g.Name, needsFile("/proc/net/route"))
+		}
+		if g.Method == "" || g.Method == "ping" {
+			add("gateway", g.Name, needsCommand("ping"))
+		}
+	}
+	for _, f := range c.Firewall {
+		command := "nft"
+		if f.Backend != "" && f.Backend != "nftables" {
+			command = f.Backend + "-save"
+		}
+		add("firewall", f.Name, needsCommand(command), needsRoot(command))
+	}
+	for _, s := range c.SecurityModules {
+		if s.SELinux != "" {
+			add("securityModule", s.Name, needsFile(selinuxEnforceFile))
+		}
+		if s.AppArmorProfile != "" || s.AppArmorMode != "" {
+			add("securityModule", s.Name, needsFile(apparmorProfiles))
+		}
+	}
+	for _, s := range c.Sysctl {
+		add("sysctl", s.Name, needsFile(filepath.Join("/proc/sys", strings.ReplaceAll(s.Key, ".", "/"))))
+	}
+	for _, p := range c.Packages {
+		switch p.Manager {
+		case "dpkg":
+			add("package", p.Name, needsCommand("dpkg-query"))
+		case "rpm":
+			add("package", p.Name, needsCommand("rpm"))
+		default:
+			add("package", p.Name, needsEither(needsCommand("dpkg-query"), needsCommand("rpm")))
+		}
+	}
+	for _, t := range c.Temperatures {
+		add("temperature", t.Name, needsPath(hwmonDir))
+	}
+	for _, g := range c.GPUs {
+		add("gpu", g.Name, needsCommand("nvidia-smi"))
+	}
+	for _, z := range c.ZFS {
+		add("zfs", z.Name, needsCommand("zpool"))
+	}
+	for _, s := range c.SSH {
+		if s.PrivateKeyFile != "" {
+			add("ssh", s.Name, needsFile(s.PrivateKeyFile))
+		}
+	}
+	for _, e := range c.Etcd {
+		for _, file := range []string{e.CAFile, e.CertFile, e.KeyFile} {
+			if file != "" {
+				add("etcd", e.Name, needsFile(file))
+			}
+		}
+	}
+	for _, s := range c.Shares {
+		add("share", s.Name, needsPath(s.Path))
+	}
+	for _, d := range c.Disks {
+		add("disk", d.Name, needsPath(d.Path))
+	}
+	for _, cg := range c.Cgroups {
+		add("cgroup", cg.Name, needsPath(cgroupRoot))
+	}
+	for _, j := range c.CronJobs {
+		for _, file := range []string{j.StateFile, j.LogFile} {
+			if file != "" {
+				add("cronjob", j.Name, needsFile(file))
+			}
+		}
+	}
+	return prereqs
+}
+
+// needsCommand requires a command checks run, through sudo when
+// config.privileges says so.
+func needsCommand(name string) prerequisite {
+	return prerequisite{what: name, test: func() error {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return fmt.Errorf("not found in PATH")
+		}
+		if !checkPrivileges.escalates(name) {
+			return nil
+		}
+		// -l with a command only succeeds when a rule allows it, and -n
+		// fails rather than prompting for a password.
+		if err := exec.Command("sudo", "-n", "-l", "--", path).Run(); err != nil { // #nosec G204 -- path is one of checkCommands
+			return fmt.Errorf("no sudo rule lets this user run it without a password")
+		}
+		return nil
+	}}
+}
+
+// needsExecutable requires a command from the config, such as a
+// remediation, to be found and executable.
+func needsExecutable(name string) prerequisite {
+	return prerequisite{what: name, test: func() error {
+		if _, err := exec.LookPath(name); err != nil {
+			return fmt.Errorf("not found or not executable")
+		}
+		return nil
+	}}
+}
+
+// needsRoot requires the daemon to run as root, or command to run through
+// sudo.
+func needsRoot(command string) prerequisite {
+	return prerequisite{what: command, test: func() error {
+		if os.Geteuid() != 0 && !checkPrivileges.escalates(command) {
+			return fmt.Errorf("needs root, or config.privileges to run it through sudo")
+		}
+		return nil
+	}}
+}
+
+// needsFile requires a file to be readable.
+func needsFile(path string) prerequisite {
+	return prerequisite{what: path, test: func() error {
+		f, err := os.Open(path) // #nosec G304 -- path is from the config file
+		if err != nil {
+			return describePathError(err)
+		}
+		closeAndLog(f, "file")
+		return nil
+	}}
+}
+
+// needsPath requires a file or directory to exist.
+func needsPath(path string) prerequisite {
+	return prerequisite{what: path, test: func() error {
+		_, err := os.Stat(path)
+		return describePathError(err)
+	}}
+}
+
+// needsSocket requires a unix domain socket to accept connections.
+func needsSocket(path string) prerequisite {
+	return prerequisite{what: path, test: func() error {
+		conn, err := dialUnix(context.Background(), path, time.Second)
+		if err != nil {
+			return describePathError(err)
+		}
+		closeAndLog(conn, "connection")
+		return nil
+	}}
+}
+
+// needsServiceManager requires the system bus or, as service checks fall
+// back to it, systemctl.
+func needsServiceManager() prerequisite {
+	return needsEither(needsSocket(systemBusAddress()), needsCommand("systemctl"))
+}
+
+// needsJournal requires the journal to be readable, unless journalctl runs
+// through sudo. A host keeping no journal files, as in some containers,
+// passes, since journalctl may still reach the journal.
+func needsJournal() prerequisite {
+	return prerequisite{what: "journal", test: func() error {
+		if os.Geteuid() == 0 || checkPrivileges.escalates("journalctl") {
+			return nil
+		}
+		var err error
+		for _, dir := range []string{"/var/log/journal", "/run/log/journal"} {
+			files, _ := filepath.Glob(filepath.Join(dir, "*", "*.journal"))
+			for _, file := range files {
+				f, openErr := os.Open(file) // #nosec G304 -- a journal file
+				if openErr == nil {
+					closeAndLog(f, "journal file")
+					return nil
+				}
+				err = openErr
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cannot be read by this user, add it to the systemd-journal group or run journalctl through sudo")
+		}
+		return nil
+	}}
+}
+
+// needsEither requires a or b, reporting both when neither holds.
+func needsEither(a, b prerequisite) prerequisite {
+	return prerequisite{what: a.what + " or " + b.what, test: func() error {
+		errA := a.test()
+		if errA == nil {
+			return nil
+		}
+		errB := b.test()
+		if errB == nil {
+			return nil
+		}
+		return fmt.Errorf("%s: %v; %s: %v", a.what, errA, b.what, errB)
+	}}
+}
+
+// describePathError shortens the common errors reaching a path, which
+// already names it.
+func describePathError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("does not exist")
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("permission denied")
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const hwmonDir = "/sys/class/hwmon"
+
+// TemperatureCheck compares hwmon temperature sensors against warning and
+// critical thresholds in degrees Celsius. Chip and Sensor narrow the match
+// to a hwmon device name and a sensor label (or "tempN"); when Critical is
+// unset, each sensor's own tempN_crit limit is used.
+type TemperatureCheck struct {
+	Name     string  `yaml:"name"`
+	Chip     string  `yaml:"chip"`
+	Sensor   string  `yaml:"sensor"`
+	Warning  float64 `yaml:"warning"`
+	Critical float64 `yaml:"critical"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the thresholds.
+func (t *TemperatureCheck) Validate() error {
+	if t.Warning < 0 || t.Critical < 0 {
+		return fmt.Errorf("temperature check %s: thresholds must not be negative", t.Name)
+	}
+	if t.Warning > 0 && t.Critical > 0 && t.Warning > t.Critical {
+		return fmt.Errorf("temperature check %s: warning must not exceed critical", t.Name)
+	}
+	return nil
+}
+
+// temperatureReading is one hwmon sensor value in degrees Celsius.
+type temperatureReading struct {
+	Chip     string
+	Label    string
+	Celsius  float64
+	Critical float64
+}
+
+func (check TemperatureCheck) run(ctx context.Context) checkResult {
+	readings, err := readTemperatures(check.Chip, check.Sensor)
+	if err != nil {
+		return checkFailed("Temperature Name: %s, sensors could not be read: %v", check.Name, err)
+	}
+	if len(readings) == 0 {
+		return checkFailed("Temperature Name: %s, no matching sensors found", check.Name)
+	}
+
+	status := statusOK
+	hottest := readings[0]
+	for _, r := range readings {
+		critical := check.Critical
+		if critical == 0 {
+			critical = r.Critical
+		}
+		s := statusOK
+		switch {
+		case critical > 0 && r.Celsius >= critical:
+			s = statusCritical
+		case check.Warning > 0 && r.Celsius >= check.Warning:
+			s = statusWarning
+		}
+		if s > status || (s == status && r.Celsius > hottest.Celsius) {
+			status, hottest = s, r
+		}
+	}
+
+	sensor := hottest.Chip + "/" + hottest.Label
+	switch status {
+	case statusCritical:
+		return checkFailed("Temperature Name: %s, Sensor: %s, Temperature: %.1f°C is critical", check.Name, sensor, hottest.Celsius)
+	case statusWarning:
+		return checkWarning("Temperature Name: %s, Sensor: %s, Temperature: %.1f°C exceeds warning threshold: %.1f°C", check.Name, sensor, hottest.Celsius, check.Warning)
+	default:
+		return checkOK("Temperature Name: %s, Sensor: %s, Temperature: %.1f°C is within limits", check.Name, sensor, hottest.Celsius)
+	}
+}
+
+// readTemperatures reads every tempN_input under /sys/class/hwmon whose
+// chip name and label match the given filters (empty matches all).
+func readTemperatures(chip, sensor string) ([]temperatureReading, error) {
+	devices, err := os.ReadDir(hwmonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []temperatureReading
+	for _, device := range devices {
+		dir := filepath.Join(hwmonDir, device.Name())
+		name := readTrimmed(filepath.Join(dir, "name"))
+		if chip != "" && name != chip {
+			continue
+		}
+		inputs, err := filepath.Glob(filepath.Join(dir, "temp*_input"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(inputs)
+		for _, input := range inputs {
+			prefix := strings.TrimSuffix(filepath.Base(input), "_input")
+			label := readTrimmed(filepath.Join(dir, prefix+"_label"))
+			if label == "" {
+				label = prefix
+			}
+			if sensor != "" && label != sensor && prefix != sensor {
+				continue
+			}
+			milli, err := strconv.ParseFloat(readTrimmed(input), 64)
+			if err != nil {
+				continue
+			}
+			reading := temperatureReading{Chip: name, Label: label, Celsius: milli / 1000}
+			if crit, err := strconv.ParseFloat(readTrimmed(filepath.Join(dir, prefix+"_crit")), 64); err == nil {
+				reading.Critical = crit / 1000
+			}
+			readings = append(readings, reading)
+		}
+	}
+	return readings, nil
+}
+
+// readTrimmed returns the trimmed contents of a small sysfs file, or an
+// empty string if it cannot be read.
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path) // #nosec G304 -- paths are built from sysfs entries
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
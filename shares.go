@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShareCheck stats (and optionally lists) a path on a network filesystem
+// with a strict timeout, catching hung or stale NFS and CIFS mounts that
+// would otherwise block the request forever. When FSType is set, the path
+// must also be on a filesystem of that type, so an unmounted share is not
+// mistaken for an empty local directory.
+type ShareCheck struct {
+	Name    string        `yaml:"name"`
+	Path    string        `yaml:"path"`
+	FSType  string        `yaml:"fsType"`
+	ReadDir bool          `yaml:"readDir"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the path and timeout.
+func (s *ShareCheck) Validate() error {
+	if !filepath.IsAbs(s.Path) {
+		return fmt.Errorf("share check %s: path must be absolute", s.Name)
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("share check %s: timeout must not be negative", s.Name)
+	}
+	return nil
+}
+
+// sharesInFlight tracks probes still blocked in the kernel. A goroutine
+// stuck on a hung mount cannot be cancelled, so a path with a probe still
+// outstanding fails immediately instead of leaking another goroutine.
+var (
+	sharesInFlight   = map[string]bool{}
+	sharesInFlightMu sync.Mutex
+)
+
+func (check ShareCheck) run(ctx context.Context) checkResult {
+	if check.FSType != "" {
+		fsType, mountPoint, err := mountFor(check.Path)
+		if err != nil {
+			return checkFailed("Share Name: %s, Path: %s, mounts could not be read: %v", check.Name, check.Path, err)
+		}
+		if !strings.HasPrefix(fsType, check.FSType) {
+			return checkFailed("Share Name: %s, Path: %s is on %s (%s), expected %s", check.Name, check.Path, mountPoint, fsType, check.FSType)
+		}
+	}
+
+	timeout := check.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	sharesInFlightMu.Lock()
+	if sharesInFlight[check.Path] {
+		sharesInFlightMu.Unlock()
+		return checkFailed("Share Name: %s, Path: %s is still hung from a previous check", check.Name, check.Path)
+	}
+	sharesInFlight[check.Path] = true
+	sharesInFlightMu.Unlock()
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			sharesInFlightMu.Lock()
+			delete(sharesInFlight, check.Path)
+			sharesInFlightMu.Unlock()
+		}()
+		done <- check.probe()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return checkFailed("Share Name: %s, Path: %s is not accessible: %v", check.Name, check.Path, err)
+		}
+		return checkOK("Share Name: %s, Path: %s responded in %s", check.Name, check.Path, time.Since(start).Round(time.Millisecond))
+	case <-time.After(timeout):
+		return checkFailed("Share Name: %s, Path: %s did not respond within %s", check.Name, check.Path, timeout)
+	case <-ctx.Done():
+		return checkFailed("Share Name: %s, Path: %s, check abandoned: %v", check.Name, check.Path, ctx.Err())
+	}
+}
+
+func (check ShareCheck) probe() error {
+	info, err := os.Stat(check.Path)
+	if err != nil || !check.ReadDir {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("readDir is set but it is not a directory")
+	}
+	dir, err := os.Open(check.Path)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(dir, "directory")
+	_, err = dir.Readdirnames(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// mountFor returns the filesystem type and mount point of the deepest mount
+// containing path, from /proc/mounts. Only the mount table is read, so this
+// does not touch the possibly hung filesystem itself.
+func mountFor(path string) (string, string, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", "", err
+	}
+	defer closeAndLog(f, "/proc/mounts")
+
+	path = filepath.Clean(path)
+	var fsType, mountPoint string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Spaces and other special characters are octal-escaped.
+		mp := strings.ReplaceAll(fields[1], `\040`, " ")
+		if (path == mp || mp == "/" || strings.HasPrefix(path, mp+"/")) && len(mp) >= len(mountPoint) {
+			fsType, mountPoint = fields[2], mp
+		}
+	}
+	return fsType, mountPoint, scanner.Err()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ShutdownConfig controls how the daemon stops on SIGTERM or SIGINT. For
+// PreStopDelay it first reports unhealthy, as when drained, while still
+// serving, so load balancers stop sending traffic before it goes. It then
+// waits up to Timeout, 5s unless set, for exports in flight to be sent and
+// then for requests in flight to be answered. With Webhook set it POSTs a
+// JSON notice that it is going down, with Headers, before the server
+// closes, so whoever watches it can tell a planned stop from a crash.
+type ShutdownConfig struct {
+	PreStopDelay time.Duration     `yaml:"preStopDelay"`
+	Timeout      time.Duration     `yaml:"timeout"`
+	Webhook      string            `yaml:"webhook"`
+	Headers      map[string]string `yaml:"headers"`
+}
+
+// Validate checks the delay, timeout and webhook URL.
+func (c *ShutdownConfig) Validate() error {
+	if c.PreStopDelay < 0 || c.Timeout < 0 {
+		return fmt.Errorf("shutdown: preStopDelay and timeout must not be negative")
+	}
+	if c.Webhook != "" {
+		u, err := url.Parse(c.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("shutdown: webhook must be an http:// or https:// URL")
+		}
+	}
+	return nil
+}
+
+// timeout returns how long each stage of shutdown may take.
+func (c *ShutdownConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+// notifyShutdown POSTs the going-down notice to the webhook.
+func (c *ShutdownConfig) notifyShutdown(status string) error {
+	host, _ := os.Hostname()
+	body, err := json.Marshal(map[string]interface{}{
+		"event":  "shutdown",
+		"host":   host,
+		"pid":    os.Getpid(),
+		"status": status,
+		"time":   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SidecarConfig suits running as a sidecar container in a Kubernetes pod,
+// to give an application without health checks of its own a deep
+// readiness probe. Every Interval, config.interval or ten seconds unless
+// set, the aggregate status is written to StatusFile for an exec probe
+// running "server-health-api -probe <statusFile>", and the HTTP API
+// listens on loopback only unless listen.host says otherwise, as the
+// kubelet does not need it. With shareProcessNamespace set on the pod,
+// process checks see the application's processes.
+type SidecarConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	StatusFile string        `yaml:"statusFile"`
+	Interval   time.Duration `yaml:"interval"`
+}
+
+// defaultStatusFile is where the status is written unless statusFile is
+// set.
+const defaultStatusFile = "/tmp/server-health-api/status"
+
+// sidecarExpiry is how many intervals a status file is trusted for, so an
+// exec probe fails rather than reading an old status once the daemon
+// stops writing it.
+const sidecarExpiry = 3
+
+// Validate checks the status file and interval.
+func (c *SidecarConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.StatusFile != "" && !filepath.IsAbs(c.StatusFile) {
+		return fmt.Errorf("sidecar: statusFile must be an absolute path")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("sidecar: interval must not be negative")
+	}
+	return nil
+}
+
+// statusFile returns the file the status is written to.
+func (c *SidecarConfig) statusFile() string {
+	if c.StatusFile == "" {
+		return defaultStatusFile
+	}
+	return c.StatusFile
+}
+
+// interval returns how often the status is written, given config.interval.
+func (c *SidecarConfig) interval(checkInterval time.Duration) time.Duration {
+	switch {
+	case c.Interval > 0:
+		return c.Interval
+	case checkInterval > 0:
+		return checkInterval
+	default:
+		return 10 * time.Second
+	}
+}
+
+// startSidecar writes the status file every interval, from the scheduler's
+// cache when one is given and by running the checks otherwise, until the
+// returned function is called, which removes the file so the pod is taken
+// out of service while it shuts down.
+func startSidecar(live *liveConfig, cache *resultCache) func() {
+	cfg := live.current().Config
+	path := cfg.Sidecar.statusFile()
+	interval := cfg.Sidecar.interval(cfg.Interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := writeSidecarStatus(live.current(), cache, path, interval); err != nil {
+				log.Printf("sidecar: %v", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Writing sidecar status to %s every %s", path, interval)
+	return func() {
+		close(done)
+		<-stopped
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("sidecar: %v", err)
+		}
+	}
+}
+
+// writeSidecarStatus replaces the status file with the outcome of the
+// checks. The file's first line is healthy, degraded or unhealthy, its
+// second when it expires, and the rest the text report.
+func writeSidecarStatus(config *Config, cache *resultCache, path string, interval time.Duration) error {
+	report := backgroundReport(config, cache, interval)
+	expires := time.Now().Add(sidecarExpiry * interval).UTC().Format(time.RFC3339)
+	return writeFileAtomic(path, []byte(report.state()+"\n"+expires+"\n"+report.textOutput()))
+}
+
+// backgroundReport evaluates the checks as /healthy does, for reports made
+// outside a request: from the scheduler's cache when one is given, and by
+// running the checks with timeout otherwise.
+func backgroundReport(config *Config, cache *resultCache, timeout time.Duration) healthReport {
+	checks := currentChecks(config)
+	var results []checkResult
+	if cache != nil {
+		results = cache.get()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		results = runChecks(ctx, checks)
+		cancel()
+	}
+	adminOverrides.apply(results)
+	results = applyComposites(config.Composites, config.checks(), results)
+	messages := config.Config.Messages.templates(nil)
+	messages.apply(results)
+	now := time.Now()
+	healthy, degraded := config.Config.Rollup.evaluate(config.checks(), results)
+	report := healthReport{
+		Healthy:  healthy,
+		Degraded: degraded,
+		Messages: make([]string, 0, len(results)),
+		Checks:   reports(results, now),
+	}
+	for _, result := range results {
+		report.Messages = append(report.Messages, result.Message)
+	}
+	messages.summarize(&report, results)
+	config.Config.Score.apply(&report, &config.Config.Rollup, config.checks(), results)
+	if cache != nil && config.Config.MaxAge > 0 {
+		stale, first := staleAfter(results, checks, config.Config.Interval, config.Config.MaxAge)
+		if now.After(stale) {
+			report.Healthy, report.Degraded = false, false
+			report.Messages = append(report.Messages, fmt.Sprintf("Check results are stale, %s check %s last ran %s ago", first.Type, first.Name, now.Sub(first.Checked).Round(time.Second)))
+		}
+	}
+	adminOverrides.applyDrain(&report)
+	adminOverrides.applyServerFault(&report)
+	report.Status = messages.status(report.state(), results, "Server is "+report.state())
+	return report
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		closeAndLog(tmp, "status file")
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runProbe is the -probe command for exec probes: it prints the status file
+// at path and returns the exit code, zero only when the file says healthy
+// or degraded and has not expired.
+func runProbe(path string) int {
+	file, err := os.Open(path) // #nosec G304 -- path is from the command line
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "probe: %v\n", err)
+		return 1
+	}
+	defer closeAndLog(file, "status file")
+	scanner := bufio.NewScanner(file)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "probe: %v\n", err)
+		return 1
+	}
+	if len(lines) < 2 {
+		fmt.Fprintf(os.Stderr, "probe: %s is not a status file\n", path)
+		return 1
+	}
+	fmt.Println(strings.Join(lines[2:], "\n"))
+	expires, err := time.Parse(time.RFC3339, lines[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "probe: %s is not a status file\n", path)
+		return 1
+	}
+	if time.Now().After(expires) {
+		fmt.Fprintf(os.Stderr, "probe: status expired at %s\n", lines[1])
+		return 1
+	}
+	if lines[0] != "healthy" && lines[0] != "degraded" {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPCheck connects to a mail server, verifies its banner and EHLO
+// response, and optionally upgrades with STARTTLS and authenticates. No
+// mail is sent.
+type SMTPCheck struct {
+	Name               string   `yaml:"name"`
+	Address            string   `yaml:"address"`
+	Port               int      `yaml:"port"`
+	TLS                bool     `yaml:"tls"`
+	StartTLS           bool     `yaml:"startTLS"`
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify"`
+	Username           string   `yaml:"username"`
+	Password           string   `yaml:"password"`
+	Banner             string   `yaml:"banner"`
+	Extensions         []string `yaml:"extensions"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the port, banner pattern and that credentials are only
+// sent over TLS.
+func (s *SMTPCheck) Validate() error {
+	if s.Address == "" {
+		return fmt.Errorf("smtp check %s: address is required", s.Name)
+	}
+	if s.Port != 0 && (s.Port < 1 || s.Port > 65535) {
+		return fmt.Errorf("smtp check %s: invalid port: %d", s.Name, s.Port)
+	}
+	if s.TLS && s.StartTLS {
+		return fmt.Errorf("smtp check %s: tls and startTLS are mutually exclusive", s.Name)
+	}
+	if (s.Username != "" || s.Password != "") && !s.TLS && !s.StartTLS {
+		return fmt.Errorf("smtp check %s: username and password require tls or startTLS, as AUTH PLAIN sends them in the clear", s.Name)
+	}
+	if _, err := regexp.Compile(s.Banner); err != nil {
+		return fmt.Errorf("smtp check %s: invalid banner pattern: %w", s.Name, err)
+	}
+	return nil
+}
+
+func (check SMTPCheck) run(ctx context.Context) checkResult {
+	port := check.Port
+	if port == 0 {
+		port = 25
+		if check.TLS {
+			port = 465
+		}
+	}
+	address := net.JoinHostPort(check.Address, strconv.Itoa(port))
+	if err := check.probe(ctx, address); err != nil {
+		return checkFailed("SMTP Name: %s, Address: %s %v", check.Name, address, err)
+	}
+	return checkOK("SMTP Name: %s, Address: %s is functional", check.Name, address)
+}
+
+// probe runs the SMTP conversation, returning an error describing the
+// first step that failed.
+func (check SMTPCheck) probe(ctx context.Context, address string) error {
+	tlsConfig := &tls.Config{ServerName: check.Address, InsecureSkipVerify: check.InsecureSkipVerify}
+
+	var dialTLS *tls.Config
+	if check.TLS {
+		dialTLS = tlsConfig
+	}
+	conn, err := dialCheck(ctx, "tcp", address, 5*time.Second, dialTLS)
+	if err != nil {
+		return fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		return fmt.Errorf("sent an unexpected banner: %w", err)
+	}
+	if check.Banner != "" && !regexp.MustCompile(check.Banner).MatchString(banner) {
+		return fmt.Errorf("banner %q does not match %q", banner, check.Banner)
+	}
+
+	extensions, err := smtpEHLO(text)
+	if err != nil {
+		return err
+	}
+
+	if check.StartTLS {
+		if _, ok := extensions["STARTTLS"]; !ok {
+			return fmt.Errorf("does not offer STARTTLS")
+		}
+		if err := smtpCommand(text, 220, "STARTTLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("STARTTLS handshake failed: %w", err)
+		}
+		text = textproto.NewConn(tlsConn)
+		if extensions, err = smtpEHLO(text); err != nil {
+			return err
+		}
+	}
+
+	for _, ext := range check.Extensions {
+		if _, ok := extensions[strings.ToUpper(ext)]; !ok {
+			return fmt.Errorf("does not advertise %s", ext)
+		}
+	}
+
+	if check.Username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte("\x00" + check.Username + "\x00" + check.Password))
+		if err := smtpCommand(text, 235, "AUTH PLAIN %s", creds); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	return smtpCommand(text, 221, "QUIT")
+}
+
+// smtpEHLO sends EHLO and returns the advertised extensions with their parameters.
+func smtpEHLO(text *textproto.Conn) (map[string]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	id, err := text.Cmd("EHLO %s", hostname)
+	if err != nil {
+		return nil, err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, msg, err := text.ReadResponse(250)
+	if err != nil {
+		return nil, fmt.Errorf("rejected EHLO: %w", err)
+	}
+
+	extensions := map[string]string{}
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		name, params, _ := strings.Cut(line, " ")
+		extensions[strings.ToUpper(name)] = params
+	}
+	return extensions, nil
+}
+
+func smtpCommand(text *textproto.Conn, expectCode int, format string, args ...interface{}) error {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, _, err = text.ReadResponse(expectCode)
+	return err
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5" // #nosec G501 -- HMAC-MD5-96 is an SNMPv3 authentication protocol
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505 -- HMAC-SHA-96 is an SNMPv3 authentication protocol
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// SNMPConfig sends an SNMP trap to each of Targets, host[:port] with the
+// port defaulting to 162, whenever a check changes status, using the
+// notification in resources/SERVER-HEALTH-MIB.txt. Version is "2c", with
+// Community, or "3", with User and optionally authentication and privacy.
+// The v3 engine ID, which receivers need to authenticate the user, defaults
+// to one derived from the host name and is logged at startup.
+// EnterpriseOID replaces the MIB's root, for sites that renumber it under
+// their own enterprise number.
+type SNMPConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Targets       []string `yaml:"targets"`
+	Version       string   `yaml:"version"`
+	Community     string   `yaml:"community"`
+	User          string   `yaml:"user"`
+	AuthProtocol  string   `yaml:"authProtocol"`
+	AuthPassword  string   `yaml:"authPassword"`
+	PrivProtocol  string   `yaml:"privProtocol"`
+	PrivPassword  string   `yaml:"privPassword"`
+	EngineID      string   `yaml:"engineID"`
+	EnterpriseOID string   `yaml:"enterpriseOID"`
+}
+
+// snmpEnterpriseOID is the root of SERVER-HEALTH-MIB, under the enterprise
+// number IANA reserves for documentation.
+const snmpEnterpriseOID = "1.3.6.1.4.1.32473.1"
+
+// snmpAuthProtocols are the supported v3 authentication protocols, with
+// their hash and the length of the truncated HMAC they send.
+var snmpAuthProtocols = map[string]struct {
+	hash   func() hash.Hash
+	macLen int
+}{
+	"MD5":    {md5.New, 12},
+	"SHA":    {sha1.New, 12},
+	"SHA256": {sha256.New, 24},
+}
+
+// Validate checks the targets, version and v3 security settings.
+func (c *SNMPConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("snmp: at least one target is required")
+	}
+	for _, t := range c.Targets {
+		if t == "" {
+			return fmt.Errorf("snmp: targets cannot be empty")
+		}
+	}
+	if c.EnterpriseOID != "" {
+		if _, err := parseOID(c.EnterpriseOID); err != nil {
+			return fmt.Errorf("snmp: enterpriseOID: %w", err)
+		}
+	}
+	switch c.Version {
+	case "", "2c":
+		return nil
+	case "3":
+	default:
+		return fmt.Errorf("snmp: version must be 2c or 3, got %q", c.Version)
+	}
+	if c.User == "" {
+		return fmt.Errorf("snmp: user is required for version 3")
+	}
+	if c.AuthProtocol != "" {
+		if _, ok := snmpAuthProtocols[c.AuthProtocol]; !ok {
+			return fmt.Errorf("snmp: authProtocol must be MD5, SHA or SHA256, got %q", c.AuthProtocol)
+		}
+		if len(c.AuthPassword) < 8 {
+			return fmt.Errorf("snmp: authPassword must be at least 8 characters")
+		}
+	}
+	switch c.PrivProtocol {
+	case "":
+	case "AES":
+		if c.AuthProtocol == "" {
+			return fmt.Errorf("snmp: privProtocol requires authProtocol")
+		}
+		if len(c.PrivPassword) < 8 {
+			return fmt.Errorf("snmp: privPassword must be at least 8 characters")
+		}
+	default:
+		return fmt.Errorf("snmp: privProtocol must be AES, got %q", c.PrivProtocol)
+	}
+	if c.EngineID != "" {
+		id, err := hex.DecodeString(c.EngineID)
+		if err != nil || len(id) < 5 || len(id) > 32 {
+			return fmt.Errorf("snmp: engineID must be 5 to 32 bytes in hex")
+		}
+	}
+	return nil
+}
+
+// parseOID parses a dotted object identifier such as 1.3.6.1.4.1.
+func parseOID(s string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%q is not a dotted object identifier", s)
+	}
+	oid := make([]uint32, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a dotted object identifier", s)
+		}
+		oid[i] = uint32(n)
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("%q is not a valid object identifier", s)
+	}
+	return oid, nil
+}
+
+// snmpExporter sends a trap when a check's status differs from the one it
+// had in the previous run.
+type snmpExporter struct {
+	cfg     SNMPConfig
+	root    []uint32
+	targets []string
+	started time.Time
+
+	// For version 3: the engine ID and boot count this sender is
+	// authoritative with, and the localized keys.
+	engineID []byte
+	boots    int
+	authKey  []byte
+	privKey  []byte
+
+	mu        sync.Mutex
+	statuses  map[string]checkStatus
+	requestID int
+}
+
+func newSNMP(cfg SNMPConfig) (*snmpExporter, error) {
+	e := &snmpExporter{cfg: cfg, started: time.Now(), statuses: map[string]checkStatus{}}
+	oid := cfg.EnterpriseOID
+	if oid == "" {
+		oid = snmpEnterpriseOID
+	}
+	root, err := parseOID(oid)
+	if err != nil {
+		return nil, fmt.Errorf("snmp: %w", err)
+	}
+	e.root = root
+	for _, t := range cfg.Targets {
+		if _, _, err := net.SplitHostPort(t); err != nil {
+			t = net.JoinHostPort(t, "162")
+		}
+		e.targets = append(e.targets, t)
+	}
+	if e.cfg.Community == "" {
+		e.cfg.Community = "public"
+	}
+	if cfg.Version != "3" {
+		return e, nil
+	}
+
+	if cfg.EngineID != "" {
+		e.engineID, _ = hex.DecodeString(cfg.EngineID)
+	} else {
+		e.engineID = defaultEngineID()
+	}
+	// The boot count must grow across restarts for receivers to accept
+	// the restarted engine's time; the start time does that without
+	// keeping state.
+	e.boots = int(min(e.started.Unix(), math.MaxInt32))
+	if p, ok := snmpAuthProtocols[cfg.AuthProtocol]; ok {
+		e.authKey = localizedKey(p.hash, cfg.AuthPassword, e.engineID)
+		if cfg.PrivProtocol == "AES" {
+			e.privKey = localizedKey(p.hash, cfg.PrivPassword, e.engineID)[:16]
+		}
+	}
+	log.Printf("snmp: sending version 3 traps with engine ID %x", e.engineID)
+	return e, nil
+}
+
+// defaultEngineID builds an RFC 3411 text engine ID from the host name,
+// under the same enterprise number as the MIB.
+func defaultEngineID() []byte {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "server-health-api"
+	}
+	if len(name) > 27 {
+		name = name[:27]
+	}
+	return append([]byte{0x80, 0x00, 0x7e, 0xd9, 0x04}, name...)
+}
+
+// localizedKey derives a user's key for engineID from password, as in RFC
+// 3414 A.2: the password repeated to a megabyte is hashed, and the result
+// hashed again around the engine ID.
+func localizedKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	block := make([]byte, 64)
+	for i := 0; i < 1<<20; i += len(block) {
+		for j := range block {
+			block[j] = password[(i+j)%len(password)]
+		}
+		h.Write(block)
+	}
+	ku := h.Sum(nil)
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+// export sends a trap for each check whose status changed. A check seen for
+// the first time is taken to have been ok, so one that starts out failing
+// is reported too.
+func (e *snmpExporter) export(results []checkResult) {
+	type change struct {
+		result   checkResult
+		previous checkStatus
+	}
+	var changes []change
+	e.mu.Lock()
+	for _, r := range results {
+		key := overrideKey(r.Type, r.Name)
+		previous := e.statuses[key] // statusOK when unseen
+		e.statuses[key] = r.Status
+		if r.Status != previous {
+			changes = append(changes, change{r, previous})
+		}
+	}
+	e.mu.Unlock()
+
+	for _, c := range changes {
+		message, err := e.message(e.trapPDU(c.result, c.previous))
+		if err != nil {
+			log.Printf("snmp: %v", err)
+			continue
+		}
+		for _, target := range e.targets {
+			exportInBackground("snmp", func() error {
+				if err := sendUDP(target, message); err != nil {
+					return fmt.Errorf("%s: %w", target, err)
+				}
+				return nil
+			})
+		}
+	}
+}
+
+func sendUDP(target string, message []byte) error {
+	conn, err := net.DialTimeout("udp", target, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(conn, "snmp connection")
+	_, err = conn.Write(message)
+	return err
+}
+
+func (e *snmpExporter) nextRequestID() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requestID = (e.requestID + 1) % math.MaxInt32
+	return e.requestID
+}
+
+// oid returns the MIB object under the root at the given arcs.
+func (e *snmpExporter) oid(arcs ...uint32) []uint32 {
+	return append(append([]uint32{}, e.root...), arcs...)
+}
+
+// Well-known objects every SNMPv2 trap starts with.
+var (
+	sysUpTimeOID   = []uint32{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	snmpTrapOIDOID = []uint32{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+)
+
+// snmpMaxString is the size limit of the MIB's SnmpAdminString objects.
+const snmpMaxString = 255
+
+// trapPDU builds the checkStateChanged notification for r.
+func (e *snmpExporter) trapPDU(r checkResult, previous checkStatus) []byte {
+	uptime := time.Since(e.started) / (10 * time.Millisecond)
+	varbind := func(oid []uint32, value []byte) []byte {
+		return berTLV(0x30, concat(berOID(oid), value))
+	}
+	varbinds := berTLV(0x30, concat(
+		varbind(sysUpTimeOID, berTimeTicks(uint32(uptime))), // #nosec G115 -- TimeTicks wrap by definition
+		varbind(snmpTrapOIDOID, berOID(e.oid(0, 1))),
+		varbind(e.oid(1, 1, 0), berString(adminString(r.Type))),
+		varbind(e.oid(1, 2, 0), berString(adminString(r.Name))),
+		varbind(e.oid(1, 3, 0), berInt(int(r.Status))),
+		varbind(e.oid(1, 4, 0), berInt(int(previous))),
+		varbind(e.oid(1, 5, 0), berString(adminString(r.Message))),
+	))
+	// An SNMPv2-Trap-PDU: request ID, error status and index, varbinds.
+	return berTLV(0xa7, concat(berInt(e.nextRequestID()), berInt(0), berInt(0), varbinds))
+}
+
+// adminString cuts s to the size of an SnmpAdminString, on a character
+// boundary.
+func adminString(s string) string {
+	if len(s) <= snmpMaxString {
+		return s
+	}
+	n := snmpMaxString
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// message wraps pdu in a version 2c or version 3 message.
+func (e *snmpExporter) message(pdu []byte) ([]byte, error) {
+	if e.cfg.Version != "3" {
+		return berTLV(0x30, concat(berInt(1), berString(e.cfg.Community), pdu)), nil
+	}
+
+	// msgFlags: authentication and privacy; traps are not reportable.
+	var flags byte
+	if e.authKey != nil {
+		flags |= 1
+	}
+	boots := e.boots
+	engineTime := int(time.Since(e.started) / time.Second)
+	data := berTLV(0x30, concat(berString(string(e.engineID)), berString(""), pdu))
+	var privParams []byte
+	if e.privKey != nil {
+		flags |= 2
+		salt := make([]byte, 8)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		encrypted, err := aesCFBEncrypt(e.privKey, boots, engineTime, salt, data)
+		if err != nil {
+			return nil, err
+		}
+		data, privParams = berTLV(0x04, encrypted), salt
+	}
+	msgID := e.nextRequestID()
+	build := func(authParams []byte) []byte {
+		security := berTLV(0x30, concat(
+			berString(string(e.engineID)),
+			berInt(boots),
+			berInt(engineTime),
+			berString(e.cfg.User),
+			berTLV(0x04, authParams),
+			berTLV(0x04, privParams),
+		))
+		// msgID, msgMaxSize, msgFlags and the user-based security model.
+		header := berTLV(0x30, concat(berInt(msgID), berInt(65507), berTLV(0x04, []byte{flags}), berInt(3)))
+		return berTLV(0x30, concat(berInt(3), header, berTLV(0x04, security), data))
+	}
+	if e.authKey == nil {
+		return build(nil), nil
+	}
+	// The HMAC is computed over the message with zeros in its place, then
+	// truncated; it keeps the same length, so the rebuilt message lines up.
+	p := snmpAuthProtocols[e.cfg.AuthProtocol]
+	mac := hmac.New(p.hash, e.authKey)
+	mac.Write(build(make([]byte, p.macLen)))
+	return build(mac.Sum(nil)[:p.macLen]), nil
+}
+
+// aesCFBEncrypt encrypts data as RFC 3826 specifies: AES-128 in CFB mode,
+// with the engine's boots and time and the salt as the IV.
+func aesCFBEncrypt(key []byte, boots, engineTime int, salt, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[0:], uint32(boots))      // #nosec G115 -- boots is at most MaxInt32
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime)) // #nosec G115 -- seconds since start
+	copy(iv[8:], salt)
+	out := make([]byte, len(data))
+	stream := make([]byte, aes.BlockSize)
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(stream, iv)
+		n := min(aes.BlockSize, len(data)-i)
+		for j := 0; j < n; j++ {
+			out[i+j] = data[i+j] ^ stream[j]
+			iv[j] = out[i+j]
+		}
+	}
+	return out, nil
+}
+
+// berTimeTicks encodes the application TimeTicks type, an unsigned 32-bit
+// count of hundredths of a second.
+func berTimeTicks(v uint32) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{0}, v)
+	for len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		b = b[1:]
+	}
+	return berTLV(0x43, b)
+}
+
+func berString(s string) []byte {
+	return berTLV(0x04, []byte(s))
+}
+
+func berOID(oid []uint32) []byte {
+	// The first two arcs share one subidentifier.
+	arcs := append([]uint32{oid[0]*40 + oid[1]}, oid[2:]...)
+	var b []byte
+	for _, arc := range arcs {
+		var enc []byte
+		for v := arc; ; v >>= 7 {
+			enc = append([]byte{byte(v & 0x7f)}, enc...)
+			if v < 0x80 {
+				break
+			}
+		}
+		for i := 0; i < len(enc)-1; i++ {
+			enc[i] |= 0x80
+		}
+		b = append(b, enc...)
+	}
+	return berTLV(0x06, b)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SocketCheck reports on the kernel's connection tables, which fill up
+// long before anything else looks wrong: connection-tracking entries
+// against nf_conntrack_max, local ports of the ephemeral range in use by
+// TCP sockets, and TCP sockets by state. The Max limits are percentages,
+// and States caps the sockets in a state, such as TIME_WAIT or CLOSE_WAIT.
+// The tables read are those of the daemon's network namespace.
+type SocketCheck struct {
+	Name             string         `yaml:"name"`
+	MaxConntrackUsed float64        `yaml:"maxConntrackUsed"`
+	MaxEphemeralUsed float64        `yaml:"maxEphemeralUsed"`
+	States           map[string]int `yaml:"states"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// tcpStates names the states /proc/net/tcp reports, by their number there.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// Validate checks the limits and state names.
+func (s *SocketCheck) Validate() error {
+	for _, limit := range []float64{s.MaxConntrackUsed, s.MaxEphemeralUsed} {
+		if limit < 0 || limit > 100 {
+			return fmt.Errorf("socket check %s: maxConntrackUsed and maxEphemeralUsed must be percentages", s.Name)
+		}
+	}
+	for state, limit := range s.States {
+		known := false
+		for _, name := range tcpStates {
+			known = known || name == state
+		}
+		if !known {
+			return fmt.Errorf("socket check %s: unknown TCP state %s", s.Name, state)
+		}
+		if limit < 0 {
+			return fmt.Errorf("socket check %s: the limit for %s must not be negative", s.Name, state)
+		}
+	}
+	return nil
+}
+
+func (check SocketCheck) run(ctx context.Context) checkResult {
+	states, ports, err := readTCPSockets()
+	if err != nil {
+		return checkFailed("Socket Name: %s, could not be read: %v", check.Name, err)
+	}
+
+	var details, problems []string
+	if count, limit, err := readConntrack(); err == nil && limit > 0 {
+		used := float64(count) / float64(limit) * 100
+		details = append(details, fmt.Sprintf("Conntrack: %d of %d (%.0f%%)", count, limit, used))
+		if check.MaxConntrackUsed > 0 && used > check.MaxConntrackUsed {
+			problems = append(problems, fmt.Sprintf("conntrack used %.0f%% exceeds %.0f%%", used, check.MaxConntrackUsed))
+		}
+	} else {
+		details = append(details, "Conntrack: not in use")
+	}
+	if low, high, err := readPortRange(); err == nil {
+		inUse := 0
+		for port := range ports {
+			if port >= low && port <= high {
+				inUse++
+			}
+		}
+		size := high - low + 1
+		used := float64(inUse) / float64(size) * 100
+		details = append(details, fmt.Sprintf("Ephemeral ports: %d of %d (%.0f%%)", inUse, size, used))
+		if check.MaxEphemeralUsed > 0 && used > check.MaxEphemeralUsed {
+			problems = append(problems, fmt.Sprintf("ephemeral ports used %.0f%% exceeds %.0f%%", used, check.MaxEphemeralUsed))
+		}
+	}
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	counts := make([]string, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s=%d", name, states[name]))
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "none")
+	}
+	details = append(details, "TCP: "+strings.Join(counts, " "))
+	limited := make([]string, 0, len(check.States))
+	for state := range check.States {
+		limited = append(limited, state)
+	}
+	slices.Sort(limited)
+	for _, state := range limited {
+		if limit := check.States[state]; states[state] > limit {
+			problems = append(problems, fmt.Sprintf("%d sockets in %s exceed %d", states[state], state, limit))
+		}
+	}
+	if len(problems) > 0 {
+		return checkFailed("Socket Name: %s, %s, %s", check.Name, strings.Join(details, ", "), strings.Join(problems, "; "))
+	}
+	return checkOK("Socket Name: %s, %s", check.Name, strings.Join(details, ", "))
+}
+
+// readTCPSockets counts the TCP sockets in /proc/net/tcp and tcp6 by state
+// and returns the local ports bound by those not listening.
+func readTCPSockets() (map[string]int, map[int]bool, error) {
+	states, ports := map[string]int{}, map[int]bool{}
+	read := 0
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue // tcp6 is missing with IPv6 disabled
+		}
+		read++
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // the header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			state, ok := tcpStates[fields[3]]
+			if !ok {
+				continue
+			}
+			states[state]++
+			_, hexPort, _ := strings.Cut(fields[1], ":")
+			if port, err := strconv.ParseInt(hexPort, 16, 32); err == nil && state != "LISTEN" {
+				ports[int(port)] = true
+			}
+		}
+		err = scanner.Err()
+		closeAndLog(file, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if read == 0 {
+		return nil, nil, fmt.Errorf("/proc/net/tcp is not available")
+	}
+	return states, ports, nil
+}
+
+// readConntrack returns the connection-tracking entries in use and the
+// table's size, which are missing while conntrack is not loaded.
+func readConntrack() (int64, int64, error) {
+	count, err := readSysctl("net.netfilter.nf_conntrack_count")
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := readSysctl("net.netfilter.nf_conntrack_max")
+	if err != nil {
+		return 0, 0, err
+	}
+	c, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	l, err := strconv.ParseInt(limit, 10, 64)
+	return c, l, err
+}
+
+// readPortRange returns the ephemeral port range local ports are picked
+// from.
+func readPortRange() (int, int, error) {
+	value, err := readSysctl("net.ipv4.ip_local_port_range")
+	if err != nil {
+		return 0, 0, err
+	}
+	var low, high int
+	if _, err := fmt.Sscanf(value, "%d %d", &low, &high); err != nil || low > high {
+		return 0, 0, fmt.Errorf("invalid ip_local_port_range %q", value)
+	}
+	return low, high, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseSOCKS5URL parses a socks5://[user:password@]host[:port] proxy URL,
+// defaulting the port to 1080.
+func parseSOCKS5URL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" || u.Hostname() == "" {
+		return nil, errors.New("proxy must be a socks5://host:port URL")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	return u, nil
+}
+
+// dialSOCKS5 connects to address through the SOCKS5 proxy at proxy, using
+// username/password authentication when the URL carries credentials. Host
+// names are resolved by the proxy, as targets behind a tunnel are often only
+// resolvable from its far side.
+func dialSOCKS5(ctx context.Context, proxy *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialCheck(ctx, "tcp", proxy.Host, timeout, nil)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err == nil {
+		stop := interruptOnDone(ctx, conn)
+		err = socks5Connect(conn, proxy.User, address)
+		stop()
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		closeAndLog(conn, "proxy connection")
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	return conn, nil
+}
+
+// socks5Replies are the failure codes of a SOCKS5 reply (RFC 1928).
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect performs the SOCKS5 handshake on conn and asks the proxy to
+// connect to address.
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portStr)
+	}
+
+	// Greeting: version, methods (no auth, and username/password if set).
+	greeting := []byte{5, 1, 0}
+	if user != nil {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case 0:
+	case 2:
+		if user == nil {
+			return fmt.Errorf("proxy requires authentication")
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("proxy accepted no offered authentication method")
+	}
+
+	// Request: version, CONNECT, reserved, address, port.
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s is too long", host)
+		}
+		req = append(append(req, 3, byte(len(host))), host...) // #nosec G115 -- length checked above
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port)) // #nosec G115 -- parsed as 16 bits
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, bound address type, address, port.
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if msg, ok := socks5Replies[header[1]]; ok {
+			return fmt.Errorf("connect to %s failed: %s", address, msg)
+		}
+		return fmt.Errorf("connect to %s failed with code %d", address, header[1])
+	}
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("malformed proxy reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// socks5Authenticate sends username/password credentials (RFC 1929).
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return fmt.Errorf("proxy credentials are too long")
+	}
+	msg := append([]byte{1, byte(len(name))}, name...)  // #nosec G115 -- length checked above
+	msg = append(append(msg, byte(len(pass))), pass...) // #nosec G115 -- length checked above
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("proxy authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCheck completes an SSH handshake and, when HostKeyFingerprint is set,
+// fails unless the server's host key matches it. With Username and
+// PrivateKeyFile set it also authenticates; otherwise a completed key
+// exchange is enough.
+type SSHCheck struct {
+	Name               string `yaml:"name"`
+	Address            string `yaml:"address"`
+	Port               int    `yaml:"port"`
+	HostKeyFingerprint string `yaml:"hostKeyFingerprint"`
+	Username           string `yaml:"username"`
+	PrivateKeyFile     string `yaml:"privateKeyFile"`
+	Passphrase         string `yaml:"passphrase"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the port, fingerprint format and credentials.
+func (s *SSHCheck) Validate() error {
+	if s.Address == "" {
+		return fmt.Errorf("ssh check %s: address is required", s.Name)
+	}
+	if s.Port != 0 && (s.Port < 1 || s.Port > 65535) {
+		return fmt.Errorf("ssh check %s: invalid port: %d", s.Name, s.Port)
+	}
+	if s.HostKeyFingerprint != "" && !strings.HasPrefix(s.HostKeyFingerprint, "SHA256:") {
+		return fmt.Errorf("ssh check %s: hostKeyFingerprint must be a SHA256: fingerprint as printed by ssh-keygen -l", s.Name)
+	}
+	if (s.Username == "") != (s.PrivateKeyFile == "") {
+		return fmt.Errorf("ssh check %s: username and privateKeyFile must be set together", s.Name)
+	}
+	return nil
+}
+
+func (check SSHCheck) run(ctx context.Context) checkResult {
+	port := check.Port
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(check.Address, strconv.Itoa(port))
+
+	var auth []ssh.AuthMethod
+	user := check.Username
+	if check.PrivateKeyFile != "" {
+		signer, err := check.signer()
+		if err != nil {
+			return checkFailed("SSH Name: %s, private key could not be loaded: %v", check.Name, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else {
+		user = "server-health-api"
+	}
+
+	var fingerprint string
+	config := &ssh.ClientConfig{
+		User:    user,
+		Auth:    auth,
+		Timeout: 5 * time.Second,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			if check.HostKeyFingerprint != "" && fingerprint != check.HostKeyFingerprint {
+				return fmt.Errorf("host key %s does not match pinned %s", fingerprint, check.HostKeyFingerprint)
+			}
+			return nil
+		},
+	}
+
+	conn, err := dialCheck(ctx, "tcp", address, config.Timeout, nil)
+	if err != nil {
+		return checkFailed("SSH Name: %s, Address: %s is not reachable", check.Name, address)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return checkFailed("SSH Name: %s, Address: %s %v", check.Name, address, err)
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	switch {
+	case err != nil && check.HostKeyFingerprint != "" && fingerprint != "" && fingerprint != check.HostKeyFingerprint:
+		return checkFailed("SSH Name: %s, Address: %s, host key %s does not match pinned %s", check.Name, address, fingerprint, check.HostKeyFingerprint)
+	case err != nil && len(auth) == 0 && fingerprint != "":
+		// Key exchange succeeded; without credentials authentication is
+		// expected to be refused.
+		return checkOK("SSH Name: %s, Address: %s, Host key: %s completed the handshake", check.Name, address, fingerprint)
+	case err != nil:
+		return checkFailed("SSH Name: %s, Address: %s handshake failed: %v", check.Name, address, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer closeAndLog(client, "ssh client")
+	return checkOK("SSH Name: %s, Address: %s, Host key: %s, authenticated as %s", check.Name, address, fingerprint, user)
+}
+
+func (check SSHCheck) signer() (ssh.Signer, error) {
+	key, err := os.ReadFile(check.PrivateKeyFile) // #nosec G304 -- path comes from the operator's config
+	if err != nil {
+		return nil, err
+	}
+	if check.Passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(check.Passphrase))
+	}
+	return ssh.ParsePrivateKey(key)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// StatsDConfig sends each check's status and run time to a StatsD server
+// over UDP every time checks run. Plain StatsD has no tags, so the check
+// type and name go into the metric names; with DogStatsD set they are sent
+// as tags instead, along with Tags.
+type StatsDConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	Address   string            `yaml:"address"`
+	Prefix    string            `yaml:"prefix"`
+	DogStatsD bool              `yaml:"dogstatsd"`
+	Tags      map[string]string `yaml:"tags"`
+}
+
+// Validate checks the server address and that tags are only set for
+// DogStatsD.
+func (c *StatsDConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("statsd: address must be host:port: %w", err)
+		}
+	}
+	if len(c.Tags) > 0 && !c.DogStatsD {
+		return fmt.Errorf("statsd: tags require dogstatsd")
+	}
+	return nil
+}
+
+// statsdEmitter writes metrics to a StatsD server.
+type statsdEmitter struct {
+	conn   net.Conn
+	prefix string
+	dog    bool
+	tags   []string
+}
+
+// statsdMaxPacket keeps packets within a typical path MTU, as StatsD servers
+// drop datagrams split in transit.
+const statsdMaxPacket = 1432
+
+var statsdNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// newStatsD connects to the configured server, defaulting to the local
+// agent at 127.0.0.1:8125.
+func newStatsD(cfg StatsDConfig) (*statsdEmitter, error) {
+	address := cfg.Address
+	if address == "" {
+		address = "127.0.0.1:8125"
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	e := &statsdEmitter{conn: conn, prefix: cfg.Prefix, dog: cfg.DogStatsD}
+	if e.prefix == "" {
+		e.prefix = "server_health"
+	}
+	for k, v := range cfg.Tags {
+		e.tags = append(e.tags, k+":"+v)
+	}
+	sort.Strings(e.tags)
+	return e, nil
+}
+
+// export sends a status gauge, 0 for ok, 1 for warning and 2 for critical,
+// and a run time in milliseconds for each check. UDP writes do not wait on
+// the server.
+func (e *statsdEmitter) export(results []checkResult) {
+	var lines []string
+	for _, r := range results {
+		lines = append(lines, e.lines(r)...)
+	}
+	e.write(lines)
+}
+
+// write sends lines, batched into as few packets as fit.
+func (e *statsdEmitter) write(lines []string) {
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			e.send(packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		e.send(packet.String())
+	}
+}
+
+// lines formats the metrics for one result.
+func (e *statsdEmitter) lines(r checkResult) []string {
+	status := fmt.Sprintf(":%d|g", r.Status)
+	duration := fmt.Sprintf(":%.3f|ms", milliseconds(r.Duration))
+	if !e.dog {
+		base := e.prefix + ".check." + statsdName(r.Type) + "." + statsdName(r.Name)
+		return []string{base + ".status" + status, base + ".duration" + duration}
+	}
+	tags := "|#" + strings.Join(append(resultTags(r), e.tags...), ",")
+	base := e.prefix + ".check"
+	return []string{base + ".status" + status + tags, base + ".duration" + duration + tags}
+}
+
+func (e *statsdEmitter) send(packet string) {
+	if _, err := e.conn.Write([]byte(packet)); err != nil {
+		log.Printf("statsd: %v", err)
+	}
+}
+
+// statsdName makes s safe as one segment of a dotted metric name.
+func statsdName(s string) string {
+	return statsdNameRegex.ReplaceAllString(s, "_")
+}
+
+// statsdTag makes s safe as a DogStatsD tag value, which cannot hold the
+// separators of the line format.
+// resultTags are the DogStatsD tags of r: its type, name and labels.
+func resultTags(r checkResult) []string {
+	tags := []string{"type:" + r.Type, "name:" + statsdTag(r.Name)}
+	for _, k := range sortedKeys(r.Labels) {
+		tags = append(tags, k+":"+statsdTag(r.Labels[k]))
+	}
+	return tags
+}
+
+func statsdTag(s string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(s)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SysctlCheck compares a kernel parameter against its expected value.
+type SysctlCheck struct {
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+var sysctlKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// Validate checks the key name.
+func (s *SysctlCheck) Validate() error {
+	if !sysctlKeyRegex.MatchString(s.Key) {
+		return fmt.Errorf("sysctl check %s: invalid key %q", s.Name, s.Key)
+	}
+	return nil
+}
+
+func (check SysctlCheck) run(ctx context.Context) checkResult {
+	value, err := readSysctl(check.Key)
+	switch {
+	case err != nil:
+		return checkFailed("Sysctl Name: %s, Key: %s could not be read: %v", check.Name, check.Key, err)
+	case value != normalizeSysctl(check.Value):
+		return checkFailed("Sysctl Name: %s, Key: %s, Expected Value: %s, Actual Value: %s", check.Name, check.Key, check.Value, value)
+	default:
+		return checkOK("Sysctl Name: %s, Key: %s, Value: %s is as expected", check.Name, check.Key, value)
+	}
+}
+
+func readSysctl(key string) (string, error) {
+	path := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+	data, err := os.ReadFile(path) // #nosec G304 -- key is validated by regex
+	if err != nil {
+		return "", err
+	}
+	return normalizeSysctl(string(data)), nil
+}
+
+// normalizeSysctl collapses whitespace so multi-value parameters such as
+// net.ipv4.ip_local_port_range compare equal regardless of tabs or spaces.
+func normalizeSysctl(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// systemBus is the connection service checks ask systemd for unit states
+// over, rather than running systemctl for each. It is opened on first use
+// and again after an error.
+var systemBus dbusConn
+
+// errNoSystemBus is returned when the system bus cannot be reached, in which
+// case service checks fall back to systemctl.
+var errNoSystemBus = errors.New("system bus unavailable")
+
+// unitTypes are the unit name suffixes systemd knows; names without one are
+// services, as with systemctl.
+var unitTypes = []string{
+	".service", ".socket", ".device", ".mount", ".automount", ".swap",
+	".target", ".path", ".timer", ".slice", ".scope",
+}
+
+// unitState returns the ActiveState and SubState systemd reports for unit.
+// Units that are not loaded are inactive and dead.
+func unitState(ctx context.Context, unit string) (active, sub string, err error) {
+	if !hasUnitType(unit) {
+		unit += ".service"
+	}
+	path := "/org/freedesktop/systemd1/unit/" + busLabelEscape(unit)
+	if active, err = systemBus.unitProperty(ctx, path, "ActiveState"); err != nil {
+		return "", "", err
+	}
+	if sub, err = systemBus.unitProperty(ctx, path, "SubState"); err != nil {
+		return "", "", err
+	}
+	return active, sub, nil
+}
+
+func hasUnitType(unit string) bool {
+	for _, t := range unitTypes {
+		if strings.HasSuffix(unit, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// busLabelEscape escapes a unit name into an object path element the way
+// systemd does: letters and digits are kept, anything else is _ and two
+// hex digits.
+func busLabelEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// systemBusAddress returns the socket of the system bus, from
+// DBUS_SYSTEM_BUS_ADDRESS when set. Abstract sockets start with "@".
+func systemBusAddress() string {
+	addresses := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if addresses == "" {
+		return "/run/dbus/system_bus_socket"
+	}
+	for _, address := range strings.Split(addresses, ";") {
+		params, ok := strings.CutPrefix(address, "unix:")
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			if path, ok := strings.CutPrefix(param, "path="); ok {
+				return path
+			}
+			if name, ok := strings.CutPrefix(param, "abstract="); ok {
+				return "@" + name
+			}
+		}
+	}
+	return ""
+}
+
+// dbusConn is a minimal D-Bus client: it authenticates as the process's
+// user and makes method calls with string arguments, one at a time.
+type dbusConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// unitProperty reads a string property of the systemd unit at path.
+func (c *dbusConn) unitProperty(ctx context.Context, path, name string) (string, error) {
+	reply, err := c.call(ctx, "org.freedesktop.systemd1", path, "org.freedesktop.DBus.Properties", "Get",
+		"org.freedesktop.systemd1.Unit", name)
+	if err != nil {
+		return "", err
+	}
+	sig, err := reply.signature()
+	if err != nil {
+		return "", err
+	}
+	if sig != "s" {
+		return "", fmt.Errorf("%s is of type %q, not a string", name, sig)
+	}
+	return reply.string()
+}
+
+// call makes a method call with string arguments and returns a reader for
+// the reply's body. A connection that fails is closed, and the call made
+// again once on a new one, since the bus may have restarted since the last
+// call.
+func (c *dbusConn) call(ctx context.Context, dest, path, iface, member string, args ...string) (*dbusReader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		reused := c.conn != nil
+		if !reused {
+			if err := c.open(ctx); err != nil {
+				return nil, err
+			}
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(5 * time.Second)
+		}
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		body, err := c.roundTrip(dest, path, iface, member, args)
+		var callErr *dbusError
+		if err == nil || errors.As(err, &callErr) {
+			return body, err
+		}
+		closeAndLog(c.conn, "system bus connection")
+		c.conn = nil
+		if !reused || attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// open connects to the system bus, authenticates with EXTERNAL and says
+// Hello, as every bus client must before its first call.
+func (c *dbusConn) open(ctx context.Context) error {
+	address := systemBusAddress()
+	if address == "" {
+		return errNoSystemBus
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoSystemBus, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		closeAndLog(conn, "system bus connection")
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err = io.WriteString(conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err == nil {
+		var line string
+		if line, err = c.reader.ReadString('\n'); err == nil && !strings.HasPrefix(line, "OK ") {
+			err = fmt.Errorf("authentication rejected: %s", strings.TrimSpace(line))
+		}
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, "BEGIN\r\n")
+	}
+	if err == nil {
+		_, err = c.roundTrip("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", nil)
+	}
+	if err != nil {
+		closeAndLog(conn, "system bus connection")
+		c.conn = nil
+		return fmt.Errorf("system bus: %w", err)
+	}
+	return nil
+}
+
+// dbusError is an error reply to a method call.
+type dbusError struct {
+	name, message string
+}
+
+func (e *dbusError) Error() string {
+	if e.message == "" {
+		return e.name
+	}
+	return e.name + ": " + e.message
+}
+
+// D-Bus message types and header fields.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusErrorReply   = 3
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// roundTrip sends a method call and reads messages until its reply,
+// skipping signals sent in between.
+func (c *dbusConn) roundTrip(dest, path, iface, member string, args []string) (*dbusReader, error) {
+	c.serial++
+	serial := c.serial
+	if _, err := c.conn.Write(dbusMethodCallMessage(serial, dest, path, iface, member, args)); err != nil {
+		return nil, err
+	}
+	for {
+		msgType, fields, body, err := readDBusMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if (msgType != dbusMethodReturn && msgType != dbusErrorReply) || fields.replySerial != serial {
+			continue
+		}
+		if msgType == dbusErrorReply {
+			e := &dbusError{name: fields.errorName}
+			if strings.HasPrefix(fields.signature, "s") {
+				e.message, _ = body.string()
+			}
+			return nil, e
+		}
+		return body, nil
+	}
+}
+
+// dbusMethodCallMessage encodes a method call in little-endian byte order.
+func dbusMethodCallMessage(serial uint32, dest, path, iface, member string, args []string) []byte {
+	body := &dbusWriter{}
+	for _, arg := range args {
+		body.string(arg)
+	}
+
+	w := &dbusWriter{}
+	w.buf = append(w.buf, 'l', dbusMethodCall, 0, 1)
+	w.uint32(uint32(len(body.buf))) // #nosec G115 -- arguments are a few unit and property names
+	w.uint32(serial)
+	w.uint32(0) // header fields length, set below
+	w.align(8)
+	start := len(w.buf)
+	field := func(code byte, sig, value string) {
+		w.align(8)
+		w.buf = append(w.buf, code)
+		w.signature(sig)
+		if sig == "g" {
+			w.signature(value)
+		} else {
+			w.string(value)
+		}
+	}
+	field(dbusFieldPath, "o", path)
+	field(dbusFieldInterface, "s", iface)
+	field(dbusFieldMember, "s", member)
+	field(dbusFieldDestination, "s", dest)
+	if len(args) > 0 {
+		field(dbusFieldSignature, "g", strings.Repeat("s", len(args)))
+	}
+	binary.LittleEndian.PutUint32(w.buf[12:], uint32(len(w.buf)-start)) // #nosec G115 -- the header is a few hundred bytes
+	w.align(8)
+	return append(w.buf, body.buf...)
+}
+
+// dbusFields are the header fields of a reply that calls look at.
+type dbusFields struct {
+	replySerial uint32
+	errorName   string
+	signature   string
+}
+
+// readDBusMessage reads one message, in either byte order.
+func readDBusMessage(r io.Reader) (msgType byte, fields dbusFields, body *dbusReader, err error) {
+	fixed := make([]byte, 16)
+	if _, err = io.ReadFull(r, fixed); err != nil {
+		return 0, fields, nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch fixed[0] {
+	case 'l':
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return 0, fields, nil, fmt.Errorf("invalid message byte order %q", fixed[0])
+	}
+	bodyLen, fieldsLen := order.Uint32(fixed[4:]), order.Uint32(fixed[12:])
+	if bodyLen > 1<<20 || fieldsLen > 1<<16 {
+		return 0, fields, nil, fmt.Errorf("message too large")
+	}
+	headerLen := (16 + int(fieldsLen) + 7) &^ 7
+	msg := make([]byte, headerLen+int(bodyLen))
+	copy(msg, fixed)
+	if _, err = io.ReadFull(r, msg[16:]); err != nil {
+		return 0, fields, nil, err
+	}
+
+	h := &dbusReader{data: msg[:16+int(fieldsLen)], pos: 16, order: order}
+	for h.pos < len(h.data) {
+		h.align(8)
+		if h.pos >= len(h.data) {
+			break
+		}
+		code := h.data[h.pos]
+		h.pos++
+		sig, err := h.signature()
+		if err != nil {
+			return 0, fields, nil, err
+		}
+		switch sig {
+		case "u":
+			v, err := h.uint32()
+			if err != nil {
+				return 0, fields, nil, err
+			}
+			if code == dbusFieldReplySerial {
+				fields.replySerial = v
+			}
+		case "s", "o":
+			v, err := h.string()
+			if err != nil {
+				return 0, fields, nil, err
+			}
+			if code == dbusFieldErrorName {
+				fields.errorName = v
+			}
+		case "g":
+			v, err := h.signature()
+			if err != nil {
+				return 0, fields, nil, err
+			}
+			if code == dbusFieldSignature {
+				fields.signature = v
+			}
+		default:
+			return 0, fields, nil, fmt.Errorf("unexpected header field type %q", sig)
+		}
+	}
+	return fixed[1], fields, &dbusReader{data: msg[headerLen:], order: order}, nil
+}
+
+// dbusWriter marshals D-Bus values in little-endian byte order.
+type dbusWriter struct {
+	buf []byte
+}
+
+func (w *dbusWriter) align(n int) {
+	for len(w.buf)%n != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *dbusWriter) uint32(v uint32) {
+	w.align(4)
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+func (w *dbusWriter) string(s string) {
+	w.uint32(uint32(len(s))) // #nosec G115 -- strings are unit, property and interface names
+	w.buf = append(append(w.buf, s...), 0)
+}
+
+func (w *dbusWriter) signature(s string) {
+	w.buf = append(append(append(w.buf, byte(len(s))), s...), 0)
+}
+
+// dbusReader unmarshals D-Bus values in order; alignment is relative to
+// the start of data, so data must start at the start of a message or its
+// body.
+type dbusReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (r *dbusReader) align(n int) {
+	r.pos = (r.pos + n - 1) / n * n
+}
+
+func (r *dbusReader) uint32() (uint32, error) {
+	r.align(4)
+	if r.pos+4 > len(r.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := r.order.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *dbusReader) string() (string, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return "", err
+	}
+	if uint64(r.pos)+uint64(n) >= uint64(len(r.data)) {
+		return "", io.ErrUnexpectedEOF
+	}
+	s := string(r.data[r.pos : r.pos+int(n)])
+	r.pos += int(n) + 1
+	return s, nil
+}
+
+func (r *dbusReader) signature() (string, error) {
+	if r.pos >= len(r.data) {
+		return "", io.ErrUnexpectedEOF
+	}
+	n := int(r.data[r.pos])
+	if r.pos+1+n >= len(r.data) {
+		return "", io.ErrUnexpectedEOF
+	}
+	s := string(r.data[r.pos+1 : r.pos+1+n])
+	r.pos += n + 2
+	return s, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxTargets caps the checks one definition expands to, so a mistyped
+// range cannot start thousands of checks.
+const maxTargets = 1024
+
+// Targets expand one port or endpoint check over several hosts and ports,
+// inlined into their YAML as hosts and ports. Hosts are names, IP
+// addresses or IPv4 ranges, such as 10.0.0.1-10.0.0.10 or 10.0.0.1-10.
+// Ports are comma-separated ports and ranges, such as 9000-9010,9100. Each
+// host and port makes a check of its own, named after the definition with
+// the host and port appended, as in backend-10.0.0.1-9000.
+type Targets struct {
+	Hosts []string `yaml:"hosts"`
+	Ports string   `yaml:"ports"`
+}
+
+// set reports whether the check expands over targets.
+func (t Targets) set() bool {
+	return len(t.Hosts) > 0 || t.Ports != ""
+}
+
+// expand returns the hosts and ports to check, each nil when unset.
+func (t Targets) expand() ([]string, []int, error) {
+	var hosts []string
+	for _, entry := range t.Hosts {
+		expanded, err := expandHosts(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	var ports []int
+	if t.Ports != "" {
+		for _, entry := range strings.Split(t.Ports, ",") {
+			expanded, err := expandPorts(strings.TrimSpace(entry))
+			if err != nil {
+				return nil, nil, err
+			}
+			ports = append(ports, expanded...)
+		}
+	}
+	if max(len(hosts), 1)*max(len(ports), 1) > maxTargets {
+		return nil, nil, fmt.Errorf("hosts and ports expand to more than %d checks", maxTargets)
+	}
+	return hosts, ports, nil
+}
+
+// Validate checks the hosts and ports expand.
+func (t Targets) Validate() error {
+	_, _, err := t.expand()
+	return err
+}
+
+// expandHosts expands entry, a host or an IPv4 range.
+func expandHosts(entry string) ([]string, error) {
+	first, last, isRange := strings.Cut(entry, "-")
+	start := net.ParseIP(first).To4()
+	if !isRange || start == nil {
+		if entry == "" {
+			return nil, fmt.Errorf("hosts must not be empty")
+		}
+		return []string{entry}, nil
+	}
+	end := net.ParseIP(last).To4()
+	if end == nil {
+		// A last octet alone, as in 10.0.0.1-10.
+		octet, err := strconv.Atoi(last)
+		if err != nil || octet < 0 || octet > 255 {
+			return nil, fmt.Errorf("invalid host range %q", entry)
+		}
+		end = net.IPv4(start[0], start[1], start[2], byte(octet)).To4()
+	}
+	from, to := binary.BigEndian.Uint32(start), binary.BigEndian.Uint32(end)
+	if from > to {
+		return nil, fmt.Errorf("host range %q starts after it ends", entry)
+	}
+	if to-from >= maxTargets {
+		return nil, fmt.Errorf("host range %q has more than %d addresses", entry, maxTargets)
+	}
+	hosts := make([]string, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		hosts = append(hosts, ip.String())
+	}
+	return hosts, nil
+}
+
+// expandPorts expands entry, a port or a range of them.
+func expandPorts(entry string) ([]int, error) {
+	first, last, isRange := strings.Cut(entry, "-")
+	if !isRange {
+		last = first
+	}
+	from, err1 := strconv.Atoi(first)
+	to, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || from < 1 || to > 65535 {
+		return nil, fmt.Errorf("invalid port or port range %q", entry)
+	}
+	if from > to {
+		return nil, fmt.Errorf("port range %q starts after it ends", entry)
+	}
+	ports := make([]int, 0, to-from+1)
+	for p := from; p <= to; p++ {
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// targetName names the check for host and port, either of which may be
+// unset.
+func targetName(name, host string, port int) string {
+	if host != "" {
+		name += "-" + host
+	}
+	if port != 0 {
+		name += "-" + strconv.Itoa(port)
+	}
+	return name
+}
+
+// each calls fn with every host and port expanded, "" or 0 for those unset.
+func (t Targets) each(fn func(host string, port int)) {
+	hosts, ports, err := t.expand()
+	if err != nil {
+		return // reported by Validate
+	}
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	if len(ports) == 0 {
+		ports = []int{0}
+	}
+	for _, host := range hosts {
+		for _, port := range ports {
+			fn(host, port)
+		}
+	}
+}
+
+// targets returns the port checks the definition expands to, or the
+// check itself when it sets no targets.
+func (port Port) targets() []Port {
+	if !port.Targets.set() {
+		return []Port{port}
+	}
+	var out []Port
+	port.Targets.each(func(host string, p int) {
+		out = append(out, port.at(host, p))
+	})
+	return out
+}
+
+// at returns the check for host and port, keeping the definition's own
+// for either left unset.
+func (port Port) at(host string, p int) Port {
+	t := port
+	t.Targets = Targets{}
+	t.Name = targetName(port.Name, host, p)
+	if host != "" {
+		t.Address = host
+	}
+	if p != 0 {
+		t.Port = p
+	}
+	return t
+}
+
+// targets returns the endpoint checks the definition expands to, or the
+// check itself when it sets no targets.
+func (endpoint Endpoint) targets() []Endpoint {
+	if !endpoint.Targets.set() {
+		return []Endpoint{endpoint}
+	}
+	var out []Endpoint
+	endpoint.Targets.each(func(host string, p int) {
+		out = append(out, endpoint.at(host, p))
+	})
+	return out
+}
+
+// at returns the check for host and port, replacing those of the URL, and
+// keeping the URL's own for either left unset.
+func (endpoint Endpoint) at(host string, p int) Endpoint {
+	t := endpoint
+	t.Targets = Targets{}
+	t.Name = targetName(endpoint.Name, host, p)
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return t // reported by Validate
+	}
+	if host == "" {
+		host = u.Hostname()
+	}
+	switch {
+	case p != 0:
+		u.Host = net.JoinHostPort(host, strconv.Itoa(p))
+	case u.Port() != "":
+		u.Host = net.JoinHostPort(host, u.Port())
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	t.URL = u.String()
+	return t
+}
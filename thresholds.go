@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thresholds grade a check's numeric reading, such as a response time or a
+// queue depth, as warning or critical using Nagios-style ranges, inlined
+// into the YAML of the checks that take them as warn and crit. A range is
+// [@]start:end: the reading breaches it when outside start to end, or
+// inside it with a leading @. start defaults to 0 and may be ~ for no lower
+// bound, and end may be left out for no upper bound, so "200" breaches above
+// 200 and "10:" below 10.
+type Thresholds struct {
+	Warn string `yaml:"warn"`
+	Crit string `yaml:"crit"`
+}
+
+// thresholdRange is a parsed Nagios-style range.
+type thresholdRange struct {
+	low, high float64
+	inside    bool
+}
+
+// parseRange parses a range such as 10, 10:, ~:10, 10:20 or @10:20.
+func parseRange(s string) (thresholdRange, error) {
+	r := thresholdRange{low: 0, high: math.Inf(1)}
+	spec := strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(spec, "@"); ok {
+		r.inside, spec = true, rest
+	}
+	start, end, hasStart := strings.Cut(spec, ":")
+	if !hasStart {
+		start, end = "", spec
+	}
+	var err error
+	switch start {
+	case "":
+	case "~":
+		r.low = math.Inf(-1)
+	default:
+		if r.low, err = strconv.ParseFloat(start, 64); err != nil {
+			return thresholdRange{}, fmt.Errorf("invalid range %q", s)
+		}
+	}
+	if end != "" {
+		if r.high, err = strconv.ParseFloat(end, 64); err != nil {
+			return thresholdRange{}, fmt.Errorf("invalid range %q", s)
+		}
+	} else if !hasStart {
+		return thresholdRange{}, fmt.Errorf("invalid range %q", s)
+	}
+	if r.low > r.high {
+		return thresholdRange{}, fmt.Errorf("range %q starts after it ends", s)
+	}
+	return r, nil
+}
+
+// breached reports whether v falls foul of the range.
+func (r thresholdRange) breached(v float64) bool {
+	within := v >= r.low && v <= r.high
+	return within == r.inside
+}
+
+// Validate checks both ranges parse.
+func (t *Thresholds) Validate() error {
+	for _, s := range []string{t.Warn, t.Crit} {
+		if s == "" {
+			continue
+		}
+		if _, err := parseRange(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set reports whether either range is configured.
+func (t Thresholds) set() bool {
+	return t.Warn != "" || t.Crit != ""
+}
+
+// grade returns the status of reading v and, unless it is ok, a description
+// of the range breached.
+func (t Thresholds) grade(v float64) (checkStatus, string) {
+	if r, err := parseRange(t.Crit); t.Crit != "" && err == nil && r.breached(v) {
+		return statusCritical, "breaches critical range " + t.Crit
+	}
+	if r, err := parseRange(t.Warn); t.Warn != "" && err == nil && r.breached(v) {
+		return statusWarning, "breaches warning range " + t.Warn
+	}
+	return statusOK, ""
+}
+
+// apply grades v when any range is set, appending reading, when not empty,
+// and any breach to result's message and raising its status to match.
+func (t Thresholds) apply(result checkResult, v float64, reading string) checkResult {
+	if !t.set() {
+		return result
+	}
+	if reading != "" {
+		result.Message += ", " + reading
+	}
+	status, breach := t.grade(v)
+	if status > result.Status {
+		result.Status, result.Reason = status, reasonThreshold
+	}
+	if breach != "" {
+		result.Message += " " + breach
+	}
+	return result
+}
+
+// milliseconds returns d as a number of milliseconds, the unit of duration
+// thresholds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
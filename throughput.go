@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultUploadBytes is how much an upload test sends unless uploadBytes
+// is set.
+const defaultUploadBytes = 10 << 20
+
+// ThroughputCheck downloads URL, up to MaxBytes when set, and fails when
+// the transfer is slower than MinMbps megabits per second, so a degraded
+// NIC or saturated link takes a host out for data-intensive roles. With
+// UploadURL set it also POSTs UploadBytes to that sink and fails when the
+// upload is slower than MinUploadMbps. Use objects large enough, tens of
+// megabytes, for the transfer to outlast connection setup.
+type ThroughputCheck struct {
+	Name          string            `yaml:"name"`
+	URL           string            `yaml:"url"`
+	MaxBytes      int64             `yaml:"maxBytes"`
+	MinMbps       float64           `yaml:"minMbps"`
+	UploadURL     string            `yaml:"uploadURL"`
+	UploadBytes   int64             `yaml:"uploadBytes"`
+	MinUploadMbps float64           `yaml:"minUploadMbps"`
+	Headers       map[string]string `yaml:"headers"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the URLs, sizes and rates.
+func (t *ThroughputCheck) Validate() error {
+	targets := []string{t.URL}
+	if t.UploadURL != "" {
+		targets = append(targets, t.UploadURL)
+	}
+	for _, target := range targets {
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("throughput check %s: %q must be an http:// or https:// URL", t.Name, target)
+		}
+	}
+	if t.MaxBytes < 0 || t.UploadBytes < 0 {
+		return fmt.Errorf("throughput check %s: maxBytes and uploadBytes must not be negative", t.Name)
+	}
+	if t.MinMbps < 0 || t.MinUploadMbps < 0 {
+		return fmt.Errorf("throughput check %s: minMbps and minUploadMbps must not be negative", t.Name)
+	}
+	if (t.UploadBytes > 0 || t.MinUploadMbps > 0) && t.UploadURL == "" {
+		return fmt.Errorf("throughput check %s: uploadBytes and minUploadMbps require uploadURL", t.Name)
+	}
+	return nil
+}
+
+func (t ThroughputCheck) run(ctx context.Context) checkResult {
+	n, elapsed, err := t.download(ctx)
+	if err != nil {
+		return checkFailed("Throughput Name: %s, URL: %s %v", t.Name, t.URL, err)
+	}
+	download := mbps(n, elapsed)
+	message := fmt.Sprintf("Throughput Name: %s, Download: %.1f Mbit/s (%d bytes in %s)", t.Name, download, n, elapsed.Round(time.Millisecond))
+	if t.MinMbps > 0 && download < t.MinMbps {
+		return checkFailed("%s is below minimum %g Mbit/s", message, t.MinMbps)
+	}
+	if t.UploadURL != "" {
+		size := t.UploadBytes
+		if size == 0 {
+			size = defaultUploadBytes
+		}
+		elapsed, err := t.upload(ctx, size)
+		if err != nil {
+			return checkFailed("Throughput Name: %s, Upload URL: %s %v", t.Name, t.UploadURL, err)
+		}
+		upload := mbps(size, elapsed)
+		message += fmt.Sprintf(", Upload: %.1f Mbit/s (%d bytes in %s)", upload, size, elapsed.Round(time.Millisecond))
+		if t.MinUploadMbps > 0 && upload < t.MinUploadMbps {
+			return checkFailed("%s is below minimum %g Mbit/s", message, t.MinUploadMbps)
+		}
+	}
+	return checkOK("%s", message)
+}
+
+// download fetches URL, returning how many bytes of the body were read and
+// how long the transfer took from the response headers on.
+func (t ThroughputCheck) download(ctx context.Context) (int64, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("is invalid: %w", err)
+	}
+	req.Header = requestHeaders("", t.Headers)
+	// A compressed transfer would measure the content rather than the link.
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := checkClient(ctx, req.URL.Scheme == "https").Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("is not reachable")
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	body := io.Reader(resp.Body)
+	if t.MaxBytes > 0 {
+		body = io.LimitReader(body, t.MaxBytes)
+	}
+	start := time.Now()
+	n, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not be read: %v", err)
+	}
+	return n, time.Since(start), nil
+}
+
+// upload POSTs size bytes to UploadURL, returning how long it took until
+// the sink answered.
+func (t ThroughputCheck) upload(ctx context.Context, size int64) (time.Duration, error) {
+	// Random data, so nothing along the way can compress it.
+	chunk := make([]byte, 64<<10)
+	if _, err := rand.Read(chunk); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.UploadURL, io.LimitReader(repeatReader(chunk), size))
+	if err != nil {
+		return 0, fmt.Errorf("is invalid: %w", err)
+	}
+	req.ContentLength = size
+	req.Header = requestHeaders("", t.Headers)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	start := time.Now()
+	resp, err := checkClient(ctx, req.URL.Scheme == "https").Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("is not reachable")
+	}
+	elapsed := time.Since(start)
+	closeAndLog(resp.Body, "response body")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return elapsed, nil
+}
+
+// repeatReader reads chunk over and over.
+type repeatReader []byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r)
+	}
+	return n, nil
+}
+
+// mbps is the rate of n bytes in d in megabits per second.
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return float64(n) * 8 / 1e6 / d.Seconds()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStepBody bounds how much of a response a transaction step reads to
+// match and capture from.
+const maxStepBody = 1 << 20
+
+// TransactionCheck runs HTTP requests in turn, as a user's transaction
+// would, passing when every step gets the response it expects. Values a
+// step captures from its response replace {name} in the URLs, headers and
+// bodies of the steps after it, and cookies set by one step are sent with
+// the next, so a step can log in and the following ones act as that user.
+type TransactionCheck struct {
+	Name      string            `yaml:"name"`
+	Steps     []TransactionStep `yaml:"steps"`
+	UserAgent string            `yaml:"userAgent"`
+
+	// Thresholds grade the total time of the steps in milliseconds.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// TransactionStep is one request of a transaction. Statuses default to
+// 200, BodyRegex must match the response body and Capture names the
+// values to take from the response.
+type TransactionStep struct {
+	Name      string             `yaml:"name"`
+	URL       string             `yaml:"url"`
+	Method    string             `yaml:"method"`
+	Headers   map[string]string  `yaml:"headers"`
+	Body      string             `yaml:"body"`
+	Statuses  []int              `yaml:"statuses"`
+	Status    int                `yaml:"status"`
+	BodyRegex string             `yaml:"bodyRegex"`
+	Expected  []HeaderAssertion  `yaml:"expectedHeaders"`
+	Capture   map[string]Capture `yaml:"capture"`
+}
+
+// Capture takes a value from a response: the field at the dot-separated
+// JSON path, such as data.token or items.0.id, of the body, or the value
+// of Header, or the first group Regex matches in the body or, with Header
+// set, in the header.
+type Capture struct {
+	JSON   string `yaml:"json"`
+	Header string `yaml:"header"`
+	Regex  string `yaml:"regex"`
+}
+
+// Validate checks the steps' URLs, methods and patterns, and that every
+// placeholder names a value an earlier step captures.
+func (t *TransactionCheck) Validate() error {
+	if len(t.Steps) == 0 {
+		return fmt.Errorf("transaction check %s: steps are required", t.Name)
+	}
+	captured := map[string]bool{}
+	for i, step := range t.Steps {
+		where := fmt.Sprintf("transaction check %s: step %s", t.Name, step.label(i))
+		texts := []string{step.URL, step.Body}
+		for _, v := range step.Headers {
+			texts = append(texts, v)
+		}
+		for _, text := range texts {
+			for _, m := range placeholderRegex.FindAllStringSubmatch(text, -1) {
+				if !captured[m[1]] {
+					return fmt.Errorf("%s: {%s} is not captured by an earlier step", where, m[1])
+				}
+			}
+		}
+		if u, err := url.Parse(step.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%s: url must be an http:// or https:// URL", where)
+		}
+		switch step.Method {
+		case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			return fmt.Errorf("%s: unsupported method %s", where, step.Method)
+		}
+		if _, err := regexp.Compile(step.BodyRegex); err != nil {
+			return fmt.Errorf("%s: invalid bodyRegex: %w", where, err)
+		}
+		for _, h := range step.Expected {
+			if h.Name == "" {
+				return fmt.Errorf("%s: expectedHeaders entries require a name", where)
+			}
+			if h.Value != "" && h.Regex != "" {
+				return fmt.Errorf("%s: header %s cannot set both value and regex", where, h.Name)
+			}
+			if _, err := regexp.Compile(h.Regex); err != nil {
+				return fmt.Errorf("%s: header %s: invalid regex: %w", where, h.Name, err)
+			}
+		}
+		for name, c := range step.Capture {
+			if !captureNameRegex.MatchString(name) {
+				return fmt.Errorf("%s: invalid capture name %q", where, name)
+			}
+			if c.JSON != "" && (c.Header != "" || c.Regex != "") {
+				return fmt.Errorf("%s: capture %s: json cannot be used with header or regex", where, name)
+			}
+			if c.JSON == "" && c.Header == "" && c.Regex == "" {
+				return fmt.Errorf("%s: capture %s: json, header or regex is required", where, name)
+			}
+			if c.Regex != "" {
+				re, err := regexp.Compile(c.Regex)
+				if err != nil {
+					return fmt.Errorf("%s: capture %s: invalid regex: %w", where, name, err)
+				}
+				if re.NumSubexp() < 1 {
+					return fmt.Errorf("%s: capture %s: regex must have a group", where, name)
+				}
+			}
+			captured[name] = true
+		}
+	}
+	if err := t.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("transaction check %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+// placeholderRegex matches a {name} placeholder, and captureNameRegex the
+// names values can be captured as.
+var (
+	placeholderRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	captureNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// label is the step's name, or its position from 1.
+func (s TransactionStep) label(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+func (t TransactionCheck) run(ctx context.Context) checkResult {
+	jar, _ := cookiejar.New(nil) // never fails without options
+	values := map[string]string{}
+	expand := func(s string) string {
+		return placeholderRegex.ReplaceAllStringFunc(s, func(m string) string {
+			return values[m[1:len(m)-1]]
+		})
+	}
+	start := time.Now()
+	for i, step := range t.Steps {
+		if problem := step.run(ctx, jar, t.UserAgent, expand, values); problem != "" {
+			return checkFailed("Transaction Name: %s, Step: %s, %s", t.Name, step.label(i), problem)
+		}
+	}
+	elapsed := time.Since(start)
+	result := checkOK("Transaction Name: %s, %d steps completed as expected", t.Name, len(t.Steps))
+	return t.Thresholds.apply(result, milliseconds(elapsed), "Total Time: "+elapsed.Round(time.Millisecond).String())
+}
+
+// run makes the step's request, adding what it captures to values, and
+// describes how the response is not as expected, or returns an empty
+// string.
+func (s TransactionStep) run(ctx context.Context, jar http.CookieJar, userAgent string, expand func(string) string, values map[string]string) string {
+	method := s.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	target := expand(s.URL)
+	var body io.Reader
+	if s.Body != "" {
+		body = strings.NewReader(expand(s.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Sprintf("URL: %s is invalid: %v", target, err)
+	}
+	headers := make(map[string]string, len(s.Headers))
+	for name, value := range s.Headers {
+		headers[name] = expand(value)
+	}
+	req.Header = requestHeaders(userAgent, headers)
+	// Each run gets its own cookies over the shared transport.
+	client := *checkClient(ctx, req.URL.Scheme == "https")
+	client.Jar = jar
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Sprintf("URL: %s is not reachable", target)
+	}
+	defer closeAndLog(resp.Body, "response body")
+
+	statuses := append(s.Statuses, s.Status)
+	if len(s.Statuses) == 0 && s.Status == 0 {
+		statuses = []int{http.StatusOK}
+	}
+	if !contains(statuses, resp.StatusCode) {
+		return fmt.Sprintf("URL: %s, Status: %d is not as expected", target, resp.StatusCode)
+	}
+	for _, h := range s.Expected {
+		if problem := h.check(resp.Header); problem != "" {
+			return fmt.Sprintf("URL: %s, Header: %s %s", target, h.Name, problem)
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStepBody))
+	if err != nil {
+		return fmt.Sprintf("URL: %s, Body: could not be read: %v", target, err)
+	}
+	if s.BodyRegex != "" {
+		if pattern := regexp.MustCompile(s.BodyRegex); !pattern.Match(data) { // checked by Validate
+			return fmt.Sprintf("URL: %s, Body does not match %q", target, s.BodyRegex)
+		}
+	}
+	for name, c := range s.Capture {
+		value, err := c.value(resp.Header, data)
+		if err != nil {
+			return fmt.Sprintf("URL: %s, could not capture %s: %v", target, name, err)
+		}
+		values[name] = value
+	}
+	return ""
+}
+
+// value takes the capture's value from a response.
+func (c Capture) value(header http.Header, body []byte) (string, error) {
+	if c.JSON != "" {
+		return jsonPath(body, c.JSON)
+	}
+	source := string(body)
+	if c.Header != "" {
+		if source = header.Get(c.Header); source == "" {
+			return "", fmt.Errorf("header %s is missing", c.Header)
+		}
+		if c.Regex == "" {
+			return source, nil
+		}
+	}
+	m := regexp.MustCompile(c.Regex).FindStringSubmatch(source) // checked by Validate
+	if m == nil {
+		return "", fmt.Errorf("%q does not match", c.Regex)
+	}
+	return m[1], nil
+}
+
+// jsonPath returns the value at a dot-separated path into a JSON document,
+// with array elements selected by index. Strings are returned unquoted and
+// other values as JSON.
+func jsonPath(body []byte, path string) (string, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return "", fmt.Errorf("body is not JSON: %w", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			field, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("%s has no field %s", path, key)
+			}
+			v = field
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("%s has no element %s", path, key)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("%s has no field %s", path, key)
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(v)
+	return string(out), err
+}
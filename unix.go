@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// unixScheme prefixes the path of a unix domain socket given as a port
+// check's address or an endpoint check's socket.
+const unixScheme = "unix://"
+
+// unixSocket returns the socket path of address when it is a unix:// URL.
+func unixSocket(address string) (string, bool) {
+	path, ok := strings.CutPrefix(address, unixScheme)
+	return path, ok
+}
+
+// validateUnixSocket checks a unix:// target names an absolute path.
+func validateUnixSocket(address string) error {
+	if path, ok := unixSocket(address); !ok || !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%s must be unix:// followed by an absolute path", address)
+	}
+	return nil
+}
+
+// dialUnix connects to the socket at path. Source addresses and resolvers
+// do not apply to local sockets.
+func dialUnix(ctx context.Context, path string, timeout time.Duration) (net.Conn, error) {
+	return (&net.Dialer{Timeout: timeout}).DialContext(ctx, "unix", path)
+}
+
+// runUnix connects to the port check's socket.
+func (port Port) runUnix(ctx context.Context, path string) checkResult {
+	start := time.Now()
+	conn, err := dialUnix(ctx, path, 1*time.Second)
+	if err != nil {
+		return checkFailed("Port Name: %s, Socket: %s is not available", port.Name, path).because(err)
+	}
+	elapsed := time.Since(start)
+	closeAndLog(conn, "connection")
+	result := checkOK("Port Name: %s, Socket: %s is available", port.Name, path)
+	return port.Thresholds.apply(result, milliseconds(elapsed), "Connect Time: "+elapsed.Round(time.Millisecond).String())
+}
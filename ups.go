@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UPSCheck queries a NUT upsd server for a UPS's status, battery charge and
+// runtime. Running on battery (OB) is reported as a warning; low battery
+// (LB) or falling below MinCharge/MinRuntime fails the check.
+type UPSCheck struct {
+	Name       string        `yaml:"name"`
+	UPS        string        `yaml:"ups"`
+	Address    string        `yaml:"address"`
+	Port       int           `yaml:"port"`
+	MinCharge  float64       `yaml:"minCharge"`
+	MinRuntime time.Duration `yaml:"minRuntime"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the UPS name and port.
+func (u *UPSCheck) Validate() error {
+	if u.UPS == "" || strings.ContainsAny(u.UPS, " \r\n\"") {
+		return fmt.Errorf("ups check %s: invalid ups name %q", u.Name, u.UPS)
+	}
+	if u.Port != 0 && (u.Port < 1 || u.Port > 65535) {
+		return fmt.Errorf("ups check %s: invalid port: %d", u.Name, u.Port)
+	}
+	return nil
+}
+
+func (check UPSCheck) run(ctx context.Context) checkResult {
+	address, port := check.Address, check.Port
+	if address == "" {
+		address = "127.0.0.1"
+	}
+	if port == 0 {
+		port = 3493
+	}
+
+	vars, err := queryNUT(ctx, net.JoinHostPort(address, strconv.Itoa(port)), check.UPS, "ups.status", "battery.charge", "battery.runtime")
+	if err != nil {
+		return checkFailed("UPS Name: %s, UPS: %s could not be queried: %v", check.Name, check.UPS, err)
+	}
+
+	status := vars["ups.status"]
+	flags := strings.Fields(status)
+	charge, chargeErr := strconv.ParseFloat(vars["battery.charge"], 64)
+	runtimeSecs, runtimeErr := strconv.ParseFloat(vars["battery.runtime"], 64)
+	runtime := time.Duration(runtimeSecs) * time.Second
+	summary := fmt.Sprintf("Status: %s, Charge: %s%%, Runtime: %s", status, vars["battery.charge"], runtime)
+
+	switch {
+	case slices.Contains(flags, "LB"):
+		return checkFailed("UPS Name: %s, UPS: %s, %s, battery is low", check.Name, check.UPS, summary)
+	case check.MinCharge > 0 && chargeErr == nil && charge < check.MinCharge:
+		return checkFailed("UPS Name: %s, UPS: %s, %s, charge is below %.0f%%", check.Name, check.UPS, summary, check.MinCharge)
+	case check.MinRuntime > 0 && runtimeErr == nil && runtime < check.MinRuntime:
+		return checkFailed("UPS Name: %s, UPS: %s, %s, runtime is below %s", check.Name, check.UPS, summary, check.MinRuntime)
+	case slices.Contains(flags, "OB"):
+		return checkWarning("UPS Name: %s, UPS: %s, %s, running on battery", check.Name, check.UPS, summary)
+	case !slices.Contains(flags, "OL"):
+		return checkWarning("UPS Name: %s, UPS: %s, %s, not on line power", check.Name, check.UPS, summary)
+	default:
+		return checkOK("UPS Name: %s, UPS: %s, %s is as expected", check.Name, check.UPS, summary)
+	}
+}
+
+// queryNUT fetches variables with the NUT network protocol's GET VAR
+// command. Variables the UPS doesn't support are left out of the result.
+func queryNUT(ctx context.Context, address, ups string, names ...string) (map[string]string, error) {
+	conn, err := dialCheck(ctx, "tcp", address, 2*time.Second, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	reader := bufio.NewReader(conn)
+	vars := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, err := fmt.Fprintf(conn, "GET VAR %s %s\n", ups, name); err != nil {
+			return nil, err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ERR ") {
+			if line == "ERR VAR-NOT-SUPPORTED" {
+				continue
+			}
+			return nil, errors.New(strings.TrimPrefix(line, "ERR "))
+		}
+		// VAR <ups> <name> "<value>"
+		prefix := fmt.Sprintf("VAR %s %s ", ups, name)
+		if !strings.HasPrefix(line, prefix) {
+			return nil, fmt.Errorf("unexpected response %q", line)
+		}
+		vars[name] = strings.Trim(strings.TrimPrefix(line, prefix), `"`)
+	}
+	if _, err := fmt.Fprint(conn, "LOGOUT\n"); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxUpstreamStatus bounds the stats read from a proxy.
+const maxUpstreamStatus = 8 << 20
+
+// UpstreamCheck reads a reverse proxy's view of its backends and fails
+// when any of Backends, or every backend when none are listed, has fewer
+// than MinUp servers, one unless set, up. The proxy is HAProxy, through
+// its stats socket at HAProxySocket, a unix socket path or host:port, or
+// its stats page at HAProxyURL, or Nginx Plus through the upstreams of
+// its API at NginxURL, such as http://127.0.0.1/api/9/http/upstreams.
+type UpstreamCheck struct {
+	Name          string   `yaml:"name"`
+	HAProxySocket string   `yaml:"haproxySocket"`
+	HAProxyURL    string   `yaml:"haproxyURL"`
+	NginxURL      string   `yaml:"nginxURL"`
+	Backends      []string `yaml:"backends"`
+	MinUp         int      `yaml:"minUp"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks one source is set and the minimum.
+func (u *UpstreamCheck) Validate() error {
+	set := 0
+	for _, source := range []string{u.HAProxySocket, u.HAProxyURL, u.NginxURL} {
+		if source != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("upstream check %s: set one of haproxySocket, haproxyURL and nginxURL", u.Name)
+	}
+	for _, target := range []string{u.HAProxyURL, u.NginxURL} {
+		if target == "" {
+			continue
+		}
+		if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("upstream check %s: %q must be an http:// or https:// URL", u.Name, target)
+		}
+	}
+	if u.MinUp < 0 {
+		return fmt.Errorf("upstream check %s: minUp must not be negative", u.Name)
+	}
+	return nil
+}
+
+// upstreamBackend is how many of a backend's servers are up.
+type upstreamBackend struct {
+	up, total int
+}
+
+func (check UpstreamCheck) run(ctx context.Context) checkResult {
+	var backends map[string]*upstreamBackend
+	var err error
+	source := check.HAProxySocket
+	switch {
+	case check.HAProxySocket != "":
+		backends, err = haproxySocketStats(ctx, check.HAProxySocket)
+	case check.HAProxyURL != "":
+		source = check.HAProxyURL
+		backends, err = haproxyURLStats(ctx, check.HAProxyURL)
+	default:
+		source = check.NginxURL
+		backends, err = nginxUpstreams(ctx, check.NginxURL)
+	}
+	if err != nil {
+		return checkFailed("Upstream Name: %s, Source: %s could not be read: %v", check.Name, source, err)
+	}
+
+	names := check.Backends
+	if len(names) == 0 {
+		for name := range backends {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	if len(names) == 0 {
+		return checkFailed("Upstream Name: %s, Source: %s reports no backends", check.Name, source)
+	}
+	minUp := orDefault(check.MinUp, 1)
+	var details, problems []string
+	for _, name := range names {
+		backend, ok := backends[name]
+		if !ok {
+			problems = append(problems, name+" is not configured")
+			continue
+		}
+		details = append(details, fmt.Sprintf("%s %d/%d up", name, backend.up, backend.total))
+		if backend.up < minUp {
+			problems = append(problems, fmt.Sprintf("%s has fewer than %d up", name, minUp))
+		}
+	}
+	message := fmt.Sprintf("Upstream Name: %s, Backends: %s", check.Name, strings.Join(details, ", "))
+	if len(problems) > 0 {
+		return checkFailed("%s, %s", message, strings.Join(problems, "; "))
+	}
+	return checkOK("%s", message)
+}
+
+// haproxySocketStats runs show stat on HAProxy's stats socket.
+func haproxySocketStats(ctx context.Context, address string) (map[string]*upstreamBackend, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	conn, err := dialCheck(ctx, network, address, 2*time.Second, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+	defer interruptOnDone(ctx, conn)()
+	if _, err := io.WriteString(conn, "show stat\n"); err != nil {
+		return nil, err
+	}
+	return parseHAProxyStats(io.LimitReader(conn, maxUpstreamStatus))
+}
+
+// haproxyURLStats reads the CSV export of HAProxy's stats page.
+func haproxyURLStats(ctx context.Context, target string) (map[string]*upstreamBackend, error) {
+	body, err := fetchUpstreamStatus(ctx, strings.TrimSuffix(target, ";csv")+";csv")
+	if err != nil {
+		return nil, err
+	}
+	return parseHAProxyStats(bytes.NewReader(body))
+}
+
+// parseHAProxyStats counts the servers of each backend, and those up, in
+// HAProxy's CSV stats. A server without health checks counts as up, as
+// HAProxy sends it traffic.
+func parseHAProxyStats(r io.Reader) (map[string]*upstreamBackend, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid stats: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(strings.TrimPrefix(header[0], "#"), " ")
+	}
+	proxy, server, status := slices.Index(header, "pxname"), slices.Index(header, "svname"), slices.Index(header, "status")
+	if proxy < 0 || server < 0 || status < 0 {
+		return nil, fmt.Errorf("stats have no pxname, svname and status columns")
+	}
+	backends := map[string]*upstreamBackend{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid stats: %w", err)
+		}
+		if len(record) <= max(proxy, server, status) {
+			continue // the blank line ending the socket's output
+		}
+		switch record[server] {
+		case "FRONTEND":
+			continue
+		case "BACKEND":
+			if backends[record[proxy]] == nil {
+				backends[record[proxy]] = &upstreamBackend{}
+			}
+			continue
+		}
+		backend := backends[record[proxy]]
+		if backend == nil {
+			backend = &upstreamBackend{}
+			backends[record[proxy]] = backend
+		}
+		backend.total++
+		if state := record[status]; strings.HasPrefix(state, "UP") || state == "no check" {
+			backend.up++
+		}
+	}
+	return backends, nil
+}
+
+// nginxUpstreams reads the upstreams of the Nginx Plus API, where a peer
+// is up in state "up".
+func nginxUpstreams(ctx context.Context, target string) (map[string]*upstreamBackend, error) {
+	body, err := fetchUpstreamStatus(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	var upstreams map[string]struct {
+		Peers []struct {
+			State string `json:"state"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal(body, &upstreams); err != nil {
+		return nil, fmt.Errorf("invalid upstreams: %w", err)
+	}
+	backends := make(map[string]*upstreamBackend, len(upstreams))
+	for name, upstream := range upstreams {
+		backend := &upstreamBackend{total: len(upstream.Peers)}
+		for _, peer := range upstream.Peers {
+			if peer.State == "up" {
+				backend.up++
+			}
+		}
+		backends[name] = backend
+	}
+	return backends, nil
+}
+
+// fetchUpstreamStatus GETs a proxy's status and returns the body of a 200
+// response.
+func fetchUpstreamStatus(ctx context.Context, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = requestHeaders("", nil)
+	resp, err := checkClient(ctx, req.URL.Scheme == "https").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("answered %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxUpstreamStatus))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VantageConfig makes this instance a coordinator for checks that several
+// instances run against the same targets, such as endpoints seen from
+// different networks. Peers are the /healthy URLs of the other instances,
+// which should run the same checks; a profile can limit them to these. A
+// check is critical only when Quorum vantage points, counting this one,
+// report it critical, and a warning while fewer do, so one node's network
+// blip does not fail the target. Checks, named as type/name or by a unique
+// name, default to every endpoint check. Username and Password are sent
+// to the peers with basic auth.
+type VantageConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Peers    []string      `yaml:"peers"`
+	Quorum   int           `yaml:"quorum"`
+	Checks   []string      `yaml:"checks"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// vantageHeader marks requests from a coordinator, which peers answer with
+// their own results rather than gathering votes in turn.
+const vantageHeader = "X-Health-Vantage"
+
+// Validate checks the peer URLs, the quorum and the checks named.
+func (c *VantageConfig) Validate(checks []check) error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Peers) == 0 {
+		return fmt.Errorf("vantage: at least one peer is required")
+	}
+	for _, peer := range c.Peers {
+		u, err := url.Parse(peer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("vantage: peer %q must be an http:// or https:// URL", peer)
+		}
+	}
+	if c.Quorum < 0 || c.Quorum > len(c.Peers)+1 {
+		return fmt.Errorf("vantage: quorum must be between 1 and the number of vantage points, %d", len(c.Peers)+1)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("vantage: timeout must not be negative")
+	}
+	if _, err := resolveCheckRefs(c.Checks, checks); err != nil {
+		return fmt.Errorf("vantage: %w", err)
+	}
+	return nil
+}
+
+// quorum returns the configured quorum, defaulting to a majority of the
+// vantage points.
+func (c *VantageConfig) quorum() int {
+	if c.Quorum > 0 {
+		return c.Quorum
+	}
+	return (len(c.Peers)+1)/2 + 1
+}
+
+// members returns the override keys of the checks voted on.
+func (c *VantageConfig) members(checks []check) map[string]bool {
+	keys := map[string]bool{}
+	if len(c.Checks) == 0 {
+		for _, chk := range checks {
+			if chk.Type == "endpoint" {
+				keys[overrideKey(chk.Type, chk.Name)] = true
+			}
+		}
+		return keys
+	}
+	refs, _ := resolveCheckRefs(c.Checks, checks) // checked by Validate
+	for _, key := range refs {
+		keys[key] = true
+	}
+	return keys
+}
+
+// peerVotes are the statuses one peer reported, keyed by type/name.
+type peerVotes struct {
+	peer     string
+	statuses map[string]string
+	err      error
+}
+
+// applyVantage replaces the status of each voted check with the quorum's
+// verdict, gathered from the peers within the timeout. Peers that cannot be
+// reached, or do not report a check, have no vote on it; while fewer
+// vantage points vote than the quorum needs, this instance's result
+// stands.
+func applyVantage(ctx context.Context, cfg VantageConfig, checks []check, results []checkResult) {
+	keys := cfg.members(checks)
+	voted := false
+	for _, r := range results {
+		voted = voted || keys[overrideKey(r.Type, r.Name)]
+	}
+	if !voted {
+		return
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	votes := make([]peerVotes, len(cfg.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range cfg.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses, err := fetchPeerStatuses(ctx, cfg, peer)
+			if err != nil {
+				log.Printf("vantage: %v", err)
+			}
+			votes[i] = peerVotes{peer: peerName(peer), statuses: statuses, err: err}
+		}()
+	}
+	wg.Wait()
+
+	quorum := cfg.quorum()
+	for i, r := range results {
+		key := overrideKey(r.Type, r.Name)
+		if !keys[key] || r.excluded() || r.Skipped {
+			continue
+		}
+		voters, failing := 1, 0
+		var failingAt, unreachable []string
+		if r.Status == statusCritical {
+			failing++
+			failingAt = append(failingAt, "local")
+		}
+		for _, v := range votes {
+			status, ok := v.statuses[key]
+			switch {
+			case v.err != nil || !ok:
+				unreachable = append(unreachable, v.peer)
+				continue
+			case status == statusCritical.String():
+				failing++
+				failingAt = append(failingAt, v.peer)
+			}
+			voters++
+		}
+		summary := fmt.Sprintf(", %d of %d vantage points failing, quorum %d", failing, voters, quorum)
+		if len(failingAt) > 0 {
+			summary += ": " + strings.Join(failingAt, ", ")
+		}
+		if len(unreachable) > 0 {
+			summary += "; no vote from " + strings.Join(unreachable, ", ")
+		}
+		results[i].Message += summary
+		switch {
+		case voters < quorum:
+			// Too few votes for a verdict; keep the local result.
+		case failing >= quorum:
+			results[i].Status = statusCritical
+		case failing > 0:
+			results[i].Status = statusWarning
+		}
+	}
+}
+
+// fetchPeerStatuses reads the check statuses in a peer's JSON report.
+// Disabled, skipped and unscheduled checks are left out.
+func fetchPeerStatuses(ctx context.Context, cfg VantageConfig, peer string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(vantageHeader, "1")
+	if cfg.Username != "" {
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", peer, err)
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s returned %s", peer, resp.Status)
+	}
+	var report struct {
+		Checks []checkReport `json:"checks"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&report); err != nil {
+		return nil, fmt.Errorf("%s: invalid report: %w", peer, err)
+	}
+	statuses := map[string]string{}
+	for _, c := range report.Checks {
+		if !c.Disabled && !c.Skipped && !c.NotScheduled {
+			statuses[overrideKey(c.Type, c.Name)] = c.Status
+		}
+	}
+	return statuses, nil
+}
+
+// peerName is how a peer is named in messages: its host.
+func peerName(peer string) string {
+	if u, err := url.Parse(peer); err == nil {
+		return u.Host
+	}
+	return peer
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// VaultCheck reads a Vault server's /v1/sys/health. A sealed or
+// uninitialised server fails the check; a standby is healthy unless
+// RequireActive is set.
+type VaultCheck struct {
+	Name          string `yaml:"name"`
+	URL           string `yaml:"url"`
+	RequireActive bool   `yaml:"requireActive"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the server URL.
+func (v *VaultCheck) Validate() error {
+	u, err := url.Parse(v.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("vault check %s: url must be an http:// or https:// URL", v.Name)
+	}
+	return nil
+}
+
+func (check VaultCheck) run(ctx context.Context) checkResult {
+	// Ask for 200 from every unsealed node so the body can be inspected.
+	endpoint := strings.TrimSuffix(check.URL, "/") + "/v1/sys/health?standbyok=true&perfstandbyok=true&sealedcode=200&uninitcode=200&drsecondarycode=200"
+	client := checkClient(ctx, strings.HasPrefix(check.URL, "https://"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return checkFailed("Vault Name: %s, invalid url: %v", check.Name, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return checkFailed("Vault Name: %s, URL: %s is not reachable", check.Name, check.URL)
+	}
+	defer closeAndLog(resp.Body, "response body")
+	var health struct {
+		Initialized bool   `json:"initialized"`
+		Sealed      bool   `json:"sealed"`
+		Standby     bool   `json:"standby"`
+		Version     string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return checkFailed("Vault Name: %s, URL: %s returned %s with an unreadable body", check.Name, check.URL, resp.Status)
+	}
+
+	switch {
+	case !health.Initialized:
+		return checkFailed("Vault Name: %s, URL: %s is not initialised", check.Name, check.URL)
+	case health.Sealed:
+		return checkFailed("Vault Name: %s, URL: %s is sealed", check.Name, check.URL)
+	case health.Standby && check.RequireActive:
+		return checkFailed("Vault Name: %s, URL: %s is a standby, expected active", check.Name, check.URL)
+	case health.Standby:
+		return checkOK("Vault Name: %s, URL: %s, Version: %s is an unsealed standby", check.Name, check.URL, health.Version)
+	default:
+		return checkOK("Vault Name: %s, URL: %s, Version: %s is active and unsealed", check.Name, check.URL, health.Version)
+	}
+}
+
+// VaultConfig configures how "vault:<path>#<key>" references in the config
+// file are resolved. The token is taken from Token, TokenFile (such as a
+// Vault Agent sink), VAULT_TOKEN, or an AppRole login, in that order.
+// CAFile, or VAULT_CACERT, verifies a server with a private CA.
+type VaultConfig struct {
+	Address      string `yaml:"address"`
+	CAFile       string `yaml:"caFile"`
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"tokenFile"`
+	RoleID       string `yaml:"roleID"`
+	SecretID     string `yaml:"secretID"`
+	SecretIDFile string `yaml:"secretIDFile"`
+}
+
+const vaultRefPrefix = "vault:"
+
+// resolveVaultSecrets replaces every string field in config holding a
+// vault:<path>#<key> reference with the secret's value. Vault is only
+// contacted when at least one reference is present.
+func resolveVaultSecrets(config *Config) error {
+	var refs []reflect.Value
+	collectVaultRefs(reflect.ValueOf(config).Elem(), &refs)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	client, err := newVaultClient(config.Config.Vault)
+	if err != nil {
+		return err
+	}
+	secrets := map[string]map[string]interface{}{}
+	for _, field := range refs {
+		ref := strings.TrimPrefix(field.String(), vaultRefPrefix)
+		path, key, ok := strings.Cut(ref, "#")
+		if !ok || path == "" || key == "" {
+			return fmt.Errorf("vault reference %q must have the form vault:<path>#<key>", ref)
+		}
+		data, found := secrets[path]
+		if !found {
+			if data, err = client.read(path); err != nil {
+				return fmt.Errorf("reading vault secret %s: %w", path, err)
+			}
+			secrets[path] = data
+		}
+		value, ok := data[key].(string)
+		if !ok {
+			return fmt.Errorf("vault secret %s has no string key %q", path, key)
+		}
+		field.SetString(value)
+	}
+	return nil
+}
+
+// collectVaultRefs walks structs, pointers and slices for settable string
+// fields starting with the vault: prefix.
+func collectVaultRefs(v reflect.Value, refs *[]reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() && strings.HasPrefix(v.String(), vaultRefPrefix) {
+			*refs = append(*refs, v)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			collectVaultRefs(v.Elem(), refs)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			collectVaultRefs(v.Field(i), refs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			collectVaultRefs(v.Index(i), refs)
+		}
+	}
+}
+
+type vaultClient struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newVaultClient(cfg VaultConfig) (*vaultClient, error) {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	c := &vaultClient{address: strings.TrimSuffix(address, "/"), client: exportClient}
+	if c.address == "" {
+		return nil, fmt.Errorf("config references vault secrets but no vault address is set")
+	}
+	caFile := cfg.CAFile
+	if caFile == "" {
+		caFile = os.Getenv("VAULT_CACERT")
+	}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile) // #nosec G304 -- path comes from the operator's config
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+		c.client = &http.Client{Timeout: exportClient.Timeout, Transport: transport}
+	}
+
+	switch {
+	case cfg.Token != "":
+		c.token = cfg.Token
+	case cfg.TokenFile != "":
+		token, err := os.ReadFile(cfg.TokenFile) // #nosec G304 -- path comes from the operator's config
+		if err != nil {
+			return nil, err
+		}
+		c.token = strings.TrimSpace(string(token))
+	case os.Getenv("VAULT_TOKEN") != "":
+		c.token = os.Getenv("VAULT_TOKEN")
+	case cfg.RoleID != "":
+		secretID := cfg.SecretID
+		if cfg.SecretIDFile != "" {
+			data, err := os.ReadFile(cfg.SecretIDFile) // #nosec G304 -- path comes from the operator's config
+			if err != nil {
+				return nil, err
+			}
+			secretID = strings.TrimSpace(string(data))
+		}
+		var login struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": cfg.RoleID, "secret_id": secretID}
+		if err := c.call(http.MethodPost, "auth/approle/login", body, &login); err != nil {
+			return nil, fmt.Errorf("vault approle login failed: %w", err)
+		}
+		c.token = login.Auth.ClientToken
+	default:
+		return nil, fmt.Errorf("config references vault secrets but no vault token or approle is set")
+	}
+	return c, nil
+}
+
+// read returns the data of the secret at path. KV version 2 responses,
+// which nest the data a level deeper, are unwrapped.
+func (c *vaultClient) read(path string) (map[string]interface{}, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := c.call(http.MethodGet, path, nil, &secret); err != nil {
+		return nil, err
+	}
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return nested, nil
+		}
+	}
+	return secret.Data, nil
+}
+
+func (c *vaultClient) call(method, path string, body, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.address+"/v1/"+strings.TrimPrefix(path, "/"), &payload)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookConfig POSTs the checks changing status to URL, with Headers, in
+// batches of up to BatchSize, 100 unless set, sent once that many are
+// waiting or FlushInterval, 5s unless set, after the last send. A batch
+// that fails is retried, with the same events and Idempotency-Key, backing
+// off up to 5 minutes, until the receiver accepts it, so delivery is at
+// least once; receivers drop repeats by the batch key or each event's id.
+// With QueueFile set, events waiting are kept there and sent after a
+// restart. At most MaxQueued, 10000 unless set, wait; past that the oldest
+// not in the batch being sent are dropped.
+type WebhookConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	URL           string            `yaml:"url"`
+	Headers       map[string]string `yaml:"headers"`
+	BatchSize     int               `yaml:"batchSize"`
+	FlushInterval time.Duration     `yaml:"flushInterval"`
+	Timeout       time.Duration     `yaml:"timeout"`
+	QueueFile     string            `yaml:"queueFile"`
+	MaxQueued     int               `yaml:"maxQueued"`
+}
+
+// Validate checks the URL and limits.
+func (c *WebhookConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("webhook: url must be an http:// or https:// URL")
+	}
+	if c.BatchSize < 0 || c.MaxQueued < 0 {
+		return fmt.Errorf("webhook: batchSize and maxQueued must not be negative")
+	}
+	if c.FlushInterval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("webhook: flushInterval and timeout must not be negative")
+	}
+	return nil
+}
+
+// webhookEvent is a check changing status. ID stays the same across
+// retries.
+type webhookEvent struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// webhookExporter queues status changes and delivers them to the webhook.
+type webhookExporter struct {
+	cfg     WebhookConfig
+	mu      sync.Mutex
+	last    map[string]checkStatus // by override key
+	queue   []webhookEvent
+	pending int // events at the head of queue in the batch being sent or retried
+	full    chan struct{}
+}
+
+func newWebhook(cfg WebhookConfig) (*webhookExporter, error) {
+	e := &webhookExporter{cfg: cfg, last: map[string]checkStatus{}, full: make(chan struct{}, 1)}
+	e.cfg.BatchSize = orDefault(cfg.BatchSize, 100)
+	e.cfg.MaxQueued = orDefault(cfg.MaxQueued, 10000)
+	if cfg.QueueFile != "" {
+		data, err := os.ReadFile(cfg.QueueFile) // #nosec G304 -- path is from the config file
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("webhook: %w", err)
+		default:
+			if err := json.Unmarshal(data, &e.queue); err != nil {
+				return nil, fmt.Errorf("webhook: %s: %w", cfg.QueueFile, err)
+			}
+			if len(e.queue) > 0 {
+				log.Printf("Webhook: %d events queued before the restart", len(e.queue))
+			}
+		}
+	}
+	go e.run()
+	return e, nil
+}
+
+// export queues an event for each check whose status changed since its
+// last run. The first run of a check only sets the baseline.
+func (e *webhookExporter) export(results []checkResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	queued := len(e.queue)
+	for _, r := range results {
+		key := overrideKey(r.Type, r.Name)
+		last, ok := e.last[key]
+		e.last[key] = r.Status
+		if !ok || last == r.Status {
+			continue
+		}
+		e.queue = append(e.queue, webhookEvent{
+			ID: newEventID(), Type: r.Type, Name: r.Name, From: last.String(), To: r.Status.String(),
+			Reason: r.Reason, Message: r.Message, Time: r.Checked, Labels: r.Labels, Annotations: r.Annotations,
+		})
+	}
+	if len(e.queue) == queued {
+		return
+	}
+	if over := min(len(e.queue)-e.cfg.MaxQueued, len(e.queue)-e.pending); over > 0 {
+		log.Printf("Webhook: queue full, dropping %d events", over)
+		e.queue = append(e.queue[:e.pending], e.queue[e.pending+over:]...)
+	}
+	e.save()
+	if len(e.queue)-e.pending >= e.cfg.BatchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// newEventID returns a random event ID.
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// save writes the queue to the queue file, replacing it atomically so a
+// crash mid-write leaves the last copy. e.mu is held.
+func (e *webhookExporter) save() {
+	if e.cfg.QueueFile == "" {
+		return
+	}
+	data, err := json.Marshal(e.queue)
+	if err == nil {
+		tmp := e.cfg.QueueFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, e.cfg.QueueFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Webhook: saving queue: %v", err)
+	}
+}
+
+// run sends the queue every flush interval, or as soon as a batch is full,
+// backing off after a failure.
+func (e *webhookExporter) run() {
+	interval := e.cfg.FlushInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var backoff time.Duration
+	for {
+		wait, full := interval, e.full
+		if backoff > 0 {
+			// A full batch does not cut a backoff short.
+			wait, full = backoff, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-full:
+		}
+		timer.Stop()
+		if err := e.flush(); err != nil {
+			backoff = min(max(2*backoff, time.Second), 5*time.Minute)
+			log.Printf("Webhook: %v; retrying in %s", err, backoff)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// flush sends the queue a batch at a time until it is empty or a batch
+// fails.
+func (e *webhookExporter) flush() error {
+	for {
+		e.mu.Lock()
+		if e.pending == 0 {
+			e.pending = min(len(e.queue), e.cfg.BatchSize)
+		}
+		batch := append([]webhookEvent(nil), e.queue[:e.pending]...)
+		e.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		exportsInFlight.Add(1)
+		err := e.post(batch)
+		exportsInFlight.Done()
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		e.queue = e.queue[e.pending:]
+		e.pending = 0
+		e.save()
+		e.mu.Unlock()
+	}
+}
+
+// post sends batch, keyed by its events' IDs. A failed batch is retried
+// as it was, so the retry has the same key.
+func (e *webhookExporter) post(batch []webhookEvent) error {
+	body, err := json.Marshal(map[string]interface{}{"events": batch})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(batch))
+	for i, ev := range batch {
+		ids[i] = ev.ID
+	}
+	key := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	timeout := e.cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range e.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(key[:16]))
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(resp.Body, "response body")
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
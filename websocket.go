@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505 -- SHA-1 is mandated by the WebSocket handshake
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WebSocketCheck performs the WebSocket upgrade handshake and, when Send is
+// set, sends it as a text message and matches the first data message
+// received against the Expect pattern.
+type WebSocketCheck struct {
+	Name               string            `yaml:"name"`
+	URL                string            `yaml:"url"`
+	InsecureSkipVerify bool              `yaml:"insecureSkipVerify"`
+	Subprotocols       []string          `yaml:"subprotocols"`
+	Headers            map[string]string `yaml:"headers"`
+	Send               string            `yaml:"send"`
+	Expect             string            `yaml:"expect"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the URL scheme and reply pattern.
+func (w *WebSocketCheck) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return fmt.Errorf("websocket check %s: url must be a ws:// or wss:// URL", w.Name)
+	}
+	if _, err := regexp.Compile(w.Expect); err != nil {
+		return fmt.Errorf("websocket check %s: invalid expect pattern: %w", w.Name, err)
+	}
+	if w.Expect != "" && w.Send == "" {
+		return fmt.Errorf("websocket check %s: expect requires send", w.Name)
+	}
+	return nil
+}
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsOpText       = 0x1
+	wsOpBinary     = 0x2
+	wsOpClose      = 0x8
+	wsOpPing       = 0x9
+	wsOpPong       = 0xa
+	wsMaxFrameSize = 1 << 20
+)
+
+func (check WebSocketCheck) run(ctx context.Context) checkResult {
+	reply, err := check.probe(ctx)
+	if err != nil {
+		return checkFailed("WebSocket Name: %s, URL: %s %v", check.Name, check.URL, err)
+	}
+	if check.Send == "" {
+		return checkOK("WebSocket Name: %s, URL: %s accepted the upgrade", check.Name, check.URL)
+	}
+	if check.Expect != "" && !regexp.MustCompile(check.Expect).MatchString(reply) {
+		return checkFailed("WebSocket Name: %s, URL: %s, reply %q does not match %q", check.Name, check.URL, reply, check.Expect)
+	}
+	return checkOK("WebSocket Name: %s, URL: %s replied as expected", check.Name, check.URL)
+}
+
+// probe upgrades the connection and returns the reply to Send, if any.
+func (check WebSocketCheck) probe(ctx context.Context) (string, error) {
+	u, err := url.Parse(check.URL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var tlsConfig *tls.Config
+	if u.Scheme == "wss" {
+		tlsConfig = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: check.InsecureSkipVerify, NextProtos: []string{"http/1.1"}}
+	}
+	conn, err := dialCheck(ctx, "tcp", host, 5*time.Second, tlsConfig)
+	if err != nil {
+		return "", fmt.Errorf("is not reachable: %w", err)
+	}
+	defer closeAndLog(conn, "connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return "", err
+	}
+	defer interruptOnDone(ctx, conn)()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range check.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(check.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(check.Subprotocols, ", "))
+	}
+	if err := req.Write(conn); err != nil {
+		return "", err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return "", fmt.Errorf("sent an invalid handshake response: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return "", fmt.Errorf("refused the upgrade with %s", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID)) // #nosec G401 -- SHA-1 is mandated by the WebSocket handshake
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return "", fmt.Errorf("returned an invalid Sec-WebSocket-Accept")
+	}
+	if len(check.Subprotocols) > 0 {
+		if chosen := resp.Header.Get("Sec-WebSocket-Protocol"); !slices.Contains(check.Subprotocols, chosen) {
+			return "", fmt.Errorf("did not agree to subprotocol %s", strings.Join(check.Subprotocols, ", "))
+		}
+	}
+
+	var reply string
+	if check.Send != "" {
+		if err := writeWSFrame(conn, wsOpText, []byte(check.Send)); err != nil {
+			return "", err
+		}
+		if reply, err = readWSMessage(conn, r); err != nil {
+			return "", fmt.Errorf("did not reply: %w", err)
+		}
+	}
+	// Close with status 1000 (normal closure); the server's reply is not awaited.
+	return reply, writeWSFrame(conn, wsOpClose, []byte{0x03, 0xe8})
+}
+
+// writeWSFrame writes one final, masked frame as clients must.
+func writeWSFrame(w io.Writer, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// writeWSServerFrame writes one final, unmasked frame as servers must.
+func writeWSServerFrame(w io.Writer, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// readWSMessage returns the first text or binary message, answering pings
+// and reassembling fragments along the way.
+func readWSMessage(w io.Writer, r *bufio.Reader) (string, error) {
+	var message []byte
+	for {
+		final, opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return "", err
+		}
+		if uint64(len(message))+uint64(len(payload)) > wsMaxFrameSize {
+			return "", fmt.Errorf("message larger than %d bytes", wsMaxFrameSize)
+		}
+
+		switch opcode {
+		case wsOpClose:
+			return "", fmt.Errorf("server closed the connection")
+		case wsOpPing:
+			if err := writeWSFrame(w, wsOpPong, payload); err != nil {
+				return "", err
+			}
+			continue
+		case wsOpText, wsOpBinary, 0x0:
+			message = append(message, payload...)
+			if final {
+				return string(message), nil
+			}
+		}
+	}
+}
+
+// readWSFrame reads one frame, unmasking the payload of frames from
+// clients.
+func readWSFrame(r *bufio.Reader) (final bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, 0, nil, err
+	}
+	final, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > wsMaxFrameSize {
+		return false, 0, nil, fmt.Errorf("message larger than %d bytes", wsMaxFrameSize)
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	return final, opcode, payload, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errWindowsOnly fails checks that read Windows counters, event logs or
+// services on other systems.
+var errWindowsOnly = errors.New("only supported on Windows")
+
+// PerfCounterCheck reads a Windows performance counter, such as
+// \PhysicalDisk(_Total)\Current Disk Queue Length, and grades its value with
+// warn and crit. Counter paths are given in English, whatever the system's
+// language. Counters that are rates are sampled twice, a second apart.
+type PerfCounterCheck struct {
+	Name    string `yaml:"name"`
+	Counter string `yaml:"counter"`
+
+	// Thresholds grade the counter's value.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// Validate checks the counter path and thresholds.
+func (p *PerfCounterCheck) Validate() error {
+	if !strings.HasPrefix(p.Counter, `\`) || strings.Count(p.Counter, `\`) < 2 {
+		return fmt.Errorf(`perf counter check %s: counter must be a path such as \Object(Instance)\Counter`, p.Name)
+	}
+	if err := p.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("perf counter check %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+func (check PerfCounterCheck) run(ctx context.Context) checkResult {
+	value, err := readPerfCounter(ctx, check.Counter)
+	if err != nil {
+		return checkFailed("Perf Counter Name: %s, Counter: %s could not be read: %v", check.Name, check.Counter, err)
+	}
+	return check.Thresholds.apply(checkOK("Perf Counter Name: %s, Counter: %s, Value: %.2f", check.Name, check.Counter, value), value, "")
+}
+
+// EventLogCheck counts the events in a Windows event log at Level or more
+// severe, from Source and with one of EventIDs when given, within a sliding
+// Window, and fails when the count exceeds Threshold, like journald checks
+// on Linux. Log defaults to System and Level to error.
+type EventLogCheck struct {
+	Name      string        `yaml:"name"`
+	Log       string        `yaml:"log"`
+	Source    string        `yaml:"source"`
+	Level     string        `yaml:"level"`
+	EventIDs  []int         `yaml:"eventIDs"`
+	Window    time.Duration `yaml:"window"`
+	Threshold int           `yaml:"threshold"`
+
+	// Thresholds grade the event count instead of Threshold.
+	Thresholds   `yaml:",inline"`
+	CheckOptions `yaml:",inline"`
+}
+
+// eventLogNameRegex matches log and provider names, such as System or
+// Microsoft-Windows-Kernel-Power, keeping quotes out of the query.
+var eventLogNameRegex = regexp.MustCompile(`^[a-zA-Z0-9 ._/-]+$`)
+
+// eventLevels are the Windows event levels by name.
+var eventLevels = map[string]int{"critical": 1, "error": 2, "warning": 3}
+
+// Validate checks the names, level, window and thresholds.
+func (e *EventLogCheck) Validate() error {
+	if e.Log != "" && !eventLogNameRegex.MatchString(e.Log) {
+		return fmt.Errorf("event log check %s: invalid log name %q", e.Name, e.Log)
+	}
+	if e.Source != "" && !eventLogNameRegex.MatchString(e.Source) {
+		return fmt.Errorf("event log check %s: invalid source %q", e.Name, e.Source)
+	}
+	if _, ok := eventLevels[e.Level]; e.Level != "" && !ok {
+		return fmt.Errorf("event log check %s: level must be critical, error or warning", e.Name)
+	}
+	for _, id := range e.EventIDs {
+		if id < 0 || id > 65535 {
+			return fmt.Errorf("event log check %s: invalid event ID: %d", e.Name, id)
+		}
+	}
+	if e.Window <= 0 {
+		return fmt.Errorf("event log check %s: window must be positive", e.Name)
+	}
+	if e.Threshold < 0 {
+		return fmt.Errorf("event log check %s: invalid threshold: %d", e.Name, e.Threshold)
+	}
+	if e.Threshold != 0 && e.Thresholds.set() {
+		return fmt.Errorf("event log check %s: threshold cannot be combined with warn and crit", e.Name)
+	}
+	if err := e.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("event log check %s: %w", e.Name, err)
+	}
+	return nil
+}
+
+// query returns the XPath query selecting the events counted.
+func (e EventLogCheck) query() string {
+	level, ok := eventLevels[e.Level]
+	if !ok {
+		level = eventLevels["error"]
+	}
+	var levels []string
+	for l := 1; l <= level; l++ {
+		levels = append(levels, fmt.Sprintf("Level=%d", l))
+	}
+	conds := []string{"(" + strings.Join(levels, " or ") + ")"}
+	if e.Source != "" {
+		conds = append(conds, "Provider[@Name='"+e.Source+"']")
+	}
+	if len(e.EventIDs) > 0 {
+		ids := make([]string, len(e.EventIDs))
+		for i, id := range e.EventIDs {
+			ids[i] = fmt.Sprintf("EventID=%d", id)
+		}
+		conds = append(conds, "("+strings.Join(ids, " or ")+")")
+	}
+	conds = append(conds, fmt.Sprintf("TimeCreated[timediff(@SystemTime) <= %d]", e.Window.Milliseconds()))
+	return "*[System[" + strings.Join(conds, " and ") + "]]"
+}
+
+func (check EventLogCheck) run(ctx context.Context) checkResult {
+	log := check.Log
+	if log == "" {
+		log = "System"
+	}
+	count, err := countEvents(ctx, log, check.query())
+	switch {
+	case err != nil:
+		return checkFailed("Event Log Name: %s, Log: %s could not be read: %v", check.Name, log, err)
+	case check.Thresholds.set():
+		return check.Thresholds.apply(checkOK("Event Log Name: %s, Log: %s, Events: %d in the last %s", check.Name, log, count, check.Window), float64(count), "")
+	case count > check.Threshold:
+		return checkFailed("Event Log Name: %s, Log: %s, Events: %d in the last %s exceeds threshold: %d", check.Name, log, count, check.Window, check.Threshold)
+	default:
+		return checkOK("Event Log Name: %s, Log: %s, Events: %d in the last %s is within threshold: %d", check.Name, log, count, check.Window, check.Threshold)
+	}
+}
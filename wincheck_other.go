@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+func readPerfCounter(context.Context, string) (float64, error) {
+	return 0, errWindowsOnly
+}
+
+func countEvents(context.Context, string, string) (int, error) {
+	return 0, errWindowsOnly
+}
+
+func windowsServiceState(string) (string, error) {
+	return "", errWindowsOnly
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	pdh                             = windows.NewLazySystemDLL("pdh.dll")
+	procPdhOpenQuery                = pdh.NewProc("PdhOpenQueryW")
+	procPdhAddEnglishCounter        = pdh.NewProc("PdhAddEnglishCounterW")
+	procPdhCollectQueryData         = pdh.NewProc("PdhCollectQueryData")
+	procPdhGetFormattedCounterValue = pdh.NewProc("PdhGetFormattedCounterValue")
+	procPdhCloseQuery               = pdh.NewProc("PdhCloseQuery")
+
+	wevtapi      = windows.NewLazySystemDLL("wevtapi.dll")
+	procEvtQuery = wevtapi.NewProc("EvtQuery")
+	procEvtNext  = wevtapi.NewProc("EvtNext")
+	procEvtClose = wevtapi.NewProc("EvtClose")
+)
+
+const (
+	pdhFmtDouble   = 0x00000200
+	pdhInvalidData = 0xC0000BC6
+
+	evtQueryChannelPath = 0x1
+)
+
+// pdhCounterValue is PDH_FMT_COUNTERVALUE holding a double; the padding
+// keeps the value at offset 8 on 32-bit systems too.
+type pdhCounterValue struct {
+	status uint32
+	_      uint32
+	value  float64
+}
+
+// readPerfCounter reads the counter at the English path. Rate counters
+// have no value until a second sample, taken a second after the first.
+func readPerfCounter(ctx context.Context, path string) (float64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var query, counter windows.Handle
+	if r, _, _ := procPdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&query))); r != 0 { // #nosec G103 -- PDH returns the handle by pointer
+		return 0, pdhError(r)
+	}
+	defer closeAndLog(pdhQuery(query), "PDH query")
+	if r, _, _ := procPdhAddEnglishCounter.Call(uintptr(query), uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&counter))); r != 0 { // #nosec G103 -- PDH takes the path and returns the handle by pointer
+		return 0, pdhError(r)
+	}
+	for sample := 0; ; sample++ {
+		if r, _, _ := procPdhCollectQueryData.Call(uintptr(query)); r != 0 {
+			return 0, pdhError(r)
+		}
+		var v pdhCounterValue
+		r, _, _ := procPdhGetFormattedCounterValue.Call(uintptr(counter), pdhFmtDouble, 0, uintptr(unsafe.Pointer(&v))) // #nosec G103 -- PDH returns the value by pointer
+		switch {
+		case r == pdhInvalidData && sample == 0:
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		case r != 0:
+			return 0, pdhError(r)
+		case v.status != 0:
+			return 0, pdhError(uintptr(v.status))
+		default:
+			return v.value, nil
+		}
+	}
+}
+
+func pdhError(status uintptr) error {
+	return fmt.Errorf("PDH status 0x%08X", status)
+}
+
+// pdhQuery, evtHandle and serviceHandle close the handles of the APIs they
+// are named for, so they can go to closeAndLog.
+type (
+	pdhQuery      windows.Handle
+	evtHandle     uintptr
+	serviceHandle windows.Handle
+)
+
+func (q pdhQuery) Close() error {
+	if r, _, _ := procPdhCloseQuery.Call(uintptr(q)); r != 0 {
+		return pdhError(r)
+	}
+	return nil
+}
+
+func (h evtHandle) Close() error {
+	if ok, _, err := procEvtClose.Call(uintptr(h)); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func (h serviceHandle) Close() error {
+	return windows.CloseServiceHandle(windows.Handle(h))
+}
+
+// countEvents counts the events in the channel query selects.
+func countEvents(ctx context.Context, channel, query string) (int, error) {
+	c, err := windows.UTF16PtrFromString(channel)
+	if err != nil {
+		return 0, err
+	}
+	q, err := windows.UTF16PtrFromString(query)
+	if err != nil {
+		return 0, err
+	}
+	results, _, err := procEvtQuery.Call(0, uintptr(unsafe.Pointer(c)), uintptr(unsafe.Pointer(q)), evtQueryChannelPath) // #nosec G103 -- the API takes UTF-16 strings by pointer
+	if results == 0 {
+		return 0, err
+	}
+	defer closeAndLog(evtHandle(results), "event query")
+
+	events := make([]uintptr, 64)
+	count := 0
+	for ctx.Err() == nil {
+		var returned uint32
+		ok, _, err := procEvtNext.Call(results, uintptr(len(events)), uintptr(unsafe.Pointer(&events[0])), 5000, 0, uintptr(unsafe.Pointer(&returned))) // #nosec G103 -- the API fills the caller's array
+		if ok == 0 {
+			if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
+				return count, nil
+			}
+			return count, err
+		}
+		for _, event := range events[:returned] {
+			closeAndLog(evtHandle(event), "event")
+		}
+		count += int(returned)
+	}
+	return count, ctx.Err()
+}
+
+// windowsServiceStates are the names service checks compare status with.
+var windowsServiceStates = map[uint32]string{
+	windows.SERVICE_STOPPED:          "stopped",
+	windows.SERVICE_START_PENDING:    "start_pending",
+	windows.SERVICE_STOP_PENDING:     "stop_pending",
+	windows.SERVICE_RUNNING:          "running",
+	windows.SERVICE_CONTINUE_PENDING: "continue_pending",
+	windows.SERVICE_PAUSE_PENDING:    "pause_pending",
+	windows.SERVICE_PAUSED:           "paused",
+}
+
+// windowsServiceState asks the service control manager for the state of
+// the service called name, needing only the rights any user has.
+func windowsServiceState(name string) (string, error) {
+	manager, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return "", err
+	}
+	defer closeAndLog(serviceHandle(manager), "service manager")
+	n, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	service, err := windows.OpenService(manager, n, windows.SERVICE_QUERY_STATUS)
+	if err != nil {
+		return "", err
+	}
+	defer closeAndLog(serviceHandle(service), "service")
+	var status windows.SERVICE_STATUS
+	if err := windows.QueryServiceStatus(service, &status); err != nil {
+		return "", err
+	}
+	if state, ok := windowsServiceStates[status.CurrentState]; ok {
+		return state, nil
+	}
+	return fmt.Sprintf("state %d", status.CurrentState), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily span of local time, from start up to end, in
+// minutes since midnight. A window whose end is before its start runs past
+// midnight.
+type timeWindow struct {
+	start, end int
+}
+
+// parseWindow parses a window written as HH:MM-HH:MM.
+func parseWindow(s string) (timeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("window %q must be HH:MM-HH:MM", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("window %q must be HH:MM-HH:MM", s)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("window %q must be HH:MM-HH:MM", s)
+	}
+	w := timeWindow{start: start.Hour()*60 + start.Minute(), end: end.Hour()*60 + end.Minute()}
+	if w.start == w.end {
+		return timeWindow{}, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// activeAt reports whether a check with these options is active at t: at
+// any time without windows, otherwise within one of them.
+func (o CheckOptions) activeAt(t time.Time) bool {
+	if len(o.Windows) == 0 {
+		return true
+	}
+	for _, s := range o.Windows {
+		if w, err := parseWindow(s); err == nil && w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkNotScheduled() checkResult {
+	result := checkOK("Check not scheduled: outside its active windows")
+	result.NotScheduled = true
+	return result
+}
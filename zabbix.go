@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ZabbixConfig pushes every check run's results to a Zabbix server or
+// proxy with the sender protocol, as trapper items on Host. Server is
+// host[:port], the port defaulting to 10051. StatusKey and MessageKey are
+// item key templates in which {type} and {name} are replaced with the
+// check's; Keys replaces the status key of individual checks, keyed by
+// type/name. Without MessageKey only statuses are sent.
+type ZabbixConfig struct {
+	Enabled    bool              `yaml:"enabled"`
+	Server     string            `yaml:"server"`
+	Host       string            `yaml:"host"`
+	StatusKey  string            `yaml:"statusKey"`
+	MessageKey string            `yaml:"messageKey"`
+	Keys       map[string]string `yaml:"keys"`
+}
+
+// Validate checks a server is set.
+func (c *ZabbixConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Server == "" {
+		return fmt.Errorf("zabbix: server is required")
+	}
+	return nil
+}
+
+// zabbixExporter sends results to a Zabbix trapper.
+type zabbixExporter struct {
+	cfg ZabbixConfig
+}
+
+// zabbixInfo is the summary in a Zabbix server's reply, such as
+// "processed: 2; failed: 0; total: 2; seconds spent: 0.000055".
+var zabbixInfo = regexp.MustCompile(`failed: (\d+)`)
+
+func newZabbix(cfg ZabbixConfig) *zabbixExporter {
+	e := &zabbixExporter{cfg: cfg}
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		e.cfg.Server = net.JoinHostPort(cfg.Server, "10051")
+	}
+	if e.cfg.Host == "" {
+		if h, err := os.Hostname(); err == nil {
+			e.cfg.Host = h
+		}
+	}
+	if e.cfg.StatusKey == "" {
+		e.cfg.StatusKey = "server_health.status[{type},{name}]"
+	}
+	return e
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// itemKey fills in a key template for r. Names are quoted as Zabbix key
+// parameters when they hold characters that would end one.
+func itemKey(template string, r checkResult) string {
+	param := func(s string) string {
+		if strings.ContainsAny(s, ",]\" ") {
+			return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+		}
+		return s
+	}
+	return strings.NewReplacer("{type}", param(r.Type), "{name}", param(r.Name)).Replace(template)
+}
+
+// export sends each check's status, 0 for ok, 1 for warning and 2 for
+// critical, and its message when MessageKey is set.
+func (e *zabbixExporter) export(results []checkResult) {
+	if len(results) == 0 {
+		return
+	}
+	items := make([]zabbixItem, 0, 2*len(results))
+	for _, r := range results {
+		key, ok := e.cfg.Keys[overrideKey(r.Type, r.Name)]
+		if !ok {
+			key = itemKey(e.cfg.StatusKey, r)
+		}
+		clock := r.Checked.Unix()
+		items = append(items, zabbixItem{Host: e.cfg.Host, Key: key, Value: fmt.Sprint(int(r.Status)), Clock: clock})
+		if e.cfg.MessageKey != "" {
+			items = append(items, zabbixItem{Host: e.cfg.Host, Key: itemKey(e.cfg.MessageKey, r), Value: r.Message, Clock: clock})
+		}
+	}
+	exportInBackground("zabbix", func() error { return e.send(items) })
+}
+
+// send writes items in one sender request and checks the server accepted
+// them all; items unknown to Zabbix, or not trapper items, are counted as
+// failed.
+func (e *zabbixExporter) send(items []zabbixItem) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"request": "sender data",
+		"data":    items,
+		"clock":   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", e.cfg.Server, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer closeAndLog(conn, "zabbix connection")
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(zabbixFrame(body)); err != nil {
+		return err
+	}
+	reply, err := readZabbixFrame(conn)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return fmt.Errorf("invalid reply: %w", err)
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("server replied %s: %s", resp.Response, resp.Info)
+	}
+	if m := zabbixInfo.FindStringSubmatch(resp.Info); m != nil && m[1] != "0" {
+		return fmt.Errorf("%s items were rejected (%s); check the item keys exist as trapper items on host %s", m[1], resp.Info, e.cfg.Host)
+	}
+	return nil
+}
+
+// zabbixFrame wraps data in the ZBXD header: protocol version 1 and the
+// data length as a little-endian 64-bit integer.
+func zabbixFrame(data []byte) []byte {
+	frame := append([]byte("ZBXD\x01"), make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(frame[5:], uint64(len(data)))
+	return append(frame, data...)
+}
+
+func readZabbixFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte("ZBXD")) {
+		return nil, fmt.Errorf("reply is not a Zabbix protocol frame")
+	}
+	// Only the low half is the length; newer servers put the uncompressed
+	// size in the high half.
+	n := binary.LittleEndian.Uint32(header[5:])
+	if n > 1<<20 {
+		return nil, fmt.Errorf("reply of %d bytes is too long", n)
+	}
+	data := make([]byte, n)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ZFSCheck asserts that ZFS pools are ONLINE, that their last scrub found
+// no errors and that no data errors are known. An empty Pool checks every
+// imported pool.
+type ZFSCheck struct {
+	Name string `yaml:"name"`
+	Pool string `yaml:"pool"`
+
+	CheckOptions `yaml:",inline"`
+}
+
+var (
+	zfsPoolNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]*$`)
+	zfsScanErrors    = regexp.MustCompile(`with (\d+) errors`)
+)
+
+// Validate checks the pool name.
+func (z *ZFSCheck) Validate() error {
+	if z.Pool != "" && !zfsPoolNameRegex.MatchString(z.Pool) {
+		return fmt.Errorf("zfs check %s: invalid pool name %q", z.Name, z.Pool)
+	}
+	return nil
+}
+
+// zpoolStatus is the health of one pool as reported by "zpool status".
+type zpoolStatus struct {
+	Name   string
+	State  string
+	Scan   string
+	Errors string
+}
+
+// problem describes why the pool is unhealthy, or returns an empty string.
+func (p zpoolStatus) problem() string {
+	if p.State != "ONLINE" {
+		return "state " + p.State
+	}
+	if m := zfsScanErrors.FindStringSubmatch(p.Scan); m != nil && m[1] != "0" {
+		return "scrub found " + m[1] + " errors"
+	}
+	if p.Errors != "" && p.Errors != "No known data errors" {
+		return p.Errors
+	}
+	return ""
+}
+
+func (check ZFSCheck) run(ctx context.Context) checkResult {
+	pools, err := zpoolStatuses(ctx, check.Pool)
+	if err != nil {
+		return checkFailed("ZFS Name: %s, zpool status failed: %v", check.Name, err)
+	}
+	if len(pools) == 0 {
+		return checkFailed("ZFS Name: %s, no pools found", check.Name)
+	}
+
+	var failed bool
+	details := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		if problem := pool.problem(); problem != "" {
+			details = append(details, fmt.Sprintf("%s: %s", pool.Name, problem))
+			failed = true
+		} else {
+			details = append(details, pool.Name+": ONLINE")
+		}
+	}
+	if failed {
+		return checkFailed("ZFS Name: %s, Pools: %s", check.Name, strings.Join(details, ", "))
+	}
+	return checkOK("ZFS Name: %s, Pools: %s are healthy", check.Name, strings.Join(details, ", "))
+}
+
+func zpoolStatuses(ctx context.Context, pool string) ([]zpoolStatus, error) {
+	args := []string{"status"}
+	if pool != "" {
+		args = append(args, pool)
+	}
+	output, err := commandOutput(ctx, "zpool", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseZpoolStatus(string(output)), nil
+}
+
+// parseZpoolStatus extracts the "pool:", "state:", "scan:" and "errors:"
+// fields for each pool in zpool status output.
+func parseZpoolStatus(output string) []zpoolStatus {
+	var pools []zpoolStatus
+	var current *zpoolStatus
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "pool":
+			pools = append(pools, zpoolStatus{Name: value})
+			current = &pools[len(pools)-1]
+		case "state":
+			if current != nil {
+				current.State = value
+			}
+		case "scan":
+			if current != nil {
+				current.Scan = value
+			}
+		case "errors":
+			if current != nil {
+				current.Errors = value
+			}
+		}
+	}
+	return pools
+}